@@ -2,11 +2,13 @@
 //
 // This service is a standalone web service which allows users to create short URLs
 // that redirect to longer target URLs. It exposes a REST API for creating and
-// resolving short links. Some API endpoints are protected with authorization.
+// resolving short links, and a gRPC API which mirrors it (see api/shortener.proto).
+// Some API endpoints are protected with authorization.
 //
 // All data can be stored in memory (default), file, or database, depending on how
-// the service is configured. The service can be configured via flags and environment
-// variables. In case of conflict the value of the environment variable prevails.
+// the service is configured. The service can be configured via a JSON or YAML config file,
+// environment variables and flags. Environment variables override the config file and
+// flags override environment variables.
 //
 // Example usage:
 //
@@ -14,12 +16,48 @@
 //
 // Environment variables:
 //
+//	CONFIG            - Path to JSON or YAML config file (.yaml and .yml files are parsed as YAML)
 //	SERVER_ADDRESS    – Address and port to run server in the form of host:port (default: localhost:8080)
+//	GRPC_ADDRESS      - Address and port to run gRPC server in the form of host:port (default: localhost:3200)
+//	METRICS_ADDRESS   - Address and port to serve Prometheus metrics, e.g. localhost:9090 (default: disabled)
 //	BASE_URL          - Base URL of the generated short URL
-//	DATABASE_DSN      - Database DSN (in case you want to store data in database)
+//	TRUSTED_SUBNET    - CIDR of clients allowed to access /api/internal/stats, empty to disable it
+//	DATABASE_DSN      - Database DSN (in case you want to store data in database), sqlite:///path/to.db selects SQLite
 //	FILE_STORAGE_PATH - File storage path (in case you want to store data on disk)
+//	FILE_SYNC         - File storage sync policy: always, interval or never (default: always)
+//	FILE_SYNC_INTERVAL - File storage sync interval for interval sync policy (default: 1s)
+//	CACHE_SIZE        - Maximum number of cached URL lookups, 0 to disable the cache (default: 0)
+//	CACHE_TTL         - Lifetime of cached URL lookups (default: 1m)
 //	TOKEN_SECRET_KEY  - Authentication token secret key
-//	TOKEN_DURATION    - Authentication token duration (in the format of Golang duration string)
+//	TOKEN_DURATION    - Access token duration (in the format of Golang duration string, default: 1h)
+//	REFRESH_TOKEN_DURATION - Refresh token duration (in the format of Golang duration string, default: 720h)
+//	TOKEN_SIGNING_KEY - PEM file of RS256 or EdDSA token signing key in the form of kid=path (default: sign with TOKEN_SECRET_KEY)
+//	TOKEN_LEGACY_SECRET - Verify tokens without the kid header with TOKEN_SECRET_KEY when TOKEN_SIGNING_KEY is set (default: false)
+//	TOKEN_VERIFICATION_KEYS - Comma-separated PEM files of older token verification keys in the form of kid=path
+//	SHUTDOWN_TIMEOUT  - Graceful shutdown timeout (in the format of Golang duration string, default: 10s)
+//	REAPER_INTERVAL   - Period between removals of expired URLs (default: 1m)
+//	EXPIRED_RETENTION - How long expired URLs are kept before removal (default: 24h)
+//	DELETE_QUEUE_SIZE - Maximum number of pending jobs of deleting user URLs (default: 1024)
+//	DELETE_MAX_ATTEMPTS - Number of attempts after which a job of deleting user URLs fails (default: 5)
+//	ENABLE_HTTPS      - Serve HTTPS, requires TLS_CERT_FILE and TLS_KEY_FILE or TLS_SELF_SIGNED (default: false)
+//	TLS_CERT_FILE     - PEM file of TLS certificate, reloaded when changed
+//	TLS_KEY_FILE      - PEM file of TLS private key, reloaded when changed
+//	TLS_SELF_SIGNED   - Serve a self-signed certificate for localhost, for development only (default: false)
+//	TLS_SELF_SIGNED_DIR - Directory to write the self-signed cert.pem and key.pem to
+//	TLS_MIN_VERSION   - Minimum TLS version: 1.2 or 1.3 (default: 1.2)
+//	TLS_CIPHER_SUITES - Comma-separated TLS 1.2 cipher suites (default: Go defaults)
+//	TLS_REDIRECT_ADDRESS - Address of HTTP listener which redirects to HTTPS, empty to disable
+//	MAX_URL_LENGTH    - Maximum length of URLs to shorten (default: 2048)
+//	STRIP_TRACKING_PARAMS - Ignore tracking query parameters, such as utm_source, when deduplicating URLs (default: false)
+//	BLOCKLIST_FILE    - File of blocked domains and URL patterns, reloaded on change (default: none)
+//	RATE_LIMIT        - Enable rate limits of the HTTP API (default: true)
+//	RATE_LIMIT_BACKEND - Rate limit state backend: memory or postgres (default: memory)
+//	RATE_LIMIT_CREATE - Limit of creating short URLs per user, e.g. 120/m (default: 120/m)
+//	RATE_LIMIT_REDIRECT - Limit of redirects per client IP (default: 1200/m)
+//	RATE_LIMIT_REGISTER - Limit of registering new users per client IP (default: 60/h)
+//	RATE_LIMIT_TRUST_REAL_IP - Take the client IP from the X-Real-IP header (default: false)
+//	IP_HASH_SALT      - Key of hashes of client IPs which count unique visitors (default: random on start)
+//	LOG_LEVEL         - Log level: debug, info, warn or error (default: info)
 //
 // Example:
 //
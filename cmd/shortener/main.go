@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
 
 	_ "net/http/pprof"
 
 	"github.com/madatsci/urlshortener/internal/app"
+	"github.com/madatsci/urlshortener/internal/app/config"
 )
 
 var (
@@ -15,21 +20,19 @@ var (
 )
 
 func main() {
-	if err := parseFlags(); err != nil {
-		panic(err)
+	c, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		panic(fmt.Errorf("invalid configuration:\n%w", err))
 	}
 
 	app, err := app.New(context.Background(), app.Options{
-		BuildVersion:    buildVersion,
-		BuildDate:       buildDate,
-		BuildCommit:     buildCommit,
-		ServerAddr:      serverAddr,
-		BaseURL:         baseURL,
-		FileStoragePath: fileStoragePath,
-		DatabaseDSN:     databaseDSN,
-		TokenSecret:     tokenSecret,
-		TokenDuration:   tokenDuration,
-		EnableHTTPS:     enableHTTPS,
+		BuildVersion: buildVersion,
+		BuildDate:    buildDate,
+		BuildCommit:  buildCommit,
+		Config:       c,
 	})
 	if err != nil {
 		panic(err)
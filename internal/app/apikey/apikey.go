@@ -0,0 +1,56 @@
+// Package apikey generates and hashes API keys.
+//
+// API keys are long-lived secrets of server-to-server clients. Only the
+// SHA-256 hash of a key is stored, the key itself is shown to the user once
+// when it is created. Keys are random, so a fast hash is sufficient.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix starts every API key, so that keys can be told apart from other
+// credentials and found by secret scanners.
+const Prefix = "usk_"
+
+const (
+	// keyBytes is the number of random bytes in a key.
+	keyBytes = 32
+	// displayLength is the number of random characters of the key shown in key listings.
+	displayLength = 6
+)
+
+// Generate returns a new random API key.
+func Generate() (string, error) {
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash returns the hex-encoded SHA-256 hash of the key.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DisplayPrefix returns the beginning of the key which helps users to identify it.
+func DisplayPrefix(key string) string {
+	n := len(Prefix) + displayLength
+	if len(key) < n {
+		return key
+	}
+
+	return key[:n]
+}
+
+// IsKey reports whether s looks like an API key.
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, Prefix) && len(s) > len(Prefix)
+}
@@ -0,0 +1,30 @@
+package apikey
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	key, err := Generate()
+	require.NoError(t, err)
+	assert.True(t, IsKey(key))
+	assert.Equal(t, 47, len(key))
+	assert.Equal(t, key[:10], DisplayPrefix(key))
+
+	other, err := Generate()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, Hash(key), Hash(other))
+	assert.Equal(t, Hash(key), Hash(key))
+	assert.Equal(t, 64, len(Hash(key)))
+}
+
+func TestIsKey(t *testing.T) {
+	assert.False(t, IsKey(""))
+	assert.False(t, IsKey(Prefix))
+	assert.False(t, IsKey("eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9"))
+	assert.True(t, IsKey(Prefix+"abc"))
+}
@@ -2,23 +2,36 @@
 //
 // It wires together the configuration, storage layer, HTTP server,
 // and logging components. The App type provides the entry point for
-// starting the service. It still does not handle graceful shutdown yet.
+// starting the service and stops it gracefully on SIGINT, SIGTERM or SIGQUIT.
 package app
 
 import (
 	"context"
-	"time"
+	"database/sql"
+	"errors"
+	"os/signal"
+	"syscall"
 
 	"go.uber.org/zap"
 
 	"github.com/madatsci/urlshortener/internal/app/config"
 	"github.com/madatsci/urlshortener/internal/app/database"
+	"github.com/madatsci/urlshortener/internal/app/grpcserver"
+	"github.com/madatsci/urlshortener/internal/app/handlers"
 	"github.com/madatsci/urlshortener/internal/app/logger"
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
+	"github.com/madatsci/urlshortener/internal/app/reaper"
+	"github.com/madatsci/urlshortener/internal/app/screening"
 	"github.com/madatsci/urlshortener/internal/app/server"
 	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/cache"
 	dbstore "github.com/madatsci/urlshortener/internal/app/store/database"
 	fstore "github.com/madatsci/urlshortener/internal/app/store/file"
+	"github.com/madatsci/urlshortener/internal/app/store/instrumented"
 	memstore "github.com/madatsci/urlshortener/internal/app/store/memory"
+	sqlitestore "github.com/madatsci/urlshortener/internal/app/store/sqlite"
+	"github.com/madatsci/urlshortener/pkg/jwt"
 )
 
 // App is the top-level application container for the URL shortener service.
@@ -29,6 +42,12 @@ type App struct {
 	store  store.Store
 	logger *zap.SugaredLogger
 	server *server.Server
+	grpc   *grpcserver.Server
+	reaper *reaper.Reaper
+	// metrics is nil if metrics are disabled.
+	metrics *metrics.Server
+	// rateLimitDB is the connection of the postgres rate limit backend, nil if it is not used.
+	rateLimitDB *sql.DB
 
 	buildVersion string
 	buildDate    string
@@ -37,40 +56,83 @@ type App struct {
 
 // Options contains all dependencies required to build App.
 type Options struct {
-	BuildVersion    string
-	BuildDate       string
-	BuildCommit     string
-	ServerAddr      string
-	BaseURL         string
-	FileStoragePath string
-	DatabaseDSN     string
-	TokenSecret     []byte
-	TokenDuration   time.Duration
-	EnableHTTPS     bool
+	BuildVersion string
+	BuildDate    string
+	BuildCommit  string
+	// Config is the validated service configuration, see config.Load.
+	Config *config.Config
 }
 
 // New creates a new App instance by initializing all core components,
-// including the configuration, logger, storage layer, and HTTP server.
+// including the logger, storage layer, and HTTP server.
 func New(ctx context.Context, opts Options) (*App, error) {
-	config := config.New(opts.ServerAddr, opts.BaseURL, opts.FileStoragePath, opts.DatabaseDSN, opts.TokenSecret, opts.TokenDuration, opts.EnableHTTPS)
+	config := opts.Config
 
-	logger, err := logger.New()
+	tokenOpts := config.TokenOptions()
+	var err error
+	tokenOpts.SigningKey, tokenOpts.VerificationKeys, err = loadTokenKeys(config.Auth.SigningKeyFile, config.Auth.VerificationKeyFiles)
 	if err != nil {
 		return nil, err
 	}
 
-	store, err := newStore(ctx, config)
+	logger, err := logger.New(config.Logging.Level)
 	if err != nil {
 		return nil, err
 	}
 
-	srv := server.New(config, store, logger)
+	var blocklist *screening.Blocklist
+	if config.URLs.BlocklistFile != "" {
+		blocklist, err = screening.NewBlocklist(config.URLs.BlocklistFile, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := newStore(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	store = instrumented.New(store)
+
+	// The connection is opened after the storage, which creates the rate_limits table.
+	var (
+		rateLimitDB      *sql.DB
+		rateLimitBackend ratelimit.Backend
+	)
+	if config.RateLimit.Enabled && config.RateLimit.Backend == "postgres" {
+		rateLimitDB, err = database.NewClient(ctx, config.Storage.DatabaseDSN)
+		if err != nil {
+			return nil, errors.Join(err, store.Close())
+		}
+		rateLimitBackend = ratelimit.NewPostgres(rateLimitDB)
+	}
+
+	tokens := jwt.New(tokenOpts)
+	srv := server.New(config, store, server.Options{
+		Tokens:           tokens,
+		RateLimitBackend: rateLimitBackend,
+		Handlers:         handlers.Options{Blocklist: blocklist},
+	}, logger)
+	grpcSrv := grpcserver.New(config, srv.Handlers(), tokens, srv.RateLimiter(), logger)
+	r := reaper.New(store, reaper.Options{
+		Interval:  config.Storage.ReaperInterval.Duration,
+		Retention: config.Storage.ExpiredRetention.Duration,
+	}, logger)
+
+	var metricsSrv *metrics.Server
+	if config.Server.MetricsAddr != "" {
+		metricsSrv = metrics.NewServer(config.Server.MetricsAddr, logger)
+	}
 
 	app := &App{
 		config:       config,
 		store:        store,
 		logger:       logger,
 		server:       srv,
+		grpc:         grpcSrv,
+		reaper:       r,
+		metrics:      metricsSrv,
+		rateLimitDB:  rateLimitDB,
 		buildVersion: opts.BuildVersion,
 		buildDate:    opts.BuildDate,
 		buildCommit:  opts.BuildCommit,
@@ -80,23 +142,140 @@ func New(ctx context.Context, opts Options) (*App, error) {
 }
 
 // Start starts the URL shortener service and blocks until it is stopped.
+//
+// It serves both HTTP and gRPC APIs. The service is stopped gracefully when
+// the process receives SIGINT, SIGTERM or SIGQUIT: the servers stop accepting
+// connections, pending delete requests are flushed, removal of expired URLs
+// is stopped and the storage is closed. The service is stopped the same way
+// when any of the servers fails.
 func (a *App) Start() error {
 	a.logger.Infof("Build version: %s", a.buildVersion)
 	a.logger.Infof("Build date: %s", a.buildDate)
 	a.logger.Infof("Build commit: %s", a.buildCommit)
 
-	return a.server.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	a.reaper.Start()
+	a.server.RateLimiter().Start()
+
+	errChan := make(chan error, 3)
+	go func() {
+		errChan <- a.server.Start()
+	}()
+	go func() {
+		errChan <- a.grpc.Start()
+	}()
+	if a.metrics != nil {
+		go func() {
+			errChan <- a.metrics.Start()
+		}()
+	}
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			// The servers which have started and the queues are stopped as on a signal.
+			a.logger.Errorf("error starting server: %s", err)
+			return errors.Join(err, a.shutdown())
+		}
+	case <-ctx.Done():
+		a.logger.Info("shutdown signal received")
+	}
+
+	return a.shutdown()
+}
+
+func (a *App) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	a.logger.Infof("shutting down servers with timeout %s", a.config.Server.ShutdownTimeout.Duration)
+	grpcErr := a.grpc.Shutdown(ctx)
+	if grpcErr != nil {
+		a.logger.Errorf("error shutting down gRPC server: %s", grpcErr)
+	}
+
+	// HTTP server is stopped last because it also drains the delete queue
+	// which is shared with the gRPC server.
+	serverErr := a.server.Shutdown(ctx)
+	if serverErr != nil {
+		a.logger.Errorf("error shutting down server: %s", serverErr)
+	}
+
+	reaperErr := a.reaper.Stop(ctx)
+	if reaperErr != nil {
+		a.logger.Errorf("error stopping reaper: %s", reaperErr)
+	}
+
+	limiterErr := a.server.RateLimiter().Stop(ctx)
+	if limiterErr != nil {
+		a.logger.Errorf("error stopping rate limiter: %s", limiterErr)
+	}
+
+	var metricsErr error
+	if a.metrics != nil {
+		metricsErr = a.metrics.Shutdown(ctx)
+		if metricsErr != nil {
+			a.logger.Errorf("error shutting down metrics server: %s", metricsErr)
+		}
+	}
+
+	storeErr := a.closeStorage()
+	if storeErr != nil {
+		a.logger.Errorf("error closing storage: %s", storeErr)
+	}
+
+	a.logger.Info("server stopped")
+
+	return errors.Join(grpcErr, serverErr, reaperErr, limiterErr, metricsErr, storeErr)
+}
+
+// closeStorage closes the storage and the connection of the rate limit backend.
+func (a *App) closeStorage() error {
+	err := a.store.Close()
+	if a.rateLimitDB != nil {
+		err = errors.Join(err, a.rateLimitDB.Close())
+	}
+
+	return err
+}
+
+func newStore(ctx context.Context, config *config.Config, logger *zap.SugaredLogger) (store.Store, error) {
+	s, err := newBackendStore(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Storage.CacheSize > 0 {
+		s = cache.New(s, cache.Options{
+			Size: config.Storage.CacheSize,
+			TTL:  config.Storage.CacheTTL.Duration,
+		})
+	}
+
+	return s, nil
 }
 
-func newStore(ctx context.Context, config *config.Config) (store.Store, error) {
-	if config.DatabaseDSN != "" {
-		conn, err := database.NewClient(ctx, config.DatabaseDSN)
+func newBackendStore(ctx context.Context, config *config.Config, logger *zap.SugaredLogger) (store.Store, error) {
+	if database.IsSQLite(config.Storage.DatabaseDSN) {
+		conn, err := database.NewSQLiteClient(ctx, config.Storage.DatabaseDSN)
+		if err != nil {
+			return nil, err
+		}
+		return sqlitestore.New(ctx, conn)
+	} else if config.Storage.DatabaseDSN != "" {
+		conn, err := database.NewClient(ctx, config.Storage.DatabaseDSN)
 		if err != nil {
 			return nil, err
 		}
 		return dbstore.New(ctx, conn)
-	} else if config.FileStoragePath != "" {
-		return fstore.New(config.FileStoragePath)
+	} else if config.Storage.FilePath != "" {
+		return fstore.New(config.Storage.FilePath, fstore.Options{
+			SyncPolicy:   fstore.SyncPolicy(config.Storage.FileSyncPolicy),
+			SyncInterval: config.Storage.FileSyncInterval.Duration,
+			Log:          logger,
+		})
 	}
 
 	return memstore.New(), nil
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// option binds a configuration field to a command-line flag and an environment variable.
+type option struct {
+	flag  string
+	env   string
+	usage string
+	// field returns a pointer to the field: *string, *[]string, *int, *bool or *Duration.
+	field func(c *Config) any
+	// allowEmpty means that an empty environment variable overrides the field,
+	// otherwise it is ignored.
+	allowEmpty bool
+}
+
+var options = []option{
+	{flag: "a", env: "SERVER_ADDRESS", usage: "address and port to run server in the form of host:port",
+		field: func(c *Config) any { return &c.Server.Addr }},
+	{flag: "g", env: "GRPC_ADDRESS", usage: "address and port to run gRPC server in the form of host:port",
+		field: func(c *Config) any { return &c.Server.GRPCAddr }},
+	{flag: "metrics-address", env: "METRICS_ADDRESS", usage: "address and port to serve metrics in the form of host:port, empty to disable",
+		field: func(c *Config) any { return &c.Server.MetricsAddr }, allowEmpty: true},
+	{flag: "b", env: "BASE_URL", usage: "base URL of the generated short URL",
+		field: func(c *Config) any { return &c.Server.BaseURL }},
+	{flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "graceful shutdown timeout",
+		field: func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{flag: "t", env: "TRUSTED_SUBNET", usage: "CIDR of clients allowed to access internal endpoints, empty to disable them",
+		field: func(c *Config) any { return &c.Server.TrustedSubnet }},
+	{flag: "d", env: "DATABASE_DSN", usage: "database DSN (PostgreSQL, or SQLite with sqlite:///path/to.db)",
+		field: func(c *Config) any { return &c.Storage.DatabaseDSN }},
+	{flag: "f", env: "FILE_STORAGE_PATH", usage: "file storage path",
+		field: func(c *Config) any { return &c.Storage.FilePath }},
+	{flag: "file-sync", env: "FILE_SYNC", usage: "file storage sync policy: always, interval or never",
+		field: func(c *Config) any { return &c.Storage.FileSyncPolicy }},
+	{flag: "file-sync-interval", env: "FILE_SYNC_INTERVAL", usage: "file storage sync interval for interval sync policy",
+		field: func(c *Config) any { return &c.Storage.FileSyncInterval }},
+	{flag: "cache-size", env: "CACHE_SIZE", usage: "maximum number of cached URL lookups, 0 to disable the cache",
+		field: func(c *Config) any { return &c.Storage.CacheSize }},
+	{flag: "cache-ttl", env: "CACHE_TTL", usage: "lifetime of cached URL lookups",
+		field: func(c *Config) any { return &c.Storage.CacheTTL }},
+	{flag: "reaper-interval", env: "REAPER_INTERVAL", usage: "period between removals of expired URLs",
+		field: func(c *Config) any { return &c.Storage.ReaperInterval }},
+	{flag: "expired-retention", env: "EXPIRED_RETENTION", usage: "how long expired URLs are kept before removal",
+		field: func(c *Config) any { return &c.Storage.ExpiredRetention }},
+	{flag: "delete-queue-size", env: "DELETE_QUEUE_SIZE", usage: "maximum number of pending jobs of deleting user URLs",
+		field: func(c *Config) any { return &c.Storage.DeleteQueueSize }},
+	{flag: "delete-max-attempts", env: "DELETE_MAX_ATTEMPTS", usage: "number of attempts after which a job of deleting user URLs fails",
+		field: func(c *Config) any { return &c.Storage.DeleteMaxAttempts }},
+	{flag: "token-secret", env: "TOKEN_SECRET_KEY", usage: "authentication token secret key",
+		field: func(c *Config) any { return &c.Auth.TokenSecret }},
+	{flag: "token-duration", env: "TOKEN_DURATION", usage: "authentication token duration",
+		field: func(c *Config) any { return &c.Auth.TokenDuration }},
+	{flag: "refresh-token-duration", env: "REFRESH_TOKEN_DURATION", usage: "refresh token duration",
+		field: func(c *Config) any { return &c.Auth.RefreshTokenDuration }},
+	{flag: "token-signing-key", env: "TOKEN_SIGNING_KEY", usage: "PEM file of RS256 or EdDSA token signing key in the form of kid=path",
+		field: func(c *Config) any { return &c.Auth.SigningKeyFile }},
+	{flag: "token-legacy-secret", env: "TOKEN_LEGACY_SECRET", usage: "verify tokens without the kid header with the token secret when a signing key is set",
+		field: func(c *Config) any { return &c.Auth.LegacyTokenSecret }},
+	{flag: "token-verification-keys", env: "TOKEN_VERIFICATION_KEYS", usage: "comma-separated PEM files of older token verification keys in the form of kid=path",
+		field: func(c *Config) any { return &c.Auth.VerificationKeyFiles }},
+	{flag: "s", env: "ENABLE_HTTPS", usage: "enable HTTPS (requires -tls-cert and -tls-key, or -tls-self-signed)",
+		field: func(c *Config) any { return &c.TLS.Enabled }},
+	{flag: "tls-cert", env: "TLS_CERT_FILE", usage: "PEM file of TLS certificate, reloaded when changed",
+		field: func(c *Config) any { return &c.TLS.CertFile }},
+	{flag: "tls-key", env: "TLS_KEY_FILE", usage: "PEM file of TLS private key, reloaded when changed",
+		field: func(c *Config) any { return &c.TLS.KeyFile }},
+	{flag: "tls-self-signed", env: "TLS_SELF_SIGNED", usage: "serve a self-signed certificate for development",
+		field: func(c *Config) any { return &c.TLS.SelfSigned }},
+	{flag: "tls-self-signed-dir", env: "TLS_SELF_SIGNED_DIR", usage: "directory to write the self-signed certificate and key to",
+		field: func(c *Config) any { return &c.TLS.SelfSignedDir }},
+	{flag: "tls-min-version", env: "TLS_MIN_VERSION", usage: "minimum TLS version: 1.2 or 1.3",
+		field: func(c *Config) any { return &c.TLS.MinVersion }},
+	{flag: "tls-cipher-suites", env: "TLS_CIPHER_SUITES", usage: "comma-separated TLS 1.2 cipher suites",
+		field: func(c *Config) any { return &c.TLS.CipherSuites }},
+	{flag: "tls-redirect-address", env: "TLS_REDIRECT_ADDRESS", usage: "address of HTTP listener which redirects to HTTPS, empty to disable",
+		field: func(c *Config) any { return &c.TLS.RedirectAddr }},
+	{flag: "max-url-length", env: "MAX_URL_LENGTH", usage: "maximum length of URLs to shorten",
+		field: func(c *Config) any { return &c.URLs.MaxLength }},
+	{flag: "strip-tracking-params", env: "STRIP_TRACKING_PARAMS", usage: "ignore tracking query parameters, such as utm_source, when deduplicating URLs",
+		field: func(c *Config) any { return &c.URLs.StripTracking }},
+	{flag: "blocklist-file", env: "BLOCKLIST_FILE", usage: "file of blocked domains and URL patterns, reloaded on change",
+		field: func(c *Config) any { return &c.URLs.BlocklistFile }},
+	{flag: "redirect-type", env: "REDIRECT_TYPE", usage: "default HTTP status of redirects: 301, 302, 307 or 308",
+		field: func(c *Config) any { return &c.URLs.RedirectType }},
+	{flag: "redirect-max-age", env: "REDIRECT_MAX_AGE", usage: "how long clients may cache permanent redirects",
+		field: func(c *Config) any { return &c.URLs.RedirectMaxAge }},
+	{flag: "rate-limit", env: "RATE_LIMIT", usage: "enable rate limits",
+		field: func(c *Config) any { return &c.RateLimit.Enabled }},
+	{flag: "rate-limit-backend", env: "RATE_LIMIT_BACKEND", usage: "rate limit state backend: memory or postgres",
+		field: func(c *Config) any { return &c.RateLimit.Backend }},
+	{flag: "rate-limit-create", env: "RATE_LIMIT_CREATE", usage: "limit of creating short URLs per user, e.g. 120/m, empty to disable",
+		field: func(c *Config) any { return &c.RateLimit.Create }, allowEmpty: true},
+	{flag: "rate-limit-redirect", env: "RATE_LIMIT_REDIRECT", usage: "limit of redirects per client IP, e.g. 1200/m, empty to disable",
+		field: func(c *Config) any { return &c.RateLimit.Redirect }, allowEmpty: true},
+	{flag: "rate-limit-register", env: "RATE_LIMIT_REGISTER", usage: "limit of registering new users per client IP, e.g. 60/h, empty to disable",
+		field: func(c *Config) any { return &c.RateLimit.Register }, allowEmpty: true},
+	{flag: "rate-limit-trust-real-ip", env: "RATE_LIMIT_TRUST_REAL_IP", usage: "take the client IP from the X-Real-IP header set by a reverse proxy",
+		field: func(c *Config) any { return &c.RateLimit.TrustRealIP }},
+	{flag: "ip-hash-salt", env: "IP_HASH_SALT", usage: "key of hashes of client IPs which count unique visitors, random if empty",
+		field: func(c *Config) any { return &c.Stats.IPHashSalt }},
+	{flag: "log-level", env: "LOG_LEVEL", usage: "log level: debug, info, warn or error",
+		field: func(c *Config) any { return &c.Logging.Level }},
+}
+
+// Load builds the configuration from defaults, the config file, environment variables
+// and command-line flags, in the order of increasing priority, and validates it.
+//
+// The config file is selected with the -c flag or the CONFIG environment variable.
+// args are command-line arguments without the program name, lookupEnv is usually os.LookupEnv.
+// All invalid values are reported at once. flag.ErrHelp is returned if -h was given.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+
+	configPath, _ := lookupEnv("CONFIG")
+	fs.StringVar(&configPath, "c", configPath, "path to JSON or YAML config file")
+
+	// Flags are applied after environment variables, so their values are only collected here.
+	flags := make(map[string]string)
+	for _, o := range options {
+		collect := func(value string) error {
+			flags[o.flag] = value
+			return nil
+		}
+		if _, ok := o.field(&Config{}).(*bool); ok {
+			fs.BoolFunc(o.flag, o.usage, collect)
+		} else {
+			fs.Func(o.flag, o.usage, collect)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if configPath != "" {
+		if err := c.loadFile(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, o := range options {
+		value, ok := lookupEnv(o.env)
+		if !ok || (value == "" && !o.allowEmpty) {
+			continue
+		}
+		if err := setValue(o.field(c), value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", o.env, err))
+		}
+	}
+	for _, o := range options {
+		value, ok := flags[o.flag]
+		if !ok {
+			continue
+		}
+		if err := setValue(o.field(c), value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -%s: %w", o.flag, err))
+		}
+	}
+
+	if err := errors.Join(append(errs, c.Validate())...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// loadFile overrides the configuration with values from the file.
+// Files with .yaml or .yml extension are parsed as YAML, others as JSON.
+// Unknown keys are rejected, so that typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func setValue(field any, value string) error {
+	switch p := field.(type) {
+	case *string:
+		*p = value
+	case *[]string:
+		*p = strings.Split(value, ",")
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid boolean")
+		}
+		*p = b
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("invalid duration")
+		}
+		p.Duration = d
+	default:
+		return fmt.Errorf("unsupported field type %T", field)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/madatsci/urlshortener/internal/app/database"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
+)
+
+// Validate checks the whole configuration and reports all invalid values at once.
+// Each error is prefixed with the config file key of the value, e.g. server.address.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	check("server.address", validateAddress(c.Server.Addr))
+	check("server.grpc_address", validateAddress(c.Server.GRPCAddr))
+	if c.Server.MetricsAddr != "" {
+		check("server.metrics_address", validateAddress(c.Server.MetricsAddr))
+	}
+	check("server.base_url", validateBaseURL(c.Server.BaseURL))
+	check("server.shutdown_timeout", positive(c.Server.ShutdownTimeout))
+	if c.Server.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.Server.TrustedSubnet); err != nil {
+			check("server.trusted_subnet", errors.New("invalid CIDR"))
+		}
+	}
+
+	check("storage.file_sync", validateSyncPolicy(c.Storage.FileSyncPolicy))
+	check("storage.file_sync_interval", positive(c.Storage.FileSyncInterval))
+	if c.Storage.CacheSize < 0 {
+		check("storage.cache_size", errors.New("must not be negative"))
+	}
+	check("storage.cache_ttl", positive(c.Storage.CacheTTL))
+	check("storage.reaper_interval", positive(c.Storage.ReaperInterval))
+	if c.Storage.ExpiredRetention.Duration < 0 {
+		check("storage.expired_retention", errors.New("must not be negative"))
+	}
+	if c.Storage.DeleteQueueSize < 1 {
+		check("storage.delete_queue_size", errors.New("must be positive"))
+	}
+	if c.Storage.DeleteMaxAttempts < 1 {
+		check("storage.delete_max_attempts", errors.New("must be positive"))
+	}
+
+	if c.Auth.TokenSecret == "" && c.Auth.usesTokenSecret() {
+		check("auth.token_secret", errors.New("must not be empty unless signing_key is set"))
+	}
+	check("auth.token_duration", positive(c.Auth.TokenDuration))
+	check("auth.refresh_token_duration", positive(c.Auth.RefreshTokenDuration))
+	ids := make(map[string]struct{})
+	if c.Auth.SigningKeyFile != "" {
+		check("auth.signing_key", validateKeySpec(c.Auth.SigningKeyFile, ids))
+	}
+	for _, spec := range c.Auth.VerificationKeyFiles {
+		check("auth.verification_keys", validateKeySpec(spec, ids))
+	}
+
+	if c.TLS.Enabled {
+		if !c.TLS.SelfSigned && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+			check("tls", errors.New("cert_file and key_file are required unless self_signed is set"))
+		}
+		_, err := c.TLS.Version()
+		check("tls.min_version", err)
+		_, err = c.TLS.CipherSuiteIDs()
+		check("tls.cipher_suites", err)
+		if c.TLS.RedirectAddr != "" {
+			check("tls.redirect_address", validateAddress(c.TLS.RedirectAddr))
+		}
+	}
+
+	if c.URLs.MaxLength < 1 {
+		check("urls.max_length", errors.New("must be positive"))
+	}
+	if !models.ValidRedirectType(c.URLs.RedirectType) {
+		check("urls.redirect_type", errors.New("invalid redirect type, must be one of: 301, 302, 307, 308"))
+	}
+	check("urls.redirect_max_age", positive(c.URLs.RedirectMaxAge))
+
+	if c.RateLimit.Enabled {
+		switch c.RateLimit.Backend {
+		case "memory":
+		case "postgres":
+			if c.Storage.DatabaseDSN == "" || database.IsSQLite(c.Storage.DatabaseDSN) {
+				check("rate_limit.backend", errors.New("postgres backend requires PostgreSQL database_dsn"))
+			}
+		default:
+			check("rate_limit.backend", errors.New("invalid backend, must be one of: memory, postgres"))
+		}
+		for _, limit := range []struct{ key, value string }{
+			{"rate_limit.create", c.RateLimit.Create},
+			{"rate_limit.redirect", c.RateLimit.Redirect},
+			{"rate_limit.register", c.RateLimit.Register},
+		} {
+			if limit.value != "" {
+				_, err := ratelimit.ParseLimit(limit.value)
+				check(limit.key, err)
+			}
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.Logging.Level); err != nil {
+		check("logging.level", fmt.Errorf("invalid level %q", c.Logging.Level))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateAddress(value string) error {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return errors.New("wrong address format, must be host:port")
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return errors.New("invalid port")
+	}
+
+	return nil
+}
+
+func validateBaseURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("invalid URL format")
+	}
+
+	return nil
+}
+
+func validateSyncPolicy(value string) error {
+	switch value {
+	case "always", "interval", "never":
+		return nil
+	default:
+		return errors.New("invalid sync policy, must be one of: always, interval, never")
+	}
+}
+
+// validateKeySpec checks the kid=path format and that key IDs are unique.
+func validateKeySpec(value string, ids map[string]struct{}) error {
+	kid, path, ok := strings.Cut(value, "=")
+	if !ok || kid == "" || path == "" {
+		return fmt.Errorf("wrong key format %q, must be kid=path", value)
+	}
+
+	if _, ok := ids[kid]; ok {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+	ids[kid] = struct{}{}
+
+	return nil
+}
+
+func positive(d Duration) error {
+	if d.Duration <= 0 {
+		return errors.New("must be positive")
+	}
+
+	return nil
+}
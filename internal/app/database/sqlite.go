@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme is the DSN scheme which selects SQLite database, e.g. sqlite:///path/to.db.
+const SQLiteScheme = "sqlite://"
+
+// IsSQLite reports whether dsn refers to SQLite database.
+func IsSQLite(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+// NewSQLiteClient creates a new SQLite client.
+//
+// The dsn is expected to have the sqlite:// scheme followed by the path to the
+// database file, e.g. sqlite:///var/lib/shortener/shortener.db. Use
+// sqlite://:memory: for a database which lives in memory only.
+func NewSQLiteClient(ctx context.Context, dsn string) (*sql.DB, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, SQLiteScheme), "?")
+
+	params := "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	if query != "" {
+		params += "&" + query
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer only. Besides, every connection to an
+	// in-memory database would see its own empty database.
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,350 @@
+// Package deleter implements the durable queue of requests to delete user URLs.
+//
+// Accepted requests are saved to the storage as jobs before the client gets
+// a response, so that they survive restarts. Jobs are executed in background:
+// each job deletes all its URLs with a single storage call. A failed job is
+// retried with exponential backoff and is marked as failed after
+// Options.MaxAttempts attempts. Clients poll the status of a job by its ID.
+//
+// Jobs are claimed from the storage for Options.Lease, so that several instances
+// of the service sharing the storage do not execute the same job. A job claimed
+// by an instance which stopped before finishing it is executed again after the lease.
+//
+// The number of pending jobs in the storage is limited by Options.MaxPending.
+// When the queue is full, Enqueue returns ErrQueueFull instead of blocking the caller.
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+const (
+	// DefaultInterval is used if Options.Interval is not set.
+	DefaultInterval = time.Second
+	// DefaultBatchSize is used if Options.BatchSize is not set.
+	DefaultBatchSize = 100
+	// DefaultMaxPending is used if Options.MaxPending is not set.
+	DefaultMaxPending = 1024
+	// DefaultMaxAttempts is used if Options.MaxAttempts is not set.
+	DefaultMaxAttempts = 5
+	// DefaultBackoff is used if Options.Backoff is not set.
+	DefaultBackoff = time.Second
+	// DefaultMaxBackoff is used if Options.MaxBackoff is not set.
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultLease is used if Options.Lease is not set.
+	DefaultLease = time.Minute
+)
+
+// queueName is the label of the queue in metrics.
+const queueName = "delete"
+
+// ErrQueueFull is returned by Enqueue when the number of pending jobs has reached Options.MaxPending.
+var ErrQueueFull = errors.New("delete queue is full")
+
+// Options is used to configure Queue.
+type Options struct {
+	// Interval is the period between checks for jobs due to be retried (default: DefaultInterval).
+	// New jobs are executed without waiting for the next check.
+	Interval time.Duration
+	// BatchSize is the maximum number of jobs fetched from the storage at once (default: DefaultBatchSize).
+	BatchSize int
+	// MaxPending is the maximum number of pending jobs (default: DefaultMaxPending).
+	MaxPending int
+	// MaxAttempts is the number of attempts after which a job is marked as failed (default: DefaultMaxAttempts).
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it is doubled with every failed attempt (default: DefaultBackoff).
+	Backoff time.Duration
+	// MaxBackoff limits the delay between retries (default: DefaultMaxBackoff).
+	MaxBackoff time.Duration
+	// Lease is the time for which a claimed job is not claimed again (default: DefaultLease).
+	// It should be longer than the execution of a job.
+	Lease time.Duration
+}
+
+// Queue accepts requests to delete user URLs and executes them in background.
+//
+// Use New to create an instance of Queue.
+type Queue struct {
+	s    store.Store
+	opts Options
+	log  *zap.SugaredLogger
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+
+	// ctx is canceled when Stop gives up waiting for the jobs.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+	closed  bool
+}
+
+// New creates a new Queue.
+func New(s store.Store, opts Options, logger *zap.SugaredLogger) *Queue {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = DefaultLease
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Queue{
+		s:      s,
+		opts:   opts,
+		log:    logger,
+		now:    time.Now,
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start starts executing jobs in background.
+//
+// Jobs left pending by the previous run are executed too.
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.started = true
+	go q.run()
+}
+
+// Stop stops accepting new jobs, executes the jobs which are due and waits until
+// they are finished or ctx is done. Jobs which are not finished stay in the storage.
+//
+// If ctx is done first, the running job is canceled. The queue does not use
+// the storage after Stop returns, so that the storage can be closed.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+	started := q.started
+	q.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-q.done
+		return ctx.Err()
+	}
+}
+
+// Enqueue saves the job of deleting URLs of the user with the given slugs.
+//
+// It returns ErrQueueFull if there are too many pending jobs.
+func (q *Queue) Enqueue(ctx context.Context, userID string, slugs []string) (models.DeleteJob, error) {
+	if err := q.checkDepth(ctx); err != nil {
+		return models.DeleteJob{}, err
+	}
+
+	now := q.now()
+	job := models.DeleteJob{
+		ID:            uuid.NewString(),
+		UserID:        userID,
+		Slugs:         slugs,
+		Status:        models.DeleteJobPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if err := q.s.CreateDeleteJob(ctx, job); err != nil {
+		return models.DeleteJob{}, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// Job returns the job of the user by ID.
+//
+// It returns store.ErrNotFound if the user has no such job.
+func (q *Queue) Job(ctx context.Context, userID, jobID string) (models.DeleteJob, error) {
+	return q.s.GetDeleteJob(ctx, userID, jobID)
+}
+
+// Process claims and executes pending jobs which are due until there are none left.
+// It returns the number of finished jobs.
+func (q *Queue) Process(ctx context.Context) (int, error) {
+	var finished int
+	for {
+		now := q.now()
+		jobs, err := q.s.ClaimDeleteJobs(ctx, now, now.Add(q.opts.Lease), q.opts.BatchSize)
+		if err != nil {
+			return finished, err
+		}
+
+		for _, job := range jobs {
+			// Claimed jobs which are not executed are claimed again after the lease.
+			if err = ctx.Err(); err != nil {
+				return finished, err
+			}
+			job = q.execute(ctx, job)
+			if err := q.s.UpdateDeleteJob(ctx, job); err != nil {
+				return finished, err
+			}
+			if job.Finished() {
+				finished++
+			}
+		}
+
+		if len(jobs) < q.opts.BatchSize {
+			return finished, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return finished, err
+		}
+	}
+}
+
+// execute deletes URLs of the job and returns the job with the new state.
+func (q *Queue) execute(ctx context.Context, job models.DeleteJob) models.DeleteJob {
+	err := q.s.BatchSoftDeleteURLs(ctx, job.UserID, job.Slugs)
+	job.UpdatedAt = q.now()
+
+	if err == nil {
+		job.Status = models.DeleteJobDone
+		job.LastError = ""
+		q.log.With("jobID", job.ID, "userID", job.UserID, "count", len(job.Slugs)).Info("deleted urls")
+		return job
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	if job.Attempts >= q.opts.MaxAttempts {
+		job.Status = models.DeleteJobFailed
+		q.log.Errorln("error deleting urls, giving up", "jobID", job.ID, "attempts", job.Attempts, "err", err)
+		return job
+	}
+
+	job.NextAttemptAt = job.UpdatedAt.Add(q.backoff(job.Attempts))
+	q.log.Warnln("error deleting urls, will retry", "jobID", job.ID, "attempts", job.Attempts, "err", err)
+
+	return job
+}
+
+// backoff returns the delay before the next attempt after the given number of failed attempts.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.Backoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, q.opts.MaxBackoff)
+}
+
+// checkDepth returns ErrQueueFull if there is no place for a new job in the queue.
+//
+// The depth is counted in the storage, so that it includes jobs enqueued by other
+// instances of the service. Concurrent calls may exceed Options.MaxPending by a few jobs.
+func (q *Queue) checkDepth(ctx context.Context) error {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return fmt.Errorf("%w: queue is stopped", ErrQueueFull)
+	}
+
+	n, err := q.depth(ctx)
+	if err != nil {
+		return err
+	}
+	if n >= q.opts.MaxPending {
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+// depth returns the number of pending jobs in the storage and reports it to metrics.
+func (q *Queue) depth(ctx context.Context) (int, error) {
+	n, err := q.s.CountPendingDeleteJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	metrics.QueueDepth.WithLabelValues(queueName).Set(float64(n))
+
+	return n, nil
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.opts.Interval)
+	defer ticker.Stop()
+
+	ctx := q.ctx
+	process := func() {
+		n, err := q.Process(ctx)
+		if err != nil {
+			q.log.Errorln("error processing delete jobs", "err", err)
+		}
+		if n > 0 {
+			q.log.With("count", n).Debug("finished delete jobs")
+			if _, err := q.depth(ctx); err != nil {
+				q.log.Errorln("error counting delete jobs", "err", err)
+			}
+		}
+	}
+
+	process()
+	for {
+		select {
+		case <-q.wake:
+			process()
+		case <-ticker.C:
+			process()
+		case <-q.stop:
+			// Jobs accepted right before the stop are executed, so that
+			// the storages which do not persist jobs do not lose them.
+			process()
+			return
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+// failingStore fails the first failures calls of BatchSoftDeleteURLs.
+type failingStore struct {
+	store.Store
+	failures int
+}
+
+func (s *failingStore) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("storage is down")
+	}
+
+	return s.Store.BatchSoftDeleteURLs(ctx, userID, slugs)
+}
+
+func TestProcess(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	user := random.RandomUser()
+	urls := random.RandomURLs(3)
+	require.NoError(t, s.BatchCreateURL(ctx, user.ID, urls))
+
+	q := New(s, Options{}, zap.NewNop().Sugar())
+	job, err := q.Enqueue(ctx, user.ID, []string{urls[0].Slug, urls[1].Slug, "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobPending, job.Status)
+
+	res, err := q.Job(ctx, user.ID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobPending, res.Status)
+
+	n, err := q.Process(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	res, err = q.Job(ctx, user.ID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobDone, res.Status)
+
+	for i, url := range urls {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, i < 2, res.Deleted)
+	}
+
+	t.Run("negative case: job of another user", func(t *testing.T) {
+		_, err := q.Job(ctx, random.RandomUser().ID, job.ID)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	user := random.RandomUser()
+	url := random.RandomURL()
+
+	newQueue := func(t *testing.T, failures int) (*Queue, *time.Time) {
+		s := &failingStore{Store: memory.New(), failures: failures}
+		require.NoError(t, s.CreateURL(ctx, user.ID, url))
+
+		now := time.Now()
+		q := New(s, Options{MaxAttempts: 3, Backoff: time.Second}, zap.NewNop().Sugar())
+		q.now = func() time.Time { return now }
+
+		return q, &now
+	}
+
+	t.Run("job is retried with backoff", func(t *testing.T) {
+		q, now := newQueue(t, 2)
+		job, err := q.Enqueue(ctx, user.ID, []string{url.Slug})
+		require.NoError(t, err)
+
+		_, err = q.Process(ctx)
+		require.NoError(t, err)
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DeleteJobPending, job.Status)
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, "storage is down", job.LastError)
+		assert.Equal(t, now.Add(time.Second), job.NextAttemptAt)
+
+		// The job is not due yet.
+		_, err = q.Process(ctx)
+		require.NoError(t, err)
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 1, job.Attempts)
+
+		*now = now.Add(time.Second)
+		_, err = q.Process(ctx)
+		require.NoError(t, err)
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, job.Attempts)
+		assert.Equal(t, now.Add(2*time.Second), job.NextAttemptAt)
+
+		*now = now.Add(2 * time.Second)
+		n, err := q.Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DeleteJobDone, job.Status)
+		assert.Empty(t, job.LastError)
+	})
+
+	t.Run("job fails after max attempts", func(t *testing.T) {
+		q, now := newQueue(t, 10)
+		job, err := q.Enqueue(ctx, user.ID, []string{url.Slug})
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err = q.Process(ctx)
+			require.NoError(t, err)
+			*now = now.Add(time.Hour)
+		}
+
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DeleteJobFailed, job.Status)
+		assert.Equal(t, 3, job.Attempts)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	q := New(memory.New(), Options{Backoff: time.Second, MaxBackoff: 5 * time.Second}, zap.NewNop().Sugar())
+
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  5 * time.Second,
+		50: 5 * time.Second,
+	} {
+		assert.Equal(t, want, q.backoff(attempts), attempts)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	user := random.RandomUser()
+
+	q := New(s, Options{MaxPending: 2}, zap.NewNop().Sugar())
+	for i := 0; i < 2; i++ {
+		_, err := q.Enqueue(ctx, user.ID, []string{"slug"})
+		require.NoError(t, err)
+	}
+	_, err := q.Enqueue(ctx, user.ID, []string{"slug"})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	t.Run("pending jobs of the previous run are counted", func(t *testing.T) {
+		q := New(s, Options{MaxPending: 2}, zap.NewNop().Sugar())
+		_, err := q.Enqueue(ctx, user.ID, []string{"slug"})
+		assert.ErrorIs(t, err, ErrQueueFull)
+	})
+
+	_, err = q.Process(ctx)
+	require.NoError(t, err)
+	_, err = q.Enqueue(ctx, user.ID, []string{"slug"})
+	assert.NoError(t, err)
+
+	t.Run("jobs of other instances are counted", func(t *testing.T) {
+		other := New(s, Options{MaxPending: 2}, zap.NewNop().Sugar())
+		_, err := other.Enqueue(ctx, user.ID, []string{"slug"})
+		require.NoError(t, err)
+
+		_, err = q.Enqueue(ctx, user.ID, []string{"slug"})
+		assert.ErrorIs(t, err, ErrQueueFull)
+	})
+}
+
+// countingStore counts calls of BatchSoftDeleteURLs.
+type countingStore struct {
+	store.Store
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *countingStore) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+
+	return s.Store.BatchSoftDeleteURLs(ctx, userID, slugs)
+}
+
+func TestSharedStore(t *testing.T) {
+	ctx := context.Background()
+	user := random.RandomUser()
+
+	t.Run("job is executed by one instance", func(t *testing.T) {
+		s := &countingStore{Store: memory.New()}
+		queues := []*Queue{
+			New(s, Options{BatchSize: 3}, zap.NewNop().Sugar()),
+			New(s, Options{BatchSize: 3}, zap.NewNop().Sugar()),
+		}
+		for i := 0; i < 20; i++ {
+			_, err := queues[i%2].Enqueue(ctx, user.ID, []string{"slug"})
+			require.NoError(t, err)
+		}
+
+		var (
+			wg       sync.WaitGroup
+			finished [2]int
+		)
+		for i, q := range queues {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := q.Process(ctx)
+				assert.NoError(t, err)
+				finished[i] = n
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 20, finished[0]+finished[1])
+		assert.Equal(t, 20, s.calls)
+	})
+
+	t.Run("job is executed again after the lease", func(t *testing.T) {
+		s := memory.New()
+		now := time.Now()
+		q := New(s, Options{Lease: time.Minute}, zap.NewNop().Sugar())
+		q.now = func() time.Time { return now }
+
+		job, err := q.Enqueue(ctx, user.ID, []string{"slug"})
+		require.NoError(t, err)
+
+		// Another instance claims the job and stops before finishing it.
+		claimed, err := s.ClaimDeleteJobs(ctx, now, now.Add(time.Minute), 10)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+
+		n, err := q.Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		now = now.Add(time.Minute)
+		n, err = q.Process(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		job, err = q.Job(ctx, user.ID, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DeleteJobDone, job.Status)
+	})
+}
+
+// blockingStore blocks deletions until their context is canceled.
+type blockingStore struct {
+	store.Store
+}
+
+func (s *blockingStore) BatchSoftDeleteURLs(ctx context.Context, _ string, _ []string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStopTimeout(t *testing.T) {
+	ctx := context.Background()
+	q := New(&blockingStore{Store: memory.New()}, Options{Interval: time.Hour}, zap.NewNop().Sugar())
+	q.Start()
+
+	_, err := q.Enqueue(ctx, random.RandomUser().ID, []string{"slug"})
+	require.NoError(t, err)
+
+	stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = q.Stop(stopCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The running job is canceled, so the storage is not used after Stop returns.
+	select {
+	case <-q.done:
+	default:
+		t.Fatal("queue is still running")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	user := random.RandomUser()
+	url := random.RandomURL()
+	require.NoError(t, s.CreateURL(ctx, user.ID, url))
+
+	// The interval is long, so the job is executed either on enqueue or on stop.
+	q := New(s, Options{Interval: time.Hour}, zap.NewNop().Sugar())
+	q.Start()
+
+	job, err := q.Enqueue(ctx, user.ID, []string{url.Slug})
+	require.NoError(t, err)
+
+	err = q.Stop(ctx)
+	require.NoError(t, err)
+
+	job, err = q.Job(ctx, user.ID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobDone, job.Status)
+
+	_, err = q.Enqueue(ctx, user.ID, []string{url.Slug})
+	assert.ErrorIs(t, err, ErrQueueFull)
+}
@@ -0,0 +1,279 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/pkg/jwt"
+)
+
+// DefaultMetadataKey is the default metadata key for authentication token.
+const DefaultMetadataKey = middleware.DefaultCookieName
+
+// Scope defines authentication requirements of an RPC method.
+type Scope int
+
+const (
+	// ScopeNone means that the method does not require authentication.
+	ScopeNone Scope = iota
+	// ScopePublic means that an unauthenticated caller is registered as a new user,
+	// the same way middleware.Auth.PublicAPIAuth does, including the registration rate limit. There is no RPC which refreshes
+	// tokens, so callers with expired tokens are registered as new users too.
+	ScopePublic
+	// ScopePrivate means that the caller must present a valid token,
+	// the same way middleware.Auth.PrivateAPIAuth requires.
+	ScopePrivate
+)
+
+// Auth is an authentication interceptor.
+//
+// Use NewAuth to create a new Auth instance.
+type Auth struct {
+	metadataKey string
+	jwt         *jwt.JWT
+	store       store.Store
+	limiter     *middleware.RateLimiter
+	trustRealIP bool
+	log         *zap.SugaredLogger
+	scopes      map[string]Scope
+}
+
+// AuthOptions represents dependencies required for Auth.
+type AuthOptions struct {
+	MetadataKey string
+	JWT         *jwt.JWT
+	Store       store.Store
+	// RateLimiter limits registrations of new users, nil if rate limits are disabled.
+	RateLimiter *middleware.RateLimiter
+	// TrustRealIP makes the limiter take the client IP from the x-real-ip metadata.
+	TrustRealIP bool
+	Log         *zap.SugaredLogger
+	// Scopes maps full RPC method names to their scopes.
+	// Methods which are not listed do not require authentication.
+	Scopes map[string]Scope
+}
+
+// NewAuth creates a new Auth interceptor.
+func NewAuth(opts AuthOptions) *Auth {
+	metadataKey := opts.MetadataKey
+	if metadataKey == "" {
+		metadataKey = DefaultMetadataKey
+	}
+
+	return &Auth{
+		metadataKey: metadataKey,
+		jwt:         opts.JWT,
+		store:       opts.Store,
+		limiter:     opts.RateLimiter,
+		trustRealIP: opts.TrustRealIP,
+		log:         opts.Log,
+		scopes:      opts.Scopes,
+	}
+}
+
+// UnaryInterceptor authenticates unary RPCs.
+func (a *Auth) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authenticates streaming RPCs.
+func (a *Auth) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a *Auth) authenticate(ctx context.Context, method string) (context.Context, error) {
+	var userID string
+	var err error
+
+	switch a.scopes[method] {
+	case ScopePublic:
+		userID, err = a.publicUserID(ctx)
+	case ScopePrivate:
+		userID, err = a.privateUserID(ctx)
+	default:
+		return ctx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.log.With("userID", userID).Debug("add userID to request context")
+
+	return context.WithValue(ctx, middleware.AuthenticatedUserKey, userID), nil
+}
+
+func (a *Auth) publicUserID(ctx context.Context) (string, error) {
+	token := a.token(ctx)
+	if token == "" {
+		a.log.Debug("auth token not found in metadata, issue new token")
+		return a.registerNewUser(ctx)
+	}
+
+	claims, err := a.jwt.Parse(token, jwt.TypeAccess)
+	if err != nil {
+		return a.registerNewUser(ctx)
+	}
+	revoked, err := a.isRevoked(ctx, claims)
+	if err != nil {
+		return "", status.Error(codes.Internal, "internal error")
+	}
+	if revoked {
+		return a.registerNewUser(ctx)
+	}
+	userID := claims.UserID
+
+	if _, err := a.store.GetUser(ctx, userID); err != nil {
+		return "", a.unauthenticated(errors.New("got unregistered user from auth token"))
+	}
+
+	return userID, nil
+}
+
+func (a *Auth) privateUserID(ctx context.Context) (string, error) {
+	token := a.token(ctx)
+	if token == "" {
+		return "", a.unauthenticated(errors.New("no auth token in metadata"))
+	}
+
+	claims, err := a.jwt.Parse(token, jwt.TypeAccess)
+	if err != nil {
+		return "", a.unauthenticated(err)
+	}
+	userID := claims.UserID
+	if userID == "" {
+		return "", a.unauthenticated(errors.New("token does not contain user ID"))
+	}
+	revoked, err := a.isRevoked(ctx, claims)
+	if err != nil {
+		return "", status.Error(codes.Internal, "internal error")
+	}
+	if revoked {
+		return "", a.unauthenticated(errors.New("token has been revoked"))
+	}
+	if _, err := a.store.GetUser(ctx, userID); err != nil {
+		return "", a.unauthenticated(errors.New("got unregistered user from auth token"))
+	}
+
+	return userID, nil
+}
+
+func (a *Auth) token(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(a.metadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (a *Auth) registerNewUser(ctx context.Context) (string, error) {
+	if retryAfter, ok := a.limiter.AllowClient(ctx, middleware.RateLimitRegister, a.clientIP(ctx)); !ok {
+		retry := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+		if err := grpc.SetHeader(ctx, metadata.Pairs("retry-after", retry)); err != nil {
+			a.log.Debugf("error setting retry-after header: %s", err)
+		}
+		return "", status.Error(codes.ResourceExhausted, "rate limit exceeded, retry later")
+	}
+
+	user := models.User{
+		ID:        uuid.NewString(),
+		CreatedAt: time.Now(),
+	}
+
+	if err := a.store.CreateUser(ctx, user); err != nil {
+		return "", status.Error(codes.Internal, "internal error")
+	}
+
+	token, err := a.jwt.GetString(user.ID)
+	if err != nil {
+		return "", status.Error(codes.Internal, "internal error")
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(a.metadataKey, token)); err != nil {
+		return "", status.Error(codes.Internal, "internal error")
+	}
+
+	a.log.With("userID", user.ID).Info("registered new user")
+
+	return user.ID, nil
+}
+
+// clientIP returns the IP address of the caller, the same way middleware.ClientIP does.
+func (a *Auth) clientIP(ctx context.Context) string {
+	if a.trustRealIP {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("x-real-ip"); len(values) > 0 {
+				if ip := net.ParseIP(values[0]); ip != nil {
+					return ip.String()
+				}
+			}
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// isRevoked reports whether the token is in the revocation list.
+//
+// Tokens issued before token IDs were introduced can not be revoked.
+func (a *Auth) isRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	if claims.ID == "" {
+		return false, nil
+	}
+
+	return a.store.IsTokenRevoked(ctx, claims.ID)
+}
+
+func (a *Auth) unauthenticated(err error) error {
+	a.log.Debugf("unauthenticated attempt to access private API: %s", err)
+	return status.Error(codes.Unauthenticated, "unauthenticated")
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context with authenticated user ID.
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
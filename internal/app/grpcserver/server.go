@@ -0,0 +1,116 @@
+// Package grpcserver implements a gRPC server which mirrors the REST API.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/handlers"
+	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	pb "github.com/madatsci/urlshortener/pkg/api/shortener"
+	"github.com/madatsci/urlshortener/pkg/jwt"
+)
+
+// Server is the gRPC server for the URL shortener service.
+//
+// Use New to create and configure a server instance, then call Start to begin
+// handling requests and Shutdown to stop it gracefully.
+type Server struct {
+	pb.UnimplementedShortenerServer
+
+	srv    *grpc.Server
+	config *config.Config
+	h      *handlers.Handlers
+	s      store.Store
+	log    *zap.SugaredLogger
+}
+
+// New creates a new gRPC server.
+//
+// It shares the handlers h with the HTTP server, so that both servers use
+// the same storage and the same queue for asynchronous deletion. Tokens are
+// issued and verified by tokens, which should be shared with the HTTP server too.
+// Registrations of new users are limited by limiter shared with the HTTP server,
+// nil if rate limits are disabled.
+func New(config *config.Config, h *handlers.Handlers, tokens *jwt.JWT, limiter *middleware.RateLimiter, logger *zap.SugaredLogger) *Server {
+	server := &Server{
+		config: config,
+		h:      h,
+		s:      h.Store(),
+		log:    logger,
+	}
+
+	auth := NewAuth(AuthOptions{
+		JWT:         tokens,
+		Store:       server.s,
+		RateLimiter: limiter,
+		TrustRealIP: config.RateLimit.TrustRealIP,
+		Log:         logger,
+		Scopes: map[string]Scope{
+			pb.Shortener_Shorten_FullMethodName:        ScopePublic,
+			pb.Shortener_ShortenBatch_FullMethodName:   ScopePublic,
+			pb.Shortener_ListUserURLs_FullMethodName:   ScopePublic,
+			pb.Shortener_DeleteUserURLs_FullMethodName: ScopePrivate,
+			pb.Shortener_GetURLStats_FullMethodName:    ScopePrivate,
+		},
+	})
+
+	server.srv = grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryInterceptor),
+		grpc.StreamInterceptor(auth.StreamInterceptor),
+	)
+	pb.RegisterShortenerServer(server.srv, server)
+
+	return server
+}
+
+// Start starts the server after it was created and configured.
+//
+// It blocks until the server is stopped. After Shutdown is called
+// Start returns nil.
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.config.Server.GRPCAddr)
+	if err != nil {
+		return err
+	}
+
+	s.log.Infof("starting gRPC server on %s", s.config.Server.GRPCAddr)
+
+	return s.Serve(lis)
+}
+
+// Serve accepts incoming connections on the listener lis.
+func (s *Server) Serve(lis net.Listener) error {
+	err := s.srv.Serve(lis)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops the server.
+//
+// It stops accepting new connections and waits for active RPCs to complete.
+// If ctx is done before that, all connections are closed forcibly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.srv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,278 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/handlers"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
+	"github.com/madatsci/urlshortener/internal/app/screening"
+	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	pb "github.com/madatsci/urlshortener/pkg/api/shortener"
+	"github.com/madatsci/urlshortener/pkg/jwt"
+)
+
+func TestShorten(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	t.Run("positive case", func(t *testing.T) {
+		var header metadata.MD
+		resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru/"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Contains(t, resp.GetResult(), "http://localhost:8080/")
+		assert.NotEmpty(t, header.Get(DefaultMetadataKey))
+	})
+
+	t.Run("negative case: empty URL", func(t *testing.T) {
+		_, err := client.Shorten(ctx, &pb.ShortenRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("negative case: invalid URL", func(t *testing.T) {
+		_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "javascript:alert(1)"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("negative case: malicious URL", func(t *testing.T) {
+		_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://malware.example/download"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("negative case: invalid token of unregistered user", func(t *testing.T) {
+		// Invalid tokens are replaced with a new one in the public scope.
+		md := metadata.Pairs(DefaultMetadataKey, "invalid")
+		_, err := client.Shorten(metadata.NewOutgoingContext(ctx, md), &pb.ShortenRequest{Url: "http://example.org"})
+		require.NoError(t, err)
+	})
+}
+
+func TestShortenBatch(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	resp, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{
+		Urls: []*pb.ShortenBatchRequest_Item{
+			{CorrelationId: "mC9g8iasXW", OriginalUrl: "https://practicum-yandex.ru"},
+			{CorrelationId: "XFADu5Xlkw", OriginalUrl: "http://example.org"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(resp.GetUrls()))
+	assert.Equal(t, "mC9g8iasXW", resp.GetUrls()[0].GetCorrelationId())
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{
+		Urls: []*pb.ShortenBatchRequest_Item{{CorrelationId: "mC9g8iasXW"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShortenRedirectOptions(t *testing.T) {
+	st := memory.New()
+	client, stop := testClientWithStore(t, st, nil)
+	defer stop()
+	ctx := context.Background()
+
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{
+		Url:          "https://practicum.yandex.ru/",
+		RedirectType: http.StatusPermanentRedirect,
+		MergeQuery:   true,
+	})
+	require.NoError(t, err)
+
+	url, err := st.GetURL(ctx, strings.TrimPrefix(resp.GetResult(), "http://localhost:8080/"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPermanentRedirect, url.RedirectType)
+	assert.True(t, url.MergeQuery)
+
+	batch, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{
+		Urls: []*pb.ShortenBatchRequest_Item{
+			{CorrelationId: "mC9g8iasXW", OriginalUrl: "http://example.org", RedirectType: http.StatusFound, MergeQuery: true},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, batch.GetUrls(), 1)
+
+	url, err = st.GetURL(ctx, strings.TrimPrefix(batch.GetUrls()[0].GetShortUrl(), "http://localhost:8080/"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, url.RedirectType)
+	assert.True(t, url.MergeQuery)
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/", RedirectType: http.StatusOK})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{
+		Urls: []*pb.ShortenBatchRequest_Item{
+			{CorrelationId: "XFADu5Xlkw", OriginalUrl: "https://example.com/", RedirectType: http.StatusOK},
+		},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestExpand(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	longURL := "https://practicum.yandex.ru/"
+	created, err := client.Shorten(ctx, &pb.ShortenRequest{Url: longURL})
+	require.NoError(t, err)
+
+	slug := created.GetResult()[len("http://localhost:8080/"):]
+	resp, err := client.Expand(ctx, &pb.ExpandRequest{Slug: slug})
+	require.NoError(t, err)
+	assert.Equal(t, longURL, resp.GetOriginalUrl())
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Slug: "wrongURL"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUserURLs(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	_, err := client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{Slugs: []string{"shortURL"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	var header metadata.MD
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "http://example.org/1"}, grpc.Header(&header))
+	require.NoError(t, err)
+	token := header.Get(DefaultMetadataKey)
+	require.NotEmpty(t, token)
+
+	authCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs(DefaultMetadataKey, token[0]))
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "http://example.org/2"})
+	require.NoError(t, err)
+
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(list.GetUrls()))
+
+	_, err = client.DeleteUserURLs(authCtx, &pb.DeleteUserURLsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.DeleteUserURLs(authCtx, &pb.DeleteUserURLsRequest{Slugs: []string{"shortURL"}})
+	require.NoError(t, err)
+}
+
+func TestGetURLStats(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	_, err := client.GetURLStats(ctx, &pb.GetURLStatsRequest{Slug: "shortURL"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	var header metadata.MD
+	created, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://example.org"}, grpc.Header(&header))
+	require.NoError(t, err)
+	token := header.Get(DefaultMetadataKey)
+	require.NotEmpty(t, token)
+
+	authCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs(DefaultMetadataKey, token[0]))
+	slug := created.GetResult()[len("http://localhost:8080/"):]
+	resp, err := client.GetURLStats(authCtx, &pb.GetURLStatsRequest{Slug: slug})
+	require.NoError(t, err)
+	assert.Equal(t, slug, resp.GetSlug())
+	assert.Equal(t, int64(0), resp.GetTotalClicks())
+
+	_, err = client.GetURLStats(authCtx, &pb.GetURLStatsRequest{Slug: "wrongURL"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestRegistrationRateLimit(t *testing.T) {
+	limit, err := ratelimit.ParseLimit("1/h")
+	require.NoError(t, err)
+	limiter := middleware.NewRateLimiter(middleware.RateLimiterOptions{
+		Backend: ratelimit.NewMemory(),
+		Limits:  map[string]ratelimit.Limit{middleware.RateLimitRegister: limit},
+		Log:     zap.NewNop().Sugar(),
+	})
+	client, stop := testClientWithStore(t, memory.New(), limiter)
+	defer stop()
+
+	var header metadata.MD
+	_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://practicum.yandex.ru/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	authToken := header.Get(DefaultMetadataKey)[0]
+
+	header = nil
+	_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://practicum.yandex.ru/1"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, header.Get("retry-after"))
+	assert.Empty(t, header.Get(DefaultMetadataKey), "user is not registered")
+
+	// Registered users are not limited.
+	authCtx := metadata.AppendToOutgoingContext(context.Background(), DefaultMetadataKey, authToken)
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru/2"})
+	require.NoError(t, err)
+}
+
+func TestPing(t *testing.T) {
+	client, stop := testClient(t)
+	defer stop()
+
+	_, err := client.Ping(context.Background(), &pb.PingRequest{})
+	require.NoError(t, err)
+}
+
+func testClient(t *testing.T) (pb.ShortenerClient, func()) {
+	return testClientWithStore(t, memory.New(), nil)
+}
+
+func testClientWithStore(t *testing.T, st store.Store, limiter *middleware.RateLimiter) (pb.ShortenerClient, func()) {
+	checker := screening.NewFake()
+	checker.Add("malware.example", "malware")
+
+	config := &config.Config{
+		Server: config.ServerConfig{BaseURL: "http://localhost:8080"},
+		Auth: config.AuthConfig{
+			TokenSecret:   "super_secret",
+			TokenDuration: config.Duration{Duration: time.Hour},
+			TokenIssuer:   "urlshortener_test",
+		},
+	}
+	logger := zap.NewNop().Sugar()
+	h := handlers.New(config, logger, st, handlers.Options{Checker: checker})
+	s := New(config, h, jwt.New(config.TokenOptions()), limiter, logger)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis) //nolint:errcheck
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	return pb.NewShortenerClient(conn), func() {
+		conn.Close()
+		s.srv.Stop()
+	}
+}
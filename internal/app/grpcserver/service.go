@@ -0,0 +1,281 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/madatsci/urlshortener/internal/app/deleter"
+	"github.com/madatsci/urlshortener/internal/app/handlers"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	pb "github.com/madatsci/urlshortener/pkg/api/shortener"
+)
+
+// Shorten creates a short URL.
+//
+// If the URL has already been shortened, it returns AlreadyExists status
+// with the existing short URL attached as *pb.ShortenResponse detail.
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	userID, err := ensureUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetUrl() == "" {
+		return nil, status.Error(codes.InvalidArgument, "url is required")
+	}
+
+	expiresAt, ttl := expiration(req.GetExpiresAt(), req.GetTtl())
+	shortURL, err := s.h.ShortenURL(ctx, userID, models.ShortenRequest{
+		URL:          req.GetUrl(),
+		Alias:        req.GetAlias(),
+		ExpiresAt:    expiresAt,
+		TTL:          ttl,
+		RedirectType: int(req.GetRedirectType()),
+		MergeQuery:   req.GetMergeQuery(),
+	})
+	if err != nil {
+		if validationErr := validationStatus(err); validationErr != nil {
+			return nil, validationErr
+		}
+
+		var alreadyExists *store.AlreadyExistsError
+		if errors.As(err, &alreadyExists) {
+			st := status.New(codes.AlreadyExists, "url has already been shortened")
+			st, detailsErr := st.WithDetails(&pb.ShortenResponse{Result: s.h.ShortURL(alreadyExists.URL.Slug)})
+			if detailsErr != nil {
+				return nil, s.internalError("Shorten", detailsErr)
+			}
+			return nil, st.Err()
+		}
+		return nil, s.internalError("Shorten", err)
+	}
+
+	s.log.With("userID", userID).Info("new URL created")
+
+	return &pb.ShortenResponse{Result: shortURL}, nil
+}
+
+// ShortenBatch creates a batch of short URLs.
+//
+// If any of the URLs has already been shortened, it returns AlreadyExists status and creates nothing.
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	userID, err := ensureUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.GetUrls()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "urls are required")
+	}
+
+	items := make([]models.ShortenBatchRequestItem, 0, len(req.GetUrls()))
+	for _, item := range req.GetUrls() {
+		if item.GetOriginalUrl() == "" {
+			return nil, status.Error(codes.InvalidArgument, "original_url is required")
+		}
+		expiresAt, ttl := expiration(item.GetExpiresAt(), item.GetTtl())
+		items = append(items, models.ShortenBatchRequestItem{
+			CorrelationID: item.GetCorrelationId(),
+			OriginalURL:   item.GetOriginalUrl(),
+			Alias:         item.GetAlias(),
+			ExpiresAt:     expiresAt,
+			TTL:           ttl,
+			RedirectType:  int(item.GetRedirectType()),
+			MergeQuery:    item.GetMergeQuery(),
+		})
+	}
+
+	created, err := s.h.ShortenURLs(ctx, userID, items)
+	if err != nil {
+		if validationErr := validationStatus(err); validationErr != nil {
+			return nil, validationErr
+		}
+		var alreadyExists *store.AlreadyExistsError
+		if errors.As(err, &alreadyExists) {
+			return nil, status.Errorf(codes.AlreadyExists, "url %s has already been shortened", alreadyExists.URL.Original)
+		}
+		return nil, s.internalError("ShortenBatch", err)
+	}
+
+	s.log.With("userID", userID, "count", len(created)).Info("new URLs created via batch request")
+
+	res := &pb.ShortenBatchResponse{Urls: make([]*pb.ShortenBatchResponse_Item, 0, len(created))}
+	for _, item := range created {
+		res.Urls = append(res.Urls, &pb.ShortenBatchResponse_Item{
+			CorrelationId: item.CorrelationID,
+			ShortUrl:      item.ShortURL,
+		})
+	}
+
+	return res, nil
+}
+
+// Expand returns the original URL by its slug.
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	url, err := s.s.GetURL(ctx, req.GetSlug())
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "url not found")
+		}
+		return nil, s.internalError("Expand", err)
+	}
+	if url.Deleted {
+		return nil, status.Error(codes.NotFound, "url has been deleted")
+	}
+	if url.Blocked {
+		return nil, status.Error(codes.NotFound, "url has been blocked")
+	}
+	if url.Expired(time.Now()) {
+		return nil, status.Error(codes.NotFound, "url has expired")
+	}
+
+	return &pb.ExpandResponse{OriginalUrl: url.Original}, nil
+}
+
+// ListUserURLs returns all URLs created by the authenticated user.
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID, err := ensureUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	urls, err := s.s.ListURLsByUserID(ctx, userID)
+	if err != nil {
+		return nil, s.internalError("ListUserURLs", err)
+	}
+
+	res := &pb.ListUserURLsResponse{Urls: make([]*pb.ListUserURLsResponse_Item, 0, len(urls))}
+	for _, url := range urls {
+		item := &pb.ListUserURLsResponse_Item{
+			ShortUrl:    s.h.ShortURL(url.Slug),
+			OriginalUrl: url.Original,
+		}
+		if url.ExpiresAt != nil {
+			item.ExpiresAt = timestamppb.New(*url.ExpiresAt)
+		}
+		res.Urls = append(res.Urls, item)
+	}
+
+	return res, nil
+}
+
+// DeleteUserURLs queues URLs created by the authenticated user for deletion.
+//
+// It returns codes.Unavailable if there are too many pending delete requests.
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	userID, err := ensureUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.GetSlugs()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "slugs are required")
+	}
+
+	if _, err := s.h.EnqueueDelete(ctx, userID, req.GetSlugs()); err != nil {
+		if errors.Is(err, deleter.ErrQueueFull) {
+			return nil, status.Error(codes.Unavailable, "too many pending delete requests, try again later")
+		}
+		return nil, s.internalError("DeleteUserURLs", err)
+	}
+
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// GetURLStats returns click statistics of the URL created by the authenticated user.
+func (s *Server) GetURLStats(ctx context.Context, req *pb.GetURLStatsRequest) (*pb.GetURLStatsResponse, error) {
+	userID, err := ensureUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.s.GetURLStats(ctx, userID, req.GetSlug())
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "url not found")
+		}
+		return nil, s.internalError("GetURLStats", err)
+	}
+
+	res := &pb.GetURLStatsResponse{
+		Slug:           stats.Slug,
+		TotalClicks:    int64(stats.TotalClicks),
+		UniqueVisitors: int64(stats.UniqueVisitors),
+		Daily:          make([]*pb.GetURLStatsResponse_Day, 0, len(stats.Daily)),
+	}
+	for _, day := range stats.Daily {
+		res.Daily = append(res.Daily, &pb.GetURLStatsResponse_Day{
+			Date:           day.Date,
+			Clicks:         int64(day.Clicks),
+			UniqueVisitors: int64(day.UniqueVisitors),
+		})
+	}
+
+	return res, nil
+}
+
+// Ping is a storage health-check.
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.s.Ping(ctx); err != nil {
+		return nil, s.internalError("Ping", err)
+	}
+
+	return &pb.PingResponse{}, nil
+}
+
+func (s *Server) internalError(method string, err error) error {
+	s.log.Errorln("error handling request", "method", method, "err", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// validationStatus converts errors caused by an invalid or blocked URL, a custom alias,
+// expiration parameters or a redirect type into gRPC status. It returns nil for other errors.
+func validationStatus(err error) error {
+	if errors.Is(err, handlers.ErrBlockedURL) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, handlers.ErrInvalidURL) || errors.Is(err, handlers.ErrInvalidAlias) ||
+		errors.Is(err, handlers.ErrInvalidExpiration) || errors.Is(err, handlers.ErrInvalidRedirectType) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var slugExists *store.SlugExistsError
+	if errors.As(err, &slugExists) {
+		return status.Errorf(codes.AlreadyExists, "alias %s is already taken", slugExists.Slug)
+	}
+
+	return nil
+}
+
+// expiration converts protobuf expiration parameters into the ones of REST API.
+func expiration(ts *timestamppb.Timestamp, d *durationpb.Duration) (*time.Time, string) {
+	var expiresAt *time.Time
+	if ts != nil {
+		t := ts.AsTime()
+		expiresAt = &t
+	}
+
+	var ttl string
+	if d != nil {
+		ttl = d.AsDuration().String()
+	}
+
+	return expiresAt, ttl
+}
+
+func ensureUserID(ctx context.Context) (string, error) {
+	userID, ok := ctx.Value(middleware.AuthenticatedUserKey).(string)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "authenticated user is required")
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+	aliasCharset   = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_"
+)
+
+// ErrInvalidAlias is returned when a custom alias does not pass validation.
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// reservedAliases are the words which can not be used as aliases
+// because they clash with API routes or may do so in the future.
+var reservedAliases = map[string]struct{}{
+	"admin":    {},
+	"api":      {},
+	"debug":    {},
+	"internal": {},
+	"metrics":  {},
+	"ping":     {},
+}
+
+// ValidateAlias checks that a custom alias can be used as a slug.
+//
+// The returned error is *ValidationError which wraps ErrInvalidAlias and describes the reason.
+func ValidateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return aliasError(fmt.Sprintf("length must be from %d to %d characters", aliasMinLength, aliasMaxLength))
+	}
+
+	for _, c := range alias {
+		if !strings.ContainsRune(aliasCharset, c) {
+			return aliasError("only latin letters, digits, '-' and '_' are allowed")
+		}
+	}
+
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return aliasError(alias + " is a reserved word")
+	}
+
+	return nil
+}
+
+func aliasError(reason string) error {
+	return &ValidationError{Field: "/alias", Reason: reason, Err: ErrInvalidAlias}
+}
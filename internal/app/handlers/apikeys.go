@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/madatsci/urlshortener/internal/app/apikey"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+// maxAPIKeyNameLength is the maximum length of an API key name in characters.
+const maxAPIKeyNameLength = 64
+
+// CreateAPIKeyHandler handles creating a new API key of the authorized user.
+//
+// The key itself is returned only in this response, only its hash is stored.
+func (h *Handlers) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "CreateAPIKeyHandler", err)
+		return
+	}
+
+	var request models.CreateAPIKeyRequest
+	dec := json.NewDecoder(r.Body)
+	if err = dec.Decode(&request); err != nil {
+		h.writeError(w, r, "CreateAPIKeyHandler", invalidJSON(err))
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		h.writeError(w, r, "CreateAPIKeyHandler", requiredField("/name"))
+		return
+	}
+	if utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		h.writeError(w, r, "CreateAPIKeyHandler", problem.Validation(problem.CodeInvalidRequest, "api key name is too long", models.FieldError{
+			Field:   "/name",
+			Message: "must be at most 64 characters long",
+		}))
+		return
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		h.writeError(w, r, "CreateAPIKeyHandler", err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    apikey.DisplayPrefix(key),
+		Hash:      apikey.Hash(key),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = h.s.CreateAPIKey(r.Context(), apiKey); err != nil {
+		h.writeError(w, r, "CreateAPIKeyHandler", err)
+		return
+	}
+
+	h.log.With("userID", userID, "keyID", apiKey.ID).Info("new API key created")
+
+	h.writeJSON(w, "CreateAPIKeyHandler", http.StatusCreated, models.CreateAPIKeyResponse{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Key:       key,
+		Prefix:    apiKey.Prefix,
+		CreatedAt: apiKey.CreatedAt,
+	})
+}
+
+// ListAPIKeysHandler handles retrieving all API keys of the authorized user.
+func (h *Handlers) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "ListAPIKeysHandler", err)
+		return
+	}
+
+	keys, err := h.s.ListAPIKeysByUserID(r.Context(), userID)
+	if err != nil {
+		h.writeError(w, r, "ListAPIKeysHandler", err)
+		return
+	}
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	items := make([]models.APIKeyItem, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, models.APIKeyItem{
+			ID:         key.ID,
+			Name:       key.Name,
+			Prefix:     key.Prefix,
+			CreatedAt:  key.CreatedAt,
+			LastUsedAt: key.LastUsedAt,
+		})
+	}
+
+	h.writeJSON(w, "ListAPIKeysHandler", http.StatusOK, items)
+}
+
+// RevokeAPIKeyHandler handles revoking the API key of the authorized user.
+func (h *Handlers) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "RevokeAPIKeyHandler", err)
+		return
+	}
+
+	keyID := chi.URLParam(r, "id")
+	if uuid.Validate(keyID) != nil {
+		h.writeError(w, r, "RevokeAPIKeyHandler", problem.NotFound("api key not found"))
+		return
+	}
+
+	if err = h.s.RevokeAPIKey(r.Context(), userID, keyID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			err = problem.NotFound("api key not found")
+		}
+		h.writeError(w, r, "RevokeAPIKeyHandler", err)
+		return
+	}
+
+	h.log.With("userID", userID, "keyID", keyID).Info("API key revoked")
+
+	w.WriteHeader(http.StatusNoContent)
+}
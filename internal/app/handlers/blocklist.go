@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+)
+
+// blocklistPageSize is the number of stored URLs checked at once when the blocklist is applied.
+const blocklistPageSize = 1000
+
+// ApplyBlocklistHandler handles blocking of stored URLs which match the blocklist.
+//
+// It is used after the blocklist has been extended, so that links shortened before
+// stop redirecting. Blocked links respond with 451 Unavailable For Legal Reasons.
+func (h *Handlers) ApplyBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	if h.blocklist == nil {
+		h.writeError(w, r, "ApplyBlocklistHandler", problem.Unavailable("blocklist is not configured"))
+		return
+	}
+
+	res, err := h.ApplyBlocklist(r.Context())
+	if err != nil {
+		h.writeError(w, r, "ApplyBlocklistHandler", err)
+		return
+	}
+
+	h.log.With("checked", res.Checked, "blocked", res.Blocked).Info("blocklist applied to stored urls")
+
+	h.writeJSON(w, "ApplyBlocklistHandler", http.StatusOK, res)
+}
+
+// ApplyBlocklist checks all stored URLs against the local blocklist and blocks the ones which match.
+//
+// Only the blocklist is used: checking every stored URL with a remote service would be too slow.
+// URLs which are already blocked are skipped.
+func (h *Handlers) ApplyBlocklist(ctx context.Context) (models.ApplyBlocklistResponse, error) {
+	var res models.ApplyBlocklistResponse
+
+	var after string
+	for {
+		urls, err := h.s.ScanURLs(ctx, after, blocklistPageSize)
+		if err != nil {
+			return res, err
+		}
+		if len(urls) == 0 {
+			return res, nil
+		}
+
+		var slugs []string
+		for _, url := range urls {
+			if url.Blocked {
+				continue
+			}
+			res.Checked++
+
+			verdict, err := h.blocklist.Check(ctx, url.DedupKey())
+			if err != nil {
+				return res, err
+			}
+			if verdict.Blocked {
+				slugs = append(slugs, url.Slug)
+			}
+		}
+
+		if len(slugs) > 0 {
+			if err := h.s.BlockURLs(ctx, slugs); err != nil {
+				return res, err
+			}
+			res.Blocked += len(slugs)
+		}
+
+		after = urls[len(urls)-1].Slug
+	}
+}
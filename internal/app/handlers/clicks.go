@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+)
+
+const (
+	// clickQueueSize is the capacity of the click queue. When the queue is full,
+	// clicks are dropped rather than delaying redirects.
+	clickQueueSize = 4096
+	// clickBatchSize is the number of clicks after which the batch is written without waiting for the ticker.
+	clickBatchSize = 100
+	// clickFlushInterval is the period after which an incomplete batch of clicks is written.
+	clickFlushInterval = 5 * time.Second
+)
+
+// recordClick puts the click on the URL to the queue for asynchronous saving.
+func (h *Handlers) recordClick(r *http.Request, url models.URL) {
+	click := models.Click{
+		ID:        uuid.NewString(),
+		URLID:     url.ID,
+		Slug:      url.Slug,
+		CreatedAt: time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    h.hashIP(middleware.ClientIP(r, h.c.RateLimit.TrustRealIP)),
+	}
+
+	h.clickMu.RLock()
+	defer h.clickMu.RUnlock()
+	if h.clicksClosed {
+		return
+	}
+
+	select {
+	case h.clickChan <- click:
+		metrics.QueueDepth.WithLabelValues(clickQueue).Set(float64(len(h.clickChan)))
+	default:
+		h.log.With("slug", url.Slug).Warn("click queue is full, click is dropped")
+	}
+}
+
+// hashIP returns a keyed hash of the IP address, so that unique visitors
+// can be counted without storing their addresses.
+func (h *Handlers) hashIP(ip string) string {
+	mac := hmac.New(sha256.New, h.ipHashSalt)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handlers) flushClicks(ctx context.Context) {
+	defer close(h.clickDone)
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	var clicks []models.Click
+
+	for {
+		select {
+		case click, ok := <-h.clickChan:
+			if !ok {
+				h.saveClicks(ctx, clicks)
+				return
+			}
+			clicks = append(clicks, click)
+			metrics.QueueDepth.WithLabelValues(clickQueue).Set(float64(len(h.clickChan)))
+			if len(clicks) >= clickBatchSize {
+				h.saveClicks(ctx, clicks)
+				clicks = nil
+			}
+		case <-ticker.C:
+			h.saveClicks(ctx, clicks)
+			clicks = nil
+		}
+	}
+}
+
+func (h *Handlers) saveClicks(ctx context.Context, clicks []models.Click) {
+	if len(clicks) == 0 {
+		return
+	}
+
+	if err := h.s.CreateClicks(ctx, clicks); err != nil {
+		h.log.Errorln("error saving clicks", "count", len(clicks), "err", err)
+		return
+	}
+
+	h.log.With("count", len(clicks)).Debug("saved clicks")
+}
+
+// newIPHashSalt returns the configured salt of IP hashes or a random one if it is not configured.
+func newIPHashSalt(c *config.Config, log *zap.SugaredLogger) []byte {
+	if c.Stats.IPHashSalt != "" {
+		return []byte(c.Stats.IPHashSalt)
+	}
+
+	log.Warn("ip hash salt is not configured, unique visitors are counted again after restart")
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		// crypto/rand does not fail on supported platforms.
+		panic(err)
+	}
+
+	return salt
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/madatsci/urlshortener/internal/app/deleter"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+const (
+	// deleteJobPath is the path of the status of a delete job without the job ID.
+	deleteJobPath = "/api/user/deletions/"
+	// deleteRetryAfter is the value of Retry-After header in seconds when the delete queue is full.
+	deleteRetryAfter = "5"
+)
+
+// DeleteUserURLsHandler accepts a job of deleting URLs with specified slugs created by the authorized user.
+//
+// The job is executed asynchronously, its status is available at the URL of the Location header.
+// If there are too many pending jobs, it responds with 503 Service Unavailable.
+func (h *Handlers) DeleteUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "DeleteUserURLsHandler", err)
+		return
+	}
+
+	h.log.With("userID", userID).Debug("deleting user urls")
+
+	var request models.DeleteByUserIDRequest
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&request); err != nil {
+		h.writeError(w, r, "DeleteUserURLsHandler", invalidJSON(err))
+		return
+	}
+
+	if len(request.Slugs) == 0 {
+		h.writeError(w, r, "DeleteUserURLsHandler", problem.Validation(problem.CodeInvalidRequest, "at least one slug is required"))
+		return
+	}
+
+	job, err := h.EnqueueDelete(r.Context(), userID, request.Slugs)
+	if err != nil {
+		if errors.Is(err, deleter.ErrQueueFull) {
+			w.Header().Set("Retry-After", deleteRetryAfter)
+			err = problem.Unavailable("too many pending delete requests, try again later")
+		}
+		h.writeError(w, r, "DeleteUserURLsHandler", err)
+		return
+	}
+
+	w.Header().Set("Location", deleteJobPath+job.ID)
+	h.writeJSON(w, "DeleteUserURLsHandler", http.StatusAccepted, newDeleteJobResponse(job))
+}
+
+// DeleteJobHandler handles retrieving the status of a job of deleting URLs of the authorized user.
+func (h *Handlers) DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "DeleteJobHandler", err)
+		return
+	}
+
+	job, err := h.deletes.Job(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			err = problem.NotFound("delete job not found")
+		}
+		h.writeError(w, r, "DeleteJobHandler", err)
+		return
+	}
+
+	h.writeJSON(w, "DeleteJobHandler", http.StatusOK, newDeleteJobResponse(job))
+}
+
+// EnqueueDelete saves a job of deleting the user's URLs for asynchronous execution.
+//
+// It returns deleter.ErrQueueFull if there are too many pending jobs.
+func (h *Handlers) EnqueueDelete(ctx context.Context, userID string, slugs []string) (models.DeleteJob, error) {
+	return h.deletes.Enqueue(ctx, userID, slugs)
+}
+
+func newDeleteJobResponse(job models.DeleteJob) *models.DeleteJobResponse {
+	return &models.DeleteJobResponse{
+		ID:        job.ID,
+		Status:    string(job.Status),
+		Slugs:     job.Slugs,
+		Attempts:  job.Attempts,
+		Error:     job.LastError,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+// Error codes of REST API in addition to the generic ones of package problem.
+const (
+	codeInvalidJSON         = "invalid_json"
+	codeInvalidCSV          = "invalid_csv"
+	codeInvalidURL          = "invalid_url"
+	codeBlockedURL          = "blocked_url"
+	codeInvalidAlias        = "invalid_alias"
+	codeInvalidExpiration   = "invalid_expiration"
+	codeInvalidRedirectType = "invalid_redirect_type"
+	codeAliasTaken          = "alias_taken"
+	codeAlreadyShortened    = "already_shortened"
+	codeDeleted             = "deleted"
+	codeExpired             = "expired"
+	codeBlocked             = "blocked"
+	// codeUnsupportedMediaType is returned when the import stream is neither CSV nor NDJSON.
+	codeUnsupportedMediaType = "unsupported_media_type"
+)
+
+// ValidationError describes an invalid field of the shortening request.
+//
+// It wraps ErrInvalidURL, ErrBlockedURL, ErrInvalidAlias, ErrInvalidExpiration
+// or ErrInvalidRedirectType.
+type ValidationError struct {
+	// Field is a JSON pointer to the invalid field, e.g. "/alias".
+	Field  string
+	Reason string
+	Err    error
+}
+
+// Error implements error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+// Unwrap returns ErrInvalidURL, ErrBlockedURL, ErrInvalidAlias, ErrInvalidExpiration
+// or ErrInvalidRedirectType.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// toProblem converts errors returned by ShortenURL and ShortenURLs into *problem.Error.
+//
+// *problem.Error is returned as is, unknown errors become internal errors.
+func (h *Handlers) toProblem(err error) *problem.Error {
+	var validationErr *ValidationError
+	var slugExists *store.SlugExistsError
+	var alreadyExists *store.AlreadyExistsError
+
+	switch {
+	case errors.As(err, &validationErr) && errors.Is(err, ErrBlockedURL):
+		p := problem.New(http.StatusUnprocessableEntity, codeBlockedURL, "url is not allowed")
+		p.Errors = []models.FieldError{{Field: validationErr.Field, Message: validationErr.Reason}}
+		return p
+	case errors.As(err, &validationErr):
+		code := codeInvalidAlias
+		switch {
+		case errors.Is(err, ErrInvalidURL):
+			code = codeInvalidURL
+		case errors.Is(err, ErrInvalidExpiration):
+			code = codeInvalidExpiration
+		case errors.Is(err, ErrInvalidRedirectType):
+			code = codeInvalidRedirectType
+		}
+		return problem.Validation(code, err.Error(), models.FieldError{
+			Field:   validationErr.Field,
+			Message: validationErr.Reason,
+		})
+	case errors.Is(err, ErrInvalidAlias):
+		return problem.Validation(codeInvalidAlias, err.Error())
+	case errors.As(err, &slugExists):
+		p := problem.Conflict(codeAliasTaken, "alias is already taken")
+		p.Alias = slugExists.Slug
+		return p
+	case errors.As(err, &alreadyExists):
+		p := problem.Conflict(codeAlreadyShortened, "url has already been shortened")
+		p.Result = h.ShortURL(alreadyExists.URL.Slug)
+		return p
+	}
+
+	return problem.From(err)
+}
+
+// writeError writes err as problem details and logs it.
+//
+// Server errors are logged with the cause, client errors are logged at debug level.
+func (h *Handlers) writeError(w http.ResponseWriter, r *http.Request, method string, err error) {
+	p := problem.From(err)
+	if p.Status >= http.StatusInternalServerError {
+		h.log.Errorln("error handling request", "method", method, "err", err)
+	} else {
+		h.log.Debugw("rejected request", "method", method, "err", err)
+	}
+
+	if err := problem.Write(w, r, p); err != nil {
+		h.log.Errorln("error writing response", "method", method, "err", err)
+	}
+}
+
+// writeJSON writes a successful JSON response.
+func (h *Handlers) writeJSON(w http.ResponseWriter, method string, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Errorln("error writing response", "method", method, "err", err)
+	}
+}
+
+// invalidJSON creates an error of the request body which can not be decoded.
+func invalidJSON(err error) *problem.Error {
+	return &problem.Error{
+		Status: http.StatusBadRequest,
+		Code:   codeInvalidJSON,
+		Detail: "request body is not valid JSON",
+		Err:    err,
+	}
+}
+
+// requiredField creates a validation error of the missing field.
+func requiredField(field string) *problem.Error {
+	return problem.Validation(problem.CodeInvalidRequest, "required field is missing", models.FieldError{
+		Field:   field,
+		Message: "is required",
+	})
+}
+
+// setItemError marks the i-th item of a batch response as invalid and describes err
+// like the problem details of a request with the single item.
+func (h *Handlers) setItemError(item *models.ShortenBatchResponseItem, err error, i int) {
+	p := h.toProblem(itemError(err, i))
+
+	var slugExists *store.SlugExistsError
+	if errors.As(err, &slugExists) {
+		p.Errors = []models.FieldError{{Field: fmt.Sprintf("/%d/alias", i), Message: "is already taken"}}
+	}
+
+	item.Status = models.BatchItemInvalid
+	item.Code = p.Code
+	item.Detail = p.Detail
+	item.Errors = p.Errors
+}
+
+// itemError makes the field of *ValidationError point to the i-th item of the batch request.
+func itemError(err error, i int) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		validationErr.Field = fmt.Sprintf("/%d%s", i, validationErr.Field)
+	}
+
+	return err
+}
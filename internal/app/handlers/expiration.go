@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidExpiration is returned when the URL expiration parameters do not pass validation.
+var ErrInvalidExpiration = errors.New("invalid expiration")
+
+// ExpirationTime calculates the URL expiration time from either the absolute
+// time expiresAt or the lifetime ttl, which is a Golang duration string.
+//
+// It returns nil if neither is set, which means that the URL never expires.
+// The returned error is *ValidationError which wraps ErrInvalidExpiration and describes the reason.
+func ExpirationTime(expiresAt *time.Time, ttl string, now time.Time) (*time.Time, error) {
+	if expiresAt != nil && ttl != "" {
+		return nil, expirationError("/ttl", "expires_at and ttl can not be used together")
+	}
+
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, expirationError("/ttl", fmt.Sprintf("ttl %q is not a valid duration", ttl))
+		}
+		if d <= 0 {
+			return nil, expirationError("/ttl", "ttl must be positive")
+		}
+		t := now.Add(d)
+		return &t, nil
+	}
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, expirationError("/expires_at", "expires_at must be in the future")
+	}
+
+	return expiresAt, nil
+}
+
+func expirationError(field, reason string) error {
+	return &ValidationError{Field: field, Reason: reason, Err: ErrInvalidExpiration}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+)
+
+// exportPageSize is the number of URLs fetched from the storage at once during export.
+const exportPageSize = 1000
+
+// ExportURLsHandler handles exporting URLs of the authorized user as a CSV or NDJSON stream.
+//
+// The format query parameter is csv or ndjson (default). URLs are filtered and sorted
+// by the same query parameters as in GetUserURLsHandler and are fetched from the storage
+// page by page, so that the whole list is never held in memory.
+func (h *Handlers) ExportURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "ExportURLsHandler", err)
+		return
+	}
+
+	params := r.URL.Query()
+	params.Del("limit")
+	query, err := parseURLQuery(params)
+	if err != nil {
+		h.writeError(w, r, "ExportURLsHandler", err)
+		return
+	}
+	query.Limit = exportPageSize
+
+	var (
+		contentType string
+		newWriter   func(io.Writer) recordWriter
+	)
+	switch params.Get("format") {
+	case "", "ndjson":
+		contentType, newWriter = contentTypeNDJSON, newNDJSONWriter
+	case "csv":
+		contentType, newWriter = contentTypeCSV, newCSVWriter
+	default:
+		h.writeError(w, r, "ExportURLsHandler", invalidParam("format", "must be csv or ndjson"))
+		return
+	}
+
+	// The first page is fetched before the response is started, so that
+	// a storage error can still be reported as problem details.
+	urls, err := h.s.ListUserURLs(r.Context(), userID, query)
+	if err != nil {
+		h.writeError(w, r, "ExportURLsHandler", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	rw := newWriter(w)
+	var count int
+	for {
+		for _, url := range urls {
+			if err = rw.Write(h.exportRecord(url)); err != nil {
+				break
+			}
+			count++
+		}
+		if err != nil || len(urls) < query.Limit {
+			break
+		}
+
+		query.After = models.CursorOf(urls[len(urls)-1])
+		urls, err = h.s.ListUserURLs(r.Context(), userID, query)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = rw.Flush()
+	}
+
+	if err != nil {
+		h.log.Errorln("error exporting urls", "userID", userID, "exported", count, "err", err)
+		// The response has been started, so the connection is aborted
+		// to let the client know that the export is incomplete.
+		panic(http.ErrAbortHandler)
+	}
+
+	h.log.With("userID", userID, "count", count).Info("urls exported")
+}
+
+// exportRecord returns the record of the exported URL.
+func (h *Handlers) exportRecord(url models.URL) models.URLRecord {
+	createdAt := url.CreatedAt
+	return models.URLRecord{
+		OriginalURL: url.Original,
+		Alias:       url.Slug,
+		ShortURL:    h.ShortURL(url.Slug),
+		CreatedAt:   &createdAt,
+		ExpiresAt:   url.ExpiresAt,
+	}
+}
+
+// recordWriter writes records of an export stream.
+type recordWriter interface {
+	Write(record models.URLRecord) error
+	// Flush writes buffered records.
+	Flush() error
+}
+
+// csvWriter writes records as CSV rows with a header.
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) recordWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+// Write implements recordWriter.
+func (c *csvWriter) Write(record models.URLRecord) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+
+	var expiresAt string
+	if record.ExpiresAt != nil {
+		expiresAt = record.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return c.w.Write([]string{
+		record.OriginalURL,
+		record.Alias,
+		record.ShortURL,
+		record.CreatedAt.Format(time.RFC3339),
+		expiresAt,
+	})
+}
+
+// Flush implements recordWriter. The header is written even if there are no records.
+func (c *csvWriter) Flush() error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+// ndjsonWriter writes records as lines of JSON objects.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) recordWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+// Write implements recordWriter.
+func (n *ndjsonWriter) Write(record models.URLRecord) error {
+	return n.enc.Encode(record)
+}
+
+// Flush implements recordWriter. Records are not buffered.
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
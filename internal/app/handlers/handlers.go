@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -15,90 +16,192 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/deleter"
 	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/screening"
 	"github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
 	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/urlnorm"
 	"github.com/madatsci/urlshortener/pkg/random"
 )
 
 // Handlers is a service that provides HTTP handlers for REST API endpoints.
 //
-// It wires storage, configuration, and logger service. It also uses a channel
-// for asynchronous processing requests for deleting URLs.
+// It wires storage, configuration, and logger service. Requests for deleting URLs
+// are executed asynchronously by the durable delete queue, clicks are saved
+// asynchronously through a channel.
 type Handlers struct {
 	s   store.Store
 	c   *config.Config
 	log *zap.SugaredLogger
 
-	delReqChan chan deleteURLRequest
+	norm      *urlnorm.Normalizer
+	screen    screening.Chain
+	blocklist *screening.Blocklist
+	deletes   *deleter.Queue
+
+	// redirectType and redirectMaxAge are the configured ones or the defaults.
+	redirectType   int
+	redirectMaxAge time.Duration
+
+	// ipHashSalt is the key of hashes of client IPs of clicks.
+	ipHashSalt []byte
+	clickChan  chan models.Click
+	clickDone  chan struct{}
+	// cancelClicks cancels saving of the queued clicks when Close gives up waiting for it.
+	cancelClicks context.CancelFunc
+	// clickMu guards clickChan from being closed while a click is sent.
+	clickMu      sync.RWMutex
+	clicksClosed bool
 }
 
-type deleteURLRequest struct {
-	userID string
-	slug   string
+// Options contains dependencies of Handlers which are created on start
+// rather than described by the configuration.
+type Options struct {
+	// Blocklist screens URLs to shorten and stored URLs, nil disables it.
+	Blocklist *screening.Blocklist
+	// Checker is an additional service which detects malicious URLs, e.g. screening.Fake in tests.
+	Checker screening.Checker
 }
 
-const slugLength = 8
+// clickQueue is the name of the click queue used in metrics.
+const clickQueue = "click"
+
+// Modes of batch requests.
+const (
+	batchModeAtomic  = "atomic"
+	batchModePartial = "partial"
+)
+
+const (
+	slugLength = 8
+	// slugAttempts is the number of attempts to generate a unique random slug.
+	slugAttempts = 3
+)
 
 // New creates new Handlers.
-func New(config *config.Config, logger *zap.SugaredLogger, store store.Store) *Handlers {
+func New(config *config.Config, logger *zap.SugaredLogger, store store.Store, opts Options) *Handlers {
 	h := &Handlers{
-		c:          config,
-		s:          store,
-		log:        logger,
-		delReqChan: make(chan deleteURLRequest, 1024),
+		c:   config,
+		s:   store,
+		log: logger,
+		norm: urlnorm.New(urlnorm.Options{
+			MaxLength:     config.URLs.MaxLength,
+			StripTracking: config.URLs.StripTracking,
+		}),
+		screen:         newScreen(opts),
+		blocklist:      opts.Blocklist,
+		redirectType:   config.URLs.RedirectType,
+		redirectMaxAge: config.URLs.RedirectMaxAge.Duration,
+		deletes: deleter.New(store, deleter.Options{
+			MaxPending:  config.Storage.DeleteQueueSize,
+			MaxAttempts: config.Storage.DeleteMaxAttempts,
+		}, logger),
+		ipHashSalt: newIPHashSalt(config, logger),
+		clickChan:  make(chan models.Click, clickQueueSize),
+		clickDone:  make(chan struct{}),
+	}
+
+	if h.redirectType == 0 {
+		h.redirectType = defaultRedirectType
+	}
+	if h.redirectMaxAge <= 0 {
+		h.redirectMaxAge = defaultRedirectMaxAge
+	}
+
+	var clickCtx context.Context
+	clickCtx, h.cancelClicks = context.WithCancel(context.Background())
+
+	h.deletes.Start()
+	go h.flushClicks(clickCtx)
+
+	return h
+}
+
+// newScreen chains the given checkers of URLs to shorten.
+func newScreen(opts Options) screening.Chain {
+	var screen screening.Chain
+	if opts.Blocklist != nil {
+		screen = append(screen, opts.Blocklist)
+	}
+	if opts.Checker != nil {
+		screen = append(screen, opts.Checker)
 	}
 
-	go h.flushDeleteURLRequests(context.TODO())
+	return screen
+}
 
-	return h
+// Close stops accepting delete requests and clicks, executes pending deletions
+// and flushes queued clicks to the storage.
+//
+// It should be called after the HTTP server has stopped serving requests. Requests
+// which are still served, e.g. because the server has not stopped in time, are not
+// able to queue deletions and their clicks are dropped.
+// It blocks until the queues are drained or ctx is done. In the latter case the
+// remaining work is canceled, but Close still returns only when the storage is
+// not used anymore, so that it can be closed.
+func (h *Handlers) Close(ctx context.Context) error {
+	h.clickMu.Lock()
+	if !h.clicksClosed {
+		h.clicksClosed = true
+		close(h.clickChan)
+	}
+	h.clickMu.Unlock()
+
+	deletesErr := h.deletes.Stop(ctx)
+
+	select {
+	case <-h.clickDone:
+		return deletesErr
+	case <-ctx.Done():
+		h.cancelClicks()
+		<-h.clickDone
+		return ctx.Err()
+	}
 }
 
 // AddHandler handles adding a new URL via text/plain request.
+//
+// Successful and conflict responses carry the short URL as text/plain,
+// other errors are written as problem details.
 func (h *Handlers) AddHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := ensureUserID(r)
 	if err != nil {
-		h.log.With("handler", "AddHandler").Error(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandler", err)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		h.writeError(w, r, "AddHandler", err)
+		return
 	}
 	url := string(body)
 	if url == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, r, "AddHandler", problem.Validation(problem.CodeInvalidRequest, "request body must contain URL"))
 		return
 	}
 
-	shortURL, err := h.storeShortURL(r.Context(), url, userID)
+	status := http.StatusCreated
+	shortURL, err := h.ShortenURL(r.Context(), userID, models.ShortenRequest{URL: url})
 	if err != nil {
-		h.handleError("AddHandler", err)
-
 		var alreadyExists *store.AlreadyExistsError
-		if errors.As(err, &alreadyExists) {
-			shortURL = h.generateShortURLFromSlug(alreadyExists.URL.Slug)
-
-			w.Header().Set("content-type", "text/plain")
-			w.WriteHeader(http.StatusConflict)
-			if _, err := w.Write([]byte(shortURL)); err != nil {
-				panic(err)
-			}
-
+		if !errors.As(err, &alreadyExists) {
+			h.writeError(w, r, "AddHandler", h.toProblem(err))
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
 
-	h.log.With("userID", userID).Info("new URL created")
+		status = http.StatusConflict
+		shortURL = h.ShortURL(alreadyExists.URL.Slug)
+	} else {
+		h.log.With("userID", userID).Info("new URL created")
+	}
 
 	w.Header().Set("content-type", "text/plain")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	if _, err := w.Write([]byte(shortURL)); err != nil {
-		panic(err)
+		h.log.Errorln("error writing response", "method", "AddHandler", "err", err)
 	}
 }
 
@@ -106,293 +209,454 @@ func (h *Handlers) AddHandler(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) AddHandlerJSON(w http.ResponseWriter, r *http.Request) {
 	userID, err := ensureUserID(r)
 	if err != nil {
-		h.log.With("handler", "AddHandlerJSON").Error(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSON", err)
 		return
 	}
 
 	var request models.ShortenRequest
 	dec := json.NewDecoder(r.Body)
 	if err = dec.Decode(&request); err != nil {
-		h.handleError("AddHandlerJSON", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSON", invalidJSON(err))
 		return
 	}
 
 	if request.URL == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, r, "AddHandlerJSON", requiredField("/url"))
 		return
 	}
 
-	shortURL, err := h.storeShortURL(r.Context(), request.URL, userID)
+	shortURL, err := h.ShortenURL(r.Context(), userID, request)
 	if err != nil {
-		h.handleError("AddHandlerJSON", err)
-
-		var alreadyExists *store.AlreadyExistsError
-		if errors.As(err, &alreadyExists) {
-			w.Header().Set("content-type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-
-			response := models.ShortenResponse{
-				Result: h.generateShortURLFromSlug(alreadyExists.URL.Slug),
-			}
-
-			enc := json.NewEncoder(w)
-			if err := enc.Encode(response); err != nil {
-				panic(err)
-			}
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSON", h.toProblem(err))
 		return
 	}
 
-	response := models.ShortenResponse{
-		Result: shortURL,
-	}
-
 	h.log.With("userID", userID).Info("new URL created")
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(response); err != nil {
-		panic(err)
-	}
+	h.writeJSON(w, "AddHandlerJSON", http.StatusCreated, models.ShortenResponse{
+		Result: shortURL,
+	})
 }
 
 // AddHandlerJSONBatch handles adding a batch of URLs via application/json request.
+//
+// The mode query parameter chooses how items which can not be shortened are handled:
+// atomic (default) rejects the whole batch, partial shortens the other items and reports
+// the result of each item. Partial batches respond with 201 Created if all items have been
+// created and with 207 Multi-Status otherwise.
 func (h *Handlers) AddHandlerJSONBatch(w http.ResponseWriter, r *http.Request) {
 	userID, err := ensureUserID(r)
 	if err != nil {
-		h.log.With("handler", "AddHandlerJSONBatch").Error(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSONBatch", err)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != batchModeAtomic && mode != batchModePartial {
+		h.writeError(w, r, "AddHandlerJSONBatch", invalidParam("mode", "must be atomic or partial"))
 		return
 	}
 
 	var request models.ShortenBatchRequest
 	dec := json.NewDecoder(r.Body)
 	if err = dec.Decode(&request); err != nil {
-		h.handleError("AddHandlerJSONBatch", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSONBatch", invalidJSON(err))
 		return
 	}
 
 	if len(request.URLs) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, r, "AddHandlerJSONBatch", problem.Validation(problem.CodeInvalidRequest, "at least one URL is required"))
 		return
 	}
 
-	urls := make([]models.URL, 0, len(request.URLs))
-	responseURLs := make([]models.ShortenBatchResponseItem, 0, len(request.URLs))
-	for _, reqURL := range request.URLs {
+	if mode == batchModePartial {
+		h.addBatchPartial(w, r, userID, request.URLs)
+		return
+	}
+
+	for i, reqURL := range request.URLs {
 		if reqURL.OriginalURL == "" {
-			w.WriteHeader(http.StatusBadRequest)
+			h.writeError(w, r, "AddHandlerJSONBatch", requiredField(fmt.Sprintf("/%d/original_url", i)))
 			return
 		}
-
-		// TODO Fix copy-paste (see h.storeShortURL()).
-		slug := random.ASCIIString(slugLength)
-		shortURL := h.generateShortURLFromSlug(slug)
-
-		url := models.URL{
-			ID:            uuid.NewString(),
-			CorrelationID: reqURL.CorrelationID,
-			Slug:          slug,
-			Original:      reqURL.OriginalURL,
-			CreatedAt:     time.Now(),
-		}
-		urls = append(urls, url)
-
-		responseURL := models.ShortenBatchResponseItem{
-			CorrelationID: reqURL.CorrelationID,
-			ShortURL:      shortURL,
-		}
-		responseURLs = append(responseURLs, responseURL)
 	}
 
-	err = h.s.BatchCreateURL(r.Context(), userID, urls)
+	responseURLs, err := h.ShortenURLs(r.Context(), userID, request.URLs)
 	if err != nil {
-		h.handleError("AddHandlerJSONBatch", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "AddHandlerJSONBatch", h.toProblem(err))
 		return
 	}
 
-	h.log.With("userID", userID, "count", len(urls)).Info("new URLs created via batch request")
+	h.log.With("userID", userID, "count", len(responseURLs)).Info("new URLs created via batch request")
 
-	response := &models.ShortenBatchResponse{
+	h.writeJSON(w, "AddHandlerJSONBatch", http.StatusCreated, &models.ShortenBatchResponse{
 		URLs: responseURLs,
-	}
+	})
+}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+// addBatchPartial shortens the items of a partial batch request and writes the result of each item.
+func (h *Handlers) addBatchPartial(w http.ResponseWriter, r *http.Request, userID string, items []models.ShortenBatchRequestItem) {
+	responseURLs, err := h.ShortenURLsPartial(r.Context(), userID, items)
+	if err != nil {
+		h.writeError(w, r, "AddHandlerJSONBatch", err)
+		return
+	}
 
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(response); err != nil {
-		panic(err)
+	status := http.StatusCreated
+	var created int
+	for _, item := range responseURLs {
+		if item.Status == models.BatchItemCreated {
+			created++
+		} else {
+			status = http.StatusMultiStatus
+		}
 	}
+
+	h.log.With("userID", userID, "count", len(responseURLs), "created", created).Info("new URLs created via partial batch request")
+
+	h.writeJSON(w, "AddHandlerJSONBatch", status, &models.ShortenBatchResponse{
+		URLs: responseURLs,
+	})
 }
 
-// GetHandler handles retrieving the URL by its slug.
+// GetHandler handles redirecting to the URL by its slug.
+//
+// The redirect status is the redirect type of the URL or the default one of the server.
+// Permanent redirects may be cached by clients, temporary ones may not.
+// HEAD requests are answered with the same headers, but are not counted as clicks.
 func (h *Handlers) GetHandler(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 
 	url, err := h.s.GetURL(r.Context(), slug)
 	if err != nil {
-		h.handleError("GetHandler", err)
-		w.WriteHeader(http.StatusBadRequest)
+		if errors.Is(err, store.ErrNotFound) {
+			err = problem.NotFound("url not found")
+		}
+		h.writeError(w, r, "GetHandler", err)
 		return
 	}
 	if url.Deleted {
-		w.WriteHeader(http.StatusGone)
+		h.writeError(w, r, "GetHandler", problem.Gone(codeDeleted, "url has been deleted"))
+		return
+	}
+	if url.Blocked {
+		h.writeError(w, r, "GetHandler", problem.New(http.StatusUnavailableForLegalReasons, codeBlocked, "url has been blocked"))
+		return
+	}
+	now := time.Now()
+	if url.Expired(now) {
+		h.writeError(w, r, "GetHandler", problem.Gone(codeExpired, "url has expired"))
 		return
 	}
 
-	w.Header().Set("location", url.Original)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	if r.Method != http.MethodHead {
+		h.recordClick(r, url)
+	}
+
+	status := h.redirectStatus(url)
+	h.setCacheHeaders(w, url, status, now)
+	w.Header().Set("location", redirectLocation(url, r))
+	w.WriteHeader(status)
 }
 
-// GetUserURLsHandler handles retrieving all URLs created by the authorized user.
-func (h *Handlers) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+// URLStatsHandler handles retrieving click statistics of the URL created by the authorized user.
+func (h *Handlers) URLStatsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := ensureUserID(r)
 	if err != nil {
-		h.log.With("handler", "GetUserURLsHandler").Error(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, r, "URLStatsHandler", err)
 		return
 	}
 
-	h.log.With("userID", userID).Debug("fetching user urls")
-
-	urls, err := h.s.ListURLsByUserID(r.Context(), userID)
+	slug := chi.URLParam(r, "slug")
+	stats, err := h.s.GetURLStats(r.Context(), userID, slug)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		if errors.Is(err, store.ErrNotFound) {
+			err = problem.NotFound("url not found")
+		}
+		h.writeError(w, r, "URLStatsHandler", err)
 		return
 	}
 
-	if len(urls) == 0 {
-		w.WriteHeader(http.StatusNoContent)
+	h.writeJSON(w, "URLStatsHandler", http.StatusOK, stats)
+}
+
+// PingHandler handles storage health-check.
+func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.s.Ping(r.Context()); err != nil {
+		h.writeError(w, r, "PingHandler", err)
 		return
 	}
 
-	responseURLs := make([]models.UserURLItem, 0, len(urls))
-	for _, url := range urls {
-		responseURL := models.UserURLItem{
-			ShortURL:    h.generateShortURLFromSlug(url.Slug),
-			OriginalURL: url.Original,
-		}
-		responseURLs = append(responseURLs, responseURL)
+	w.WriteHeader(http.StatusOK)
+}
+
+// InternalStatsHandler handles retrieving the number of shortened URLs and registered users.
+func (h *Handlers) InternalStatsHandler(w http.ResponseWriter, r *http.Request) {
+	urls, err := h.s.CountURLs(r.Context())
+	if err != nil {
+		h.writeError(w, r, "InternalStatsHandler", err)
+		return
 	}
 
-	response := &models.ListByUserIDResponse{
-		URLs: responseURLs,
+	users, err := h.s.CountUsers(r.Context())
+	if err != nil {
+		h.writeError(w, r, "InternalStatsHandler", err)
+		return
 	}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	h.writeJSON(w, "InternalStatsHandler", http.StatusOK, models.InternalStatsResponse{URLs: urls, Users: users})
+}
 
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(response); err != nil {
-		panic(err)
-	}
+// Store returns Handlers' storage.
+func (h *Handlers) Store() store.Store {
+	return h.s
 }
 
-// DeleteUserURLsHandler deletes URLs with specified slugs created by the authorized user.
-func (h *Handlers) DeleteUserURLsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := ensureUserID(r)
+// ShortenURL creates a short URL for req.URL on behalf of the user.
+//
+// If req.Alias is not empty, it is used as the slug. Otherwise a random slug is generated.
+// It returns *ValidationError wrapping ErrInvalidURL if req.URL does not pass validation,
+// *ValidationError wrapping ErrBlockedURL if req.URL is blocklisted or malicious,
+// *ValidationError wrapping ErrInvalidAlias if alias does not pass validation,
+// *store.SlugExistsError if alias is already taken, *ValidationError wrapping
+// ErrInvalidExpiration if req.ExpiresAt or req.TTL are invalid and *ValidationError
+// wrapping ErrInvalidRedirectType if req.RedirectType is not a supported redirect status.
+//
+// If req.URL, or a URL with the same canonical form, has already been shortened,
+// it returns *store.AlreadyExistsError which contains the existing URL.
+func (h *Handlers) ShortenURL(ctx context.Context, userID string, req models.ShortenRequest) (string, error) {
+	canonical, err := h.CanonicalURL(req.URL)
 	if err != nil {
-		h.log.With("handler", "DeleteUserURLsHandler").Error(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return "", err
+	}
+	if err := h.screenURL(ctx, "/url", canonical); err != nil {
+		return "", err
 	}
 
-	h.log.With("userID", userID).Debug("deleting user urls")
-
-	var request models.DeleteByUserIDRequest
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&request); err != nil {
-		h.handleError("DeleteUserURLsHandler", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	alias := req.Alias
+	if alias != "" {
+		if err := ValidateAlias(alias); err != nil {
+			return "", err
+		}
 	}
 
-	if len(request.Slugs) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	now := time.Now()
+	expiresAt, err := ExpirationTime(req.ExpiresAt, req.TTL, now)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateRedirectType(req.RedirectType); err != nil {
+		return "", err
 	}
 
-	for _, slug := range request.Slugs {
-		h.delReqChan <- deleteURLRequest{
-			userID: userID,
-			slug:   slug,
+	for i := 0; i < slugAttempts; i++ {
+		slug := alias
+		if slug == "" {
+			slug = random.ASCIIString(slugLength)
 		}
-	}
 
-	w.WriteHeader(http.StatusAccepted)
-}
+		url := models.URL{
+			ID:           uuid.NewString(),
+			Slug:         slug,
+			Original:     req.URL,
+			Canonical:    canonical,
+			CreatedAt:    now,
+			ExpiresAt:    expiresAt,
+			RedirectType: req.RedirectType,
+			MergeQuery:   req.MergeQuery,
+		}
 
-// PingHandler handles storage health-check.
-func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
-	if err := h.s.Ping(r.Context()); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		err = h.s.CreateURL(ctx, userID, url)
+		if err == nil {
+			return h.ShortURL(slug), nil
+		}
+
+		// Random slug collision is retried with a new slug.
+		var slugExists *store.SlugExistsError
+		if alias != "" || !errors.As(err, &slugExists) {
+			return "", err
+		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	return "", err
 }
 
-// Store returns Handlers' storage.
-func (h *Handlers) Store() store.Store {
-	return h.s
-}
-
-func (h *Handlers) storeShortURL(ctx context.Context, longURL, userID string) (string, error) {
-	slug := random.ASCIIString(slugLength)
-	shortURL := h.generateShortURLFromSlug(slug)
+// ShortenURLs creates short URLs for a batch of URLs on behalf of the user.
+//
+// Items with alias use it as the slug, the others get a random one.
+// It returns *ValidationError wrapping ErrInvalidURL if any URL does not pass validation
+// or has the same canonical form as another item, *ValidationError wrapping
+// ErrBlockedURL if any URL is blocklisted or malicious, *ValidationError wrapping
+// ErrInvalidAlias if any alias does not pass validation
+// or is used twice, *store.SlugExistsError if any alias is already taken,
+// *ValidationError wrapping ErrInvalidExpiration if expiration of any item is invalid
+// and *ValidationError wrapping ErrInvalidRedirectType if any redirect type is invalid.
+// Fields of the validation errors point to the invalid item, e.g. "/1/ttl".
+//
+// If any URL has already been shortened, it returns *store.AlreadyExistsError.
+func (h *Handlers) ShortenURLs(ctx context.Context, userID string, items []models.ShortenBatchRequestItem) ([]models.ShortenBatchResponseItem, error) {
+	now := time.Now()
+	aliases := make(map[string]struct{})
+	seen := make(map[string]struct{})
+	urls := make([]models.URL, 0, len(items))
+	res := make([]models.ShortenBatchResponseItem, 0, len(items))
+	for i, item := range items {
+		url, err := h.newBatchURL(ctx, item, now, seen, aliases)
+		if err != nil {
+			return nil, itemError(err, i)
+		}
 
-	url := models.URL{
-		ID:        uuid.NewString(),
-		Slug:      slug,
-		Original:  longURL,
-		CreatedAt: time.Now(),
+		urls = append(urls, url)
+		res = append(res, models.ShortenBatchResponseItem{
+			CorrelationID: item.CorrelationID,
+			ShortURL:      h.ShortURL(url.Slug),
+			Status:        models.BatchItemCreated,
+		})
 	}
 
-	return shortURL, h.s.CreateURL(ctx, userID, url)
-}
+	for attempt := 1; ; attempt++ {
+		err := h.s.BatchCreateURL(ctx, userID, urls)
+		if err == nil {
+			return res, nil
+		}
 
-func (h *Handlers) generateShortURLFromSlug(slug string) string {
-	return fmt.Sprintf("%s/%s", h.c.BaseURL, slug)
-}
+		var slugExists *store.SlugExistsError
+		if !errors.As(err, &slugExists) || attempt == slugAttempts {
+			return nil, err
+		}
+		if _, ok := aliases[slugExists.Slug]; ok {
+			return nil, err
+		}
 
-func (h *Handlers) handleError(method string, err error) {
-	h.log.Errorln("error handling request", "method", method, "err", err)
+		// Random slug collision is retried with a new slug.
+		for i := range urls {
+			if urls[i].Slug == slugExists.Slug {
+				urls[i].Slug = random.ASCIIString(slugLength)
+				res[i].ShortURL = h.ShortURL(urls[i].Slug)
+			}
+		}
+	}
 }
 
-func (h *Handlers) flushDeleteURLRequests(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
-	var reqs []deleteURLRequest
-
-	for {
-		select {
-		case req := <-h.delReqChan:
-			reqs = append(reqs, req)
-		case <-ticker.C:
-			if len(reqs) == 0 {
-				continue
-			}
+// ShortenURLsPartial creates short URLs for a batch of URLs on behalf of the user
+// shortening each URL independently of the others.
+//
+// Unlike ShortenURLs, an item which can not be shortened does not prevent the others
+// from being shortened. The result of each item is returned in the order of items:
+// created, exists with the short URL of the URL which has already been shortened,
+// or invalid with the reason described as in the problem details. An error is returned
+// only if the storage fails.
+func (h *Handlers) ShortenURLsPartial(ctx context.Context, userID string, items []models.ShortenBatchRequestItem) ([]models.ShortenBatchResponseItem, error) {
+	now := time.Now()
+	aliases := make(map[string]struct{})
+	seen := make(map[string]struct{})
+	urls := make([]models.URL, len(items))
+	res := make([]models.ShortenBatchResponseItem, len(items))
+	var pending []int
+	for i, item := range items {
+		res[i].CorrelationID = item.CorrelationID
+
+		url, err := h.newBatchURL(ctx, item, now, seen, aliases)
+		if err != nil {
+			h.setItemError(&res[i], err, i)
+			continue
+		}
+		urls[i] = url
+		pending = append(pending, i)
+	}
 
-			for _, req := range reqs {
-				if err := h.s.SoftDeleteURL(ctx, req.userID, req.slug); err != nil {
-					h.log.Errorln("error deleting url", "err", err)
-					continue
-				}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		batch := make([]models.URL, 0, len(pending))
+		for _, i := range pending {
+			batch = append(batch, urls[i])
+		}
 
-				h.log.With("userID", req.userID, "slug", req.slug).Info("deleted url")
-			}
+		results, err := h.s.BatchUpsertURLs(ctx, userID, batch)
+		if err != nil {
+			return nil, err
+		}
 
-			reqs = nil
+		var retry []int
+		for j, result := range results {
+			i := pending[j]
+			switch {
+			case result.Created:
+				res[i].ShortURL = h.ShortURL(result.URL.Slug)
+				res[i].Status = models.BatchItemCreated
+			case result.Err == nil:
+				res[i].ShortURL = h.ShortURL(result.URL.Slug)
+				res[i].Status = models.BatchItemExists
+			case items[i].Alias == "" && attempt < slugAttempts:
+				// Random slug collision is retried with a new slug.
+				urls[i].Slug = random.ASCIIString(slugLength)
+				retry = append(retry, i)
+			default:
+				h.setItemError(&res[i], result.Err, i)
+			}
 		}
+		pending = retry
+	}
+
+	return res, nil
+}
+
+// newBatchURL validates the item of a batch request and creates its URL.
+//
+// seen and aliases hold canonical URLs and aliases of the previous items, so that
+// they are not used twice in a batch. The item is added to them if it is valid.
+func (h *Handlers) newBatchURL(ctx context.Context, item models.ShortenBatchRequestItem, now time.Time, seen, aliases map[string]struct{}) (models.URL, error) {
+	if item.OriginalURL == "" {
+		return models.URL{}, urlError("/original_url", "is required")
+	}
+	canonical, err := h.norm.Normalize(item.OriginalURL)
+	if err != nil {
+		return models.URL{}, urlError("/original_url", err.Error())
+	}
+	if _, ok := seen[canonical]; ok {
+		return models.URL{}, urlError("/original_url", item.OriginalURL+" is used more than once")
+	}
+	if err := h.screenURL(ctx, "/original_url", canonical); err != nil {
+		return models.URL{}, err
 	}
+
+	expiresAt, err := ExpirationTime(item.ExpiresAt, item.TTL, now)
+	if err != nil {
+		return models.URL{}, err
+	}
+	if err := ValidateRedirectType(item.RedirectType); err != nil {
+		return models.URL{}, err
+	}
+
+	slug := item.Alias
+	if slug != "" {
+		if err := ValidateAlias(slug); err != nil {
+			return models.URL{}, err
+		}
+		if _, ok := aliases[slug]; ok {
+			return models.URL{}, aliasError(slug + " is used more than once")
+		}
+		aliases[slug] = struct{}{}
+	} else {
+		slug = random.ASCIIString(slugLength)
+	}
+	seen[canonical] = struct{}{}
+
+	return models.URL{
+		ID:            uuid.NewString(),
+		CorrelationID: item.CorrelationID,
+		Slug:          slug,
+		Original:      item.OriginalURL,
+		Canonical:     canonical,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+		RedirectType:  item.RedirectType,
+		MergeQuery:    item.MergeQuery,
+	}, nil
+}
+
+// ShortURL returns the short URL for the given slug.
+func (h *Handlers) ShortURL(slug string) string {
+	return fmt.Sprintf("%s/%s", h.c.Server.BaseURL, slug)
 }
 
 func ensureUserID(r *http.Request) (string, error) {
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/pkg/random"
+)
+
+// Media types of import and export streams.
+const (
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+const (
+	// maxImportErrors is the number of failed lines described in the import response.
+	maxImportErrors = 100
+	// maxImportLineSize limits the length of an NDJSON line.
+	maxImportLineSize = 64 * 1024
+)
+
+// csvColumns are the columns of import and export streams in CSV format.
+var csvColumns = []string{"original_url", "alias", "short_url", "created_at", "expires_at"}
+
+// ImportURLsHandler handles importing URLs of the authorized user from a CSV or NDJSON stream.
+//
+// The stream is processed line by line as it is read, so that it is never held in memory.
+// Lines which can not be imported are skipped and reported in the response. A malformed
+// stream, e.g. a CSV header without the original_url column, fails the whole import.
+func (h *Handlers) ImportURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "ImportURLsHandler", err)
+		return
+	}
+
+	reader, err := newRecordReader(r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		h.writeError(w, r, "ImportURLsHandler", err)
+		return
+	}
+
+	resp, err := h.importRecords(r.Context(), userID, reader)
+	if err != nil {
+		h.writeError(w, r, "ImportURLsHandler", err)
+		return
+	}
+
+	h.log.With("userID", userID, "created", resp.Created, "existing", resp.Existing, "failed", resp.Failed).Info("urls imported")
+
+	h.writeJSON(w, "ImportURLsHandler", http.StatusOK, resp)
+}
+
+// importRecords creates short URLs for the records read from reader on behalf of the user.
+//
+// Records are validated like items of a batch request. URLs which have already been
+// shortened are linked to the user. Records which can not be imported are counted
+// as failed. An error is returned if the stream can not be read or the storage fails
+// while importing it, in which case nothing is imported.
+//
+// Records whose random slugs are taken are retried with new slugs after the stream
+// has been imported. If the storage fails then, the imported records are kept and
+// the records which have not been retried yet are counted as failed.
+func (h *Handlers) importRecords(ctx context.Context, userID string, reader recordReader) (*models.ImportResponse, error) {
+	resp := &models.ImportResponse{}
+	fail := func(line int, err error) {
+		resp.Failed++
+		if len(resp.Errors) == maxImportErrors {
+			return
+		}
+		p := h.toProblem(err)
+		resp.Errors = append(resp.Errors, models.ImportError{Line: line, Code: p.Code, Detail: p.Detail, Errors: p.Errors})
+	}
+
+	// Lines of records without alias, whose random slugs can be replaced on conflict.
+	var randomSlugs lineSet
+	now := time.Now()
+	urls := func(yield func(models.URL, error) bool) {
+		for {
+			line, record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			var rowErr *rowError
+			if errors.As(err, &rowErr) {
+				fail(rowErr.line, rowErr.err)
+				continue
+			}
+			if err != nil {
+				yield(models.URL{}, err)
+				return
+			}
+
+			url, err := h.newImportURL(ctx, record, now)
+			if err != nil {
+				fail(line, err)
+				continue
+			}
+			if record.Alias == "" {
+				randomSlugs.add(line)
+			}
+			// Imported URLs are correlated with the lines of the stream.
+			url.CorrelationID = strconv.Itoa(line)
+
+			if !yield(url, nil) {
+				return
+			}
+		}
+	}
+
+	res, err := h.s.ImportURLs(ctx, userID, urls)
+	if err != nil {
+		return nil, err
+	}
+	resp.Created, resp.Existing = res.Created, res.Existing
+
+	var retry []models.URL
+	for _, url := range res.Conflicts {
+		line, _ := strconv.Atoi(url.CorrelationID)
+		if !randomSlugs.has(line) {
+			fail(line, &store.SlugExistsError{Slug: url.Slug})
+			continue
+		}
+		retry = append(retry, url)
+	}
+
+	// Random slug collisions are retried with new slugs.
+	for attempt := 1; len(retry) > 0; attempt++ {
+		for i := range retry {
+			retry[i].Slug = random.ASCIIString(slugLength)
+		}
+		results, err := h.s.BatchUpsertURLs(ctx, userID, retry)
+		if err != nil {
+			h.log.Errorln("error retrying imported urls", "userID", userID, "count", len(retry), "err", err)
+			for _, url := range retry {
+				line, _ := strconv.Atoi(url.CorrelationID)
+				fail(line, err)
+			}
+			break
+		}
+
+		var conflicts []models.URL
+		for _, result := range results {
+			switch {
+			case result.Created:
+				resp.Created++
+			case result.Err == nil:
+				resp.Existing++
+			case attempt < slugAttempts:
+				conflicts = append(conflicts, result.URL)
+			default:
+				line, _ := strconv.Atoi(result.URL.CorrelationID)
+				fail(line, problem.Conflict(problem.CodeConflict, "failed to generate a unique slug"))
+			}
+		}
+		retry = conflicts
+	}
+
+	slices.SortFunc(resp.Errors, func(a, b models.ImportError) int {
+		return a.Line - b.Line
+	})
+
+	return resp, nil
+}
+
+// newImportURL validates the imported record and creates its URL.
+func (h *Handlers) newImportURL(ctx context.Context, record models.URLRecord, now time.Time) (models.URL, error) {
+	if record.OriginalURL == "" {
+		return models.URL{}, urlError("/original_url", "is required")
+	}
+	canonical, err := h.norm.Normalize(record.OriginalURL)
+	if err != nil {
+		return models.URL{}, urlError("/original_url", err.Error())
+	}
+	if err := h.screenURL(ctx, "/original_url", canonical); err != nil {
+		return models.URL{}, err
+	}
+
+	createdAt := now
+	if record.CreatedAt != nil {
+		if record.CreatedAt.After(now) {
+			return models.URL{}, problem.Validation(problem.CodeInvalidRequest, "invalid created_at", models.FieldError{
+				Field:   "/created_at",
+				Message: "must not be in the future",
+			})
+		}
+		createdAt = *record.CreatedAt
+	}
+
+	expiresAt, err := ExpirationTime(record.ExpiresAt, "", now)
+	if err != nil {
+		return models.URL{}, err
+	}
+
+	slug := record.Alias
+	if slug != "" {
+		if err := ValidateAlias(slug); err != nil {
+			return models.URL{}, err
+		}
+	} else {
+		slug = random.ASCIIString(slugLength)
+	}
+
+	return models.URL{
+		ID:        uuid.NewString(),
+		Slug:      slug,
+		Original:  record.OriginalURL,
+		Canonical: canonical,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// recordReader reads records of an import stream.
+type recordReader interface {
+	// Read returns the next record and the number of its line. It returns io.EOF at the end
+	// of the stream and *rowError if the record is malformed. Other errors stop the import.
+	Read() (int, models.URLRecord, error)
+}
+
+// rowError is an error of a single line of the import stream, which does not stop the import.
+type rowError struct {
+	line int
+	err  error
+}
+
+// Error implements error interface.
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+// Unwrap returns the error of the line.
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+// newRecordReader returns a reader of the import stream in the format of the content type.
+func newRecordReader(contentType string, body io.Reader) (recordReader, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case contentTypeCSV:
+		return newCSVReader(body)
+	case contentTypeNDJSON, "application/ndjson":
+		return newNDJSONReader(body), nil
+	}
+
+	return nil, problem.New(
+		http.StatusUnsupportedMediaType,
+		codeUnsupportedMediaType,
+		fmt.Sprintf("content type must be %s or %s", contentTypeCSV, contentTypeNDJSON),
+	)
+}
+
+// csvReader reads records from CSV rows. The first row is the header, which names
+// the columns. The original_url column is required, unknown columns are ignored.
+type csvReader struct {
+	r *csv.Reader
+	// columns maps the names of known columns to their indexes.
+	columns map[string]int
+}
+
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, invalidStream("csv header is missing")
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, invalidStream(fmt.Sprintf("invalid csv header: %s", parseErr.Err))
+	}
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if slices.Contains(csvColumns, name) {
+			columns[name] = i
+		}
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, invalidStream("csv header must contain original_url column")
+	}
+
+	return &csvReader{r: r, columns: columns}, nil
+}
+
+// Read implements recordReader.
+func (c *csvReader) Read() (int, models.URLRecord, error) {
+	var record models.URLRecord
+
+	row, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, record, &rowError{
+			line: parseErr.StartLine,
+			err:  problem.Validation(codeInvalidCSV, "line is not valid CSV: "+parseErr.Err.Error()),
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		return 0, record, err
+	}
+	if err != nil {
+		return 0, record, readError(err)
+	}
+	line, _ := c.r.FieldPos(0)
+
+	value := func(column string) string {
+		if i, ok := c.columns[column]; ok {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	record.OriginalURL = value("original_url")
+	record.Alias = value("alias")
+	for _, t := range []struct {
+		column string
+		dst    **time.Time
+	}{
+		{column: "created_at", dst: &record.CreatedAt},
+		{column: "expires_at", dst: &record.ExpiresAt},
+	} {
+		v := value(t.column)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return line, record, &rowError{
+				line: line,
+				err: problem.Validation(problem.CodeInvalidRequest, "invalid "+t.column, models.FieldError{
+					Field:   "/" + t.column,
+					Message: "must be RFC 3339 time",
+				}),
+			}
+		}
+		*t.dst = &parsed
+	}
+
+	return line, record, nil
+}
+
+// ndjsonReader reads records from lines of JSON objects. Empty lines are skipped.
+type ndjsonReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONReader(body io.Reader) *ndjsonReader {
+	s := bufio.NewScanner(body)
+	s.Buffer(make([]byte, 0, 4096), maxImportLineSize)
+
+	return &ndjsonReader{s: s}
+}
+
+// Read implements recordReader.
+func (n *ndjsonReader) Read() (int, models.URLRecord, error) {
+	var record models.URLRecord
+
+	for n.s.Scan() {
+		n.line++
+		data := n.s.Bytes()
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(data, &record); err != nil {
+			return n.line, record, &rowError{line: n.line, err: problem.Validation(codeInvalidJSON, "line is not valid JSON")}
+		}
+		return n.line, record, nil
+	}
+
+	err := n.s.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return n.line + 1, record, invalidStream(fmt.Sprintf("line %d is longer than %d bytes", n.line+1, maxImportLineSize))
+	}
+	if err != nil {
+		return n.line, record, readError(err)
+	}
+
+	return n.line, record, io.EOF
+}
+
+// invalidStream creates an error of the import stream which can not be read.
+func invalidStream(detail string) *problem.Error {
+	return problem.Validation(problem.CodeInvalidRequest, detail)
+}
+
+// readError creates an error of the request body which can not be read.
+func readError(err error) *problem.Error {
+	return &problem.Error{
+		Status: http.StatusBadRequest,
+		Code:   problem.CodeInvalidRequest,
+		Detail: "error reading request body",
+		Err:    err,
+	}
+}
+
+// lineSet is a set of line numbers of the import stream, which takes a bit per line.
+type lineSet []uint64
+
+func (s *lineSet) add(line int) {
+	for len(*s) <= line/64 {
+		*s = append(*s, 0)
+	}
+	(*s)[line/64] |= 1 << (line % 64)
+}
+
+func (s lineSet) has(line int) bool {
+	return line/64 < len(s) && s[line/64]&(1<<(line%64)) != 0
+}
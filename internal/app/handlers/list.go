@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+)
+
+const (
+	// defaultPageSize is the number of URLs per page if the cursor parameter is set without limit.
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// GetUserURLsHandler handles retrieving a page of URLs created by the authorized user.
+//
+// URLs are sorted by creation time. If there are more URLs, the Link header
+// contains the URL of the next page. Without limit and cursor parameters all URLs
+// are returned, as before pagination was introduced.
+func (h *Handlers) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := ensureUserID(r)
+	if err != nil {
+		h.writeError(w, r, "GetUserURLsHandler", err)
+		return
+	}
+
+	query, err := parseURLQuery(r.URL.Query())
+	if err != nil {
+		h.writeError(w, r, "GetUserURLsHandler", err)
+		return
+	}
+
+	h.log.With("userID", userID).Debug("fetching user urls")
+
+	// One more URL tells whether there is a next page.
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit++
+	}
+	urls, err := h.s.ListUserURLs(r.Context(), userID, query)
+	if err != nil {
+		h.writeError(w, r, "GetUserURLsHandler", err)
+		return
+	}
+
+	if limit > 0 && len(urls) > limit {
+		urls = urls[:limit]
+		w.Header().Set("Link", nextPageLink(r, models.CursorOf(urls[len(urls)-1])))
+	}
+
+	if len(urls) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	responseURLs := make([]models.UserURLItem, 0, len(urls))
+	for _, url := range urls {
+		responseURL := models.UserURLItem{
+			ShortURL:     h.ShortURL(url.Slug),
+			OriginalURL:  url.Original,
+			CreatedAt:    url.CreatedAt,
+			ExpiresAt:    url.ExpiresAt,
+			RedirectType: url.RedirectType,
+			MergeQuery:   url.MergeQuery,
+		}
+		responseURLs = append(responseURLs, responseURL)
+	}
+
+	h.writeJSON(w, "GetUserURLsHandler", http.StatusOK, &models.ListByUserIDResponse{
+		URLs: responseURLs,
+	})
+}
+
+// parseURLQuery parses query parameters of GET /api/user/urls:
+// limit, cursor, order (asc or desc), q (substring of the original URL),
+// from and to (RFC 3339 time or YYYY-MM-DD date).
+//
+// The limit is zero, i.e. unlimited, if neither limit nor cursor is set.
+func parseURLQuery(params url.Values) (models.URLQuery, error) {
+	query := models.URLQuery{Search: params.Get("q")}
+	if params.Has("cursor") {
+		query.Limit = defaultPageSize
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, invalidParam("limit", fmt.Sprintf("must be a number from 1 to %d", maxPageSize))
+		}
+		query.Limit = limit
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		cursor, err := models.ParseURLCursor(v)
+		if err != nil {
+			return query, invalidParam("cursor", "must be a cursor from the Link header")
+		}
+		query.After = cursor
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, invalidParam("order", "must be asc or desc")
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+		// endOfDay makes a date-only upper bound include the whole day.
+		endOfDay bool
+	}{
+		{name: "from", dst: &query.CreatedFrom},
+		{name: "to", dst: &query.CreatedTo, endOfDay: true},
+	} {
+		v := params.Get(p.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, v)
+			if err != nil {
+				return query, invalidParam(p.name, "must be RFC 3339 time or YYYY-MM-DD date")
+			}
+			if p.endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*p.dst = &t
+	}
+
+	return query, nil
+}
+
+// nextPageLink returns the Link header value which points to the page after the cursor.
+func nextPageLink(r *http.Request, cursor *models.URLCursor) string {
+	params := r.URL.Query()
+	params.Set("cursor", cursor.String())
+
+	return fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, params.Encode())
+}
+
+// invalidParam creates a validation error of the query parameter.
+func invalidParam(name, message string) *problem.Error {
+	return problem.Validation(problem.CodeInvalidRequest, "invalid query parameter", models.FieldError{
+		Field:   name,
+		Message: message,
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"time"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+)
+
+const (
+	// defaultRedirectType is used if the redirect type is not configured.
+	defaultRedirectType = http.StatusTemporaryRedirect
+	// defaultRedirectMaxAge is used if the cache lifetime of permanent redirects is not configured.
+	defaultRedirectMaxAge = 24 * time.Hour
+)
+
+// ErrInvalidRedirectType is returned when the redirect type of a URL is not a supported redirect status.
+var ErrInvalidRedirectType = errors.New("invalid redirect type")
+
+// ValidateRedirectType checks that status can be used as the redirect type of a URL.
+// Zero is valid and means the default redirect type of the server.
+//
+// The returned error is *ValidationError which wraps ErrInvalidRedirectType and describes the reason.
+func ValidateRedirectType(status int) error {
+	if status != 0 && !models.ValidRedirectType(status) {
+		return &ValidationError{
+			Field:  "/redirect_type",
+			Reason: "must be one of: 301, 302, 307, 308",
+			Err:    ErrInvalidRedirectType,
+		}
+	}
+
+	return nil
+}
+
+// redirectStatus returns the redirect status of the URL.
+func (h *Handlers) redirectStatus(url models.URL) int {
+	if url.RedirectType != 0 {
+		return url.RedirectType
+	}
+	return h.redirectType
+}
+
+// setCacheHeaders allows clients to cache permanent redirects for RedirectMaxAge,
+// but not after the URL expires. Temporary redirects are never cached, so that
+// every click reaches the server.
+func (h *Handlers) setCacheHeaders(w http.ResponseWriter, url models.URL, status int, now time.Time) {
+	if !models.PermanentRedirect(status) {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+
+	maxAge := h.redirectMaxAge
+	if url.ExpiresAt != nil {
+		maxAge = min(maxAge, url.ExpiresAt.Sub(now))
+	}
+	seconds := int64(max(maxAge, 0) / time.Second)
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(seconds, 10))
+	w.Header().Set("Expires", now.Add(time.Duration(seconds)*time.Second).UTC().Format(http.TimeFormat))
+}
+
+// redirectLocation returns the destination of the redirect to the URL.
+//
+// If the URL merges queries, parameters of the request are added to the original URL.
+// Parameters which the original URL already has are kept as they are, so that
+// a request can not override them.
+func redirectLocation(url models.URL, r *http.Request) string {
+	if !url.MergeQuery || r.URL.RawQuery == "" {
+		return url.Original
+	}
+
+	dest, err := neturl.Parse(url.Original)
+	if err != nil {
+		// Original URLs are validated before they are stored.
+		return url.Original
+	}
+
+	own := dest.Query()
+	extra := make(neturl.Values)
+	for key, values := range r.URL.Query() {
+		if _, ok := own[key]; !ok {
+			extra[key] = values
+		}
+	}
+	if len(extra) == 0 {
+		return url.Original
+	}
+
+	if dest.RawQuery != "" {
+		dest.RawQuery += "&"
+	}
+	dest.RawQuery += extra.Encode()
+
+	return dest.String()
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrInvalidURL is returned when the URL to shorten does not pass validation.
+	ErrInvalidURL = errors.New("invalid url")
+	// ErrBlockedURL is returned when the URL to shorten is blocklisted or reported as malicious.
+	ErrBlockedURL = errors.New("blocked url")
+)
+
+// CanonicalURL validates the URL to shorten and returns its canonical form,
+// which is used to find URLs that have already been shortened.
+//
+// The returned error is *ValidationError which wraps ErrInvalidURL and describes the reason.
+func (h *Handlers) CanonicalURL(rawURL string) (string, error) {
+	canonical, err := h.norm.Normalize(rawURL)
+	if err != nil {
+		return "", urlError("/url", err.Error())
+	}
+
+	return canonical, nil
+}
+
+// screenURL checks the canonical URL against the blocklist and the malicious URL checker.
+//
+// It returns *ValidationError which wraps ErrBlockedURL if the URL is blocked.
+// If the URL can not be checked, it is allowed, so that an unavailable checker
+// does not stop shortening.
+func (h *Handlers) screenURL(ctx context.Context, field, canonical string) error {
+	if len(h.screen) == 0 {
+		return nil
+	}
+
+	verdict, err := h.screen.Check(ctx, canonical)
+	if err != nil {
+		h.log.Warnw("error screening url", "url", canonical, "err", err)
+	}
+	if verdict.Blocked {
+		return &ValidationError{Field: field, Reason: verdict.Reason, Err: ErrBlockedURL}
+	}
+
+	return nil
+}
+
+func urlError(field, reason string) error {
+	return &ValidationError{Field: field, Reason: reason, Err: ErrInvalidURL}
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/madatsci/urlshortener/pkg/jwt"
+)
+
+// loadTokenKeys loads token signing and verification keys from PEM files
+// given in the form of kid=path.
+func loadTokenKeys(signing string, verification []string) (*jwt.Key, []*jwt.Key, error) {
+	ids := make(map[string]struct{})
+	load := func(spec string) (*jwt.Key, error) {
+		kid, path, ok := strings.Cut(spec, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid token key %q, must be kid=path", spec)
+		}
+		if _, ok := ids[kid]; ok {
+			return nil, fmt.Errorf("duplicate token key id %q", kid)
+		}
+		ids[kid] = struct{}{}
+
+		return jwt.LoadKeyFile(kid, path)
+	}
+
+	var signingKey *jwt.Key
+	if signing != "" {
+		key, err := load(signing)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !key.CanSign() {
+			return nil, nil, errors.New("token signing key must be a private key")
+		}
+		signingKey = key
+	}
+
+	verificationKeys := make([]*jwt.Key, 0, len(verification))
+	for _, spec := range verification {
+		key, err := load(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return signingKey, verificationKeys, nil
+}
@@ -3,9 +3,19 @@ package logger
 
 import "go.uber.org/zap"
 
-// New builds a logger.
-func New() (*zap.SugaredLogger, error) {
-	logger, err := zap.NewDevelopment()
+// New builds a logger which writes messages of the given level and above,
+// e.g. "info". An empty level means debug.
+func New(level string) (*zap.SugaredLogger, error) {
+	cfg := zap.NewDevelopmentConfig()
+	if level != "" {
+		lvl, err := zap.ParseAtomicLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Level = lvl
+	}
+
+	logger, err := cfg.Build()
 	if err != nil {
 		return nil, err
 	}
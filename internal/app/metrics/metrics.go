@@ -0,0 +1,97 @@
+// Package metrics defines Prometheus metrics of the service and serves them over HTTP.
+//
+// All metrics are registered in Registry, which also collects Go runtime
+// and process metrics. Metrics are exposed by Server on a separate address,
+// so that they are not available through the public API.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "urlshortener"
+
+// Registry holds all metrics of the service.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts processed HTTP requests by method, route pattern and status code.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of processed HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes HTTP request latency by method and route pattern.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// StoreOperationDuration observes storage operation latency by operation name.
+	StoreOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Storage operation latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// StoreErrors counts failed storage operations by operation name.
+	StoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "errors_total",
+		Help:      "Number of failed storage operations.",
+	}, []string{"operation"})
+
+	// CacheRequests counts URL cache lookups by result: hit or miss.
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Number of URL cache lookups.",
+	}, []string{"result"})
+
+	// RateLimited counts requests rejected by rate limits by limit class.
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Number of HTTP requests rejected by rate limits.",
+	}, []string{"class"})
+
+	// QueueDepth is the number of items waiting in asynchronous processing queues.
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of items waiting in asynchronous processing queues.",
+	}, []string{"queue"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		StoreOperationDuration,
+		StoreErrors,
+		CacheRequests,
+		RateLimited,
+		QueueDepth,
+	)
+}
+
+// Handler returns an HTTP handler which exposes metrics from Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
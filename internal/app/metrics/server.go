@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Server serves metrics on GET /metrics.
+//
+// Use NewServer to create an instance of Server.
+type Server struct {
+	srv *http.Server
+	log *zap.SugaredLogger
+}
+
+// NewServer creates a new metrics server listening on addr.
+func NewServer(addr string, logger *zap.SugaredLogger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+
+	return &Server{
+		srv: &http.Server{
+			Addr:    addr,
+			Handler: mux,
+		},
+		log: logger,
+	}
+}
+
+// Start starts the server and blocks until it is stopped.
+// After Shutdown is called Start returns nil.
+func (s *Server) Start() error {
+	s.log.Infof("starting metrics server on %s", s.srv.Addr)
+
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
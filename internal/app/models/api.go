@@ -1,10 +1,23 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ShortenRequest represents POST /api/shorten request body.
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL   string `json:"url"`
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt is the absolute expiration time. It can not be used together with TTL.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL is the URL lifetime in the format of Golang duration string, e.g. "24h".
+	TTL string `json:"ttl,omitempty"`
+	// RedirectType is the HTTP status of the redirect: 301, 302, 307 or 308.
+	// Zero means the default redirect type of the server.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// MergeQuery adds query parameters of the short URL request to the original URL.
+	MergeQuery bool `json:"merge_query,omitempty"`
 }
 
 // ShortenResponse represents POST /api/shorten response body.
@@ -40,8 +53,13 @@ func (r *ShortenBatchRequest) UnmarshalJSON(data []byte) error {
 
 // ShortenBatchRequestItem represents a single item in POST /api/shorten/batch request body.
 type ShortenBatchRequestItem struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTL           string     `json:"ttl,omitempty"`
+	RedirectType  int        `json:"redirect_type,omitempty"`
+	MergeQuery    bool       `json:"merge_query,omitempty"`
 }
 
 // ShortenBatchResponse represents POST /api/shorten/batch response body.
@@ -84,10 +102,26 @@ func (r *ShortenBatchResponse) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Statuses of items in POST /api/shorten/batch response body.
+const (
+	// BatchItemCreated means that a new short URL has been created.
+	BatchItemCreated = "created"
+	// BatchItemExists means that the URL has already been shortened, ShortURL is the existing short URL.
+	BatchItemExists = "exists"
+	// BatchItemInvalid means that the item has not been shortened, Code, Detail and Errors describe why.
+	BatchItemInvalid = "invalid"
+)
+
 // ShortenBatchResponseItem represents a single item in POST /api/shorten/batch response body.
 type ShortenBatchResponseItem struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	// Status is BatchItemCreated, BatchItemExists or BatchItemInvalid.
+	Status string `json:"status,omitempty"`
+	// Code, Detail and Errors have the same meaning as in Problem.
+	Code   string       `json:"code,omitempty"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
 // ListByUserIDResponse represents GET /api/user/urls response body.
@@ -132,8 +166,48 @@ func (r *ListByUserIDResponse) UnmarshalJSON(data []byte) error {
 
 // UserURLItem represents a single item in GET /api/user/urls response body.
 type UserURLItem struct {
-	ShortURL    string `json:"short_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// RedirectType is zero if the URL uses the default redirect type of the server.
+	RedirectType int  `json:"redirect_type,omitempty"`
+	MergeQuery   bool `json:"merge_query,omitempty"`
+}
+
+// URLRecord represents a line of POST /api/user/urls/import request body
+// and GET /api/user/urls/export response body.
+//
+// Records are written as NDJSON objects or CSV rows with columns of the same names.
+type URLRecord struct {
 	OriginalURL string `json:"original_url"`
+	// Alias is the slug of the short URL. Exported slugs can be imported as aliases.
+	Alias string `json:"alias,omitempty"`
+	// ShortURL is only exported, it is ignored by import.
+	ShortURL  string     `json:"short_url,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ImportResponse represents POST /api/user/urls/import response body.
+type ImportResponse struct {
+	// Created is the number of created short URLs.
+	Created int `json:"created"`
+	// Existing is the number of URLs which have already been shortened.
+	Existing int `json:"existing"`
+	// Failed is the number of lines which have not been imported.
+	Failed int `json:"failed"`
+	// Errors describes up to the first 100 failed lines.
+	Errors []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError describes a line of the import stream which has not been imported.
+type ImportError struct {
+	Line int `json:"line"`
+	// Code and Detail have the same meaning as in Problem.
+	Code   string       `json:"code"`
+	Detail string       `json:"detail"`
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
 // DeleteByUserIDRequest represents DELETE /api/user/urls request body.
@@ -145,3 +219,55 @@ type DeleteByUserIDRequest struct {
 func (r *DeleteByUserIDRequest) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &r.Slugs)
 }
+
+// CreateAPIKeyRequest represents POST /api/user/keys request body.
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateAPIKeyResponse represents POST /api/user/keys response body.
+//
+// It is the only response which contains the key itself.
+type CreateAPIKeyResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyItem represents a single item in GET /api/user/keys response body.
+type APIKeyItem struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// DeleteJobResponse represents DELETE /api/user/urls and GET /api/user/deletions/{id} response body.
+type DeleteJobResponse struct {
+	ID string `json:"id"`
+	// Status is one of pending, done or failed.
+	Status   string   `json:"status"`
+	Slugs    []string `json:"slugs"`
+	Attempts int      `json:"attempts"`
+	// Error is the error of the last failed attempt.
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InternalStatsResponse represents GET /api/internal/stats response body.
+type InternalStatsResponse struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// ApplyBlocklistResponse represents POST /api/internal/blocklist/apply response body.
+type ApplyBlocklistResponse struct {
+	// Checked is the number of stored URLs checked against the blocklist.
+	Checked int `json:"checked"`
+	// Blocked is the number of URLs blocked by this request.
+	Blocked int `json:"blocked"`
+}
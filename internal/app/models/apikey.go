@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// APIKey represents a stored API key of a user.
+type APIKey struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix is the beginning of the key which helps users to identify it.
+	Prefix string `json:"prefix"`
+	// Hash is the hash of the key. The key itself is never stored.
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt is the time the key was last used for authentication.
+	// It is updated with a resolution of about a minute.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
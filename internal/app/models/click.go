@@ -0,0 +1,73 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// Click represents a single redirect from a short URL.
+type Click struct {
+	ID        string    `json:"id"`
+	URLID     string    `json:"url_id"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// IPHash is a keyed hash of the client IP address. Raw addresses are never stored.
+	IPHash string `json:"ip_hash"`
+}
+
+// URLStats represents click statistics of a URL.
+type URLStats struct {
+	Slug           string       `json:"slug"`
+	TotalClicks    int          `json:"total_clicks"`
+	UniqueVisitors int          `json:"unique_visitors"`
+	Daily          []DailyStats `json:"daily"`
+}
+
+// DailyStats represents click statistics of a URL for a single day in UTC.
+type DailyStats struct {
+	Date           string `json:"date"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+// DateFormat is the format of DailyStats.Date.
+const DateFormat = "2006-01-02"
+
+// NewURLStats aggregates clicks of the URL with the given slug.
+func NewURLStats(slug string, clicks []Click) URLStats {
+	stats := URLStats{
+		Slug:        slug,
+		TotalClicks: len(clicks),
+		Daily:       []DailyStats{},
+	}
+
+	visitors := make(map[string]struct{})
+	days := make(map[string]*DailyStats)
+	dayVisitors := make(map[string]map[string]struct{})
+	for _, click := range clicks {
+		visitors[click.IPHash] = struct{}{}
+
+		date := click.CreatedAt.UTC().Format(DateFormat)
+		day, ok := days[date]
+		if !ok {
+			day = &DailyStats{Date: date}
+			days[date] = day
+			dayVisitors[date] = make(map[string]struct{})
+		}
+		day.Clicks++
+		dayVisitors[date][click.IPHash] = struct{}{}
+	}
+	stats.UniqueVisitors = len(visitors)
+
+	for date, day := range days {
+		day.UniqueVisitors = len(dayVisitors[date])
+		stats.Daily = append(stats.Daily, *day)
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+
+	return stats
+}
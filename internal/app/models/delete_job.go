@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// DeleteJobStatus is the state of DeleteJob.
+type DeleteJobStatus string
+
+// Statuses of DeleteJob.
+const (
+	// DeleteJobPending means that the job is waiting for its first or next attempt.
+	DeleteJobPending DeleteJobStatus = "pending"
+	// DeleteJobDone means that the URLs have been deleted.
+	DeleteJobDone DeleteJobStatus = "done"
+	// DeleteJobFailed means that all attempts to delete the URLs have failed.
+	DeleteJobFailed DeleteJobStatus = "failed"
+)
+
+// DeleteJob represents an accepted request of a user to delete their URLs.
+type DeleteJob struct {
+	ID     string          `json:"id"`
+	UserID string          `json:"user_id"`
+	Slugs  []string        `json:"slugs"`
+	Status DeleteJobStatus `json:"status"`
+	// Attempts is the number of failed attempts.
+	Attempts int `json:"attempts"`
+	// LastError is the error of the last failed attempt.
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// NextAttemptAt is the time after which a pending job is executed.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// Finished reports whether the job will not be executed anymore.
+func (j DeleteJob) Finished() bool {
+	return j.Status == DeleteJobDone || j.Status == DeleteJobFailed
+}
@@ -0,0 +1,30 @@
+package models
+
+// Problem represents an error response body in the format of
+// RFC 7807 problem details (application/problem+json).
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Code is a machine-readable error code, e.g. "invalid_alias".
+	Code string `json:"code"`
+	// RequestID identifies the request in the server logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists invalid request fields.
+	Errors []FieldError `json:"errors,omitempty"`
+	// Result is the existing short URL in case the URL has already been shortened.
+	Result string `json:"result,omitempty"`
+	// Alias is the alias which is already taken.
+	Alias string `json:"alias,omitempty"`
+}
+
+// FieldError describes an invalid field of the request body or an invalid query parameter.
+type FieldError struct {
+	// Field is a JSON pointer to the field, e.g. "/alias" or "/0/original_url",
+	// or the name of the query parameter, e.g. "limit".
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// URLQuery selects a page of URLs created by a user.
+//
+// URLs are sorted by creation time, URLs created at the same time are sorted by ID.
+type URLQuery struct {
+	// Limit is the maximum number of URLs to return.
+	Limit int
+	// After is the cursor of the last URL of the previous page, nil for the first page.
+	After *URLCursor
+	// Desc sorts the newest URLs first.
+	Desc bool
+	// Search is a case-insensitive substring of the original URL.
+	Search string
+	// CreatedFrom (inclusive) and CreatedTo (exclusive) limit the creation time of URLs.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// URLCursor is the position of a URL in the list sorted by creation time.
+type URLCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// CursorOf returns the cursor which points to the URL.
+func CursorOf(url URL) *URLCursor {
+	return &URLCursor{CreatedAt: url.CreatedAt, ID: url.ID}
+}
+
+// ParseURLCursor decodes the cursor returned by URLCursor.String.
+func ParseURLCursor(s string) (*URLCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, errInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &URLCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// String encodes the cursor as an opaque URL-safe string.
+func (c *URLCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// compare compares positions of the URL and the cursor in ascending order.
+func (c *URLCursor) compare(url URL) int {
+	if n := url.CreatedAt.Compare(c.CreatedAt); n != 0 {
+		return n
+	}
+
+	return strings.Compare(url.ID, c.ID)
+}
+
+// Matches reports whether the URL passes the filters of the query, the cursor included.
+func (q *URLQuery) Matches(url URL) bool {
+	if q.Search != "" && !strings.Contains(strings.ToLower(url.Original), strings.ToLower(q.Search)) {
+		return false
+	}
+	if q.CreatedFrom != nil && url.CreatedAt.Before(*q.CreatedFrom) {
+		return false
+	}
+	if q.CreatedTo != nil && !url.CreatedAt.Before(*q.CreatedTo) {
+		return false
+	}
+	if q.After != nil {
+		n := q.After.compare(url)
+		if n == 0 || (n < 0) != q.Desc {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CompareURLs compares URLs in the order of the list of user URLs:
+// by creation time, URLs created at the same time by ID.
+func CompareURLs(a, b URL) int {
+	return CursorOf(b).compare(a)
+}
+
+// Page returns the page of URLs which match the query from the list of n URLs
+// sorted with CompareURLs. at returns the i-th URL of the list and whether it is listed,
+// e.g. it has not been deleted by the user. The page starts right after the cursor,
+// so only the URLs of the page and the URLs skipped by filters are visited.
+// It is used by storages which keep URLs in memory.
+func (q *URLQuery) Page(n int, at func(i int) (URL, bool)) []URL {
+	start, step := 0, 1
+	if q.Desc {
+		start, step = n-1, -1
+	}
+	if q.After != nil {
+		// The first URL after the cursor in ascending order.
+		start = sort.Search(n, func(i int) bool {
+			url, _ := at(i)
+			return q.After.compare(url) > 0
+		})
+		if q.Desc {
+			// The last URL before the cursor.
+			start = sort.Search(n, func(i int) bool {
+				url, _ := at(i)
+				return q.After.compare(url) >= 0
+			}) - 1
+		}
+	}
+
+	var res []URL
+	for i := start; i >= 0 && i < n; i += step {
+		if q.Limit > 0 && len(res) == q.Limit {
+			break
+		}
+		if url, ok := at(i); ok && q.Matches(url) {
+			res = append(res, url)
+		}
+	}
+
+	return res
+}
@@ -1,13 +1,65 @@
 package models
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 // URL represents stored URL.
 type URL struct {
-	ID            string    `json:"id"`
-	CorrelationID string    `json:"correlation_id"`
-	Slug          string    `json:"slug"`
-	Original      string    `json:"original_url"`
-	CreatedAt     time.Time `json:"created_at"`
-	Deleted       bool      `json:"is_deleted"`
+	ID            string `json:"id"`
+	CorrelationID string `json:"correlation_id"`
+	Slug          string `json:"slug"`
+	Original      string `json:"original_url"`
+	// Canonical is the normalized form of Original which is used to find duplicates.
+	Canonical string    `json:"canonical_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Deleted   bool      `json:"is_deleted"`
+	// Blocked means the URL has been blocklisted after it was shortened and is no longer served.
+	Blocked bool `json:"is_blocked"`
+	// ExpiresAt is the time after which the URL is no longer available.
+	// Nil means the URL never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectType is the HTTP status of the redirect: 301, 302, 307 or 308.
+	// Zero means the default redirect type of the server.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// MergeQuery adds query parameters of the short URL request to the original URL.
+	MergeQuery bool `json:"merge_query,omitempty"`
+}
+
+// Expired reports whether the URL has expired by the time now.
+func (u URL) Expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// Dead reports whether the URL is no longer served by the time now because it has
+// been deleted, blocked or has expired. Dead URLs are not returned as duplicates,
+// so their canonical form can be shortened again.
+func (u URL) Dead(now time.Time) bool {
+	return u.Deleted || u.Blocked || u.Expired(now)
+}
+
+// DedupKey returns the key by which duplicates of the URL are found: the canonical
+// form, or the original URL for URLs stored before canonical forms were introduced.
+func (u URL) DedupKey() string {
+	if u.Canonical != "" {
+		return u.Canonical
+	}
+	return u.Original
+}
+
+// ValidRedirectType reports whether status can be used as the redirect type of a URL.
+func ValidRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// PermanentRedirect reports whether the redirect status is permanent, so that
+// clients are allowed to cache it.
+func PermanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
 }
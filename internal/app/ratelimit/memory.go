@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Backend which keeps buckets in memory of a single instance of the service.
+//
+// Use NewMemory to create an instance of Memory.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+// NewMemory creates a new in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]memoryBucket)}
+}
+
+// Take implements Backend.
+func (m *Memory) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	if err := validate(limit); err != nil {
+		return Result{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b.tokens = float64(limit.Requests)
+		b.updatedAt = now
+	}
+
+	var res Result
+	b.bucket, res = b.take(limit, now)
+	b.fullAt = b.bucket.fullAt(limit)
+	m.buckets[key] = b
+
+	return res, nil
+}
+
+// Sweep implements Backend.
+func (m *Memory) Sweep(_ context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for key, b := range m.buckets {
+		if !b.fullAt.After(now) {
+			delete(m.buckets, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// available is the number of tokens in the existing bucket refilled up to $4.
+const available = `LEAST($2::float8, rate_limits.tokens +
+	GREATEST(EXTRACT(EPOCH FROM ($4::timestamptz - rate_limits.updated_at))::float8, 0) * $3::float8)`
+
+// left is the number of tokens in the existing bucket after the request.
+const left = `CASE WHEN ` + available + ` >= 1 THEN ` + available + ` - 1 ELSE ` + available + ` END`
+
+// takeQuery takes a token with a single statement, so that concurrent requests
+// to different replicas see the latest state of the locked row.
+var takeQuery = fmt.Sprintf(`
+	INSERT INTO rate_limits (key, tokens, allowed, updated_at, full_at)
+	VALUES ($1, $2::float8 - 1, true, $4, $4::timestamptz + make_interval(secs => 1 / $3::float8))
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = %[1]s,
+		allowed = %[2]s >= 1,
+		updated_at = GREATEST(rate_limits.updated_at, $4),
+		full_at = GREATEST(rate_limits.updated_at, $4) + make_interval(secs => ($2::float8 - (%[1]s)) / $3::float8)
+	RETURNING tokens, allowed`, left, available)
+
+// Postgres is a Backend which keeps buckets in PostgreSQL, so that they are
+// shared by all replicas of the service.
+//
+// The rate_limits table is created by migrations of the database storage.
+// Use NewPostgres to create an instance of Postgres.
+type Postgres struct {
+	conn *sql.DB
+}
+
+// NewPostgres creates a new PostgreSQL backend.
+func NewPostgres(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+// Take implements Backend.
+func (p *Postgres) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	if err := validate(limit); err != nil {
+		return Result{}, err
+	}
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+	err := p.conn.QueryRowContext(ctx, takeQuery, key, float64(limit.Requests), limit.rate(), now).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(limit, tokens, allowed), nil
+}
+
+// Sweep implements Backend.
+func (p *Postgres) Sweep(ctx context.Context, now time.Time) (int, error) {
+	res, err := p.conn.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
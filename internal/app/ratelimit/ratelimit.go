@@ -0,0 +1,159 @@
+// Package ratelimit implements token bucket rate limiting with pluggable state backends.
+//
+// A bucket holds up to Limit.Requests tokens and is refilled at the rate of
+// Limit.Requests tokens per Limit.Period. Every request takes a token, requests
+// are rejected while the bucket is empty. Buckets are identified by arbitrary
+// keys, e.g. a user ID or a client IP address.
+//
+// Memory keeps buckets of a single instance of the service. Postgres shares
+// buckets between replicas which use the same database.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the number of requests allowed per period, which is also the burst size.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit in the form of requests/period, e.g. 100/m.
+// The period is s, m, h or a Go duration string, e.g. 100/10m.
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("wrong limit format %q, must be requests/period, e.g. 100/m", value)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid number of requests %q", requests)
+	}
+
+	var d time.Duration
+	switch period {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		d, err = time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid period %q", period)
+		}
+	}
+
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// String returns the limit in the form accepted by ParseLimit.
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// rate returns the number of tokens added to the bucket per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Limit is the limit of the bucket.
+	Limit Limit
+	// Remaining is the number of requests which are allowed right now.
+	Remaining int
+	// RetryAfter is the time until the next request is allowed, zero if Allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// Backend keeps the state of buckets.
+type Backend interface {
+	// Take takes a token from the bucket with the given key at time now.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// Sweep removes buckets which are full at time now, as they are no
+	// different from buckets which do not exist. It returns the number of removed buckets.
+	Sweep(ctx context.Context, now time.Time) (int, error)
+}
+
+// ErrInvalidLimit is returned by backends if the limit allows no requests.
+var ErrInvalidLimit = errors.New("invalid rate limit")
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// take refills the bucket up to now and takes a token if there is one.
+func (b bucket) take(limit Limit, now time.Time) (bucket, Result) {
+	rate := limit.rate()
+	burst := float64(limit.Requests)
+
+	// Clocks of replicas may differ slightly, time never goes back for a bucket.
+	elapsed := max(now.Sub(b.updatedAt).Seconds(), 0)
+	tokens := min(b.tokens+elapsed*rate, burst)
+	b.updatedAt = maxTime(b.updatedAt, now)
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	b.tokens = tokens
+
+	return b, result(limit, tokens, allowed)
+}
+
+// result describes the bucket which has tokens left after the request.
+func result(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.rate()
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	return res
+}
+
+// fullAt returns the time when the bucket is full.
+func (b bucket) fullAt(limit Limit) time.Time {
+	return b.updatedAt.Add(seconds((float64(limit.Requests) - b.tokens) / limit.rate()))
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// validate checks that the limit allows requests.
+func validate(limit Limit) error {
+	if limit.Requests < 1 || limit.Period <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidLimit, limit)
+	}
+
+	return nil
+}
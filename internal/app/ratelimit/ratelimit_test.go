@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/database"
+	dbstore "github.com/madatsci/urlshortener/internal/app/store/database"
+)
+
+func TestParseLimit(t *testing.T) {
+	for value, want := range map[string]Limit{
+		"100/m":   {Requests: 100, Period: time.Minute},
+		"5/s":     {Requests: 5, Period: time.Second},
+		"20/h":    {Requests: 20, Period: time.Hour},
+		"10/30s":  {Requests: 10, Period: 30 * time.Second},
+		"1000/1h": {Requests: 1000, Period: time.Hour},
+	} {
+		limit, err := ParseLimit(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, limit, value)
+	}
+
+	for _, value := range []string{"", "100", "0/m", "-1/m", "many/m", "10/day", "10/-1s"} {
+		_, err := ParseLimit(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("database DSN not set")
+	}
+
+	ctx := context.Background()
+	conn, err := database.NewClient(ctx, dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The table is created by migrations of the database storage.
+	_, err = dbstore.New(ctx, conn)
+	require.NoError(t, err)
+
+	testBackend(t, NewPostgres(conn))
+}
+
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	// Postgres stores microseconds.
+	now := time.Now().Truncate(time.Second)
+
+	t.Run("bucket is emptied and refilled", func(t *testing.T) {
+		key := uuid.NewString()
+
+		for i := 2; i >= 0; i-- {
+			res, err := b.Take(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, i, res.Remaining)
+			assert.Equal(t, time.Duration(3-i)*time.Second, res.Reset)
+			assert.Zero(t, res.RetryAfter)
+		}
+
+		res, err := b.Take(ctx, key, limit, now.Add(500*time.Millisecond))
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+		assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+		// A rejected request does not take a token.
+		res, err = b.Take(ctx, key, limit, now.Add(time.Second))
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+
+		// The bucket is not refilled above the limit.
+		res, err = b.Take(ctx, key, limit, now.Add(time.Hour))
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 2, res.Remaining)
+	})
+
+	t.Run("buckets are independent", func(t *testing.T) {
+		key1, key2 := uuid.NewString(), uuid.NewString()
+		for i := 0; i < 3; i++ {
+			_, err := b.Take(ctx, key1, limit, now)
+			require.NoError(t, err)
+		}
+
+		res, err := b.Take(ctx, key1, limit, now)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+
+		res, err = b.Take(ctx, key2, limit, now)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	})
+
+	t.Run("time does not go back", func(t *testing.T) {
+		key := uuid.NewString()
+		for i := 0; i < 3; i++ {
+			_, err := b.Take(ctx, key, limit, now)
+			require.NoError(t, err)
+		}
+
+		res, err := b.Take(ctx, key, limit, now.Add(-time.Minute))
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, time.Second, res.RetryAfter)
+	})
+
+	t.Run("full buckets are swept", func(t *testing.T) {
+		key := uuid.NewString()
+		later := now.Add(24 * time.Hour)
+		for i := 0; i < 3; i++ {
+			_, err := b.Take(ctx, key, limit, later)
+			require.NoError(t, err)
+		}
+
+		_, err := b.Sweep(ctx, later.Add(500*time.Millisecond))
+		require.NoError(t, err)
+		res, err := b.Take(ctx, key, limit, later.Add(500*time.Millisecond))
+		require.NoError(t, err)
+		assert.False(t, res.Allowed, "bucket which is not full is kept")
+
+		n, err := b.Sweep(ctx, later.Add(time.Hour))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, n, 1)
+	})
+
+	t.Run("negative case: invalid limit", func(t *testing.T) {
+		_, err := b.Take(ctx, uuid.NewString(), Limit{}, now)
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+}
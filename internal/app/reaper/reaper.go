@@ -0,0 +1,170 @@
+// Package reaper implements periodic removal of expired URLs and expired
+// entries of the token revocation list from the storage.
+//
+// Expired URLs are kept in the storage for the retention period, so that
+// the service keeps responding to them with 410 Gone, and then removed
+// permanently. Removal is done in batches, so that the storage is not locked
+// for a long time when a lot of URLs expire at once.
+//
+// Revoked tokens are removed from the revocation list as soon as they expire,
+// since expired tokens are rejected anyway.
+//
+// Finished jobs of deleting user URLs are kept for Options.JobRetention,
+// so that clients can poll their status, and then removed.
+package reaper
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+const (
+	// DefaultInterval is used if Options.Interval is not set.
+	DefaultInterval = time.Minute
+	// DefaultBatchSize is used if Options.BatchSize is not set.
+	DefaultBatchSize = 1000
+	// DefaultJobRetention is used if Options.JobRetention is not set.
+	DefaultJobRetention = 24 * time.Hour
+)
+
+// Options is used to configure Reaper.
+type Options struct {
+	// Interval is the period between removals (default: DefaultInterval).
+	Interval time.Duration
+	// Retention is how long expired URLs are kept before removal.
+	Retention time.Duration
+	// BatchSize is the maximum number of URLs removed by a single storage call (default: DefaultBatchSize).
+	BatchSize int
+	// JobRetention is how long finished delete jobs are kept before removal (default: DefaultJobRetention).
+	JobRetention time.Duration
+}
+
+// Reaper periodically removes expired URLs from the storage.
+//
+// Use New to create an instance of Reaper.
+type Reaper struct {
+	s    store.Store
+	opts Options
+	log  *zap.SugaredLogger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a new Reaper.
+func New(s store.Store, opts Options, logger *zap.SugaredLogger) *Reaper {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.JobRetention <= 0 {
+		opts.JobRetention = DefaultJobRetention
+	}
+
+	return &Reaper{
+		s:    s,
+		opts: opts,
+		log:  logger,
+	}
+}
+
+// Start starts removing expired URLs in background.
+func (r *Reaper) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go r.run(ctx)
+}
+
+// Stop stops the reaper and waits until the current removal is finished or ctx is done.
+func (r *Reaper) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reap removes all URLs which expired more than the retention period ago.
+// It returns the number of removed URLs.
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	before := time.Now().Add(-r.opts.Retention)
+
+	var total int
+	for {
+		n, err := r.s.DeleteExpiredURLs(ctx, before, r.opts.BatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < r.opts.BatchSize {
+			return total, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReapTokens removes revocation list entries of expired tokens.
+// It returns the number of removed entries.
+func (r *Reaper) ReapTokens(ctx context.Context) (int, error) {
+	return r.s.DeleteExpiredRevokedTokens(ctx, time.Now())
+}
+
+// ReapDeleteJobs removes delete jobs which finished more than the job retention period ago.
+// It returns the number of removed jobs.
+func (r *Reaper) ReapDeleteJobs(ctx context.Context) (int, error) {
+	return r.s.DeleteFinishedDeleteJobs(ctx, time.Now().Add(-r.opts.JobRetention))
+}
+
+func (r *Reaper) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := r.Reap(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.log.Errorln("error removing expired urls", "err", err)
+			}
+			if n > 0 {
+				r.log.With("count", n).Info("removed expired urls")
+			}
+
+			n, err = r.ReapTokens(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.log.Errorln("error removing expired revoked tokens", "err", err)
+			}
+			if n > 0 {
+				r.log.With("count", n).Debug("removed expired revoked tokens")
+			}
+
+			n, err = r.ReapDeleteJobs(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.log.Errorln("error removing finished delete jobs", "err", err)
+			}
+			if n > 0 {
+				r.log.With("count", n).Debug("removed finished delete jobs")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
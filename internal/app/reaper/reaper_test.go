@@ -0,0 +1,119 @@
+package reaper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+func TestReap(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	user := random.RandomUser()
+	urls := random.RandomURLs(5)
+	longAgo := time.Now().Add(-2 * time.Hour)
+	recently := time.Now().Add(-time.Minute)
+	urls[0].ExpiresAt = &longAgo
+	urls[1].ExpiresAt = &longAgo
+	urls[2].ExpiresAt = &longAgo
+	urls[3].ExpiresAt = &recently
+	err := s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	r := New(s, Options{Retention: time.Hour, BatchSize: 2}, zap.NewNop().Sugar())
+
+	n, err := r.Reap(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	for _, u := range urls[:3] {
+		_, err = s.GetURL(ctx, u.Slug)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	}
+	for _, u := range urls[3:] {
+		_, err = s.GetURL(ctx, u.Slug)
+		assert.NoError(t, err)
+	}
+}
+
+func TestReapTokens(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	_, err := s.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	_, err = s.RevokeToken(ctx, "active", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	r := New(s, Options{}, zap.NewNop().Sugar())
+
+	n, err := r.ReapTokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	revoked, err := s.IsTokenRevoked(ctx, "active")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestReapDeleteJobs(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	user := random.RandomUser()
+	longAgo := time.Now().Add(-2 * time.Hour)
+	jobs := []models.DeleteJob{
+		{ID: "old_done", UserID: user.ID, Status: models.DeleteJobDone, UpdatedAt: longAgo},
+		{ID: "old_failed", UserID: user.ID, Status: models.DeleteJobFailed, UpdatedAt: longAgo},
+		{ID: "old_pending", UserID: user.ID, Status: models.DeleteJobPending, UpdatedAt: longAgo},
+		{ID: "recent_done", UserID: user.ID, Status: models.DeleteJobDone, UpdatedAt: time.Now()},
+	}
+	for _, job := range jobs {
+		require.NoError(t, s.CreateDeleteJob(ctx, job))
+	}
+
+	r := New(s, Options{JobRetention: time.Hour}, zap.NewNop().Sugar())
+
+	n, err := r.ReapDeleteJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	for _, id := range []string{"old_pending", "recent_done"} {
+		_, err = s.GetDeleteJob(ctx, user.ID, id)
+		assert.NoError(t, err)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	user := random.RandomUser()
+	url := random.RandomURL()
+	expiresAt := time.Now().Add(-time.Minute)
+	url.ExpiresAt = &expiresAt
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	r := New(s, Options{Interval: 10 * time.Millisecond}, zap.NewNop().Sugar())
+	r.Start()
+
+	assert.Eventually(t, func() bool {
+		_, err := s.GetURL(ctx, url.Slug)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, r.Stop(stopCtx))
+}
@@ -0,0 +1,167 @@
+package screening
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+// blocklistCheckInterval is how often the blocklist file is checked for changes.
+const blocklistCheckInterval = 10 * time.Second
+
+// Blocklist is a Checker which blocks URLs by the rules of a local file.
+//
+// The file has a rule per line. A domain, e.g. example.com, blocks URLs with
+// this host and all its subdomains. A regular expression between slashes,
+// e.g. /paypal.*login/, blocks canonical URLs which match it. Empty lines and
+// lines starting with # are ignored.
+//
+// The file is reloaded when it is modified, so that rules can be changed without
+// restart. If the modified file can not be loaded, the previous rules are kept.
+//
+// Use NewBlocklist to create an instance of Blocklist.
+type Blocklist struct {
+	path string
+	log  *zap.SugaredLogger
+	// checkInterval limits how often the file is checked.
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	rules     *rules
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// rules are the parsed rules of the blocklist.
+type rules struct {
+	domains  map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// NewBlocklist loads the blocklist from the file.
+func NewBlocklist(path string, log *zap.SugaredLogger) (*Blocklist, error) {
+	b := &Blocklist{
+		path:          path,
+		log:           log,
+		checkInterval: blocklistCheckInterval,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Check implements Checker.
+func (b *Blocklist) Check(_ context.Context, canonicalURL string) (Verdict, error) {
+	r := b.current()
+
+	for _, domain := range parentDomains(host(canonicalURL)) {
+		if _, ok := r.domains[domain]; ok {
+			return Verdict{Blocked: true, Reason: fmt.Sprintf("domain %s is blocklisted", domain)}, nil
+		}
+	}
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(canonicalURL) {
+			return Verdict{Blocked: true, Reason: "url matches a blocklisted pattern"}, nil
+		}
+	}
+
+	return Allowed, nil
+}
+
+// current returns the rules, reloading the file if it has been modified.
+func (b *Blocklist) current() *rules {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Since(b.lastCheck) >= b.checkInterval {
+		b.lastCheck = time.Now()
+		info, err := os.Stat(b.path)
+		if err != nil {
+			b.log.Errorf("error checking blocklist: %s", err)
+		} else if !info.ModTime().Equal(b.modTime) {
+			if err := b.load(info.ModTime()); err != nil {
+				b.log.Errorf("error reloading blocklist: %s", err)
+			} else {
+				b.log.Infof("blocklist reloaded from %s", b.path)
+			}
+		}
+	}
+
+	return b.rules
+}
+
+// load must be called with mu held or before the blocklist is shared.
+func (b *Blocklist) load(modTime time.Time) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := parseRules(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.path, err)
+	}
+
+	b.rules = r
+	b.modTime = modTime
+	b.lastCheck = time.Now()
+
+	return nil
+}
+
+// parseRules parses the blocklist file. All invalid lines are reported at once.
+func parseRules(r io.Reader) (*rules, error) {
+	res := &rules{domains: make(map[string]struct{})}
+	var errs []string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			pattern, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: invalid pattern: %s", n, err))
+				continue
+			}
+			res.patterns = append(res.patterns, pattern)
+			continue
+		}
+
+		domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(line, "."))
+		if err != nil || domain == "" {
+			errs = append(errs, fmt.Sprintf("line %d: invalid domain %q", n, line))
+			continue
+		}
+		res.domains[domain] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid blocklist: %s", strings.Join(errs, "; "))
+	}
+
+	return res, nil
+}
@@ -0,0 +1,99 @@
+package screening
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func writeBlocklist(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, `
+# phishing
+evil.example
+Пример.рф.
+/paypal.*login/
+`, time.Now())
+
+	b, err := NewBlocklist(path, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{url: "https://evil.example/", blocked: true},
+		{url: "https://login.evil.example:8443/a", blocked: true},
+		{url: "https://notevil.example/", blocked: false},
+		{url: "https://example/", blocked: false},
+		{url: "https://xn--e1afmkfd.xn--p1ai/", blocked: true},
+		{url: "https://secure-paypal.example/account/login", blocked: true},
+		{url: "https://example.org/", blocked: false},
+	}
+
+	for _, test := range tests {
+		verdict, err := b.Check(context.Background(), test.url)
+		require.NoError(t, err)
+		assert.Equal(t, test.blocked, verdict.Blocked, test.url)
+		if test.blocked {
+			assert.NotEmpty(t, verdict.Reason, test.url)
+		}
+	}
+}
+
+func TestBlocklistInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+
+	_, err := NewBlocklist(path, zap.NewNop().Sugar())
+	assert.Error(t, err, "missing file")
+
+	writeBlocklist(t, path, "evil.example\n/[/\nexa mple.com\n", time.Now())
+	_, err = NewBlocklist(path, zap.NewNop().Sugar())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+	assert.Contains(t, err.Error(), "line 3")
+}
+
+func TestBlocklistReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	modTime := time.Now().Add(-time.Hour)
+	writeBlocklist(t, path, "evil.example\n", modTime)
+
+	b, err := NewBlocklist(path, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	b.checkInterval = 0
+
+	blocked := func(url string) bool {
+		verdict, err := b.Check(context.Background(), url)
+		require.NoError(t, err)
+		return verdict.Blocked
+	}
+
+	assert.True(t, blocked("https://evil.example/"))
+	assert.False(t, blocked("https://bad.example/"))
+
+	writeBlocklist(t, path, "bad.example\n", modTime.Add(time.Minute))
+	assert.False(t, blocked("https://evil.example/"))
+	assert.True(t, blocked("https://bad.example/"))
+
+	// Invalid rules are not applied.
+	writeBlocklist(t, path, "/[/\n", modTime.Add(2*time.Minute))
+	assert.True(t, blocked("https://bad.example/"))
+
+	// Removed file keeps the rules too.
+	require.NoError(t, os.Remove(path))
+	assert.True(t, blocked("https://bad.example/"))
+}
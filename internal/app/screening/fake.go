@@ -0,0 +1,66 @@
+package screening
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is an in-memory Checker which stands in for a remote service of
+// malicious URLs in tests and local development.
+//
+// Use NewFake to create an instance of Fake.
+type Fake struct {
+	mu sync.Mutex
+	// threats maps canonical URLs and hosts to the threat type, e.g. "phishing".
+	threats map[string]string
+	err     error
+	calls   int
+}
+
+// NewFake creates a new Fake which does not block any URL.
+func NewFake() *Fake {
+	return &Fake{threats: make(map[string]string)}
+}
+
+// Add reports the canonical URL or the host as a threat of the given type, e.g. "phishing".
+func (f *Fake) Add(urlOrHost, threat string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.threats[urlOrHost] = threat
+}
+
+// Fail makes checks fail with err, nil restores normal operation.
+func (f *Fake) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+// Calls returns the number of checks.
+func (f *Fake) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls
+}
+
+// Check implements Checker.
+func (f *Fake) Check(_ context.Context, canonicalURL string) (Verdict, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil {
+		return Allowed, f.err
+	}
+
+	for _, key := range []string{canonicalURL, host(canonicalURL)} {
+		if threat, ok := f.threats[key]; ok {
+			return Verdict{Blocked: true, Reason: "url is reported as " + threat}, nil
+		}
+	}
+
+	return Allowed, nil
+}
@@ -0,0 +1,82 @@
+// Package screening checks URLs submitted for shortening against blocklists
+// and services which detect malicious URLs, such as phishing or malware sites.
+//
+// A Checker tells whether a URL must be rejected. Blocklist is a local checker of
+// domains and patterns loaded from a file, Fake is an in-memory stand-in for a
+// remote service in tests, and Chain combines several checkers.
+package screening
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Verdict is the result of checking a URL.
+type Verdict struct {
+	Blocked bool
+	// Reason describes why the URL is blocked. It can be shown to the client.
+	Reason string
+}
+
+// Allowed is the verdict of a URL which is not blocked.
+var Allowed = Verdict{}
+
+// Checker checks whether URLs must be rejected.
+type Checker interface {
+	// Check returns the verdict of the canonical form of the URL.
+	// An error means that the URL could not be checked.
+	Check(ctx context.Context, canonicalURL string) (Verdict, error)
+}
+
+// Chain is a Checker which checks URLs with each checker in turn.
+//
+// It returns the first verdict which blocks the URL. If a checker fails,
+// the others are still asked and the error is returned only if none of them
+// blocks the URL.
+type Chain []Checker
+
+// Check implements Checker.
+func (c Chain) Check(ctx context.Context, canonicalURL string) (Verdict, error) {
+	var firstErr error
+	for _, checker := range c {
+		verdict, err := checker.Check(ctx, canonicalURL)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if verdict.Blocked {
+			return verdict, nil
+		}
+	}
+
+	return Allowed, firstErr
+}
+
+// host returns the lowercase host of the URL without the port.
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// parentDomains returns the host and all its parent domains, e.g. a.example.com,
+// example.com and com for a.example.com.
+func parentDomains(host string) []string {
+	var domains []string
+	for host != "" {
+		domains = append(domains, host)
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+
+	return domains
+}
@@ -0,0 +1,60 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	f := NewFake()
+	f.Add("malware.example", "malware")
+	f.Add("https://example.org/phish", "phishing")
+
+	verdict, err := f.Check(context.Background(), "https://malware.example/download")
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{Blocked: true, Reason: "url is reported as malware"}, verdict)
+
+	verdict, err = f.Check(context.Background(), "https://example.org/phish")
+	require.NoError(t, err)
+	assert.True(t, verdict.Blocked)
+
+	verdict, err = f.Check(context.Background(), "https://example.org/")
+	require.NoError(t, err)
+	assert.Equal(t, Allowed, verdict)
+
+	f.Fail(errors.New("unavailable"))
+	_, err = f.Check(context.Background(), "https://example.org/")
+	assert.Error(t, err)
+	assert.Equal(t, 4, f.Calls())
+}
+
+func TestChain(t *testing.T) {
+	failing := NewFake()
+	failing.Fail(errors.New("unavailable"))
+	blocking := NewFake()
+	blocking.Add("evil.example", "phishing")
+
+	chain := Chain{failing, blocking}
+
+	verdict, err := chain.Check(context.Background(), "https://evil.example/")
+	require.NoError(t, err)
+	assert.True(t, verdict.Blocked)
+
+	verdict, err = chain.Check(context.Background(), "https://example.org/")
+	assert.Error(t, err)
+	assert.False(t, verdict.Blocked)
+
+	verdict, err = Chain{blocking}.Check(context.Background(), "https://example.org/")
+	require.NoError(t, err)
+	assert.Equal(t, Allowed, verdict)
+}
+
+func TestParentDomains(t *testing.T) {
+	assert.Equal(t, []string{"a.example.com", "example.com", "com"}, parentDomains("a.example.com"))
+	assert.Empty(t, parentDomains(""))
+	assert.Equal(t, "example.com", host("https://Example.COM.:8080/a"))
+}
@@ -41,7 +41,7 @@ func ExampleServer() {
 	w.Result().Body.Close()
 
 	// Add URL via JSON
-	r = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://example.org"}`))
+	r = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://example.org/about"}`))
 	w = httptest.NewRecorder()
 	s.mux.ServeHTTP(w, r)
 	fmt.Println(w.Code)
@@ -93,16 +93,19 @@ func ExampleServer() {
 
 func newExampleServer() (*Server, error) {
 	c := &config.Config{
-		BaseURL:       "http://localhost:8080",
-		TokenDuration: time.Hour,
+		Server: config.ServerConfig{BaseURL: "http://localhost:8080"},
+		Auth: config.AuthConfig{
+			TokenSecret:   "secret_key",
+			TokenDuration: config.Duration{Duration: time.Hour},
+		},
 	}
 
-	log, err := logger.New()
+	log, err := logger.New("")
 	if err != nil {
 		return nil, err
 	}
 
-	return New(c, memory.New(), log), nil
+	return New(c, memory.New(), Options{}, log), nil
 }
 
 func parseAuthToken(res *http.Response) string {
@@ -114,3 +117,13 @@ func parseAuthToken(res *http.Response) string {
 
 	return ""
 }
+
+func parseRefreshToken(res *http.Response) string {
+	for _, c := range res.Cookies() {
+		if c.Name == middleware.RefreshCookieName {
+			return c.Value
+		}
+	}
+
+	return ""
+}
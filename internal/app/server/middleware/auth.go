@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	"github.com/madatsci/urlshortener/internal/app/apikey"
 	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
 	"github.com/madatsci/urlshortener/internal/app/store"
 	"github.com/madatsci/urlshortener/pkg/jwt"
 )
@@ -17,9 +20,27 @@ import (
 // DefaultCookieName is the default cookie name for authentication token.
 const DefaultCookieName = "auth_token"
 
+// RefreshCookieName is the cookie name for refresh token.
+const RefreshCookieName = "refresh_token"
+
+// refreshCookiePath limits the refresh token cookie to the endpoints which accept it,
+// so that the long-lived token is not sent with every request.
+const refreshCookiePath = "/api/auth"
+
 // AuthenticatedUserKey should be used to read userID from context.
 const AuthenticatedUserKey ctxKey = 0
 
+// registeredUserKey marks the context of a request which has registered the new user.
+const registeredUserKey ctxKey = 1
+
+// APIKeyHeader is the header which can be used to pass an API key
+// instead of "Authorization: Bearer <key>".
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyTouchInterval limits how often the last-used time of an API key is updated,
+// so that every request made with a key does not result in a storage write.
+const apiKeyTouchInterval = time.Minute
+
 // Auth is an authentication middleware.
 //
 // User NewAuth to create a new Auth instance.
@@ -28,7 +49,8 @@ type Auth struct {
 	jwt        *jwt.JWT
 	store      store.Store
 	log        *zap.SugaredLogger
-	userID     string
+	secure     bool
+	limiter    *RateLimiter
 }
 
 // Options represents dependencies required for Auth.
@@ -37,6 +59,10 @@ type Options struct {
 	JWT        *jwt.JWT
 	Store      store.Store
 	Log        *zap.SugaredLogger
+	// Secure marks cookies to be sent over HTTPS only.
+	Secure bool
+	// RateLimiter limits registration of new users, nil disables the limit.
+	RateLimiter *RateLimiter
 }
 
 type ctxKey int
@@ -53,85 +79,245 @@ func NewAuth(opts Options) *Auth {
 		jwt:        opts.JWT,
 		store:      opts.Store,
 		log:        opts.Log,
+		secure:     opts.Secure,
+		limiter:    opts.RateLimiter,
 	}
 }
 
 // PublicAPIAuth defines authentication handler for public API scope.
+//
+// Requests with an API key are authenticated by the key. Otherwise the user
+// is read from the cookie, and a new user is registered if there is no valid cookie.
+// Registrations are limited by the client IP if RateLimiter is set.
+//
+// The refresh token cookie is not sent to the public API, so an expired access token
+// can not be refreshed here. The request is rejected with 401 instead of registering
+// a new user, so that the client can refresh its tokens and keep its URLs. The expired
+// cookie is removed, so a client which does not refresh gets a new user on the next request.
 func (a *Auth) PublicAPIAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var userID string
-		var err error
+		if key, ok := apiKeyFromRequest(r); ok {
+			a.authenticateAPIKey(w, r, next, key)
+			return
+		}
+
+		var claims *jwt.Claims
 
 		cookie, err := r.Cookie(a.cookieName)
-		if err != nil {
-			if err == http.ErrNoCookie {
-				a.log.Debug("cookie header not found, issue new token")
-				userID, err = a.registerNewUser(r.Context(), w)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		if err != nil && err != http.ErrNoCookie {
+			a.handleError(w, r, err)
+			return
 		}
 
 		if cookie != nil {
 			a.log.With("cookie", cookie).Debug("got cookie from request")
-			userID, err = a.jwt.GetUserID(cookie.Value)
-			if err != nil {
-				userID, err = a.registerNewUser(r.Context(), w)
+			claims, err = a.jwt.Parse(cookie.Value, jwt.TypeAccess)
+			if errors.Is(err, jwt.ErrExpired) {
+				a.handleExpired(w, r)
+				return
+			}
+			if err == nil {
+				revoked, err := a.isRevoked(r.Context(), claims)
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+					a.handleError(w, r, err)
 					return
 				}
+				if revoked {
+					claims = nil
+				}
 			}
+		}
 
-			if _, err := a.store.GetUser(r.Context(), userID); err != nil {
-				a.handleUnauthorized(w, errors.New("got unregistered user from auth token"))
+		if claims == nil {
+			a.log.Debug("valid token not found in cookie, issue new token")
+			if !a.limiter.allowClient(w, r, RateLimitRegister) {
 				return
 			}
+			userID, err := a.registerNewUser(r.Context(), w)
+			if err != nil {
+				a.handleError(w, r, err)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), registeredUserKey, true))
+			a.continueWithUser(w, r, next, userID)
+			return
 		}
 
-		a.userID = userID
-		a.continueWithUser(w, r, next)
+		if _, err := a.store.GetUser(r.Context(), claims.UserID); err != nil {
+			a.handleUnauthorized(w, r, errors.New("got unregistered user from auth token"))
+			return
+		}
+
+		a.continueWithUser(w, r, next, claims.UserID)
 	})
 }
 
 // PrivateAPIAuth defines authentication handler for private API scope.
+//
+// Requests must be authenticated either by an API key or by the cookie.
 func (a *Auth) PrivateAPIAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := apiKeyFromRequest(r); ok {
+			a.authenticateAPIKey(w, r, next, key)
+			return
+		}
+
 		cookie, err := r.Cookie(a.cookieName)
 		if err != nil {
 			if err == http.ErrNoCookie {
-				a.handleUnauthorized(w, errors.New("no authorization cookie"))
-				return
+				a.handleUnauthorized(w, r, errors.New("no authorization cookie"))
 			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+				a.handleError(w, r, err)
 			}
 			return
 		}
 
-		userID, err := a.jwt.GetUserID(cookie.Value)
+		claims, err := a.jwt.Parse(cookie.Value, jwt.TypeAccess)
 		if err != nil {
-			a.handleUnauthorized(w, err)
+			a.handleUnauthorized(w, r, err)
+			return
+		}
+		if claims.UserID == "" {
+			a.handleUnauthorized(w, r, errors.New("token does not contain user ID"))
 			return
 		}
-		if userID == "" {
-			a.handleUnauthorized(w, errors.New("token does not contain user ID"))
+		revoked, err := a.isRevoked(r.Context(), claims)
+		if err != nil {
+			a.handleError(w, r, err)
 			return
 		}
-		if _, err := a.store.GetUser(r.Context(), userID); err != nil {
-			a.handleUnauthorized(w, errors.New("got unregistered user from auth token"))
+		if revoked {
+			a.handleUnauthorized(w, r, errors.New("token has been revoked"))
+			return
+		}
+		if _, err := a.store.GetUser(r.Context(), claims.UserID); err != nil {
+			a.handleUnauthorized(w, r, errors.New("got unregistered user from auth token"))
 			return
 		}
 
-		a.userID = userID
-		a.continueWithUser(w, r, next)
+		a.continueWithUser(w, r, next, claims.UserID)
 	})
 }
 
+// authenticateAPIKey continues with the owner of the API key or rejects the request
+// if the key is unknown or revoked.
+func (a *Auth) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := a.store.GetAPIKeyByHash(r.Context(), apikey.Hash(key))
+	if errors.Is(err, store.ErrNotFound) {
+		a.handleUnauthorized(w, r, errors.New("unknown api key"))
+		return
+	}
+	if err != nil {
+		a.handleError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		// Failing to record the usage must not fail the request.
+		if err := a.store.TouchAPIKey(r.Context(), apiKey.ID, now); err != nil {
+			a.log.Errorln("error recording api key usage", "keyID", apiKey.ID, "err", err)
+		}
+	}
+
+	a.continueWithUser(w, r, next, apiKey.UserID)
+}
+
+// apiKeyFromRequest reads the API key from the X-API-Key header or
+// from the Authorization header with Bearer scheme.
+//
+// Bearer tokens which do not look like API keys are ignored.
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key, true
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && apikey.IsKey(token) {
+		return token, true
+	}
+
+	return "", false
+}
+
+// RefreshHandler exchanges the refresh token cookie for a new pair of access and refresh tokens.
+//
+// Refresh tokens are rotated: the presented token is revoked, so it can be used only once.
+func (a *Auth) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(RefreshCookieName)
+	if err != nil {
+		a.handleUnauthorized(w, r, errors.New("no refresh token cookie"))
+		return
+	}
+
+	claims, err := a.jwt.Parse(cookie.Value, jwt.TypeRefresh)
+	if err != nil {
+		a.handleUnauthorized(w, r, err)
+		return
+	}
+	revoked, err := a.isRevoked(r.Context(), claims)
+	if err != nil {
+		a.handleError(w, r, err)
+		return
+	}
+	if revoked {
+		a.handleUnauthorized(w, r, errors.New("refresh token has been revoked"))
+		return
+	}
+	if _, err := a.store.GetUser(r.Context(), claims.UserID); err != nil {
+		a.handleUnauthorized(w, r, errors.New("got unregistered user from refresh token"))
+		return
+	}
+
+	// The token is checked again on revocation, so that concurrent requests
+	// with the same token do not both get new tokens.
+	revoked, err = a.store.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		a.handleError(w, r, err)
+		return
+	}
+	if !revoked {
+		a.handleUnauthorized(w, r, errors.New("refresh token has been revoked"))
+		return
+	}
+	if err := a.issueTokens(w, claims.UserID); err != nil {
+		a.handleError(w, r, err)
+		return
+	}
+
+	a.log.With("userID", claims.UserID).Debug("refreshed tokens")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutHandler revokes the access and refresh tokens of the request and removes their cookies.
+func (a *Auth) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	tokens := []struct{ cookieName, tokenType string }{
+		{a.cookieName, jwt.TypeAccess},
+		{RefreshCookieName, jwt.TypeRefresh},
+	}
+	for _, token := range tokens {
+		cookie, err := r.Cookie(token.cookieName)
+		if err != nil {
+			continue
+		}
+		// Invalid and expired tokens can not be used anyway.
+		claims, err := a.jwt.Parse(cookie.Value, token.tokenType)
+		if err != nil || claims.ID == "" {
+			continue
+		}
+		if _, err := a.store.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			a.handleError(w, r, err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: a.cookieName, Path: "/", MaxAge: -1, HttpOnly: true, Secure: a.secure})
+	http.SetCookie(w, &http.Cookie{Name: RefreshCookieName, Path: refreshCookiePath, MaxAge: -1, HttpOnly: true, Secure: a.secure})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *Auth) registerNewUser(ctx context.Context, w http.ResponseWriter) (string, error) {
 	user := models.User{
 		ID:        uuid.NewString(),
@@ -142,24 +328,82 @@ func (a *Auth) registerNewUser(ctx context.Context, w http.ResponseWriter) (stri
 		return user.ID, err
 	}
 
-	token, err := a.jwt.GetString(user.ID)
-	if err != nil {
+	if err := a.issueTokens(w, user.ID); err != nil {
 		return "", err
 	}
-	http.SetCookie(w, &http.Cookie{Name: a.cookieName, Value: token})
 
 	a.log.With("userID", user.ID).Info("registered new user")
 
 	return user.ID, nil
 }
 
-func (a *Auth) handleUnauthorized(w http.ResponseWriter, err error) {
+// issueTokens sets cookies with new access and refresh tokens of the user.
+func (a *Auth) issueTokens(w http.ResponseWriter, userID string) error {
+	access, err := a.jwt.GetString(userID)
+	if err != nil {
+		return err
+	}
+	refresh, refreshClaims, err := a.jwt.Issue(userID, jwt.TypeRefresh)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookieName,
+		Value:    access,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.secure,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    refresh,
+		Path:     refreshCookiePath,
+		Expires:  refreshClaims.ExpiresAt.Time,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// isRevoked reports whether the token is in the revocation list.
+//
+// Tokens issued before token IDs were introduced can not be revoked.
+func (a *Auth) isRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	if claims.ID == "" {
+		return false, nil
+	}
+
+	return a.store.IsTokenRevoked(ctx, claims.ID)
+}
+
+func (a *Auth) handleUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
 	a.log.Debugf("unauthorized attempt to access private API: %s", err)
-	w.WriteHeader(http.StatusUnauthorized)
+	if err := problem.Write(w, r, problem.Unauthorized("valid authentication token is required")); err != nil {
+		a.log.Errorln("error writing response", "err", err)
+	}
+}
+
+// handleExpired rejects the request with an expired access token and removes its cookie.
+func (a *Auth) handleExpired(w http.ResponseWriter, r *http.Request) {
+	a.log.Debug("auth token has expired")
+	http.SetCookie(w, &http.Cookie{Name: a.cookieName, Path: "/", MaxAge: -1, HttpOnly: true, Secure: a.secure})
+	if err := problem.Write(w, r, problem.Unauthorized("authentication token has expired, refresh it with POST /api/auth/refresh")); err != nil {
+		a.log.Errorln("error writing response", "err", err)
+	}
+}
+
+func (a *Auth) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	a.log.Errorln("error authenticating request", "err", err)
+	if err := problem.Write(w, r, problem.Internal(err)); err != nil {
+		a.log.Errorln("error writing response", "err", err)
+	}
 }
 
-func (a *Auth) continueWithUser(w http.ResponseWriter, r *http.Request, next http.Handler) {
-	a.log.With("userID", a.userID).Debug("add userID to request context")
-	ctx := context.WithValue(r.Context(), AuthenticatedUserKey, a.userID)
+func (a *Auth) continueWithUser(w http.ResponseWriter, r *http.Request, next http.Handler, userID string) {
+	a.log.With("userID", userID).Debug("add userID to request context")
+	ctx := context.WithValue(r.Context(), AuthenticatedUserKey, userID)
 	next.ServeHTTP(w, r.WithContext(ctx))
 }
@@ -1,3 +1,3 @@
-// Package middleware implements HTTP server middleware, such as logging,
-// gzip encoding, and authentication.
+// Package middleware implements HTTP server middleware, such as request IDs, logging,
+// metrics, gzip encoding, and authentication.
 package middleware
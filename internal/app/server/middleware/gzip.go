@@ -36,16 +36,17 @@ func Gzip(next http.Handler) http.Handler {
 	})
 }
 
+// compressWriter compresses the response body.
+//
+// Responses which can not have a body, such as 204 No Content, are written as is.
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
-	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
-	}
+	return &compressWriter{w: w}
 }
 
 // Header is an implementation of http.ResponseWriter interface.
@@ -55,19 +56,33 @@ func (c *compressWriter) Header() http.Header {
 
 // Write is an implementation of http.ResponseWriter interface.
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.zw == nil {
+		return c.w.Write(p)
+	}
+
 	return c.zw.Write(p)
 }
 
 // WriteHeader is an implementation of http.ResponseWriter interface.
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
+	c.wroteHeader = true
+	if statusCode != http.StatusNoContent && statusCode != http.StatusNotModified {
 		c.w.Header().Set("Content-Encoding", "gzip")
+		c.w.Header().Del("Content-Length")
+		c.zw = gzip.NewWriter(c.w)
 	}
 	c.w.WriteHeader(statusCode)
 }
 
 // Close closes the gzip Writer.
 func (c *compressWriter) Close() error {
+	if c.zw == nil {
+		return nil
+	}
+
 	return c.zw.Close()
 }
 
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 )
 
@@ -42,6 +43,7 @@ func (l *Logger) Logger(next http.Handler) http.Handler {
 			"status", responseData.status,
 			"duration", duration,
 			"size", responseData.size,
+			"request_id", middleware.GetReqID(r.Context()),
 		).Info("processed request")
 	})
 }
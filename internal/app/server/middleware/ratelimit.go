@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+)
+
+// Rate limit classes.
+const (
+	// RateLimitCreate limits creation of short URLs.
+	RateLimitCreate = "create"
+	// RateLimitRedirect limits redirects by short URLs.
+	RateLimitRedirect = "redirect"
+	// RateLimitRegister limits registration of new users by client IP.
+	RateLimitRegister = "register"
+)
+
+// DefaultSweepInterval is the default period between removals of full buckets from the backend.
+const DefaultSweepInterval = time.Minute
+
+// ipv6PrefixLength is the length of the IPv6 network prefix by which clients are limited.
+const ipv6PrefixLength = 64
+
+// RateLimiterOptions represents dependencies required for RateLimiter.
+type RateLimiterOptions struct {
+	Backend ratelimit.Backend
+	// Limits are limits by class. Requests of classes without a limit are not limited.
+	Limits map[string]ratelimit.Limit
+	// TrustRealIP makes the X-Real-IP header the client address instead of
+	// the remote address of the connection. Only enable it behind a reverse
+	// proxy which overwrites the header.
+	TrustRealIP bool
+	// SweepInterval is the period between removals of full buckets (default: DefaultSweepInterval).
+	SweepInterval time.Duration
+	Log           *zap.SugaredLogger
+}
+
+// RateLimiter is a rate limiting middleware with token buckets.
+//
+// Requests get RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers. Rejected requests get 429 Too Many Requests with
+// the Retry-After header. If the backend fails, requests are let through.
+// Clients are limited by their IPv4 address or by the /64 network of their IPv6 address.
+//
+// Full buckets are removed from the backend in background between Start and Stop.
+//
+// A nil *RateLimiter does not limit requests. Use NewRateLimiter to create a new RateLimiter.
+type RateLimiter struct {
+	backend       ratelimit.Backend
+	limits        map[string]ratelimit.Limit
+	trustRealIP   bool
+	sweepInterval time.Duration
+	log           *zap.SugaredLogger
+	// now is replaced in tests.
+	now func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRateLimiter creates a new RateLimiter middleware.
+func NewRateLimiter(opts RateLimiterOptions) *RateLimiter {
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = DefaultSweepInterval
+	}
+
+	return &RateLimiter{
+		backend:       opts.Backend,
+		limits:        opts.Limits,
+		trustRealIP:   opts.TrustRealIP,
+		sweepInterval: opts.SweepInterval,
+		log:           opts.Log,
+		now:           time.Now,
+	}
+}
+
+// Start starts removing full buckets from the backend in background.
+func (l *RateLimiter) Start() {
+	if l == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+
+	go l.run(ctx)
+}
+
+// Stop stops removing full buckets and waits until the current removal is finished or ctx is done.
+func (l *RateLimiter) Stop(ctx context.Context) error {
+	if l == nil || l.cancel == nil {
+		return nil
+	}
+	l.cancel()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Limit returns a middleware which limits requests of the class by the authenticated
+// user, or by the client IP if the request is not authenticated.
+//
+// Users registered by the request itself are limited by the client IP as well,
+// otherwise every request without a cookie would get a new full bucket.
+func (l *RateLimiter) Limit(class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(AuthenticatedUserKey).(string)
+			registered, _ := r.Context().Value(registeredUserKey).(bool)
+			if (userID == "" || registered) && !l.allowClient(w, r, class) {
+				return
+			}
+			if userID != "" && !l.allow(w, r, class, "user:"+userID) {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowClient is like allow but the requests are limited by the client IP.
+func (l *RateLimiter) allowClient(w http.ResponseWriter, r *http.Request, class string) bool {
+	if l == nil {
+		return true
+	}
+
+	return l.allow(w, r, class, clientKey(ClientIP(r, l.trustRealIP)))
+}
+
+// AllowClient takes a token of the class from the bucket of the client IP, so that
+// transports other than HTTP share the limits. If there are no tokens left,
+// it returns false and the time until the next request is allowed.
+// A nil *RateLimiter allows all requests.
+func (l *RateLimiter) AllowClient(ctx context.Context, class, ip string) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	key := clientKey(ip)
+	res, ok := l.take(ctx, class, key)
+	if !ok || res.Allowed {
+		return 0, true
+	}
+	l.reject(class, key)
+
+	return res.RetryAfter, false
+}
+
+// allow takes a token from the bucket of the class and key. If there are no
+// tokens left, it writes the 429 response and returns false.
+func (l *RateLimiter) allow(w http.ResponseWriter, r *http.Request, class, key string) bool {
+	if l == nil {
+		return true
+	}
+	res, ok := l.take(r.Context(), class, key)
+	if !ok {
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(res.Limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", res.Limit.Requests, ceilSeconds(res.Limit.Period)))
+	if res.Allowed {
+		return true
+	}
+	l.reject(class, key)
+
+	header.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	if err := problem.Write(w, r, problem.TooManyRequests("rate limit exceeded, retry later")); err != nil {
+		l.log.Errorln("error writing response", "err", err)
+	}
+
+	return false
+}
+
+// take takes a token from the bucket of the class and key. It returns false if
+// the class is not limited or the backend fails, then the request is let through.
+func (l *RateLimiter) take(ctx context.Context, class, key string) (ratelimit.Result, bool) {
+	limit, ok := l.limits[class]
+	if !ok {
+		return ratelimit.Result{}, false
+	}
+
+	res, err := l.backend.Take(ctx, class+":"+key, limit, l.now())
+	if err != nil {
+		// The service keeps working without rate limits rather than failing all requests.
+		l.log.Errorln("error taking rate limit token", "class", class, "err", err)
+		return ratelimit.Result{}, false
+	}
+
+	return res, true
+}
+
+// reject records the request rejected by the limit of the class.
+func (l *RateLimiter) reject(class, key string) {
+	metrics.RateLimited.WithLabelValues(class).Inc()
+	l.log.With("class", class, "key", key).Debug("rate limit exceeded")
+}
+
+func (l *RateLimiter) run(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep removes full buckets from the backend.
+func (l *RateLimiter) sweep(ctx context.Context) {
+	n, err := l.backend.Sweep(ctx, l.now())
+	if err != nil && ctx.Err() == nil {
+		l.log.Errorln("error removing rate limit buckets", "err", err)
+	}
+	if n > 0 {
+		l.log.With("count", n).Debug("removed rate limit buckets")
+	}
+}
+
+// clientKey returns the bucket key of the client IP. IPv6 clients are limited by
+// the network of the address, as a single client usually gets a whole /64 and
+// would get a full bucket with every address of it otherwise.
+func clientKey(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() != nil {
+		return "ip:" + ip
+	}
+
+	return fmt.Sprintf("ip:%s/%d", addr.Mask(net.CIDRMask(ipv6PrefixLength, 128)), ipv6PrefixLength)
+}
+
+// ceilSeconds rounds d up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestID is a middleware which assigns an ID to every request.
+//
+// The ID is taken from the X-Request-Id request header or generated if the
+// header is missing. It is stored in the request context, so that it can be
+// read with middleware.GetReqID of chi, and returned in the X-Request-Id response header.
+func RequestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
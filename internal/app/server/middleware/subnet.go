@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+)
+
+// RealIPHeader contains the IP address of the client set by a reverse proxy.
+const RealIPHeader = "X-Real-IP"
+
+// ClientIP returns the IP address of the client. If trustRealIP is set, it is taken
+// from the X-Real-IP header when the header holds a valid address.
+func ClientIP(r *http.Request, trustRealIP bool) string {
+	if trustRealIP {
+		if ip := net.ParseIP(r.Header.Get(RealIPHeader)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// TrustedSubnet returns a middleware which only lets through requests whose
+// X-Real-IP header is an address within subnet, other requests get 403 Forbidden.
+// All requests are forbidden if subnet is nil.
+//
+// The header is trusted as is, so the service must be behind a reverse proxy
+// which overwrites it.
+func TrustedSubnet(subnet *net.IPNet, log *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var detail string
+			ip := net.ParseIP(r.Header.Get(RealIPHeader))
+			switch {
+			case subnet == nil:
+				detail = "trusted subnet is not configured"
+			case ip == nil || !subnet.Contains(ip):
+				detail = "client address is not within the trusted subnet"
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := problem.Write(w, r, problem.Forbidden(detail)); err != nil {
+				log.Errorln("error writing response", "err", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package problem implements the error model of REST API.
+//
+// Errors are written as RFC 7807 problem details with the application/problem+json
+// content type. Every response carries the request ID, so that it can be found in the logs.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Error codes.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeNotFound         = "not_found"
+	CodeGone             = "gone"
+	CodeConflict         = "conflict"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInternal         = "internal_error"
+	CodeUnavailable      = "unavailable"
+	CodeRateLimited      = "rate_limited"
+)
+
+// Error is an API error which is written to the client as problem details.
+//
+// Use the constructors, such as Validation or NotFound, to create an Error.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	// Errors lists invalid request fields of a validation error.
+	Errors []models.FieldError
+	// Result is the existing short URL of a conflict error.
+	Result string
+	// Alias is the taken alias of a conflict error.
+	Alias string
+	// Err is the cause of the error. It is logged but never exposed to the client.
+	Err error
+}
+
+// Error implements error interface.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+
+	return e.Code + ": " + e.Detail
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates a new Error with the given status.
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// Validation creates a 400 Bad Request error with optional field details.
+func Validation(code, detail string, fields ...models.FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: code, Detail: detail, Errors: fields}
+}
+
+// NotFound creates a 404 Not Found error.
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// Gone creates a 410 Gone error.
+func Gone(code, detail string) *Error {
+	return New(http.StatusGone, code, detail)
+}
+
+// Conflict creates a 409 Conflict error.
+func Conflict(code, detail string) *Error {
+	return New(http.StatusConflict, code, detail)
+}
+
+// Unauthorized creates a 401 Unauthorized error.
+func Unauthorized(detail string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, detail)
+}
+
+// Forbidden creates a 403 Forbidden error.
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+// Unavailable creates a 503 Service Unavailable error.
+func Unavailable(detail string) *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, detail)
+}
+
+// TooManyRequests creates a 429 Too Many Requests error.
+func TooManyRequests(detail string) *Error {
+	return New(http.StatusTooManyRequests, CodeRateLimited, detail)
+}
+
+// Internal creates a 500 Internal Server Error caused by err.
+func Internal(err error) *Error {
+	return &Error{
+		Status: http.StatusInternalServerError,
+		Code:   CodeInternal,
+		Detail: "internal error",
+		Err:    err,
+	}
+}
+
+// From converts err into *Error. Errors of other types become internal errors.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return Internal(err)
+}
+
+// Write writes err to the client as problem details.
+//
+// Errors other than *Error are written as internal errors without exposing
+// their messages. It returns the error of writing the response body.
+func Write(w http.ResponseWriter, r *http.Request, err error) error {
+	e := From(err)
+
+	body := models.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestID: middleware.GetReqID(r.Context()),
+		Errors:    e.Errors,
+		Result:    e.Result,
+		Alias:     e.Alias,
+	}
+
+	w.Header().Set("content-type", ContentType)
+	w.WriteHeader(e.Status)
+
+	return json.NewEncoder(w).Encode(body)
+}
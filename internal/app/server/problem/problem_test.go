@@ -0,0 +1,79 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want models.Problem
+	}{
+		{
+			name: "validation error",
+			err:  Validation(CodeInvalidRequest, "required field is missing", models.FieldError{Field: "/url", Message: "is required"}),
+			want: models.Problem{
+				Type:     "about:blank",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "required field is missing",
+				Instance: "/api/shorten",
+				Code:     CodeInvalidRequest,
+				Errors:   []models.FieldError{{Field: "/url", Message: "is required"}},
+			},
+		},
+		{
+			name: "wrapped error",
+			err:  fmt.Errorf("wrapped: %w", Gone("expired", "url has expired")),
+			want: models.Problem{
+				Type:     "about:blank",
+				Title:    "Gone",
+				Status:   http.StatusGone,
+				Detail:   "url has expired",
+				Instance: "/api/shorten",
+				Code:     "expired",
+			},
+		},
+		{
+			name: "unknown error is not exposed",
+			err:  errors.New("connection refused"),
+			want: models.Problem{
+				Type:     "about:blank",
+				Title:    "Internal Server Error",
+				Status:   http.StatusInternalServerError,
+				Detail:   "internal error",
+				Instance: "/api/shorten",
+				Code:     CodeInternal,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+
+			err := Write(w, r, test.err)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.want.Status, w.Code)
+			assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+
+			var res models.Problem
+			err = json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, res)
+		})
+	}
+}
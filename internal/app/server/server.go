@@ -2,16 +2,11 @@
 package server
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"math/big"
+	"context"
+	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -19,7 +14,9 @@ import (
 
 	"github.com/madatsci/urlshortener/internal/app/config"
 	"github.com/madatsci/urlshortener/internal/app/handlers"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
 	mw "github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
 	"github.com/madatsci/urlshortener/internal/app/store"
 	"github.com/madatsci/urlshortener/pkg/jwt"
 )
@@ -28,48 +25,73 @@ import (
 //
 // It holds the application's HTTP router, configuration, handler logic, and logger.
 // Use New to create and configure a server instance, then call Start to begin
-// handling HTTP requests.
+// handling HTTP requests and Shutdown to stop it gracefully.
 type Server struct {
-	mux    http.Handler
-	config *config.Config
-	h      *handlers.Handlers
-	log    *zap.SugaredLogger
+	mux http.Handler
+	srv *http.Server
+	// redirect is the HTTP to HTTPS redirect server, nil if disabled.
+	redirect *http.Server
+	config   *config.Config
+	h        *handlers.Handlers
+	// limiter is nil if rate limits are disabled.
+	limiter *mw.RateLimiter
+	log     *zap.SugaredLogger
+}
+
+// Options contains dependencies of the server which are created on start
+// rather than described by the configuration.
+type Options struct {
+	// Tokens issues and verifies authentication tokens. If nil, tokens are
+	// signed with the token secret from the configuration.
+	Tokens *jwt.JWT
+	// RateLimitBackend keeps the state of rate limits (default: ratelimit.NewMemory()).
+	RateLimitBackend ratelimit.Backend
+	// Handlers are options of the handlers of the server.
+	Handlers handlers.Options
 }
 
 // New creates a new HTTP server.
-func New(config *config.Config, store store.Store, logger *zap.SugaredLogger) *Server {
+func New(config *config.Config, store store.Store, opts Options, logger *zap.SugaredLogger) *Server {
 	server := &Server{
 		config: config,
 		log:    logger,
 	}
 
-	h := handlers.New(config, logger, store)
+	h := handlers.New(config, logger, store, opts.Handlers)
 
 	r := chi.NewRouter()
 
 	loggerMiddleware := mw.NewLogger(server.log)
+	r.Use(mw.RequestID)
 	r.Use(loggerMiddleware.Logger)
+	r.Use(mw.Metrics)
 	r.Use(mw.Gzip)
 	r.Use(middleware.Recoverer)
 
 	// Mounting net/http/pprof.
 	r.Mount("/debug", middleware.Profiler())
 
+	limiter := newRateLimiter(config, opts.RateLimitBackend, logger)
+	tokens := opts.Tokens
+	if tokens == nil {
+		tokens = jwt.New(config.TokenOptions())
+	}
 	authMiddleware := mw.NewAuth(mw.Options{
-		JWT: jwt.New(jwt.Options{
-			Secret:   config.TokenSecret,
-			Duration: config.TokenDuration,
-			Issuer:   config.TokenIssuer,
-		}),
-		Store: store,
-		Log:   logger,
+		JWT:         tokens,
+		Store:       store,
+		Log:         logger,
+		Secure:      config.TLS.Enabled,
+		RateLimiter: limiter,
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware.PublicAPIAuth)
-		r.Post("/", h.AddHandler)
-		r.Post("/api/shorten", h.AddHandlerJSON)
-		r.Post("/api/shorten/batch", h.AddHandlerJSONBatch)
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Limit(mw.RateLimitCreate))
+			r.Post("/", h.AddHandler)
+			r.Post("/api/shorten", h.AddHandlerJSON)
+			r.Post("/api/shorten/batch", h.AddHandlerJSONBatch)
+		})
 		// For some unknown reason Yandex Practicum tests now require
 		// this endpoint to be public.
 		// https://github.com/Yandex-Practicum/go-autotests/pull/82
@@ -79,86 +101,192 @@ func New(config *config.Config, store store.Store, logger *zap.SugaredLogger) *S
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware.PrivateAPIAuth)
 		r.Delete("/api/user/urls", h.DeleteUserURLsHandler)
+		r.Get("/api/user/deletions/{id}", h.DeleteJobHandler)
+		r.Get("/api/user/urls/{slug}/stats", h.URLStatsHandler)
+		r.With(limiter.Limit(mw.RateLimitCreate)).Post("/api/user/urls/import", h.ImportURLsHandler)
+		r.Get("/api/user/urls/export", h.ExportURLsHandler)
+		r.Post("/api/user/keys", h.CreateAPIKeyHandler)
+		r.Get("/api/user/keys", h.ListAPIKeysHandler)
+		r.Delete("/api/user/keys/{id}", h.RevokeAPIKeyHandler)
 	})
 
+	r.Group(func(r chi.Router) {
+		r.Use(mw.TrustedSubnet(config.Server.TrustedNet(), logger))
+		r.Get("/api/internal/stats", h.InternalStatsHandler)
+		r.Post("/api/internal/blocklist/apply", h.ApplyBlocklistHandler)
+	})
+
+	r.Post("/api/auth/refresh", authMiddleware.RefreshHandler)
+	r.Post("/api/auth/logout", authMiddleware.LogoutHandler)
+
+	r.Get("/.well-known/jwks.json", server.jwksHandler(tokens))
+
 	r.Get("/ping", h.PingHandler)
-	r.Get("/{slug}", h.GetHandler)
+	r.With(limiter.Limit(mw.RateLimitRedirect)).Get("/{slug}", h.GetHandler)
+	r.With(limiter.Limit(mw.RateLimitRedirect)).Head("/{slug}", h.GetHandler)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		server.writeProblem(w, r, problem.NotFound("route not found"))
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		server.writeProblem(w, r, problem.New(http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "method not allowed"))
+	})
 
 	server.h = h
+	server.limiter = limiter
 	server.mux = r
+	server.srv = &http.Server{
+		Addr:    config.Server.Addr,
+		Handler: r,
+	}
+	if config.TLS.Enabled && config.TLS.RedirectAddr != "" {
+		server.redirect = &http.Server{
+			Addr:    config.TLS.RedirectAddr,
+			Handler: redirectHandler(config.Server.Addr),
+		}
+	}
 
 	return server
 }
 
+// newRateLimiter creates the rate limiting middleware, nil if rate limits are disabled.
+//
+// The in-memory backend is used if backend is nil.
+func newRateLimiter(config *config.Config, backend ratelimit.Backend, logger *zap.SugaredLogger) *mw.RateLimiter {
+	if !config.RateLimit.Enabled {
+		return nil
+	}
+
+	limits := make(map[string]ratelimit.Limit)
+	for class, value := range map[string]string{
+		mw.RateLimitCreate:   config.RateLimit.Create,
+		mw.RateLimitRedirect: config.RateLimit.Redirect,
+		mw.RateLimitRegister: config.RateLimit.Register,
+	} {
+		if value == "" {
+			continue
+		}
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			// The configuration is validated on load.
+			logger.Errorln("invalid rate limit, the limit is disabled", "class", class, "err", err)
+			continue
+		}
+		limits[class] = limit
+	}
+
+	if backend == nil {
+		backend = ratelimit.NewMemory()
+	}
+
+	return mw.NewRateLimiter(mw.RateLimiterOptions{
+		Backend:     backend,
+		Limits:      limits,
+		TrustRealIP: config.RateLimit.TrustRealIP,
+		Log:         logger,
+	})
+}
+
 // Start starts the server after it was created and configured.
+//
+// It blocks until the server is stopped. After Shutdown is called
+// Start returns nil.
 func (s *Server) Start() error {
-	s.log.Infof("starting server with config: %+v", s.config)
+	s.log.Infow("starting server", "config", s.config.Redacted())
 
-	if s.config.EnableHTTPS {
+	var err error
+	if s.config.TLS.Enabled {
 		s.log.Info("HTTPS is enabled")
-		cert, err := s.generateSelfSignedCert()
-		if err != nil {
-			return err
+		tlsConfig, tlsErr := s.tlsConfig()
+		if tlsErr != nil {
+			return tlsErr
 		}
-		server := &http.Server{
-			Addr:    s.config.ServerAddr,
-			Handler: s.mux,
-			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{*cert},
-			},
+		s.srv.TLSConfig = tlsConfig
+
+		if s.redirect != nil {
+			lis, lisErr := net.Listen("tcp", s.redirect.Addr)
+			if lisErr != nil {
+				return lisErr
+			}
+			s.log.Infof("redirecting HTTP requests on %s to HTTPS", s.redirect.Addr)
+			go func() {
+				if err := s.redirect.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					s.log.Errorf("error serving HTTP redirects: %s", err)
+				}
+			}()
 		}
-		return server.ListenAndServeTLS("", "")
+
+		err = s.srv.ListenAndServeTLS("", "")
+		if s.redirect != nil && !errors.Is(err, http.ErrServerClosed) {
+			// Redirects lead nowhere without the HTTPS server.
+			s.redirect.Close()
+		}
+	} else {
+		err = s.srv.ListenAndServe()
 	}
 
-	return http.ListenAndServe(s.config.ServerAddr, s.mux)
-}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 
-// Router returns server router for usage in tests.
-func (s *Server) Router() http.Handler {
-	return s.mux
+	return err
 }
 
-// generateSelfSignedCert generates a self-signed TLS certificate for development purposes.
-// Self-signed certificates aren't trusted by default because they're not issued
-// by a recognized Certificate Authority (CA).
-// To resolve this for testing with curl, you have a few options:
-//  1. Use the --insecure (-k) flag with curl to bypass certificate validation.
-//     For development purposes, it is the simplest and most common approach.
-//  2. Modify the code to save the generated certificate to a file (e.g., server.crt) temporarily.
-//     Then use `curl --cacert server.crt https://...`
-//  3. Add the self-signed certificate to your system's trusted certificate store
-//     (not recommended for general use).
-func (s *Server) generateSelfSignedCert() (*tls.Certificate, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
+// Shutdown gracefully stops the server.
+//
+// It stops accepting new connections, waits for active requests to complete
+// and then flushes all pending asynchronous jobs, such as queued delete requests and clicks.
+// The jobs are flushed even if active requests have not completed in time: they get
+// their own deadline of the configured shutdown timeout. When Shutdown returns,
+// the storage is not used anymore.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.redirect != nil {
+		errs = append(errs, s.redirect.Shutdown(ctx))
 	}
+	errs = append(errs, s.srv.Shutdown(ctx))
 
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"Self-signed"},
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(time.Hour * 24 * 365),
-		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
-		DNSNames:    []string{"localhost"},
+	timeout := s.config.Server.ShutdownTimeout.Duration
+	if timeout <= 0 {
+		timeout = config.Default().Server.ShutdownTimeout.Duration
 	}
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	errs = append(errs, s.h.Close(drainCtx))
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
-	if err != nil {
-		return nil, err
-	}
+	return errors.Join(errs...)
+}
+
+// Handlers returns server handlers, so that they can be shared with other transports.
+func (s *Server) Handlers() *handlers.Handlers {
+	return s.h
+}
 
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+// RateLimiter returns the rate limiter of the server, so that it can be shared
+// with other transports. It is nil if rate limits are disabled.
+func (s *Server) RateLimiter() *mw.RateLimiter {
+	return s.limiter
+}
 
-	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
-	if err != nil {
-		return nil, err
+// Router returns server router for usage in tests.
+func (s *Server) Router() http.Handler {
+	return s.mux
+}
+
+// jwksHandler serves public keys which verify authentication tokens.
+func (s *Server) jwksHandler(tokens *jwt.JWT) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("cache-control", "public, max-age=300")
+
+		if err := json.NewEncoder(w).Encode(tokens.JWKS()); err != nil {
+			s.log.Errorln("error writing response", "err", err)
+		}
 	}
+}
 
-	return &tlsCert, nil
+func (s *Server) writeProblem(w http.ResponseWriter, r *http.Request, err *problem.Error) {
+	if err := problem.Write(w, r, err); err != nil {
+		s.log.Errorln("error writing response", "err", err)
+	}
 }
@@ -4,29 +4,49 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/handlers"
+	"github.com/madatsci/urlshortener/internal/app/metrics"
 	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/ratelimit"
+	"github.com/madatsci/urlshortener/internal/app/screening"
+	mw "github.com/madatsci/urlshortener/internal/app/server/middleware"
+	"github.com/madatsci/urlshortener/internal/app/server/problem"
+	"github.com/madatsci/urlshortener/internal/app/store"
 	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	"github.com/madatsci/urlshortener/internal/random"
 	"github.com/madatsci/urlshortener/pkg/jwt"
 )
 
 const (
-	filepath      = "../../../tmp/test_storage.json"
+	storagePath   = "../../../tmp/test_storage.json"
 	tokenSecret   = "super_secret"
 	tokenDuration = time.Hour
 	tokenIssuer   = "urlshortener_test"
@@ -57,7 +77,7 @@ func TestAddHandler(t *testing.T) {
 			requestBody: "",
 			want: want{
 				code:        http.StatusBadRequest,
-				contentType: "",
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -111,8 +131,8 @@ func TestAddHandlerJSON(t *testing.T) {
 			name:        "negative case: invalid JSON",
 			requestBody: "{",
 			want: want{
-				code:        http.StatusInternalServerError,
-				contentType: "",
+				code:        http.StatusBadRequest,
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -121,7 +141,7 @@ func TestAddHandlerJSON(t *testing.T) {
 			requestBody: `{"url":""}`,
 			want: want{
 				code:        http.StatusBadRequest,
-				contentType: "",
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -150,6 +170,274 @@ func TestAddHandlerJSON(t *testing.T) {
 	}
 }
 
+func TestAddHandlerJSONAlias(t *testing.T) {
+	tests := []struct {
+		name        string
+		requestBody string
+		code        int
+		wantBody    string
+		wantCode    string
+	}{
+		{
+			name:        "positive case",
+			requestBody: `{"url":"https://practicum.yandex.ru/","alias":"promo-2024"}`,
+			code:        http.StatusCreated,
+			wantBody:    `{"result":"http://localhost:8080/promo-2024"}`,
+		},
+		{
+			name:        "negative case: alias is taken",
+			requestBody: `{"url":"http://example.org","alias":"promo-2024"}`,
+			code:        http.StatusConflict,
+			wantCode:    "alias_taken",
+		},
+		{
+			name:        "negative case: invalid characters",
+			wantCode:    "invalid_alias",
+			requestBody: `{"url":"http://example.org","alias":"promo/2024"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "negative case: too short",
+			wantCode:    "invalid_alias",
+			requestBody: `{"url":"http://example.org","alias":"ab"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "negative case: reserved word",
+			wantCode:    "invalid_alias",
+			requestBody: `{"url":"http://example.org","alias":"API"}`,
+			code:        http.StatusBadRequest,
+		},
+	}
+
+	_, ts := testServer()
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(test.requestBody), "")
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.code, resp.StatusCode, "Unexpected response code")
+
+			if test.wantBody != "" {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Unexpected content type")
+				respStr, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, test.wantBody, string(respStr))
+			}
+
+			if test.wantCode != "" {
+				assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"), "Unexpected content type")
+				var res models.Problem
+				err := json.NewDecoder(resp.Body).Decode(&res)
+				require.NoError(t, err)
+				assert.Equal(t, test.wantCode, res.Code)
+				if test.code == http.StatusConflict {
+					assert.Equal(t, "promo-2024", res.Alias)
+				}
+			}
+		})
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		requestBody := `[{"correlation_id":"1","original_url":"http://example.org/1","alias":"batch-alias"},{"correlation_id":"2","original_url":"http://example.org/2"}]`
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var res models.ShortenBatchResponse
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(res.URLs))
+		assert.Equal(t, "http://localhost:8080/batch-alias", res.URLs[0].ShortURL)
+
+		requestBody = `[{"correlation_id":"1","original_url":"http://example.org/other","alias":"batch-alias"}]`
+		resp = testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		requestBody = `[{"correlation_id":"1","original_url":"http://example.org/3","alias":"twice"},{"correlation_id":"2","original_url":"http://example.org/4","alias":"twice"}]`
+		resp = testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestAddHandlerJSONExpiration(t *testing.T) {
+	tests := []struct {
+		name        string
+		requestBody string
+		code        int
+	}{
+		{
+			name:        "positive case: ttl",
+			requestBody: `{"url":"http://example.org/ttl","ttl":"1h"}`,
+			code:        http.StatusCreated,
+		},
+		{
+			name:        "positive case: expires_at",
+			requestBody: fmt.Sprintf(`{"url":"http://example.org/expires","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339)),
+			code:        http.StatusCreated,
+		},
+		{
+			name:        "negative case: both ttl and expires_at",
+			requestBody: fmt.Sprintf(`{"url":"http://example.org/both","ttl":"1h","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339)),
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "negative case: expires_at in the past",
+			requestBody: fmt.Sprintf(`{"url":"http://example.org/past","expires_at":%q}`, time.Now().Add(-time.Hour).Format(time.RFC3339)),
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "negative case: invalid ttl",
+			requestBody: `{"url":"http://example.org/invalid","ttl":"one hour"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "negative case: negative ttl",
+			requestBody: `{"url":"http://example.org/negative","ttl":"-1h"}`,
+			code:        http.StatusBadRequest,
+		},
+	}
+
+	s, ts := testServer()
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(test.requestBody), "")
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.code, resp.StatusCode, "Unexpected response code")
+			if test.code != http.StatusBadRequest {
+				return
+			}
+
+			var res models.Problem
+			err := json.NewDecoder(resp.Body).Decode(&res)
+			require.NoError(t, err)
+			assert.Equal(t, "invalid_expiration", res.Code)
+		})
+	}
+
+	urls, err := s.h.Store().ListAllUrls(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(urls))
+	for _, url := range urls {
+		require.NotNil(t, url.ExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *url.ExpiresAt, time.Minute)
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		requestBody := `[{"correlation_id":"1","original_url":"http://example.org/1","ttl":"1h"},{"correlation_id":"2","original_url":"http://example.org/2","ttl":"0s"}]`
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestAddHandlerURLValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		requestBody string
+		field       string
+	}{
+		{
+			name:        "javascript URL",
+			path:        "/",
+			requestBody: "javascript:alert(1)",
+			field:       "/url",
+		},
+		{
+			name:        "relative path",
+			path:        "/api/shorten",
+			requestBody: `{"url":"/path/to/page"}`,
+			field:       "/url",
+		},
+		{
+			name:        "data URL",
+			path:        "/api/shorten",
+			requestBody: `{"url":"data:text/html,hello"}`,
+			field:       "/url",
+		},
+		{
+			name:        "too long",
+			path:        "/api/shorten",
+			requestBody: fmt.Sprintf(`{"url":"https://example.org/%s"}`, strings.Repeat("a", 2048)),
+			field:       "/url",
+		},
+		{
+			name:        "batch item",
+			path:        "/api/shorten/batch",
+			requestBody: `[{"correlation_id":"1","original_url":"http://example.org/1"},{"correlation_id":"2","original_url":"example.org"}]`,
+			field:       "/1/original_url",
+		},
+		{
+			name:        "batch duplicates",
+			path:        "/api/shorten/batch",
+			requestBody: `[{"correlation_id":"1","original_url":"http://example.org/1"},{"correlation_id":"2","original_url":"HTTP://EXAMPLE.ORG:80/1"}]`,
+			field:       "/1/original_url",
+		},
+	}
+
+	s, ts := testServer()
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, http.MethodPost, test.path, strings.NewReader(test.requestBody), "")
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Unexpected response code")
+			assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"), "Unexpected content type")
+
+			var res models.Problem
+			err := json.NewDecoder(resp.Body).Decode(&res)
+			require.NoError(t, err)
+			assert.Equal(t, "invalid_url", res.Code)
+			require.Len(t, res.Errors, 1)
+			assert.Equal(t, test.field, res.Errors[0].Field)
+		})
+	}
+
+	urls, err := s.h.Store().ListAllUrls(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func TestAddHandlerCanonicalDedup(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("HTTP://Example.com/"), "")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	shortURL, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	resp = testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://example.com"}`), "")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	var res models.Problem
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	require.NoError(t, err)
+	assert.Equal(t, "already_shortened", res.Code)
+	assert.Equal(t, string(shortURL), res.Result)
+
+	// The original form is kept for redirects.
+	urls, err := s.h.Store().ListAllUrls(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	for _, url := range urls {
+		assert.Equal(t, "HTTP://Example.com/", url.Original)
+		assert.Equal(t, "http://example.com/", url.Canonical)
+	}
+}
+
 func TestAddHandlerJSONBatch(t *testing.T) {
 	type want struct {
 		code        int
@@ -174,8 +462,8 @@ func TestAddHandlerJSONBatch(t *testing.T) {
 			name:        "negative case: invalid JSON",
 			requestBody: "{",
 			want: want{
-				code:        http.StatusInternalServerError,
-				contentType: "",
+				code:        http.StatusBadRequest,
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -184,7 +472,7 @@ func TestAddHandlerJSONBatch(t *testing.T) {
 			requestBody: `[{"correlation_id":"mC9g8iasXW","original_url":""},{"correlation_id":"XFADu5Xlkw","original_url":""}]`,
 			want: want{
 				code:        http.StatusBadRequest,
-				contentType: "",
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -193,7 +481,7 @@ func TestAddHandlerJSONBatch(t *testing.T) {
 			requestBody: `[]`,
 			want: want{
 				code:        http.StatusBadRequest,
-				contentType: "",
+				contentType: problem.ContentType,
 				wantErr:     true,
 			},
 		},
@@ -223,6 +511,221 @@ func TestAddHandlerJSONBatch(t *testing.T) {
 	}
 }
 
+func TestAddHandlerJSONBatchPartial(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	batch := func(t *testing.T, mode, requestBody string) (int, models.ShortenBatchResponse) {
+		t.Helper()
+
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten/batch?mode="+mode, strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+
+		var res models.ShortenBatchResponse
+		if resp.StatusCode < http.StatusBadRequest {
+			err := json.NewDecoder(resp.Body).Decode(&res)
+			require.NoError(t, err)
+		}
+
+		return resp.StatusCode, res
+	}
+
+	code, res := batch(t, "partial", `[{"correlation_id":"1","original_url":"https://example.org/existing","alias":"partial-alias"}]`)
+	require.Equal(t, http.StatusCreated, code)
+	require.Len(t, res.URLs, 1)
+	assert.Equal(t, models.BatchItemCreated, res.URLs[0].Status)
+	existing := res.URLs[0].ShortURL
+
+	code, res = batch(t, "partial", `[
+		{"correlation_id":"new","original_url":"https://example.org/new"},
+		{"correlation_id":"exists","original_url":"HTTPS://EXAMPLE.ORG/existing"},
+		{"correlation_id":"invalid","original_url":"javascript:alert(1)"},
+		{"correlation_id":"missing"},
+		{"correlation_id":"taken","original_url":"https://example.org/other","alias":"partial-alias"},
+		{"correlation_id":"ttl","original_url":"https://example.org/ttl","ttl":"-1h"}
+	]`)
+	require.Equal(t, http.StatusMultiStatus, code)
+	require.Len(t, res.URLs, 6)
+
+	items := make(map[string]models.ShortenBatchResponseItem, len(res.URLs))
+	for _, item := range res.URLs {
+		items[item.CorrelationID] = item
+	}
+
+	assert.Equal(t, models.BatchItemCreated, items["new"].Status)
+	assert.NotEmpty(t, items["new"].ShortURL)
+
+	assert.Equal(t, models.BatchItemExists, items["exists"].Status)
+	assert.Equal(t, existing, items["exists"].ShortURL)
+
+	assert.Equal(t, models.BatchItemInvalid, items["invalid"].Status)
+	assert.Empty(t, items["invalid"].ShortURL)
+	assert.Equal(t, "invalid_url", items["invalid"].Code)
+	require.NotEmpty(t, items["invalid"].Errors)
+	assert.Equal(t, "/2/original_url", items["invalid"].Errors[0].Field)
+
+	assert.Equal(t, models.BatchItemInvalid, items["missing"].Status)
+	require.NotEmpty(t, items["missing"].Errors)
+	assert.Equal(t, "/3/original_url", items["missing"].Errors[0].Field)
+
+	assert.Equal(t, models.BatchItemInvalid, items["taken"].Status)
+	assert.Equal(t, "alias_taken", items["taken"].Code)
+	require.NotEmpty(t, items["taken"].Errors)
+	assert.Equal(t, "/4/alias", items["taken"].Errors[0].Field)
+
+	assert.Equal(t, models.BatchItemInvalid, items["ttl"].Status)
+	assert.Empty(t, items["ttl"].ShortURL)
+	require.NotEmpty(t, items["ttl"].Errors)
+	assert.Equal(t, "/5/ttl", items["ttl"].Errors[0].Field)
+	assert.Equal(t, "invalid_expiration", items["ttl"].Code)
+	assert.NotContains(t, items["ttl"].Detail, "ttl:")
+
+	// Invalid items are not shortened.
+	resp := testRequest(t, ts, http.MethodGet, "/partial-alias", nil, "")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, "https://example.org/existing", resp.Header.Get("Location"))
+
+	// The whole batch is rejected in atomic mode.
+	code, _ = batch(t, "atomic", `[{"correlation_id":"1","original_url":"https://example.org/atomic"},{"correlation_id":"2","original_url":"javascript:alert(1)"}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = batch(t, "all", `[{"correlation_id":"1","original_url":"https://example.org/"}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = batch(t, "partial", `[]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestImportExport(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.org/existing","alias":"existing"}`), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	importURLs := func(t *testing.T, contentType, body string) (int, models.ImportResponse) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/urls/import", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: authToken})
+		resp := sendRequest(t, req)
+		defer resp.Body.Close()
+
+		var res models.ImportResponse
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			require.NoError(t, err)
+		}
+
+		return resp.StatusCode, res
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		code, res := importURLs(t, "text/csv; charset=utf-8", `original_url,alias,created_at,comment
+https://example.org/csv-1,csv-alias,2024-01-02T03:04:05Z,first
+https://example.org/csv-2,,,
+https://example.org/existing,,,already shortened
+javascript:alert(1),,,
+https://example.org/csv-3,existing,,alias is taken
+https://example.org/csv-4,,not a time,
+"broken,,,
+`)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, res.Created)
+		assert.Equal(t, 1, res.Existing)
+		assert.Equal(t, 4, res.Failed)
+
+		lines := make(map[int]string)
+		for _, e := range res.Errors {
+			lines[e.Line] = e.Code
+		}
+		assert.Equal(t, map[int]string{5: "invalid_url", 6: "alias_taken", 7: "invalid_request", 8: "invalid_csv"}, lines)
+
+		resp := testRequest(t, ts, http.MethodGet, "/csv-alias", nil, "")
+		defer resp.Body.Close()
+		assert.Equal(t, "https://example.org/csv-1", resp.Header.Get("Location"))
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		code, res := importURLs(t, "application/x-ndjson", `{"original_url":"https://example.org/ndjson-1","alias":"ndjson-alias"}
+
+{"original_url":"https://example.org/ndjson-2","expires_at":"2000-01-01T00:00:00Z"}
+{"original_url":
+{"original_url":"https://example.org/csv-1"}
+`)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, res.Created)
+		assert.Equal(t, 1, res.Existing)
+		assert.Equal(t, 2, res.Failed)
+		require.Len(t, res.Errors, 2)
+		assert.Equal(t, 3, res.Errors[0].Line)
+		assert.Equal(t, "invalid_expiration", res.Errors[0].Code)
+		assert.Equal(t, 4, res.Errors[1].Line)
+		assert.Equal(t, "invalid_json", res.Errors[1].Code)
+	})
+
+	t.Run("invalid stream", func(t *testing.T) {
+		code, _ := importURLs(t, "application/json", `{"original_url":"https://example.org/"}`)
+		assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+		code, _ = importURLs(t, "text/csv", "url,alias\nhttps://example.org/missing-column,\n")
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = importURLs(t, "application/x-ndjson", `{"original_url":"https://example.org/too-long","alias":"`+strings.Repeat("a", 64*1024)+`"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls/export", nil, "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("export ndjson", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls/export", nil, authToken)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+		var records []models.URLRecord
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var record models.URLRecord
+			require.NoError(t, dec.Decode(&record))
+			records = append(records, record)
+		}
+		// The shortened URL, 2 created by CSV import and 1 by NDJSON import.
+		require.Len(t, records, 4)
+		assert.Equal(t, "https://example.org/csv-1", records[0].OriginalURL)
+		assert.Equal(t, "csv-alias", records[0].Alias)
+		assert.Equal(t, "http://localhost:8080/csv-alias", records[0].ShortURL)
+		assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), records[0].CreatedAt.UTC())
+	})
+
+	t.Run("export csv", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls/export?format=csv&q=csv-", nil, authToken)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+
+		rows, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, []string{"original_url", "alias", "short_url", "created_at", "expires_at"}, rows[0])
+		assert.Equal(t, []string{"https://example.org/csv-1", "csv-alias", "http://localhost:8080/csv-alias", "2024-01-02T03:04:05Z", ""}, rows[1])
+		assert.Equal(t, "https://example.org/csv-2", rows[2][0])
+
+		resp = testRequest(t, ts, http.MethodGet, "/api/user/urls/export?format=xml", nil, authToken)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
 func TestGetHandler(t *testing.T) {
 	type want struct {
 		code     int
@@ -248,7 +751,7 @@ func TestGetHandler(t *testing.T) {
 			name: "negative case: not found",
 			path: "/wrongURL",
 			want: want{
-				code:     http.StatusBadRequest,
+				code:     http.StatusNotFound,
 				location: "",
 				wantErr:  true,
 			},
@@ -262,6 +765,15 @@ func TestGetHandler(t *testing.T) {
 				wantErr:  true,
 			},
 		},
+		{
+			name: "negative case: expired",
+			path: "/expiredURL",
+			want: want{
+				code:     http.StatusGone,
+				location: "",
+				wantErr:  true,
+			},
+		},
 		{
 			name: "negative case: empty path",
 			path: "/",
@@ -297,6 +809,17 @@ func TestGetHandler(t *testing.T) {
 	err = s.h.Store().CreateURL(ctx, uuid.NewString(), deletedURL)
 	require.NoError(t, err)
 
+	expiresAt := time.Now().Add(-time.Minute)
+	expiredURL := models.URL{
+		ID:        uuid.NewString(),
+		Slug:      "expiredURL",
+		Original:  longURL + "expired_page/",
+		CreatedAt: time.Now(),
+		ExpiresAt: &expiresAt,
+	}
+	err = s.h.Store().CreateURL(ctx, uuid.NewString(), expiredURL)
+	require.NoError(t, err)
+
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			resp := testRequest(t, ts, http.MethodGet, test.path, nil, "")
@@ -311,6 +834,109 @@ func TestGetHandler(t *testing.T) {
 	}
 }
 
+func TestRedirectType(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+
+	shorten := func(t *testing.T, requestBody string) string {
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var res models.ShortenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return strings.TrimPrefix(res.Result, s.config.Server.BaseURL)
+	}
+
+	t.Run("default is temporary", func(t *testing.T) {
+		path := shorten(t, `{"url":"https://example.org/default"}`)
+
+		resp := testRequest(t, ts, http.MethodGet, path+"?utm_source=mail", nil, "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "https://example.org/default", resp.Header.Get("Location"))
+		assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+		assert.Empty(t, resp.Header.Get("Expires"))
+	})
+
+	t.Run("permanent with merged query", func(t *testing.T) {
+		path := shorten(t, `{"url":"https://example.org/page?lang=en#top","redirect_type":308,"merge_query":true}`)
+
+		resp := testRequest(t, ts, http.MethodGet, path+"?utm_source=mail&lang=de", nil, "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+		assert.Equal(t, "https://example.org/page?lang=en&utm_source=mail#top", resp.Header.Get("Location"))
+		assert.Equal(t, "public, max-age=86400", resp.Header.Get("Cache-Control"))
+		expires, err := http.ParseTime(resp.Header.Get("Expires"))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), expires, time.Minute)
+	})
+
+	t.Run("permanent expiring", func(t *testing.T) {
+		path := shorten(t, `{"url":"https://example.org/expiring","redirect_type":301,"ttl":"1h"}`)
+
+		resp := testRequest(t, ts, http.MethodGet, path+"?utm_source=mail", nil, "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "https://example.org/expiring", resp.Header.Get("Location"))
+		var maxAge int
+		_, err := fmt.Sscanf(resp.Header.Get("Cache-Control"), "public, max-age=%d", &maxAge)
+		require.NoError(t, err)
+		assert.InDelta(t, 3600, maxAge, 60)
+	})
+
+	t.Run("head", func(t *testing.T) {
+		path := shorten(t, `{"url":"https://example.org/head","redirect_type":302}`)
+
+		resp := testRequest(t, ts, http.MethodHead, path, nil, "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "https://example.org/head", resp.Header.Get("Location"))
+		assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	})
+
+	t.Run("listed", func(t *testing.T) {
+		urls, err := s.h.Store().ListAllUrls(context.Background())
+		require.NoError(t, err)
+		for _, url := range urls {
+			if url.Original == "https://example.org/page?lang=en#top" {
+				assert.Equal(t, http.StatusPermanentRedirect, url.RedirectType)
+				assert.True(t, url.MergeQuery)
+			}
+		}
+	})
+
+	t.Run("negative case: invalid redirect type", func(t *testing.T) {
+		requestBody := `{"url":"https://example.org/invalid","redirect_type":303}`
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var res models.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Equal(t, "invalid_redirect_type", res.Code)
+		require.Len(t, res.Errors, 1)
+		assert.Equal(t, "/redirect_type", res.Errors[0].Field)
+	})
+
+	t.Run("negative case: invalid redirect type in batch", func(t *testing.T) {
+		requestBody := `[{"correlation_id":"1","original_url":"https://example.org/batch","redirect_type":200}]`
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var res models.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Equal(t, "invalid_redirect_type", res.Code)
+		require.Len(t, res.Errors, 1)
+		assert.Equal(t, "/0/redirect_type", res.Errors[0].Field)
+	})
+}
+
 func TestGetUserURLsHandler(t *testing.T) {
 	existingURLs := []models.URL{
 		{
@@ -359,9 +985,16 @@ func TestGetUserURLsHandler(t *testing.T) {
 			},
 		},
 		{
-			name:         "negative case: unauthorized",
-			authorized:   false,
-			existingURLs: existingURLs,
+			name:       "negative case: unauthorized",
+			authorized: false,
+			existingURLs: []models.URL{
+				{
+					ID:        uuid.NewString(),
+					Slug:      "short_3",
+					Original:  "https://example.com/3",
+					CreatedAt: time.Now(),
+				},
+			},
 			want: want{
 				// For some unknown reason Yandex Practicum tests now require
 				// this endpoint to be public.
@@ -425,8 +1058,863 @@ func TestGetUserURLsHandler(t *testing.T) {
 	}
 }
 
-// TODO Add test for DeleteUserURLsHandler.
-
+func TestGetUserURLsHandlerPagination(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	user := models.User{ID: uuid.NewString(), CreatedAt: time.Now()}
+	require.NoError(t, s.h.Store().CreateUser(ctx, user))
+
+	start := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	urls := make([]models.URL, 0, 5)
+	for i := 0; i < 5; i++ {
+		url := models.URL{
+			ID:        uuid.NewString(),
+			Slug:      fmt.Sprintf("page_%d", i),
+			Original:  fmt.Sprintf("https://example.com/%d", i),
+			CreatedAt: start.AddDate(0, 0, i),
+		}
+		if i == 3 {
+			url.Original = "https://other.org/3"
+		}
+		require.NoError(t, s.h.Store().CreateURL(ctx, user.ID, url))
+		urls = append(urls, url)
+	}
+
+	authToken, err := jwt.New(jwt.Options{
+		Secret:   []byte(tokenSecret),
+		Duration: tokenDuration,
+		Issuer:   tokenIssuer,
+	}).GetString(user.ID)
+	require.NoError(t, err)
+
+	list := func(t *testing.T, path string) ([]string, string) {
+		resp := testRequest(t, ts, http.MethodGet, path, nil, authToken)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			return nil, resp.Header.Get("Link")
+		}
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res models.ListByUserIDResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		slugs := make([]string, 0, len(res.URLs))
+		for _, url := range res.URLs {
+			slugs = append(slugs, strings.TrimPrefix(url.ShortURL, s.config.Server.BaseURL+"/"))
+		}
+		return slugs, resp.Header.Get("Link")
+	}
+
+	t.Run("pages are linked", func(t *testing.T) {
+		var got []string
+		path := "/api/user/urls?limit=2&q=EXAMPLE"
+		for pages := 0; path != ""; pages++ {
+			require.Less(t, pages, 3)
+			slugs, link := list(t, path)
+			got = append(got, slugs...)
+
+			path = ""
+			if link != "" {
+				require.True(t, strings.HasPrefix(link, "</api/user/urls?"))
+				require.True(t, strings.HasSuffix(link, `>; rel="next"`))
+				path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+				assert.Contains(t, path, "q=EXAMPLE")
+			}
+		}
+		assert.Equal(t, []string{"page_0", "page_1", "page_2", "page_4"}, got)
+	})
+
+	t.Run("all urls without limit", func(t *testing.T) {
+		slugs, link := list(t, "/api/user/urls")
+		assert.Equal(t, []string{"page_0", "page_1", "page_2", "page_3", "page_4"}, slugs)
+		assert.Empty(t, link)
+
+		other := models.User{ID: uuid.NewString(), CreatedAt: time.Now()}
+		require.NoError(t, s.h.Store().CreateUser(ctx, other))
+		many := random.RandomURLs(150)
+		require.NoError(t, s.h.Store().BatchCreateURL(ctx, other.ID, many))
+		otherToken, err := jwt.New(jwt.Options{
+			Secret:   []byte(tokenSecret),
+			Duration: tokenDuration,
+			Issuer:   tokenIssuer,
+		}).GetString(other.ID)
+		require.NoError(t, err)
+
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, otherToken)
+		defer resp.Body.Close()
+		var res models.ListByUserIDResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Len(t, res.URLs, len(many))
+		assert.Empty(t, resp.Header.Get("Link"))
+	})
+
+	t.Run("descending order", func(t *testing.T) {
+		slugs, link := list(t, "/api/user/urls?order=desc&limit=2")
+		assert.Equal(t, []string{"page_4", "page_3"}, slugs)
+		assert.NotEmpty(t, link)
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		slugs, link := list(t, "/api/user/urls?from=2026-03-02&to=2026-03-03")
+		assert.Equal(t, []string{"page_1", "page_2"}, slugs)
+		assert.Empty(t, link)
+
+		slugs, _ = list(t, "/api/user/urls?from=2026-03-04T12:00:00Z")
+		assert.Equal(t, []string{"page_3", "page_4"}, slugs)
+	})
+
+	t.Run("created_at is returned", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls?limit=1", nil, authToken)
+		defer resp.Body.Close()
+
+		var res models.ListByUserIDResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		require.Len(t, res.URLs, 1)
+		assert.True(t, urls[0].CreatedAt.Equal(res.URLs[0].CreatedAt))
+	})
+
+	t.Run("negative case: invalid parameters", func(t *testing.T) {
+		for query, field := range map[string]string{
+			"limit=0":        "limit",
+			"limit=1001":     "limit",
+			"cursor=abc":     "cursor",
+			"order=random":   "order",
+			"from=yesterday": "from",
+			"to=2026-13-01":  "to",
+		} {
+			resp := testRequest(t, ts, http.MethodGet, "/api/user/urls?"+query, nil, authToken)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			var res models.Problem
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+			assert.Equal(t, problem.CodeInvalidRequest, res.Code)
+			require.Len(t, res.Errors, 1)
+			assert.Equal(t, field, res.Errors[0].Field)
+		}
+	})
+}
+
+func TestDeleteUserURLsHandler(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	tests := []struct {
+		name        string
+		requestBody string
+		authToken   string
+		code        int
+	}{
+		{
+			name:        "positive case",
+			requestBody: `["shortURL"]`,
+			authToken:   authToken,
+			code:        http.StatusAccepted,
+		},
+		{
+			name:        "negative case: unauthorized",
+			requestBody: `["shortURL"]`,
+			authToken:   "",
+			code:        http.StatusUnauthorized,
+		},
+		{
+			name:        "negative case: empty list",
+			requestBody: `[]`,
+			authToken:   authToken,
+			code:        http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(test.requestBody), test.authToken)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.code, resp.StatusCode, "Unexpected response code")
+		})
+	}
+}
+
+func TestDeleteJobHandler(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+
+	longURL := "https://practicum.yandex.ru/"
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(longURL), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+	slug := strings.TrimPrefix(expectedShortURL(t, s, longURL), s.config.Server.BaseURL+"/")
+
+	resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+slug+`"]`), authToken)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var job models.DeleteJobResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, []string{slug}, job.Slugs)
+	location := resp.Header.Get("Location")
+	assert.Equal(t, "/api/user/deletions/"+job.ID, location)
+
+	assert.Eventually(t, func() bool {
+		resp := testRequest(t, ts, http.MethodGet, location, nil, authToken)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false
+		}
+
+		var job models.DeleteJobResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		return job.Status == string(models.DeleteJobDone)
+	}, time.Second, 10*time.Millisecond)
+
+	url, err := s.h.Store().GetURL(context.Background(), slug)
+	require.NoError(t, err)
+	assert.True(t, url.Deleted)
+
+	t.Run("negative case: job of another user", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://example.org/"), "")
+		otherToken := parseAuthToken(resp)
+		resp.Body.Close()
+
+		resp = testRequest(t, ts, http.MethodGet, location, nil, otherToken)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// conflictImportStore imports the first URL of a stream and reports the others
+// as slug conflicts, which can not be retried because the storage fails.
+type conflictImportStore struct {
+	*memory.Store
+}
+
+func (s *conflictImportStore) ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var all []models.URL
+	for url, err := range urls {
+		if err != nil {
+			return store.ImportResult{}, err
+		}
+		all = append(all, url)
+	}
+
+	first := func(yield func(models.URL, error) bool) {
+		yield(all[0], nil)
+	}
+	res, err := s.Store.ImportURLs(ctx, userID, first)
+	if err != nil {
+		return res, err
+	}
+	res.Conflicts = all[1:]
+
+	return res, nil
+}
+
+func (s *conflictImportStore) BatchUpsertURLs(context.Context, string, []models.URL) ([]store.UpsertResult, error) {
+	return nil, errors.New("storage is down")
+}
+
+func TestImportRetryFailed(t *testing.T) {
+	_, ts := testServerWithStore(&conflictImportStore{Store: memory.New()})
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://example.org/"), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	body := "original_url\nhttps://example.org/imported\nhttps://example.org/conflict\n"
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/urls/import", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: authToken})
+	resp = sendRequest(t, req)
+	defer resp.Body.Close()
+
+	// The imported URL is reported, the URL which could not be retried is failed.
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var res models.ImportResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, 1, res.Created)
+	assert.Equal(t, 1, res.Failed)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 3, res.Errors[0].Line)
+	assert.Equal(t, "internal_error", res.Errors[0].Code)
+}
+
+// stalledStore never claims pending delete jobs, so that they are not executed.
+type stalledStore struct {
+	*memory.Store
+}
+
+func (s *stalledStore) ClaimDeleteJobs(context.Context, time.Time, time.Time, int) ([]models.DeleteJob, error) {
+	return nil, nil
+}
+
+func TestDeleteQueueFull(t *testing.T) {
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	c.Storage.DeleteQueueSize = 1
+	s := New(c, &stalledStore{Store: memory.New()}, Options{}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+
+	resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["slug"]`), authToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["slug"]`), authToken)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	var res models.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, problem.CodeUnavailable, res.Code)
+}
+
+func TestURLStatsHandler(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+
+	longURL := "https://practicum.yandex.ru/"
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(longURL), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	shortURL := expectedShortURL(t, s, longURL)
+	slug := strings.TrimPrefix(shortURL, s.config.Server.BaseURL+"/")
+
+	// Behind a reverse proxy visitors are told apart by the X-Real-IP header.
+	s.config.RateLimit.TrustRealIP = true
+	for _, click := range []struct{ referrer, realIP string }{
+		{"https://example.org/", "203.0.113.1"},
+		{"https://example.com/", "203.0.113.1"},
+		{"https://example.com/", "203.0.113.2"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/"+slug, nil)
+		require.NoError(t, err)
+		req.Header.Set("Referer", click.referrer)
+		req.Header.Set("X-Real-IP", click.realIP)
+		resp = sendRequest(t, req)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+
+	// Flush the click queue.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.h.Close(ctx))
+
+	resp = testRequest(t, ts, http.MethodGet, "/api/user/urls/"+slug+"/stats", nil, authToken)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var stats models.URLStats
+	err := json.NewDecoder(resp.Body).Decode(&stats)
+	require.NoError(t, err)
+	assert.Equal(t, slug, stats.Slug)
+	assert.Equal(t, 3, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+	require.Equal(t, 1, len(stats.Daily))
+	assert.Equal(t, time.Now().UTC().Format(models.DateFormat), stats.Daily[0].Date)
+	assert.Equal(t, 3, stats.Daily[0].Clicks)
+
+	t.Run("negative case: unauthorized", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/urls/"+slug+"/stats", nil, "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("negative case: URL of another user", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("http://example.org/other"), "")
+		otherToken := parseAuthToken(resp)
+		resp.Body.Close()
+
+		resp = testRequest(t, ts, http.MethodGet, "/api/user/urls/"+slug+"/stats", nil, otherToken)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestProblemDetails(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	t.Run("request ID", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/unknown", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-Id", "test-request-id")
+		resp := sendRequest(t, req)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "test-request-id", resp.Header.Get("X-Request-Id"))
+
+		var res models.Problem
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, models.Problem{
+			Type:      "about:blank",
+			Title:     "Not Found",
+			Status:    http.StatusNotFound,
+			Detail:    "url not found",
+			Instance:  "/unknown",
+			Code:      problem.CodeNotFound,
+			RequestID: "test-request-id",
+		}, res)
+	})
+
+	t.Run("generated request ID", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader("{"), "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		var res models.Problem
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, "invalid_json", res.Code)
+		assert.NotEmpty(t, res.RequestID)
+		assert.Equal(t, resp.Header.Get("X-Request-Id"), res.RequestID)
+	})
+
+	t.Run("field errors", func(t *testing.T) {
+		requestBody := `[{"correlation_id":"1","original_url":"http://example.org/1"},{"correlation_id":"2","original_url":"http://example.org/2","ttl":"-1h"}]`
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody), "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		var res models.Problem
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, "invalid_expiration", res.Code)
+		assert.Equal(t, []models.FieldError{{Field: "/1/ttl", Message: "ttl must be positive"}}, res.Errors)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["slug"]`), "")
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	resp = testRequest(t, ts, http.MethodGet, "/api/user/keys", nil, authToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = testRequest(t, ts, http.MethodPost, "/api/user/keys", strings.NewReader(`{"name":"ci"}`), authToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.CreateAPIKeyResponse
+	err := json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ci", created.Name)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+
+	keyRequest := func(t *testing.T, method, path string, body io.Reader, header, value string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, body)
+		require.NoError(t, err)
+		req.Header.Set(header, value)
+		return sendRequest(t, req)
+	}
+
+	t.Run("bearer", func(t *testing.T) {
+		resp := keyRequest(t, http.MethodGet, "/api/user/urls", nil, "Authorization", "Bearer "+created.Key)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Cookies())
+
+		var res []models.UserURLItem
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(res))
+		assert.Equal(t, "https://practicum.yandex.ru/", res[0].OriginalURL)
+	})
+
+	t.Run("X-API-Key", func(t *testing.T) {
+		resp := keyRequest(t, http.MethodDelete, "/api/user/urls", strings.NewReader(`["slug"]`), "X-API-Key", created.Key)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/user/keys", nil, authToken)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res []models.APIKeyItem
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(res))
+		assert.Equal(t, created.ID, res[0].ID)
+		assert.Equal(t, created.Prefix, res[0].Prefix)
+		assert.NotNil(t, res[0].LastUsedAt)
+	})
+
+	t.Run("negative case: unknown key", func(t *testing.T) {
+		// Both private and public scopes reject unknown keys instead of issuing a cookie.
+		resp := keyRequest(t, http.MethodGet, "/api/user/urls/slug/stats", nil, "X-API-Key", "usk_unknown")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = keyRequest(t, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://example.org"}`), "Authorization", "Bearer usk_unknown")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("negative case: missing name", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/api/user/keys", strings.NewReader(`{"name":" "}`), authToken)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("negative case: key of another user", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("http://example.org/other"), "")
+		otherToken := parseAuthToken(resp)
+		resp.Body.Close()
+
+		resp = testRequest(t, ts, http.MethodDelete, "/api/user/keys/"+created.ID, nil, otherToken)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodDelete, "/api/user/keys/"+created.ID, nil, authToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = keyRequest(t, http.MethodGet, "/api/user/urls", nil, "Authorization", "Bearer "+created.Key)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = testRequest(t, ts, http.MethodDelete, "/api/user/keys/"+created.ID, nil, authToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	resp.Body.Close()
+	authToken := parseAuthToken(resp)
+	refreshToken := parseRefreshToken(resp)
+	require.NotEmpty(t, authToken)
+	require.NotEmpty(t, refreshToken)
+
+	refresh := func(t *testing.T, refreshToken string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/auth/refresh", nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+		return sendRequest(t, req)
+	}
+
+	resp = refresh(t, refreshToken)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	newAuthToken := parseAuthToken(resp)
+	newRefreshToken := parseRefreshToken(resp)
+	require.NotEmpty(t, newAuthToken)
+	require.NotEmpty(t, newRefreshToken)
+	assert.NotEqual(t, authToken, newAuthToken)
+	assert.NotEqual(t, refreshToken, newRefreshToken)
+
+	// The new access token belongs to the same user.
+	resp = testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, newAuthToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("negative case: reused refresh token", func(t *testing.T) {
+		resp := refresh(t, refreshToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("negative case: access token as refresh token", func(t *testing.T) {
+		resp := refresh(t, newAuthToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/auth/logout", nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: newAuthToken})
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: newRefreshToken})
+		resp := sendRequest(t, req)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["slug"]`), newAuthToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = refresh(t, newRefreshToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	signingKey, err := jwt.ParseKeyPEM("ed-1", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	s, ts := testServer()
+	ts.Close()
+	s.config.Auth.SigningKeyFile = "ed-1=/keys/ed.pem"
+	tokenOpts := s.config.TokenOptions()
+	tokenOpts.SigningKey = signingKey
+	s = New(s.config, memory.New(), Options{Tokens: jwt.New(tokenOpts)}, zap.NewNop().Sugar())
+	ts = httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodGet, "/.well-known/jwks.json", nil, "")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var set jwt.JWKSet
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	require.NoError(t, err)
+	// The HS256 secret is never published.
+	require.Equal(t, 1, len(set.Keys))
+	assert.Equal(t, "ed-1", set.Keys[0].KeyID)
+	assert.Equal(t, "EdDSA", set.Keys[0].Algorithm)
+
+	// Tokens are signed with the signing key and accepted by the server.
+	resp = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	resp.Body.Close()
+	authToken := parseAuthToken(resp)
+	require.NotEmpty(t, authToken)
+
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(authToken, ".")[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"alg":"EdDSA","kid":"ed-1","typ":"JWT"}`, string(header))
+
+	resp = testRequest(t, ts, http.MethodGet, "/api/user/urls/unknown/stats", nil, authToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Tokens without the kid header signed with the secret are rejected.
+	forged, err := jwt.New(jwt.Options{Secret: []byte(tokenSecret), Duration: time.Hour}).GetString("victim")
+	require.NoError(t, err)
+	resp = testRequest(t, ts, http.MethodGet, "/api/user/urls/unknown/stats", nil, forged)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestExpiredAccessToken(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	resp.Body.Close()
+	authToken := parseAuthToken(resp)
+	require.NotEmpty(t, authToken)
+
+	tokens := jwt.New(jwt.Options{Secret: []byte(tokenSecret), Duration: time.Hour})
+	userID, err := tokens.GetUserID(authToken)
+	require.NoError(t, err)
+	expired, err := jwt.New(jwt.Options{Secret: []byte(tokenSecret), Duration: -time.Hour}).GetString(userID)
+	require.NoError(t, err)
+
+	// A new user is not registered in place of the user with the expired token.
+	resp = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/other"), expired)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Empty(t, parseRefreshToken(resp))
+
+	// The expired cookie is removed.
+	var removed bool
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "auth_token" && cookie.MaxAge < 0 {
+			removed = true
+		}
+	}
+	assert.True(t, removed)
+}
+
+// raceRevokeStore holds IsTokenRevoked until two requests have checked the token,
+// so that both of them pass the check before the token is revoked.
+type raceRevokeStore struct {
+	*memory.Store
+	checked sync.WaitGroup
+}
+
+func (s *raceRevokeStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	revoked, err := s.Store.IsTokenRevoked(ctx, tokenID)
+	s.checked.Done()
+	s.checked.Wait()
+
+	return revoked, err
+}
+
+func TestRefreshTokenConcurrent(t *testing.T) {
+	st := &raceRevokeStore{Store: memory.New()}
+	_, ts := testServerWithStore(st)
+	defer ts.Close()
+
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"), "")
+	resp.Body.Close()
+	refreshToken := parseRefreshToken(resp)
+	require.NotEmpty(t, refreshToken)
+
+	st.checked.Add(2)
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/auth/refresh", nil)
+			if !assert.NoError(t, err) {
+				codes <- 0
+				return
+			}
+			req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+			resp, err := ts.Client().Do(req)
+			if !assert.NoError(t, err) {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+
+	// The refresh token is exchanged only once.
+	got := []int{<-codes, <-codes}
+	assert.ElementsMatch(t, []int{http.StatusNoContent, http.StatusUnauthorized}, got)
+}
+
+// slowDeleteStore delays deletions, so that they are still pending when the server shuts down.
+type slowDeleteStore struct {
+	*memory.Store
+}
+
+func (s *slowDeleteStore) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	time.Sleep(200 * time.Millisecond)
+	return s.Store.BatchSoftDeleteURLs(ctx, userID, slugs)
+}
+
+func TestShutdown(t *testing.T) {
+	s, ts := testServerWithStore(&slowDeleteStore{Store: memory.New()})
+	defer ts.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Start()
+	}()
+
+	longURL := "https://practicum.yandex.ru/"
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(longURL), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+	require.NotEmpty(t, authToken)
+
+	shortURL := expectedShortURL(t, s, longURL)
+	slug := strings.TrimPrefix(shortURL, s.config.Server.BaseURL+"/")
+
+	resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+slug+`"]`), authToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// The deletion is still pending when the server is shut down.
+	url, err := s.h.Store().GetURL(context.Background(), slug)
+	require.NoError(t, err)
+	require.False(t, url.Deleted)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	require.NoError(t, err)
+
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("server did not stop")
+	}
+
+	// Queued delete request must be flushed on shutdown.
+	url, err = s.h.Store().GetURL(context.Background(), slug)
+	require.NoError(t, err)
+	assert.Equal(t, true, url.Deleted)
+}
+
+func TestShutdownExpiredContext(t *testing.T) {
+	s, ts := testServerWithStore(&slowDeleteStore{Store: memory.New()})
+	defer ts.Close()
+
+	longURL := "https://practicum.yandex.ru/"
+	resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(longURL), "")
+	authToken := parseAuthToken(resp)
+	resp.Body.Close()
+
+	slug := strings.TrimPrefix(expectedShortURL(t, s, longURL), s.config.Server.BaseURL+"/")
+	resp = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+slug+`"]`), authToken)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// The queues are drained with their own deadline when the servers took all the time.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = s.Shutdown(ctx)
+
+	url, err := s.h.Store().GetURL(context.Background(), slug)
+	require.NoError(t, err)
+	assert.True(t, url.Deleted)
+}
+
+func TestMetrics(t *testing.T) {
+	_, ts := testServer()
+	defer ts.Close()
+
+	requests := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/{slug}", "404")
+	before := testutil.ToFloat64(requests)
+
+	for _, slug := range []string{"unknown1", "unknown2"} {
+		resp := testRequest(t, ts, http.MethodGet, "/"+slug, nil, "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	// Requests are counted by route pattern rather than by URI.
+	assert.Equal(t, before+2, testutil.ToFloat64(requests))
+
+	unmatched := metrics.HTTPRequests.WithLabelValues(http.MethodPut, "unmatched", "405")
+	before = testutil.ToFloat64(unmatched)
+	resp := testRequest(t, ts, http.MethodPut, "/", nil, "")
+	resp.Body.Close()
+	assert.Equal(t, before+1, testutil.ToFloat64(unmatched))
+}
+
 func TestGzipCompression(t *testing.T) {
 	s, ts := testServer()
 	defer ts.Close()
@@ -483,21 +1971,28 @@ func TestGzipCompression(t *testing.T) {
 }
 
 func testServer() (*Server, *httptest.Server) {
-	os.Remove(filepath)
+	return testServerWithStore(memory.New())
+}
+
+func testServerWithStore(st store.Store) (*Server, *httptest.Server) {
+	os.Remove(storagePath)
 
 	config := &config.Config{
-		ServerAddr:      "localhost:8080",
-		BaseURL:         "http://localhost:8080",
-		FileStoragePath: filepath,
-		TokenSecret:     []byte(tokenSecret),
-		TokenDuration:   tokenDuration,
-		TokenIssuer:     tokenIssuer,
+		Server: config.ServerConfig{
+			Addr:    "localhost:0",
+			BaseURL: "http://localhost:8080",
+		},
+		Storage: config.StorageConfig{FilePath: storagePath},
+		Auth: config.AuthConfig{
+			TokenSecret:   tokenSecret,
+			TokenDuration: config.Duration{Duration: tokenDuration},
+			TokenIssuer:   tokenIssuer,
+		},
 	}
 
 	logger := zap.NewNop().Sugar()
 
-	store := memory.New()
-	s := New(config, store, logger)
+	s := New(config, st, Options{}, logger)
 
 	return s, httptest.NewServer(s.Router())
 }
@@ -543,5 +2038,384 @@ func expectedShortURL(t *testing.T, s *Server, url string) string {
 		t.Errorf("url %s was not saved", url)
 	}
 
-	return fmt.Sprintf("%s/%s", s.config.BaseURL, slug)
+	return fmt.Sprintf("%s/%s", s.config.Server.BaseURL, slug)
+}
+
+func TestInternalStats(t *testing.T) {
+	s, ts := testServer()
+	defer ts.Close()
+
+	statsRequest := func(ts *httptest.Server, realIP string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/internal/stats", nil)
+		require.NoError(t, err)
+		if realIP != "" {
+			req.Header.Set("X-Real-IP", realIP)
+		}
+		return sendRequest(t, req)
+	}
+
+	t.Run("negative case: trusted subnet is not configured", func(t *testing.T) {
+		resp := statsRequest(ts, "127.0.0.1")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	s.config.Server.TrustedSubnet = "192.168.1.0/24"
+	s = New(s.config, memory.New(), Options{}, zap.NewNop().Sugar())
+	trusted := httptest.NewServer(s.Router())
+	defer trusted.Close()
+
+	for _, longURL := range []string{"https://practicum.yandex.ru/", "https://example.org/"} {
+		resp := testRequest(t, trusted, http.MethodPost, "/", strings.NewReader(longURL), "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	t.Run("trusted client", func(t *testing.T) {
+		resp := statsRequest(trusted, "192.168.1.10")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats models.InternalStatsResponse
+		err := json.NewDecoder(resp.Body).Decode(&stats)
+		require.NoError(t, err)
+		assert.Equal(t, models.InternalStatsResponse{URLs: 2, Users: 2}, stats)
+	})
+
+	t.Run("negative case: untrusted client", func(t *testing.T) {
+		for _, realIP := range []string{"", "10.0.0.1", "not an IP"} {
+			resp := statsRequest(trusted, realIP)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
+
+func TestScreening(t *testing.T) {
+	blocklistPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(blocklistPath, []byte("evil.example\n"), 0o600)
+	require.NoError(t, err)
+	blocklist, err := screening.NewBlocklist(blocklistPath, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	checker := screening.NewFake()
+	checker.Add("malware.example", "malware")
+
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	c.Server.TrustedSubnet = "192.168.1.0/24"
+	s := New(c, memory.New(), Options{
+		Handlers: handlers.Options{Blocklist: blocklist, Checker: checker},
+	}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		requestBody string
+		field       string
+	}{
+		{
+			name:        "blocklisted domain",
+			path:        "/",
+			requestBody: "https://login.evil.example/",
+			field:       "/url",
+		},
+		{
+			name:        "malicious URL",
+			path:        "/api/shorten",
+			requestBody: `{"url":"https://malware.example/download"}`,
+			field:       "/url",
+		},
+		{
+			name:        "batch item",
+			path:        "/api/shorten/batch",
+			requestBody: `[{"correlation_id":"1","original_url":"http://example.org/1"},{"correlation_id":"2","original_url":"https://EVIL.example/"}]`,
+			field:       "/1/original_url",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, http.MethodPost, test.path, strings.NewReader(test.requestBody), "")
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+			assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+
+			var res models.Problem
+			err := json.NewDecoder(resp.Body).Decode(&res)
+			require.NoError(t, err)
+			assert.Equal(t, "blocked_url", res.Code)
+			require.Len(t, res.Errors, 1)
+			assert.Equal(t, test.field, res.Errors[0].Field)
+			assert.NotEmpty(t, res.Errors[0].Message)
+		})
+	}
+
+	t.Run("checker failure", func(t *testing.T) {
+		checker.Fail(errors.New("unavailable"))
+		defer checker.Fail(nil)
+
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://example.org/"), "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+}
+
+func TestApplyBlocklist(t *testing.T) {
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	c.Server.TrustedSubnet = "192.168.1.0/24"
+	store := memory.New()
+
+	// Links are shortened before their host is blocklisted.
+	s := New(c, store, Options{}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	shorten := func(longURL string) string {
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(longURL), "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return strings.TrimPrefix(string(body), c.Server.BaseURL)
+	}
+	blocked := shorten("https://phishing.example/login")
+	allowed := shorten("https://example.org/")
+
+	apply := func(ts *httptest.Server, realIP string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/internal/blocklist/apply", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Real-IP", realIP)
+		return sendRequest(t, req)
+	}
+
+	t.Run("negative case: blocklist is not configured", func(t *testing.T) {
+		resp := apply(ts, "192.168.1.10")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	blocklistPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(blocklistPath, []byte("phishing.example\n"), 0o600)
+	require.NoError(t, err)
+	blocklist, err := screening.NewBlocklist(blocklistPath, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	s = New(c, store, Options{Handlers: handlers.Options{Blocklist: blocklist}}, zap.NewNop().Sugar())
+	blocking := httptest.NewServer(s.Router())
+	defer blocking.Close()
+
+	t.Run("negative case: untrusted client", func(t *testing.T) {
+		resp := apply(blocking, "10.0.0.1")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("trusted client", func(t *testing.T) {
+		resp := apply(blocking, "192.168.1.10")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res models.ApplyBlocklistResponse
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, models.ApplyBlocklistResponse{Checked: 2, Blocked: 1}, res)
+	})
+
+	t.Run("blocked link", func(t *testing.T) {
+		resp := testRequest(t, blocking, http.MethodGet, blocked, nil, "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode)
+
+		var res models.Problem
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, "blocked", res.Code)
+
+		resp = testRequest(t, blocking, http.MethodGet, allowed, nil, "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	})
+
+	t.Run("already blocked links are skipped", func(t *testing.T) {
+		resp := apply(blocking, "192.168.1.10")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res models.ApplyBlocklistResponse
+		err := json.NewDecoder(resp.Body).Decode(&res)
+		require.NoError(t, err)
+		assert.Equal(t, models.ApplyBlocklistResponse{Checked: 1, Blocked: 0}, res)
+	})
+}
+
+func TestRateLimitDisabledByDefault(t *testing.T) {
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	s := New(c, memory.New(), Options{}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	// All requests come from the same IP, e.g. from a reverse proxy, and register new users.
+	for i := 0; i < 100; i++ {
+		resp := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(fmt.Sprintf("https://example.org/%d", i)), "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode, "request %d", i)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitCreateWithoutCookie(t *testing.T) {
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	c.RateLimit.Enabled = true
+	c.RateLimit.Create = "2/m"
+	c.RateLimit.Register = ""
+	s := New(c, memory.New(), Options{}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	// Every request registers a new user, so the limit must apply to the client IP.
+	for i := 0; i < 2; i++ {
+		body := fmt.Sprintf(`{"url":"https://example.org/%d"}`, i)
+		resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(body), "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.org/2"}`), "")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRateLimit(t *testing.T) {
+	c := config.Default()
+	c.Auth.TokenSecret = tokenSecret
+	c.RateLimit.Enabled = true
+	c.RateLimit.Create = "2/m"
+	c.RateLimit.Redirect = "1/m"
+	c.RateLimit.Register = "2/h"
+	c.RateLimit.TrustRealIP = true
+	s := New(c, memory.New(), Options{}, zap.NewNop().Sugar())
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	request := func(method, path, body, realIP, authToken string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Real-IP", realIP)
+		if authToken != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: authToken})
+		}
+		return sendRequest(t, req)
+	}
+
+	assertLimited := func(t *testing.T, resp *http.Response, limit string) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, limit, resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+		assert.NotEmpty(t, resp.Header.Get("RateLimit-Reset"))
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+		var res models.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Equal(t, problem.CodeRateLimited, res.Code)
+	}
+
+	var authToken string
+	t.Run("registrations are limited by client IP", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			resp := request(http.MethodPost, "/", fmt.Sprintf("https://practicum.yandex.ru/%d", i), "10.0.0.1", "")
+			resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			authToken = parseAuthToken(resp)
+		}
+
+		resp := request(http.MethodPost, "/", "https://practicum.yandex.ru/", "10.0.0.1", "")
+		defer resp.Body.Close()
+		assertLimited(t, resp, "2")
+		assert.Empty(t, resp.Cookies(), "user is not registered")
+
+		resp = request(http.MethodPost, "/", "https://practicum.yandex.ru/2", "10.0.0.2", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("creation is limited by user", func(t *testing.T) {
+		// The user has created a URL while registering, a different IP does not matter.
+		resp := request(http.MethodPost, "/api/shorten", `{"url":"https://example.org/"}`, "10.0.0.3", authToken)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "2;w=60", resp.Header.Get("RateLimit-Policy"))
+
+		resp = request(http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://example.com/"}]`, "10.0.0.3", authToken)
+		defer resp.Body.Close()
+		assertLimited(t, resp, "2")
+	})
+
+	t.Run("redirects are limited by client IP", func(t *testing.T) {
+		shortURL := expectedShortURL(t, s, "https://practicum.yandex.ru/0")
+		path := strings.TrimPrefix(shortURL, s.config.Server.BaseURL)
+
+		resp := request(http.MethodGet, path, "", "10.0.0.4", "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		resp = request(http.MethodGet, path, "", "10.0.0.4", "")
+		defer resp.Body.Close()
+		assertLimited(t, resp, "1")
+
+		resp = request(http.MethodGet, path, "", "10.0.0.5", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	})
+
+	t.Run("IPv6 clients are limited by /64", func(t *testing.T) {
+		shortURL := expectedShortURL(t, s, "https://practicum.yandex.ru/0")
+		path := strings.TrimPrefix(shortURL, s.config.Server.BaseURL)
+
+		resp := request(http.MethodGet, path, "", "2001:db8:1:1::1", "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		resp = request(http.MethodGet, path, "", "2001:db8:1:1:ffff::2", "")
+		defer resp.Body.Close()
+		assertLimited(t, resp, "1")
+
+		resp = request(http.MethodGet, path, "", "2001:db8:1:2::1", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	})
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	backend := &sweepCounter{Backend: ratelimit.NewMemory()}
+	l := mw.NewRateLimiter(mw.RateLimiterOptions{
+		Backend:       backend,
+		SweepInterval: 10 * time.Millisecond,
+		Log:           zap.NewNop().Sugar(),
+	})
+
+	l.Start()
+	assert.Eventually(t, func() bool { return backend.sweeps.Load() > 0 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, l.Stop(context.Background()))
+
+	// No buckets are removed after the limiter is stopped.
+	sweeps := backend.sweeps.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, sweeps, backend.sweeps.Load())
+}
+
+// sweepCounter counts removals of full buckets.
+type sweepCounter struct {
+	ratelimit.Backend
+	sweeps atomic.Int32
+}
+
+func (c *sweepCounter) Sweep(ctx context.Context, now time.Time) (int, error) {
+	c.sweeps.Add(1)
+	return c.Backend.Sweep(ctx, now)
 }
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// certCheckInterval is how often certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// tlsConfig builds the TLS configuration of the server from config.TLS.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	c := s.config.TLS
+
+	minVersion, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := c.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+
+	if c.SelfSigned {
+		s.log.Warn("serving self-signed TLS certificate, do not use it in production")
+		cert, err := s.generateSelfSignedCert(c.SelfSignedDir)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+
+		return tlsConfig, nil
+	}
+
+	reloader, err := newCertReloader(c.CertFile, c.KeyFile, s.log)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.GetCertificate = reloader.GetCertificate
+
+	return tlsConfig, nil
+}
+
+// certReloader serves the certificate from files and reloads it when
+// the files are modified, so that renewed certificates are picked up without restart.
+type certReloader struct {
+	certFile, keyFile string
+	log               *zap.SugaredLogger
+	// checkInterval limits how often the files are checked.
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string, log *zap.SugaredLogger) (*certReloader, error) {
+	r := &certReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		log:           log,
+		checkInterval: certCheckInterval,
+	}
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+//
+// If the files can not be loaded, the previous certificate is served.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.checkInterval {
+		r.lastCheck = time.Now()
+		modTime, err := r.latestModTime()
+		if err != nil {
+			r.log.Errorf("error checking TLS certificate: %s", err)
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(modTime); err != nil {
+				r.log.Errorf("error reloading TLS certificate: %s", err)
+			} else {
+				r.log.Infof("TLS certificate reloaded from %s", r.certFile)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+// load must be called with mu held or before the reloader is shared.
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// redirectHandler redirects plain HTTP requests to HTTPS on the port of httpsAddr.
+func redirectHandler(httpsAddr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
+
+// generateSelfSignedCert generates a self-signed TLS certificate for localhost for development purposes.
+// Self-signed certificates aren't trusted by default because they're not issued
+// by a recognized Certificate Authority (CA).
+// To resolve this for testing with curl, you have a few options:
+//  1. Use the --insecure (-k) flag with curl to bypass certificate validation.
+//     For development purposes, it is the simplest and most common approach.
+//  2. Set dir to save the generated certificate to dir/cert.pem.
+//     Then use `curl --cacert cert.pem https://...`
+//  3. Add the self-signed certificate to your system's trusted certificate store
+//     (not recommended for general use).
+func (s *Server) generateSelfSignedCert(dir string) (*tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Self-signed"},
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+		DNSNames:    []string{"localhost"},
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	if dir != "" {
+		if err := writeCertFiles(dir, certPEM, keyPEM); err != nil {
+			return nil, err
+		}
+		s.log.Infof("self-signed TLS certificate written to %s", filepath.Join(dir, "cert.pem"))
+	}
+
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsCert, nil
+}
+
+func writeCertFiles(dir string, certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		return fmt.Errorf("error writing certificate: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		return fmt.Errorf("error writing key: %w", err)
+	}
+
+	return nil
+}
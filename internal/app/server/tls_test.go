@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/madatsci/urlshortener/internal/app/config"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+)
+
+func TestTLS(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar()}
+
+	t.Run("self-signed certificate is written to disk", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "certs")
+		cert, err := s.generateSelfSignedCert(dir)
+		require.NoError(t, err)
+
+		loaded, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+		require.NoError(t, err)
+		assert.Equal(t, cert.Certificate, loaded.Certificate)
+
+		info, err := os.Stat(filepath.Join(dir, "key.pem"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("certificate is reloaded when files change", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		first, err := s.generateSelfSignedCert(dir)
+		require.NoError(t, err)
+
+		r, err := newCertReloader(certFile, keyFile, s.log)
+		require.NoError(t, err)
+		r.checkInterval = 0
+
+		got, err := r.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, first.Certificate, got.Certificate)
+
+		// Broken files keep the previous certificate.
+		require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0644))
+		touch(t, time.Now().Add(time.Minute), certFile)
+		got, err = r.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, first.Certificate, got.Certificate)
+
+		second, err := s.generateSelfSignedCert(dir)
+		require.NoError(t, err)
+		touch(t, time.Now().Add(2*time.Minute), certFile, keyFile)
+		got, err = r.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, second.Certificate, got.Certificate)
+	})
+
+	t.Run("TLS settings", func(t *testing.T) {
+		s := &Server{log: zap.NewNop().Sugar(), config: &config.Config{TLS: config.TLSConfig{
+			Enabled:      true,
+			SelfSigned:   true,
+			MinVersion:   "1.3",
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		}}}
+
+		tlsConfig, err := s.tlsConfig()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, tlsConfig.CipherSuites)
+		assert.Len(t, tlsConfig.Certificates, 1)
+	})
+
+	t.Run("negative case: missing certificate files", func(t *testing.T) {
+		s := &Server{log: zap.NewNop().Sugar(), config: &config.Config{TLS: config.TLSConfig{
+			Enabled:    true,
+			CertFile:   filepath.Join(t.TempDir(), "cert.pem"),
+			KeyFile:    filepath.Join(t.TempDir(), "key.pem"),
+			MinVersion: "1.2",
+		}}}
+
+		_, err := s.tlsConfig()
+		assert.Error(t, err)
+	})
+
+	t.Run("redirect to HTTPS", func(t *testing.T) {
+		tests := []struct {
+			httpsAddr string
+			host      string
+			want      string
+		}{
+			{httpsAddr: ":443", host: "sho.rt", want: "https://sho.rt/abc?x=1"},
+			{httpsAddr: "localhost:8443", host: "localhost:8080", want: "https://localhost:8443/abc?x=1"},
+		}
+		for _, tc := range tests {
+			r := httptest.NewRequest(http.MethodPost, "http://"+tc.host+"/abc?x=1", nil)
+			w := httptest.NewRecorder()
+			redirectHandler(tc.httpsAddr)(w, r)
+
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tc.want, w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("redirect listener is closed when HTTPS fails", func(t *testing.T) {
+		busy, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		defer busy.Close()
+		lis, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		redirectAddr := lis.Addr().String()
+		require.NoError(t, lis.Close())
+
+		c := config.Default()
+		c.Server.Addr = busy.Addr().String()
+		c.TLS = config.TLSConfig{Enabled: true, SelfSigned: true, MinVersion: "1.2", RedirectAddr: redirectAddr}
+		s := New(c, memory.New(), Options{}, zap.NewNop().Sugar())
+		defer s.Shutdown(context.Background())
+
+		require.Error(t, s.Start())
+		assert.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", redirectAddr)
+			if err != nil {
+				return true
+			}
+			conn.Close()
+			return false
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func touch(t *testing.T, mtime time.Time, paths ...string) {
+	for _, path := range paths {
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+}
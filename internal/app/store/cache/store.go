@@ -0,0 +1,325 @@
+// Package cache implements a store.Store decorator which caches URL lookups in memory.
+//
+// The cache is size-bounded with least recently used eviction, and every entry
+// expires after a TTL. Unknown slugs are cached too (negative caching), with
+// a shorter TTL. Entries are invalidated when URLs are created, deleted, blocked
+// or purged after expiration through the decorator. Changes made by other instances of the service become
+// visible after the TTL.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+const (
+	// DefaultSize is used if Options.Size is not set.
+	DefaultSize = 10000
+	// DefaultTTL is used if Options.TTL is not set.
+	DefaultTTL = time.Minute
+	// DefaultNegativeTTL is used if Options.NegativeTTL is not set.
+	DefaultNegativeTTL = 5 * time.Second
+)
+
+var (
+	hits   = metrics.CacheRequests.WithLabelValues("hit")
+	misses = metrics.CacheRequests.WithLabelValues("miss")
+)
+
+// Options is used to configure Store.
+type Options struct {
+	// Size is the maximum number of cached slugs (default: DefaultSize).
+	Size int
+	// TTL is the lifetime of cached URLs (default: DefaultTTL).
+	TTL time.Duration
+	// NegativeTTL is the lifetime of cached unknown slugs (default: DefaultNegativeTTL).
+	NegativeTTL time.Duration
+}
+
+// Store wraps store.Store and caches results of GetURL.
+//
+// Use New to create an instance of Store.
+type Store struct {
+	store.Store
+
+	opts  Options
+	items map[string]*list.Element
+	// lru holds entries from the most to the least recently used.
+	lru *list.List
+	// reads holds the slugs which are being read from the storage by GetURL.
+	reads map[string]*read
+	mu    sync.Mutex
+	now   func() time.Time
+}
+
+// read tracks concurrent reads of a slug from the storage.
+type read struct {
+	count int
+	// generation is bumped when the slug is invalidated, so that a URL which has
+	// been read before the change is not cached after it.
+	generation uint64
+}
+
+type entry struct {
+	slug string
+	url  models.URL
+	// found is false for unknown slugs.
+	found     bool
+	expiresAt time.Time
+}
+
+// New wraps s with a cache.
+func New(s store.Store, opts Options) *Store {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = DefaultNegativeTTL
+	}
+
+	return &Store{
+		Store: s,
+		opts:  opts,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		reads: make(map[string]*read),
+		now:   time.Now,
+	}
+}
+
+// CreateURL adds a new URL to the storage and invalidates its slug.
+func (s *Store) CreateURL(ctx context.Context, userID string, url models.URL) error {
+	err := s.Store.CreateURL(ctx, userID, url)
+	s.invalidate(url.Slug)
+
+	return err
+}
+
+// BatchCreateURL adds a batch of URLs to the storage and invalidates their slugs.
+func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models.URL) error {
+	err := s.Store.BatchCreateURL(ctx, userID, urls)
+	for _, url := range urls {
+		s.invalidate(url.Slug)
+	}
+
+	return err
+}
+
+// BatchUpsertURLs adds URLs of a batch to the storage and invalidates their slugs.
+func (s *Store) BatchUpsertURLs(ctx context.Context, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	res, err := s.Store.BatchUpsertURLs(ctx, userID, urls)
+	for _, url := range urls {
+		s.invalidate(url.Slug)
+	}
+
+	return res, err
+}
+
+// ImportURLs adds URLs read from the stream to the storage and invalidates unknown slugs.
+//
+// Imported URLs can only change slugs which have been unknown, so the cached URLs are kept.
+func (s *Store) ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	res, err := s.Store.ImportURLs(ctx, userID, urls)
+	s.invalidateUnknown()
+
+	return res, err
+}
+
+// GetURL retrieves a URL by its slug from the cache or from the storage.
+//
+// A URL read from the storage is not cached if its slug is invalidated during the read,
+// so that a URL which has been changed meanwhile is not served until the TTL expires.
+// It returns store.ErrNotFound if URL is not found.
+func (s *Store) GetURL(ctx context.Context, slug string) (models.URL, error) {
+	if e, ok := s.get(slug); ok {
+		hits.Inc()
+		if !e.found {
+			return models.URL{}, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+		}
+		return e.url, nil
+	}
+	misses.Inc()
+
+	generation := s.startRead(slug)
+	url, err := s.Store.GetURL(ctx, slug)
+	switch {
+	case err == nil:
+		s.finishRead(slug, generation, &entry{slug: slug, url: url, found: true, expiresAt: s.now().Add(s.opts.TTL)})
+	case errors.Is(err, store.ErrNotFound):
+		s.finishRead(slug, generation, &entry{slug: slug, expiresAt: s.now().Add(s.opts.NegativeTTL)})
+	default:
+		s.finishRead(slug, generation, nil)
+	}
+
+	return url, err
+}
+
+// SoftDeleteURL marks URLs as deleted and invalidates the slug.
+func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) error {
+	err := s.Store.SoftDeleteURL(ctx, userID, slug)
+	s.invalidate(slug)
+
+	return err
+}
+
+// BatchSoftDeleteURLs marks URLs as deleted and invalidates the slugs.
+func (s *Store) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	err := s.Store.BatchSoftDeleteURLs(ctx, userID, slugs)
+	for _, slug := range slugs {
+		s.invalidate(slug)
+	}
+
+	return err
+}
+
+// BlockURLs marks URLs as blocked and invalidates the slugs.
+func (s *Store) BlockURLs(ctx context.Context, slugs []string) error {
+	err := s.Store.BlockURLs(ctx, slugs)
+	for _, slug := range slugs {
+		s.invalidate(slug)
+	}
+
+	return err
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time
+// and invalidates the cached URLs which expired before it.
+//
+// The purged slugs are not known, so every cached URL which could have been purged is invalidated.
+func (s *Store) DeleteExpiredURLs(ctx context.Context, before time.Time, limit int) (int, error) {
+	n, err := s.Store.DeleteExpiredURLs(ctx, before, limit)
+	s.invalidateExpired(before)
+
+	return n, err
+}
+
+func (s *Store) get(slug string) (entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[slug]
+	if !ok {
+		return entry{}, false
+	}
+
+	e := el.Value.(entry)
+	if !s.now().Before(e.expiresAt) {
+		s.lru.Remove(el)
+		delete(s.items, slug)
+		return entry{}, false
+	}
+
+	s.lru.MoveToFront(el)
+	return e, true
+}
+
+// startRead registers a read of the slug from the storage and returns the generation of the slug.
+func (s *Store) startRead(slug string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reads[slug]
+	if !ok {
+		r = &read{}
+		s.reads[slug] = r
+	}
+	r.count++
+
+	return r.generation
+}
+
+// finishRead completes a read of the slug started at the given generation and caches
+// the entry unless the slug has been invalidated since then. A nil entry is not cached.
+func (s *Store) finishRead(slug string, generation uint64, e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.reads[slug]
+	r.count--
+	if r.count == 0 {
+		delete(s.reads, slug)
+	}
+
+	if e != nil && r.generation == generation {
+		s.set(*e)
+	}
+}
+
+// set caches the entry. s.mu must be held.
+func (s *Store) set(e entry) {
+	if el, ok := s.items[e.slug]; ok {
+		el.Value = e
+		s.lru.MoveToFront(el)
+		return
+	}
+
+	s.items[e.slug] = s.lru.PushFront(e)
+	if s.lru.Len() > s.opts.Size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.items, oldest.Value.(entry).slug)
+	}
+}
+
+func (s *Store) invalidate(slug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.reads[slug]; ok {
+		r.generation++
+	}
+	if el, ok := s.items[slug]; ok {
+		s.lru.Remove(el)
+		delete(s.items, slug)
+	}
+}
+
+// invalidateUnknown removes the cached unknown slugs.
+func (s *Store) invalidateUnknown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invalidateReads()
+
+	for slug, el := range s.items {
+		if !el.Value.(entry).found {
+			s.lru.Remove(el)
+			delete(s.items, slug)
+		}
+	}
+}
+
+// invalidateExpired removes the cached URLs which expired before the given time.
+func (s *Store) invalidateExpired(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invalidateReads()
+
+	for slug, el := range s.items {
+		if e := el.Value.(entry); e.found && e.url.ExpiresAt != nil && e.url.ExpiresAt.Before(before) {
+			s.lru.Remove(el)
+			delete(s.items, slug)
+		}
+	}
+}
+
+// invalidateReads bumps the generations of all slugs which are being read,
+// so that none of them is cached. s.mu must be held.
+func (s *Store) invalidateReads() {
+	for _, r := range s.reads {
+		r.generation++
+	}
+}
@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/database"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	dbstore "github.com/madatsci/urlshortener/internal/app/store/database"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+// countingStore counts GetURL calls which reach the underlying storage.
+type countingStore struct {
+	store.Store
+	calls int
+}
+
+func (s *countingStore) GetURL(ctx context.Context, slug string) (models.URL, error) {
+	s.calls++
+	return s.Store.GetURL(ctx, slug)
+}
+
+func TestGetURL(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: memory.New()}
+	s := New(backend, Options{TTL: time.Minute, NegativeTTL: time.Second})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	user := random.RandomUser()
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	t.Run("hit", func(t *testing.T) {
+		backend.calls = 0
+		for i := 0; i < 3; i++ {
+			res, err := s.GetURL(ctx, url.Slug)
+			require.NoError(t, err)
+			assert.Equal(t, url.Original, res.Original)
+		}
+		assert.Equal(t, 1, backend.calls)
+	})
+
+	t.Run("expired entry", func(t *testing.T) {
+		backend.calls = 0
+		now = now.Add(time.Minute)
+		_, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, 1, backend.calls)
+	})
+
+	t.Run("negative caching", func(t *testing.T) {
+		backend.calls = 0
+		for i := 0; i < 3; i++ {
+			_, err := s.GetURL(ctx, "unknown")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+		}
+		assert.Equal(t, 1, backend.calls)
+
+		now = now.Add(time.Second)
+		_, err := s.GetURL(ctx, "unknown")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		assert.Equal(t, 2, backend.calls)
+	})
+
+	t.Run("invalidated on create", func(t *testing.T) {
+		newURL := random.RandomURL()
+		_, err := s.GetURL(ctx, newURL.Slug)
+		require.ErrorIs(t, err, store.ErrNotFound)
+
+		err = s.BatchCreateURL(ctx, user.ID, []models.URL{newURL})
+		require.NoError(t, err)
+
+		res, err := s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, newURL.Original, res.Original)
+	})
+
+	t.Run("invalidated on delete", func(t *testing.T) {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		require.False(t, res.Deleted)
+
+		err = s.SoftDeleteURL(ctx, user.ID, url.Slug)
+		require.NoError(t, err)
+
+		res, err = s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.True(t, res.Deleted)
+	})
+
+	t.Run("invalidated on batch delete", func(t *testing.T) {
+		newURL := random.RandomURL()
+		err := s.BatchCreateURL(ctx, user.ID, []models.URL{newURL})
+		require.NoError(t, err)
+
+		res, err := s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		require.False(t, res.Deleted)
+
+		err = s.BatchSoftDeleteURLs(ctx, user.ID, []string{newURL.Slug})
+		require.NoError(t, err)
+
+		res, err = s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		assert.True(t, res.Deleted)
+	})
+
+	t.Run("invalidated on block", func(t *testing.T) {
+		newURL := random.RandomURL()
+		err := s.CreateURL(ctx, user.ID, newURL)
+		require.NoError(t, err)
+
+		res, err := s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		require.False(t, res.Blocked)
+
+		err = s.BlockURLs(ctx, []string{newURL.Slug})
+		require.NoError(t, err)
+
+		res, err = s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		assert.True(t, res.Blocked)
+	})
+
+	t.Run("unknown slugs invalidated on import", func(t *testing.T) {
+		newURL := random.RandomURL()
+		_, err := s.GetURL(ctx, newURL.Slug)
+		require.ErrorIs(t, err, store.ErrNotFound)
+
+		_, err = s.ImportURLs(ctx, user.ID, func(yield func(models.URL, error) bool) {
+			yield(newURL, nil)
+		})
+		require.NoError(t, err)
+
+		res, err := s.GetURL(ctx, newURL.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, newURL.Original, res.Original)
+	})
+
+	t.Run("invalidated on purge", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Hour)
+		expired := random.RandomURL()
+		expired.ExpiresAt = &expiresAt
+		live := random.RandomURL()
+		err := s.BatchCreateURL(ctx, user.ID, []models.URL{expired, live})
+		require.NoError(t, err)
+
+		for _, slug := range []string{expired.Slug, live.Slug} {
+			_, err = s.GetURL(ctx, slug)
+			require.NoError(t, err)
+		}
+
+		n, err := s.DeleteExpiredURLs(ctx, time.Now(), 100)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		backend.calls = 0
+		_, err = s.GetURL(ctx, expired.Slug)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		_, err = s.GetURL(ctx, live.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, 1, backend.calls)
+	})
+}
+
+// blockingStore holds GetURL after reading the URL until it is released.
+type blockingStore struct {
+	store.Store
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) GetURL(ctx context.Context, slug string) (models.URL, error) {
+	url, err := s.Store.GetURL(ctx, slug)
+	if s.read != nil {
+		s.read <- struct{}{}
+		<-s.release
+	}
+
+	return url, err
+}
+
+func TestGetURLInvalidatedDuringRead(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingStore{Store: memory.New()}
+	s := New(backend, Options{})
+
+	user := random.RandomUser()
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	backend.read = make(chan struct{})
+	backend.release = make(chan struct{})
+	done := make(chan models.URL)
+	go func() {
+		res, err := s.GetURL(ctx, url.Slug)
+		assert.NoError(t, err)
+		done <- res
+	}()
+
+	// The URL is deleted after it has been read, but before it is cached.
+	<-backend.read
+	err = s.SoftDeleteURL(ctx, user.ID, url.Slug)
+	require.NoError(t, err)
+	close(backend.release)
+	assert.False(t, (<-done).Deleted)
+
+	backend.read = nil
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.True(t, res.Deleted)
+	assert.Empty(t, s.reads)
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: memory.New()}
+	s := New(backend, Options{Size: 2})
+
+	user := random.RandomUser()
+	urls := random.RandomURLs(3)
+	err := s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	for _, slug := range []string{urls[0].Slug, urls[1].Slug, urls[0].Slug, urls[2].Slug} {
+		_, err = s.GetURL(ctx, slug)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, backend.calls)
+	assert.Equal(t, 2, s.lru.Len())
+
+	// urls[1] is the least recently used and must have been evicted.
+	_, err = s.GetURL(ctx, urls[0].Slug)
+	require.NoError(t, err)
+	assert.Equal(t, 3, backend.calls)
+
+	_, err = s.GetURL(ctx, urls[1].Slug)
+	require.NoError(t, err)
+	assert.Equal(t, 4, backend.calls)
+}
+
+func BenchmarkGetURL(b *testing.B) {
+	ctx := context.Background()
+
+	backends := map[string]func(b *testing.B) store.Store{
+		"memory": func(b *testing.B) store.Store {
+			return memory.New()
+		},
+		"database": func(b *testing.B) store.Store {
+			dsn := os.Getenv("DATABASE_DSN")
+			if dsn == "" {
+				b.Skip("database DSN not configured")
+			}
+			conn, err := database.NewClient(ctx, dsn)
+			require.NoError(b, err)
+			s, err := dbstore.New(ctx, conn)
+			require.NoError(b, err)
+			return s
+		},
+	}
+
+	for name, newBackend := range backends {
+		for _, cached := range []bool{false, true} {
+			benchName := name + "/uncached"
+			if cached {
+				benchName = name + "/cached"
+			}
+
+			b.Run(benchName, func(b *testing.B) {
+				s := newBackend(b)
+				if cached {
+					s = New(s, Options{})
+				}
+
+				user := random.RandomUser()
+				require.NoError(b, s.CreateUser(ctx, user))
+				urls := random.RandomURLs(100)
+				require.NoError(b, s.BatchCreateURL(ctx, user.ID, urls))
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := s.GetURL(ctx, urls[i%len(urls)].Slug); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls ADD COLUMN expires_at timestamp with time zone NULL;
+CREATE INDEX urls_expires_at ON urls (expires_at) WHERE expires_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX urls_expires_at;
+ALTER TABLE urls DROP COLUMN expires_at;
+-- +goose StatementEnd
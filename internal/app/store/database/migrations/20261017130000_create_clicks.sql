@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE clicks (
+    id uuid PRIMARY KEY,
+    url_id uuid NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    referrer text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    ip_hash character varying(64) NOT NULL
+);
+
+CREATE INDEX clicks_url_id_created_at ON clicks (url_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE clicks;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Creation time of the URL is copied to the links, so that pages of user URLs
+-- are read from the index of the user without sorting all of their links.
+ALTER TABLE user_urls ADD COLUMN url_created_at timestamp without time zone;
+UPDATE user_urls SET url_created_at = urls.created_at FROM urls WHERE urls.id = user_urls.url_id;
+ALTER TABLE user_urls ALTER COLUMN url_created_at SET NOT NULL;
+CREATE INDEX user_urls_user_id_url_created_at ON user_urls (user_id, url_created_at, url_id) WHERE NOT is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX user_urls_user_id_url_created_at;
+ALTER TABLE user_urls DROP COLUMN url_created_at;
+-- +goose StatementEnd
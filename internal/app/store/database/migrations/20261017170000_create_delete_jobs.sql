@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE delete_jobs (
+    id uuid PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    slugs text[] NOT NULL,
+    status character varying(16) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    next_attempt_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX delete_jobs_pending ON delete_jobs (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX delete_jobs_finished ON delete_jobs (updated_at) WHERE status <> 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE delete_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE UNLOGGED TABLE rate_limits (
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    allowed boolean NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    full_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX rate_limits_full_at ON rate_limits (full_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE rate_limits;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- URLs are deduplicated by the canonical form instead of the original one.
+-- Existing URLs keep the original form as the canonical one.
+ALTER TABLE urls ADD COLUMN canonical_url text;
+UPDATE urls SET canonical_url = original_url;
+ALTER TABLE urls ALTER COLUMN canonical_url SET NOT NULL;
+
+DROP INDEX urls_original_url;
+CREATE UNIQUE INDEX urls_canonical_url ON urls (canonical_url);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX urls_canonical_url;
+CREATE UNIQUE INDEX urls_original_url ON urls (original_url);
+ALTER TABLE urls DROP COLUMN canonical_url;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Expired, deleted and blocked URLs release their canonical form when the same
+-- URL is shortened again, so that only one live URL has each canonical form.
+ALTER TABLE urls ADD COLUMN is_canonical boolean NOT NULL DEFAULT true;
+
+DROP INDEX urls_canonical_url;
+CREATE UNIQUE INDEX urls_canonical_url ON urls (canonical_url) WHERE is_canonical;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM user_urls WHERE url_id IN (SELECT id FROM urls WHERE NOT is_canonical);
+DELETE FROM urls WHERE NOT is_canonical;
+
+DROP INDEX urls_canonical_url;
+CREATE UNIQUE INDEX urls_canonical_url ON urls (canonical_url);
+ALTER TABLE urls DROP COLUMN is_canonical;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+package database
+
+import "github.com/madatsci/urlshortener/internal/app/store/sqlquery"
+
+// Statements shared with the SQLite storage, rewritten with PostgreSQL placeholders.
+var (
+	insertUserQuery                 = sqlquery.Postgres(sqlquery.InsertUser)
+	getUserQuery                    = sqlquery.Postgres(sqlquery.GetUser)
+	insertURLQuery                  = sqlquery.Postgres(sqlquery.InsertURL)
+	insertUserURLQuery              = sqlquery.Postgres(sqlquery.InsertUserURL)
+	getURLBySlugQuery               = sqlquery.Postgres(sqlquery.GetURLBySlug)
+	listURLsByUserIDQuery           = sqlquery.Postgres(sqlquery.ListURLsByUserID)
+	listAllURLsQuery                = sqlquery.Postgres(sqlquery.ListAllURLs)
+	countURLsQuery                  = sqlquery.Postgres(sqlquery.CountURLs)
+	countUsersQuery                 = sqlquery.Postgres(sqlquery.CountUsers)
+	deleteUserURLQuery              = sqlquery.Postgres(sqlquery.DeleteUserURL)
+	countURLLinksQuery              = sqlquery.Postgres(sqlquery.CountURLLinks)
+	deleteURLQuery                  = sqlquery.Postgres(sqlquery.DeleteURL)
+	insertClickQuery                = sqlquery.Postgres(sqlquery.InsertClick)
+	getUserURLIDQuery               = sqlquery.Postgres(sqlquery.GetUserURLID)
+	countClicksQuery                = sqlquery.Postgres(sqlquery.CountClicks)
+	scanURLsQuery                   = sqlquery.Postgres(sqlquery.ScanURLs)
+	insertAPIKeyQuery               = sqlquery.Postgres(sqlquery.InsertAPIKey)
+	listAPIKeysQuery                = sqlquery.Postgres(sqlquery.ListAPIKeys)
+	getAPIKeyByHashQuery            = sqlquery.Postgres(sqlquery.GetAPIKeyByHash)
+	deleteAPIKeyQuery               = sqlquery.Postgres(sqlquery.DeleteAPIKey)
+	touchAPIKeyQuery                = sqlquery.Postgres(sqlquery.TouchAPIKey)
+	revokeTokenQuery                = sqlquery.Postgres(sqlquery.RevokeToken)
+	isTokenRevokedQuery             = sqlquery.Postgres(sqlquery.IsTokenRevoked)
+	deleteExpiredRevokedTokensQuery = sqlquery.Postgres(sqlquery.DeleteExpiredRevokedTokens)
+	insertDeleteJobQuery            = sqlquery.Postgres(sqlquery.InsertDeleteJob)
+	updateDeleteJobQuery            = sqlquery.Postgres(sqlquery.UpdateDeleteJob)
+	countDeleteJobsQuery            = sqlquery.Postgres(sqlquery.CountDeleteJobs)
+	deleteFinishedDeleteJobsQuery   = sqlquery.Postgres(sqlquery.DeleteFinishedDeleteJobs)
+	getURLByCanonicalQuery          = sqlquery.Postgres(sqlquery.GetURLByCanonical)
+)
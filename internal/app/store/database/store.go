@@ -5,12 +5,19 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 
 	"github.com/madatsci/urlshortener/internal/app/models"
@@ -20,6 +27,10 @@ import (
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
+// upsertChunkSize is the number of URLs inserted by a single statement of BatchUpsertURLs.
+// It keeps the number of parameters well below the limit of 65535.
+const upsertChunkSize = 1000
+
 // Store is an implementation of store.Store interface which interacts with database.
 //
 // Use New to create an instance of Store.
@@ -41,7 +52,7 @@ func New(ctx context.Context, conn *sql.DB) (*Store, error) {
 func (s *Store) CreateUser(ctx context.Context, user models.User) error {
 	_, err := s.conn.ExecContext(
 		ctx,
-		"INSERT INTO users (id, created_at) VALUES ($1, $2)",
+		insertUserQuery,
 		user.ID,
 		user.CreatedAt,
 	)
@@ -57,7 +68,7 @@ func (s *Store) GetUser(ctx context.Context, userID string) (models.User, error)
 
 	err := s.conn.QueryRowContext(
 		ctx,
-		"SELECT id, created_at FROM users WHERE id = $1",
+		getUserQuery,
 		userID,
 	).Scan(&user.ID, &user.CreatedAt)
 
@@ -70,46 +81,63 @@ func (s *Store) GetUser(ctx context.Context, userID string) (models.User, error)
 
 // CreateURL adds a new URL to the storage.
 //
-// It also links the URL to the current user.
+// It also links the URL to the current user. It returns *store.SlugExistsError
+// if a different URL with the same slug already exists. If a URL with the same
+// canonical form already exists, it links that URL to the user instead and
+// returns *store.AlreadyExistsError.
 func (s *Store) CreateURL(ctx context.Context, userID string, url models.URL) error {
-	originalURL, err := s.getURLByOriginal(ctx, url.Original)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			_, err = s.conn.ExecContext(
-				ctx,
-				"INSERT INTO urls (id, correlation_id, slug, original_url, created_at) VALUES ($1, $2, $3, $4, $5)",
-				url.ID,
-				url.CorrelationID,
-				url.Slug,
-				url.Original,
-				url.CreatedAt,
-			)
-			if err != nil {
-				return err
-			}
+	if err := releaseDeadCanonicals(ctx, s.conn, []string{url.DedupKey()}, time.Now()); err != nil {
+		return err
+	}
 
+	existing, err := s.getURLByCanonical(ctx, url.DedupKey())
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = s.conn.ExecContext(
+			ctx,
+			insertURLQuery,
+			url.ID,
+			url.CorrelationID,
+			url.Slug,
+			url.Original,
+			url.DedupKey(),
+			url.CreatedAt,
+			url.ExpiresAt,
+			url.RedirectType,
+			url.MergeQuery,
+		)
+		if err == nil {
 			return s.linkURLtoUser(ctx, url, userID)
 		}
+		if !isUniqueViolation(err, "urls_canonical_url") {
+			return slugError(err, url.Slug)
+		}
 
+		// The same URL has been created by a concurrent request.
+		existing, err = s.getURLByCanonical(ctx, url.DedupKey())
+	}
+	if err != nil {
 		return err
 	}
 
-	if err = s.linkURLtoUser(ctx, originalURL, userID); err != nil {
+	if err = s.linkURLtoUser(ctx, existing, userID); err != nil {
 		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-			return &store.AlreadyExistsError{
-				Err: pgErr,
-				URL: originalURL,
-			}
+		if !errors.As(err, &pgErr) || !pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			return err
 		}
 	}
 
-	return err
+	return &store.AlreadyExistsError{
+		Err: fmt.Errorf("url %s has already been shortened", url.Original),
+		URL: existing,
+	}
 }
 
 // BatchCreateURL adds a batch of URLs to the storage.
 //
-// It also links the created URLs to the current user.
+// It also links the created URLs to the current user. If any of the slugs
+// is already taken, it returns *store.SlugExistsError and adds nothing.
+// If any of the URLs has already been shortened, it returns *store.AlreadyExistsError
+// and adds nothing.
 func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models.URL) error {
 	tx, err := s.conn.Begin()
 	if err != nil {
@@ -117,33 +145,36 @@ func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models
 	}
 	defer tx.Rollback() //nolint:errcheck
 
-	urlStmt, err := tx.PrepareContext(
-		ctx,
-		"INSERT INTO urls (id, correlation_id, slug, original_url, created_at) VALUES ($1, $2, $3, $4, $5)",
-	)
+	canonicals := make([]string, 0, len(urls))
+	for _, url := range urls {
+		canonicals = append(canonicals, url.DedupKey())
+	}
+	if err = releaseDeadCanonicals(ctx, tx, canonicals, time.Now()); err != nil {
+		return err
+	}
+
+	urlStmt, err := tx.PrepareContext(ctx, insertURLQuery)
 	if err != nil {
 		return err
 	}
 	defer urlStmt.Close()
 
-	userURLStmt, err := tx.PrepareContext(
-		ctx,
-		"INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at) VALUES ($1, $2, $3, $4, $5)",
-	)
+	userURLStmt, err := tx.PrepareContext(ctx, insertUserURLQuery)
 	if err != nil {
 		return err
 	}
 	defer userURLStmt.Close()
 
 	for _, url := range urls {
-		// TODO Handle integrity violation.
-		_, err := urlStmt.ExecContext(ctx, url.ID, url.CorrelationID, url.Slug, url.Original, url.CreatedAt)
+		_, err := urlStmt.ExecContext(ctx, url.ID, url.CorrelationID, url.Slug, url.Original, url.DedupKey(), url.CreatedAt, url.ExpiresAt, url.RedirectType, url.MergeQuery)
 		if err != nil {
-			return err
+			if isUniqueViolation(err, "urls_canonical_url") {
+				return s.alreadyExistsError(ctx, err, url)
+			}
+			return slugError(err, url.Slug)
 		}
 
-		// TODO Handle integrity violation.
-		_, err = userURLStmt.ExecContext(ctx, uuid.NewString(), userID, url.ID, false, time.Now())
+		_, err = userURLStmt.ExecContext(ctx, uuid.NewString(), userID, url.ID, false, time.Now(), url.CreatedAt)
 		if err != nil {
 			return err
 		}
@@ -152,18 +183,67 @@ func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models
 	return tx.Commit()
 }
 
+// BatchUpsertURLs adds URLs of a batch independently of each other and links them to the user.
+//
+// URLs are inserted in chunks with INSERT ... ON CONFLICT DO NOTHING, so that a URL
+// which has already been shortened or has a taken slug does not abort the batch.
+// The existing URLs with the same canonical form are linked to the user instead,
+// URLs whose slug is taken by a different URL are reported with *store.SlugExistsError.
+func (s *Store) BatchUpsertURLs(ctx context.Context, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res := make([]store.UpsertResult, 0, len(urls))
+	for chunk := range slices.Chunk(urls, upsertChunkSize) {
+		chunkRes, err := upsertURLs(ctx, tx, userID, chunk)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, chunkRes...)
+	}
+
+	return res, tx.Commit()
+}
+
+// ImportURLs adds URLs read from the stream like BatchUpsertURLs.
+//
+// The stream is copied with COPY into a temporary table as it is read, and then
+// moved to urls with INSERT ... ON CONFLICT DO NOTHING in the same transaction.
+func (s *Store) ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var res store.ImportResult
+
+	conn, err := s.conn.Conn(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		res, err = importURLs(ctx, driverConn.(*stdlib.Conn).Conn(), userID, urls)
+		return err
+	})
+
+	return res, err
+}
+
 // GetURL retrieves a URL by its slug from the storage.
 //
-// It returns error if URL is not found.
+// It returns store.ErrNotFound if URL is not found.
 func (s *Store) GetURL(ctx context.Context, slug string) (models.URL, error) {
 	var url models.URL
 
 	err := s.conn.QueryRowContext(
 		ctx,
-		"SELECT id, correlation_id, slug, original_url, created_at, is_deleted FROM urls WHERE slug = $1",
+		getURLBySlugQuery,
 		slug,
-	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.CreatedAt, &url.Deleted)
+	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return url, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
 	if err != nil {
 		return url, err
 	}
@@ -177,7 +257,7 @@ func (s *Store) ListURLsByUserID(ctx context.Context, userID string) ([]models.U
 
 	rows, err := s.conn.QueryContext(
 		ctx,
-		"SELECT id, correlation_id, slug, original_url, created_at, is_deleted FROM urls WHERE id IN (SELECT url_id FROM user_urls WHERE user_id = $1 AND NOT is_deleted)",
+		listURLsByUserIDQuery,
 		userID,
 	)
 	if err != nil {
@@ -187,7 +267,7 @@ func (s *Store) ListURLsByUserID(ctx context.Context, userID string) ([]models.U
 
 	for rows.Next() {
 		var url models.URL
-		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.CreatedAt, &url.Deleted)
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
 		if err != nil {
 			return nil, err
 		}
@@ -201,16 +281,70 @@ func (s *Store) ListURLsByUserID(ctx context.Context, userID string) ([]models.U
 	return res, nil
 }
 
+// ListUserURLs returns a page of URLs created by the specified user which match the query.
+//
+// Pages are selected with a keyset condition on (created_at, id) of the URLs. Both are
+// copied to the links of the user, so that pages are read from the index of the user
+// and deep pages are as cheap as the first one.
+func (s *Store) ListUserURLs(ctx context.Context, userID string, q models.URLQuery) ([]models.URL, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"uu.user_id = " + arg(userID), "NOT uu.is_deleted"}
+	if q.Search != "" {
+		where = append(where, "u.original_url ILIKE "+arg("%"+escapeLike(q.Search)+"%")+` ESCAPE '\'`)
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "uu.url_created_at >= "+arg(*q.CreatedFrom))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "uu.url_created_at < "+arg(*q.CreatedTo))
+	}
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(uu.url_created_at, uu.url_id) %s (%s, %s)", cmp, arg(q.After.CreatedAt), arg(q.After.ID)))
+	}
+
+	query := `SELECT u.id, u.correlation_id, u.slug, u.original_url, u.canonical_url, u.created_at, u.is_deleted, u.is_blocked, u.expires_at, u.redirect_type, u.merge_query
+		FROM user_urls uu JOIN urls u ON u.id = uu.url_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY uu.url_created_at ` + order + `, uu.url_id ` + order
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.URL, 0, q.Limit)
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, url)
+	}
+
+	return res, rows.Err()
+}
+
 // ListAllUrls returns the full map of stored URLs.
 //
 // This function should not be used in production.
 func (s *Store) ListAllUrls(ctx context.Context) (map[string]models.URL, error) {
 	res := make(map[string]models.URL, 0)
 
-	rows, err := s.conn.QueryContext(
-		ctx,
-		"SELECT id, correlation_id, slug, original_url, created_at, is_deleted FROM urls",
-	)
+	rows, err := s.conn.QueryContext(ctx, listAllURLsQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +352,7 @@ func (s *Store) ListAllUrls(ctx context.Context) (map[string]models.URL, error)
 
 	for rows.Next() {
 		var url models.URL
-		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.CreatedAt, &url.Deleted)
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
 		if err != nil {
 			return nil, err
 		}
@@ -232,6 +366,22 @@ func (s *Store) ListAllUrls(ctx context.Context) (map[string]models.URL, error)
 	return res, nil
 }
 
+// CountURLs returns the number of stored URLs which are not deleted.
+func (s *Store) CountURLs(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, countURLsQuery).Scan(&n)
+
+	return n, err
+}
+
+// CountUsers returns the number of registered users.
+func (s *Store) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, countUsersQuery).Scan(&n)
+
+	return n, err
+}
+
 // SoftDeleteURL marks URLs as deleted.
 func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) error {
 	tx, err := s.conn.Begin()
@@ -243,16 +393,16 @@ func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) e
 	var url models.URL
 	err = tx.QueryRowContext(
 		ctx,
-		"SELECT id, correlation_id, slug, original_url, created_at, is_deleted FROM urls WHERE slug = $1",
+		getURLBySlugQuery,
 		slug,
-	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.CreatedAt, &url.Deleted)
+	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
 	if err != nil {
 		return err
 	}
 
 	_, err = tx.ExecContext(
 		ctx,
-		"UPDATE user_urls SET is_deleted = true WHERE user_id = $1 AND url_id = $2",
+		deleteUserURLQuery,
 		userID,
 		url.ID,
 	)
@@ -263,7 +413,7 @@ func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) e
 	var linksCount int
 	err = tx.QueryRowContext(
 		ctx,
-		"SELECT COUNT(id) FROM user_urls WHERE url_id = $1 AND NOT is_deleted",
+		countURLLinksQuery,
 		url.ID,
 	).Scan(&linksCount)
 	if err != nil {
@@ -273,7 +423,7 @@ func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) e
 	if linksCount == 0 {
 		_, err = tx.ExecContext(
 			ctx,
-			"UPDATE urls SET is_deleted = true WHERE id = $1",
+			deleteURLQuery,
 			url.ID,
 		)
 		if err != nil {
@@ -284,11 +434,487 @@ func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) e
 	return tx.Commit()
 }
 
+// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+//
+// Slugs which do not exist or were not created by the user are skipped.
+// A URL is marked as deleted when it has been deleted by all users who created it.
+func (s *Store) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE user_urls SET is_deleted = true
+		WHERE user_id = $1 AND NOT is_deleted AND url_id IN (SELECT id FROM urls WHERE slug = ANY($2))`,
+		userID,
+		slugs,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE urls SET is_deleted = true
+		WHERE slug = ANY($1) AND is_deleted IS NOT TRUE
+		AND NOT EXISTS (SELECT 1 FROM user_urls WHERE url_id = urls.id AND NOT is_deleted)`,
+		slugs,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateClicks adds a batch of clicks to the storage.
+//
+// Clicks of URLs which do not exist anymore are skipped.
+func (s *Store) CreateClicks(ctx context.Context, clicks []models.Click) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.PrepareContext(ctx, insertClickQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ID, click.CreatedAt, click.Referrer, click.UserAgent, click.IPHash, click.URLID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetURLStats returns click statistics of the URL created by the specified user.
+//
+// It returns store.ErrNotFound if there is no such URL, it was not created
+// by the user or it was deleted by the user.
+func (s *Store) GetURLStats(ctx context.Context, userID, slug string) (models.URLStats, error) {
+	stats := models.URLStats{
+		Slug:  slug,
+		Daily: []models.DailyStats{},
+	}
+
+	var urlID string
+	err := s.conn.QueryRowContext(
+		ctx,
+		getUserURLIDQuery,
+		slug,
+		userID,
+	).Scan(&urlID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stats, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	err = s.conn.QueryRowContext(
+		ctx,
+		countClicksQuery,
+		urlID,
+	).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := s.conn.QueryContext(
+		ctx,
+		"SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(id), COUNT(DISTINCT ip_hash) FROM clicks WHERE url_id = $1 GROUP BY day ORDER BY day",
+		urlID,
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		var dayStats models.DailyStats
+		if err = rows.Scan(&day, &dayStats.Clicks, &dayStats.UniqueVisitors); err != nil {
+			return stats, err
+		}
+		dayStats.Date = day.Format(models.DateFormat)
+		stats.Daily = append(stats.Daily, dayStats)
+	}
+
+	return stats, rows.Err()
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+func (s *Store) DeleteExpiredURLs(ctx context.Context, before time.Time, limit int) (int, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id FROM urls WHERE expires_at < $1 ORDER BY expires_at LIMIT $2 FOR UPDATE SKIP LOCKED",
+		before,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	// Rows must be closed before the connection can be used for another query.
+	rows.Close()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_urls WHERE url_id = ANY($1::uuid[])", ids); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM urls WHERE id = ANY($1::uuid[])", ids); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+func (s *Store) ScanURLs(ctx context.Context, afterSlug string, limit int) ([]models.URL, error) {
+	rows, err := s.conn.QueryContext(
+		ctx,
+		scanURLsQuery,
+		afterSlug,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []models.URL
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, url)
+	}
+
+	return res, rows.Err()
+}
+
+// BlockURLs marks URLs with the given slugs as blocked.
+//
+// Slugs which do not exist are skipped.
+func (s *Store) BlockURLs(ctx context.Context, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	_, err := s.conn.ExecContext(ctx, "UPDATE urls SET is_blocked = true WHERE slug = ANY($1) AND NOT is_blocked", slugs)
+
+	return err
+}
+
+// CreateAPIKey adds a new API key of a user.
+func (s *Store) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		insertAPIKeyQuery,
+		key.ID,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		key.CreatedAt,
+	)
+
+	return err
+}
+
+// ListAPIKeysByUserID returns all API keys of the specified user ordered by creation time.
+func (s *Store) ListAPIKeysByUserID(ctx context.Context, userID string) ([]models.APIKey, error) {
+	res := make([]models.APIKey, 0)
+
+	rows, err := s.conn.QueryContext(
+		ctx,
+		listAPIKeysQuery,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key models.APIKey
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAPIKeyByHash fetches API key by the hash of the key.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		getAPIKeyByHashQuery,
+		hash,
+	).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.LastUsedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, fmt.Errorf("api key: %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// RevokeAPIKey removes the API key of the specified user.
+//
+// It returns store.ErrNotFound if the user has no such key.
+func (s *Store) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	res, err := s.conn.ExecContext(ctx, deleteAPIKeyQuery, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	return keyAffected(res, keyID)
+}
+
+// TouchAPIKey records the time the API key was last used.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	res, err := s.conn.ExecContext(ctx, touchAPIKeyQuery, usedAt, keyID)
+	if err != nil {
+		return err
+	}
+
+	return keyAffected(res, keyID)
+}
+
+// RevokeToken adds the ID of an authentication token to the revocation list.
+//
+// It returns false if the token had already been revoked.
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	res, err := s.conn.ExecContext(
+		ctx,
+		revokeTokenQuery,
+		tokenID,
+		expiresAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// IsTokenRevoked reports whether the authentication token with the given ID has been revoked.
+func (s *Store) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		isTokenRevokedQuery,
+		tokenID,
+	).Scan(&revoked)
+
+	return revoked, err
+}
+
+// DeleteExpiredRevokedTokens removes revocation list entries of tokens which expired before the given time.
+func (s *Store) DeleteExpiredRevokedTokens(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, deleteExpiredRevokedTokensQuery, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+// CreateDeleteJob adds a new job of deleting user URLs.
+func (s *Store) CreateDeleteJob(ctx context.Context, job models.DeleteJob) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		insertDeleteJobQuery,
+		job.ID,
+		job.UserID,
+		job.Slugs,
+		string(job.Status),
+		job.Attempts,
+		job.LastError,
+		job.CreatedAt,
+		job.UpdatedAt,
+		job.NextAttemptAt,
+	)
+
+	return err
+}
+
+// GetDeleteJob fetches the job of the specified user by ID.
+//
+// It returns store.ErrNotFound if the user has no such job.
+func (s *Store) GetDeleteJob(ctx context.Context, userID, jobID string) (models.DeleteJob, error) {
+	row := s.conn.QueryRowContext(
+		ctx,
+		"SELECT "+deleteJobColumns+" FROM delete_jobs WHERE id = $1 AND user_id = $2",
+		jobID,
+		userID,
+	)
+
+	job, err := scanDeleteJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, fmt.Errorf("delete job %s: %w", jobID, store.ErrNotFound)
+	}
+
+	return job, err
+}
+
+// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time,
+// the oldest first, and postpones their next attempt until leaseUntil.
+//
+// Rows locked by concurrent claims are skipped, so that every job is claimed by one instance.
+func (s *Store) ClaimDeleteJobs(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.DeleteJob, error) {
+	rows, err := s.conn.QueryContext(
+		ctx,
+		`UPDATE delete_jobs SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM delete_jobs
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY created_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deleteJobColumns,
+		leaseUntil,
+		string(models.DeleteJobPending),
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.DeleteJob, 0)
+	for rows.Next() {
+		job, err := scanDeleteJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	slices.SortFunc(res, func(a, b models.DeleteJob) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	return res, nil
+}
+
+// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+//
+// It returns store.ErrNotFound if there is no such job.
+func (s *Store) UpdateDeleteJob(ctx context.Context, job models.DeleteJob) error {
+	res, err := s.conn.ExecContext(
+		ctx,
+		updateDeleteJobQuery,
+		string(job.Status),
+		job.Attempts,
+		job.LastError,
+		job.UpdatedAt,
+		job.NextAttemptAt,
+		job.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("delete job %s: %w", job.ID, store.ErrNotFound)
+	}
+
+	return nil
+}
+
+// CountPendingDeleteJobs returns the number of pending jobs.
+func (s *Store) CountPendingDeleteJobs(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, countDeleteJobsQuery, string(models.DeleteJobPending)).Scan(&n)
+
+	return n, err
+}
+
+// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) DeleteFinishedDeleteJobs(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, deleteFinishedDeleteJobsQuery, string(models.DeleteJobPending), before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
 // Ping is a storage healthcheck.
 func (s *Store) Ping(ctx context.Context) error {
 	return s.conn.PingContext(ctx)
 }
 
+// Close closes the underlying database connection pool.
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
 func (s *Store) bootstrap() error {
 	goose.SetBaseFS(embedMigrations)
 
@@ -303,14 +929,255 @@ func (s *Store) bootstrap() error {
 	return nil
 }
 
-func (s *Store) getURLByOriginal(ctx context.Context, originalURL string) (models.URL, error) {
+// upsertURLs inserts a chunk of URLs of BatchUpsertURLs and links them to the user.
+func upsertURLs(ctx context.Context, tx *sql.Tx, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	values := make([]string, 0, len(urls))
+	args := make([]any, 0, 9*len(urls))
+	canonicals := make([]string, 0, len(urls))
+	for _, url := range urls {
+		values = append(values, placeholders(len(args), 9))
+		args = append(args, url.ID, url.CorrelationID, url.Slug, url.Original, url.DedupKey(), url.CreatedAt, url.ExpiresAt, url.RedirectType, url.MergeQuery)
+		canonicals = append(canonicals, url.DedupKey())
+	}
+
+	if err := releaseDeadCanonicals(ctx, tx, canonicals, time.Now()); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`INSERT INTO urls (id, correlation_id, slug, original_url, canonical_url, created_at, expires_at, redirect_type, merge_query)
+		VALUES `+strings.Join(values, ", ")+` ON CONFLICT DO NOTHING RETURNING id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := make(map[string]struct{}, len(urls))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		created[id] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// Rows must be closed before the connection can be used for another query.
+	rows.Close()
+
+	// URLs which have not been inserted have either been shortened or have a taken slug.
+	var duplicates []string
+	for _, url := range urls {
+		if _, ok := created[url.ID]; !ok {
+			duplicates = append(duplicates, url.DedupKey())
+		}
+	}
+	existing := make(map[string]models.URL, len(duplicates))
+	if len(duplicates) > 0 {
+		rows, err = tx.QueryContext(
+			ctx,
+			"SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls WHERE canonical_url = ANY($1) AND is_canonical",
+			duplicates,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var url models.URL
+			err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+			if err != nil {
+				return nil, err
+			}
+			existing[url.Canonical] = url
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+	}
+
+	res := make([]store.UpsertResult, 0, len(urls))
+	links := make([]string, 0, len(urls))
+	linkArgs := make([]any, 0, 6*len(urls))
+	now := time.Now()
+	for _, url := range urls {
+		result := store.UpsertResult{URL: url, Created: true}
+		if _, ok := created[url.ID]; !ok {
+			if e, ok := existing[url.DedupKey()]; ok {
+				result = store.UpsertResult{URL: e}
+			} else {
+				result = store.UpsertResult{URL: url, Err: &store.SlugExistsError{Slug: url.Slug}}
+			}
+		}
+		res = append(res, result)
+
+		if result.Err == nil {
+			links = append(links, placeholders(len(linkArgs), 6))
+			linkArgs = append(linkArgs, uuid.NewString(), userID, result.URL.ID, false, now, result.URL.CreatedAt)
+		}
+	}
+
+	if len(links) > 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at, url_created_at)
+			VALUES `+strings.Join(links, ", ")+` ON CONFLICT (user_id, url_id) DO NOTHING`,
+			linkArgs...,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// importURLs copies URLs of the stream into a temporary table and moves them to urls.
+func importURLs(ctx context.Context, conn *pgx.Conn, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var res store.ImportResult
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	_, err = tx.Exec(ctx, "CREATE TEMPORARY TABLE import_urls (LIKE urls INCLUDING DEFAULTS) ON COMMIT DROP")
+	if err != nil {
+		return res, err
+	}
+
+	next, stop := iter.Pull2(urls)
+	defer stop()
+
+	total, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"import_urls"},
+		[]string{"id", "correlation_id", "slug", "original_url", "canonical_url", "created_at", "expires_at", "redirect_type", "merge_query"},
+		pgx.CopyFromFunc(func() ([]any, error) {
+			url, err, ok := next()
+			if !ok || err != nil {
+				return nil, err
+			}
+			return []any{url.ID, url.CorrelationID, url.Slug, url.Original, url.DedupKey(), url.CreatedAt, url.ExpiresAt, url.RedirectType, url.MergeQuery}, nil
+		}),
+	)
+	if err != nil {
+		return res, err
+	}
+	if _, err = tx.Exec(ctx, "ANALYZE import_urls"); err != nil {
+		return res, err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE urls SET is_canonical = false
+		WHERE canonical_url IN (SELECT canonical_url FROM import_urls) AND is_canonical
+		AND (is_deleted OR is_blocked OR expires_at <= $1)`,
+		time.Now(),
+	)
+	if err != nil {
+		return res, err
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		`WITH inserted AS (
+			INSERT INTO urls (id, correlation_id, slug, original_url, canonical_url, created_at, expires_at, redirect_type, merge_query)
+			SELECT id, correlation_id, slug, original_url, canonical_url, created_at, expires_at, redirect_type, merge_query FROM import_urls
+			ON CONFLICT DO NOTHING
+			RETURNING id
+		)
+		SELECT count(*) FROM inserted`,
+	).Scan(&res.Created)
+	if err != nil {
+		return res, err
+	}
+
+	// URLs which have neither been inserted nor shortened before have a taken slug.
+	rows, err := tx.Query(
+		ctx,
+		`SELECT i.id, i.correlation_id, i.slug, i.original_url, i.canonical_url, i.created_at, i.expires_at, i.redirect_type, i.merge_query
+		FROM import_urls i
+		WHERE NOT EXISTS (SELECT 1 FROM urls u WHERE u.canonical_url = i.canonical_url AND u.is_canonical)`,
+	)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url models.URL
+		if err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery); err != nil {
+			return res, err
+		}
+		res.Conflicts = append(res.Conflicts, url)
+	}
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+	res.Existing = int(total) - res.Created - len(res.Conflicts)
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at, url_created_at)
+		SELECT gen_random_uuid(), $1, u.id, false, $2, u.created_at
+		FROM urls u WHERE u.canonical_url IN (SELECT canonical_url FROM import_urls) AND u.is_canonical
+		ON CONFLICT (user_id, url_id) DO NOTHING`,
+		userID,
+		time.Now(),
+	)
+	if err != nil {
+		return res, err
+	}
+
+	return res, tx.Commit(ctx)
+}
+
+// placeholders returns a row of n numbered placeholders following the first offset ones, e.g. ($3, $4).
+func placeholders(offset, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", offset+i+1)
+	}
+
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+// releaseDeadCanonicals releases the canonical forms of expired, deleted and blocked URLs,
+// so that new URLs can be created with them instead of being linked to the dead ones.
+func releaseDeadCanonicals(ctx context.Context, e execer, canonicals []string, now time.Time) error {
+	_, err := e.ExecContext(
+		ctx,
+		`UPDATE urls SET is_canonical = false
+		WHERE canonical_url = ANY($1) AND is_canonical
+		AND (is_deleted OR is_blocked OR expires_at <= $2)`,
+		canonicals,
+		now,
+	)
+
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *Store) getURLByCanonical(ctx context.Context, canonicalURL string) (models.URL, error) {
 	var url models.URL
 
 	err := s.conn.QueryRowContext(
 		ctx,
-		"SELECT id, correlation_id, slug, original_url, created_at, is_deleted FROM urls WHERE original_url = $1",
-		originalURL,
-	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.CreatedAt, &url.Deleted)
+		getURLByCanonicalQuery,
+		canonicalURL,
+	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
 
 	if err != nil {
 		return url, err
@@ -319,6 +1186,17 @@ func (s *Store) getURLByOriginal(ctx context.Context, originalURL string) (model
 	return url, nil
 }
 
+// alreadyExistsError converts unique violation of urls.canonical_url into
+// *store.AlreadyExistsError with the existing URL.
+func (s *Store) alreadyExistsError(ctx context.Context, err error, url models.URL) error {
+	existing, getErr := s.getURLByCanonical(ctx, url.DedupKey())
+	if getErr != nil {
+		return err
+	}
+
+	return &store.AlreadyExistsError{Err: err, URL: existing}
+}
+
 func (s *Store) linkURLtoUser(ctx context.Context, url models.URL, userID string) error {
 	userURL := models.UserURL{
 		ID:        uuid.NewString(),
@@ -330,30 +1208,64 @@ func (s *Store) linkURLtoUser(ctx context.Context, url models.URL, userID string
 
 	_, err := s.conn.ExecContext(
 		ctx,
-		"INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at) VALUES ($1, $2, $3, $4, $5)",
+		insertUserURLQuery,
 		userURL.ID,
 		userURL.UserID,
 		userURL.URLID,
 		userURL.Deleted,
 		userURL.CreatedAt,
+		url.CreatedAt,
 	)
 
 	return err
 }
 
-func (s *Store) geUserURLLink(ctx context.Context, userID, urlID string) (models.UserURL, error) {
-	var link models.UserURL
+// slugError converts unique violation of urls.slug into *store.SlugExistsError.
+func slugError(err error, slug string) error {
+	if isUniqueViolation(err, "urls_slug") {
+		return &store.SlugExistsError{Slug: slug}
+	}
 
-	err := s.conn.QueryRowContext(
-		ctx,
-		"SELECT id, user_id, url_id, is_deleted, created_at FROM user_urls WHERE user_id = $1 AND url_id = $2",
-		userID,
-		urlID,
-	).Scan(&link.ID, &link.UserID, &link.URLID, &link.Deleted, &link.CreatedAt)
+	return err
+}
+
+// isUniqueViolation reports whether err is a violation of the unique index.
+func isUniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == index
+}
+
+// keyAffected returns store.ErrNotFound if the statement did not affect the API key.
+func keyAffected(res sql.Result, keyID string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	return nil
+}
+
+// escapeLike escapes wildcards of the LIKE pattern with a backslash.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// deleteJobColumns are the columns of delete_jobs in the order of scanDeleteJob.
+// Slugs are selected as a JSON array, so that they can be scanned without the driver's array types.
+const deleteJobColumns = "id, user_id, to_json(slugs), status, attempts, last_error, created_at, updated_at, next_attempt_at"
+
+// scanDeleteJob scans a row of deleteJobColumns.
+func scanDeleteJob(row interface{ Scan(dest ...any) error }) (models.DeleteJob, error) {
+	var job models.DeleteJob
+	var slugs []byte
 
+	err := row.Scan(&job.ID, &job.UserID, &slugs, &job.Status, &job.Attempts, &job.LastError, &job.CreatedAt, &job.UpdatedAt, &job.NextAttemptAt)
 	if err != nil {
-		return link, err
+		return job, err
 	}
 
-	return link, nil
+	return job, json.Unmarshal(slugs, &job.Slugs)
 }
@@ -6,11 +6,11 @@ import (
 	"os"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madatsci/urlshortener/internal/app/database"
-	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/storetest"
 	"github.com/madatsci/urlshortener/internal/random"
 )
 
@@ -56,28 +56,29 @@ func cleanup(s *Store) error {
 		return err
 	}
 
+	_, err = s.conn.Exec("TRUNCATE TABLE revoked_tokens")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func TestCreateUser(t *testing.T) {
-	ctx := context.Background()
-	s, err := newTestStore(ctx)
-	if err != nil {
-		if err == errMissingDSN {
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := newTestStore(context.Background())
+		if errors.Is(err, errMissingDSN) {
 			t.Skip()
 		}
-		t.Fatal(err)
-	}
-	defer cleanup(s)
-
-	user := random.RandomUser()
-	err = s.CreateUser(ctx, user)
-	require.NoError(t, err)
+		require.NoError(t, err)
+		require.NoError(t, cleanup(s))
+		t.Cleanup(func() {
+			cleanup(s)
+			s.Close()
+		})
 
-	res, err := s.GetUser(ctx, user.ID)
-	require.NoError(t, err)
-	assert.Equal(t, user.ID, res.ID)
-	assert.Equal(t, user.CreatedAt.Unix(), res.CreatedAt.Unix())
+		return s
+	})
 }
 
 func BenchmarkCreateUser(b *testing.B) {
@@ -103,91 +104,6 @@ func BenchmarkCreateUser(b *testing.B) {
 	})
 }
 
-func TestCreateURL(t *testing.T) {
-	ctx := context.Background()
-	s, err := newTestStore(ctx)
-	if err != nil {
-		if err == errMissingDSN {
-			t.Skip()
-		}
-		t.Fatal(err)
-	}
-
-	t.Run("new URL", func(t *testing.T) {
-		defer cleanup(s)
-
-		user := random.RandomUser()
-		err = s.CreateUser(ctx, user)
-		require.NoError(t, err)
-
-		url := random.RandomURL()
-		err = s.CreateURL(ctx, user.ID, url)
-		require.NoError(t, err)
-
-		persistedURL, urlErr := s.GetURL(ctx, url.Slug)
-		require.NoError(t, urlErr)
-		assert.Equal(t, url.ID, persistedURL.ID)
-		assert.Equal(t, url.Slug, persistedURL.Slug)
-		assert.Equal(t, url.Original, persistedURL.Original)
-		assert.Equal(t, false, persistedURL.Deleted)
-		assert.Equal(t, url.CreatedAt.Unix(), persistedURL.CreatedAt.Unix())
-
-		link, linkErr := s.geUserURLLink(ctx, user.ID, url.ID)
-		require.NoError(t, linkErr)
-		assert.Equal(t, user.ID, link.UserID)
-		assert.Equal(t, url.ID, link.URLID)
-		assert.Equal(t, false, link.Deleted)
-
-		listURLs, listErr := s.ListAllUrls(ctx)
-		require.NoError(t, listErr)
-		assert.Equal(t, 1, len(listURLs))
-	})
-
-	t.Run("existing URL", func(t *testing.T) {
-		defer cleanup(s)
-
-		user1 := random.RandomUser()
-		err = s.CreateUser(ctx, user1)
-		require.NoError(t, err)
-
-		url := random.RandomURL()
-		err = s.CreateURL(ctx, user1.ID, url)
-		require.NoError(t, err)
-
-		user2 := random.RandomUser()
-		err = s.CreateUser(ctx, user2)
-		require.NoError(t, err)
-
-		// Create the same URL by user2
-		err = s.CreateURL(ctx, user2.ID, url)
-		require.NoError(t, err)
-
-		persistedURL, err := s.GetURL(ctx, url.Slug)
-		require.NoError(t, err)
-		assert.Equal(t, url.ID, persistedURL.ID)
-		assert.Equal(t, url.Slug, persistedURL.Slug)
-		assert.Equal(t, url.Original, persistedURL.Original)
-		assert.Equal(t, false, persistedURL.Deleted)
-		assert.Equal(t, url.CreatedAt.Unix(), persistedURL.CreatedAt.Unix())
-
-		link1, err := s.geUserURLLink(ctx, user1.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, user1.ID, link1.UserID)
-		assert.Equal(t, url.ID, link1.URLID)
-		assert.Equal(t, false, link1.Deleted)
-
-		link2, err := s.geUserURLLink(ctx, user2.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, user2.ID, link2.UserID)
-		assert.Equal(t, url.ID, link2.URLID)
-		assert.Equal(t, false, link2.Deleted)
-
-		listURLs, err := s.ListAllUrls(ctx)
-		require.NoError(t, err)
-		assert.Equal(t, 1, len(listURLs))
-	})
-}
-
 func BenchmarkCreateURL(b *testing.B) {
 	ctx := context.Background()
 	s, err := newTestStore(ctx)
@@ -236,42 +152,6 @@ func BenchmarkCreateURL(b *testing.B) {
 	})
 }
 
-func TestBatchCreateURL(t *testing.T) {
-	ctx := context.Background()
-	s, err := newTestStore(ctx)
-	if err != nil {
-		if err == errMissingDSN {
-			t.Skip()
-		}
-		t.Fatal(err)
-	}
-	defer cleanup(s)
-
-	user := random.RandomUser()
-	err = s.CreateUser(ctx, user)
-	require.NoError(t, err)
-
-	urls := make([]models.URL, 3)
-	for i := 0; i < 3; i++ {
-		urls[i] = random.RandomURL()
-	}
-
-	err = s.BatchCreateURL(ctx, user.ID, urls)
-	require.NoError(t, err)
-
-	listURLs, err := s.ListAllUrls(ctx)
-	require.NoError(t, err)
-	assert.Equal(t, 3, len(listURLs))
-
-	for _, url := range urls {
-		link, err := s.geUserURLLink(ctx, user.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, user.ID, link.UserID)
-		assert.Equal(t, url.ID, link.URLID)
-		assert.Equal(t, false, link.Deleted)
-	}
-}
-
 func BenchmarkBatchCreateURL(b *testing.B) {
 	ctx := context.Background()
 	s, err := newTestStore(ctx)
@@ -298,128 +178,3 @@ func BenchmarkBatchCreateURL(b *testing.B) {
 		}
 	})
 }
-
-func TestListURLsByUserID(t *testing.T) {
-	ctx := context.Background()
-	s, err := newTestStore(ctx)
-	if err != nil {
-		if err == errMissingDSN {
-			t.Skip()
-		}
-		t.Fatal(err)
-	}
-	defer cleanup(s)
-
-	user1 := random.RandomUser()
-	err = s.CreateUser(ctx, user1)
-	require.NoError(t, err)
-
-	user2 := random.RandomUser()
-	err = s.CreateUser(ctx, user2)
-	require.NoError(t, err)
-
-	url1 := random.RandomURL()
-	err = s.CreateURL(ctx, user1.ID, url1)
-	require.NoError(t, err)
-
-	url2 := random.RandomURL()
-	err = s.CreateURL(ctx, user1.ID, url2)
-	require.NoError(t, err)
-
-	url3 := random.RandomURL()
-
-	err = s.CreateURL(ctx, user2.ID, url3)
-	require.NoError(t, err)
-
-	user1URLs, err := s.ListURLsByUserID(ctx, user1.ID)
-	require.NoError(t, err)
-	assert.Equal(t, 2, len(user1URLs))
-
-	user2URLs, err := s.ListURLsByUserID(ctx, user1.ID)
-	require.NoError(t, err)
-	assert.Equal(t, 2, len(user2URLs))
-}
-
-func TestSoftDeleteURL(t *testing.T) {
-	ctx := context.Background()
-	s, err := newTestStore(ctx)
-	if err != nil {
-		if err == errMissingDSN {
-			t.Skip()
-		}
-		t.Fatal(err)
-	}
-
-	t.Run("all links to URL are deleted", func(t *testing.T) {
-		defer cleanup(s)
-
-		user := random.RandomUser()
-		err = s.CreateUser(ctx, user)
-		require.NoError(t, err)
-
-		url := random.RandomURL()
-		err = s.CreateURL(ctx, user.ID, url)
-		require.NoError(t, err)
-
-		persistedURL, urlErr := s.GetURL(ctx, url.Slug)
-		require.NoError(t, urlErr)
-		assert.Equal(t, false, persistedURL.Deleted)
-
-		link, linkErr := s.geUserURLLink(ctx, user.ID, url.ID)
-		require.NoError(t, linkErr)
-		assert.Equal(t, false, link.Deleted)
-
-		deleteErr := s.SoftDeleteURL(ctx, user.ID, url.Slug)
-		require.NoError(t, deleteErr)
-
-		persistedURL, urlErr = s.GetURL(ctx, url.Slug)
-		require.NoError(t, urlErr)
-		assert.Equal(t, true, persistedURL.Deleted)
-
-		link, linkErr = s.geUserURLLink(ctx, user.ID, url.ID)
-		require.NoError(t, linkErr)
-		assert.Equal(t, true, link.Deleted)
-	})
-
-	t.Run("not all links to URL are deleted", func(t *testing.T) {
-		defer cleanup(s)
-
-		user1 := random.RandomUser()
-		err = s.CreateUser(ctx, user1)
-		require.NoError(t, err)
-
-		user2 := random.RandomUser()
-		err = s.CreateUser(ctx, user2)
-		require.NoError(t, err)
-
-		url := random.RandomURL()
-		err = s.CreateURL(ctx, user1.ID, url)
-		require.NoError(t, err)
-
-		err = s.CreateURL(ctx, user2.ID, url)
-		require.NoError(t, err)
-
-		link1, err := s.geUserURLLink(ctx, user1.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, false, link1.Deleted)
-
-		link2, err := s.geUserURLLink(ctx, user2.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, false, link2.Deleted)
-
-		err = s.SoftDeleteURL(ctx, user1.ID, url.Slug)
-		require.NoError(t, err)
-
-		link1, err = s.geUserURLLink(ctx, user1.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, true, link1.Deleted)
-
-		link2, err = s.geUserURLLink(ctx, user2.ID, url.ID)
-		require.NoError(t, err)
-		assert.Equal(t, false, link2.Deleted)
-
-		persistedURL, err := s.GetURL(ctx, url.Slug)
-		require.NoError(t, err)
-		assert.Equal(t, false, persistedURL.Deleted)
-	})
-}
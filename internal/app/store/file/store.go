@@ -1,49 +1,235 @@
 // Package filestore implements data storage in a file.
+//
+// The file is an append-only journal of JSON-encoded records, one per line.
+// Each record describes an operation: a user created, a URL created, a URL
+// linked to a user, a link deleted by a user or removed by a failed import, an expired URL removed,
+// a batch of clicks recorded, an API key created, used or revoked,
+// an authentication token revoked or expired revoked tokens removed,
+// a job of deleting user URLs created or updated or finished jobs removed.
+// On start the journal is replayed to restore the state in memory.
+//
+// To keep the journal from growing indefinitely, it is periodically compacted:
+// the current state is written as a single snapshot record to a temporary file,
+// which then atomically replaces the journal.
+//
+// Files written in the legacy format (a single JSON-encoded ServiceState)
+// are loaded as a snapshot and converted to the journal on the next compaction.
 package filestore
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+// SyncPolicy defines when the journal is flushed to disk with fsync.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes the journal after every write. It is the safest and the slowest policy.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes the journal periodically, so that up to Options.SyncInterval
+	// of writes can be lost on power failure.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+const (
+	// DefaultSyncInterval is used with SyncInterval policy if Options.SyncInterval is not set.
+	DefaultSyncInterval = time.Second
+	// DefaultCompactThreshold is used if Options.CompactThreshold is not set.
+	DefaultCompactThreshold = 10000
+)
+
+// Journal record operations.
+const (
+	opSnapshot    = "snapshot"
+	opUserCreated = "user_created"
+	opURLCreated  = "url_created"
+	opLinkCreated = "link_created"
+	opLinkDeleted = "link_deleted"
+	// opLinkRemoved removes the link entirely, unlike opLinkDeleted which marks it as deleted.
+	opLinkRemoved = "link_removed"
+	opURLRemoved  = "url_removed"
+	opURLBlocked  = "url_blocked"
+	// opClicksCreated is written per batch of clicks rather than per click
+	// to keep the journal compact.
+	opClicksCreated    = "clicks_created"
+	opAPIKeyCreated    = "api_key_created"
+	opAPIKeyUsed       = "api_key_used"
+	opAPIKeyRevoked    = "api_key_revoked"
+	opTokenRevoked     = "token_revoked"
+	opTokensExpired    = "tokens_expired"
+	opDeleteJobCreated = "delete_job_created"
+	// opDeleteJobUpdated carries the whole job, but only its state is applied.
+	opDeleteJobUpdated  = "delete_job_updated"
+	opDeleteJobsRemoved = "delete_jobs_removed"
 )
 
+// Options is used to configure Store.
+type Options struct {
+	// SyncPolicy defines when the journal is flushed to disk (default: SyncAlways).
+	SyncPolicy SyncPolicy
+	// SyncInterval is the flush period for SyncInterval policy (default: DefaultSyncInterval).
+	SyncInterval time.Duration
+	// CompactThreshold is the number of journal records written since the last snapshot
+	// after which the journal is compacted (default: DefaultCompactThreshold).
+	CompactThreshold int
+	// Log receives errors of compaction, which do not fail writes (default: no logging).
+	Log *zap.SugaredLogger
+}
+
 // Store is an implementation of store.Store interface which uses a file to save data on disk.
 //
 // Use New to create an instance of Store.
 type Store struct {
 	filepath string
-	urls     map[string]models.URL
-	users    map[string]models.User
+	opts     Options
+	journal  *os.File
+	// records is the number of journal records written since the last snapshot.
+	records int
+
+	urls map[string]models.URL
+	// slugs holds slugs of all URLs sorted, so that ScanURLs reads pages without sorting.
+	slugs []string
+	// canonical maps canonical forms of URLs to their slugs to find duplicates.
+	canonical map[string]string
+	// deletedURLs is the number of URLs marked as deleted, so that CountURLs does not scan urls.
+	deletedURLs int
+	users       map[string]models.User
+	// userURLs maps user ID to slugs of URLs created by the user, sorted with models.CompareURLs,
+	// so that pages of user URLs are read without sorting.
 	userURLs map[string][]string
-	mu       sync.Mutex
+	// urlUsers is a reverse index of userURLs: it maps slug to IDs of users who created it.
+	urlUsers map[string][]string
+	// deletedUserURLs holds slugs deleted by each user, like user_urls.is_deleted in the database.
+	deletedUserURLs map[string]map[string]struct{}
+	// clicks maps slug to clicks of the URL.
+	clicks map[string][]models.Click
+	// expiring maps slugs of URLs with limited lifetime to their expiration time.
+	expiring map[string]time.Time
+	// apiKeys maps key ID to API key, apiKeyHashes maps key hash to key ID.
+	apiKeys      map[string]models.APIKey
+	apiKeyHashes map[string]string
+	// revokedTokens maps IDs of revoked authentication tokens to their expiration time.
+	revokedTokens map[string]time.Time
+	// deleteJobs maps job ID to the job of deleting user URLs.
+	deleteJobs map[string]models.DeleteJob
+	mu         sync.Mutex
+	// syncDir is replaced in tests.
+	syncDir func(path string) error
+
+	stopSync chan struct{}
+	syncDone chan struct{}
 }
 
 // ServiceState is used to store service state in file.
 //
-// It is JSON-encoded and then persisted to file.
+// It is JSON-encoded and persisted to file as a snapshot record on compaction.
 type ServiceState struct {
 	URLs     map[string]models.URL  `json:"urls"`
 	Users    map[string]models.User `json:"users"`
 	UserURLs map[string][]string    `json:"user_urls"`
+	// DeletedUserURLs maps user ID to slugs deleted by this user.
+	DeletedUserURLs map[string][]string `json:"deleted_user_urls,omitempty"`
+	// Clicks maps slug to clicks of the URL.
+	Clicks map[string][]models.Click `json:"clicks,omitempty"`
+	// APIKeys maps key ID to API key.
+	APIKeys map[string]models.APIKey `json:"api_keys,omitempty"`
+	// RevokedTokens maps IDs of revoked authentication tokens to their expiration time.
+	RevokedTokens map[string]time.Time `json:"revoked_tokens,omitempty"`
+	// DeleteJobs maps job ID to the job of deleting user URLs.
+	DeleteJobs map[string]models.DeleteJob `json:"delete_jobs,omitempty"`
+}
+
+// journalRecord is a single line of the journal.
+type journalRecord struct {
+	Op      string         `json:"op"`
+	State   *ServiceState  `json:"state,omitempty"`
+	User    *models.User   `json:"user,omitempty"`
+	URL     *models.URL    `json:"url,omitempty"`
+	UserID  string         `json:"user_id,omitempty"`
+	Slug    string         `json:"slug,omitempty"`
+	Clicks  []models.Click `json:"clicks,omitempty"`
+	APIKey  *models.APIKey `json:"api_key,omitempty"`
+	KeyID   string         `json:"key_id,omitempty"`
+	TokenID string         `json:"token_id,omitempty"`
+	Time    *time.Time     `json:"time,omitempty"`
+
+	DeleteJob *models.DeleteJob `json:"delete_job,omitempty"`
 }
 
 // New creates a new file storage.
-func New(filepath string) (*Store, error) {
+//
+// It replays the journal from the file, creating the file if it does not exist.
+func New(filepath string, opts Options) (*Store, error) {
+	if opts.SyncPolicy == "" {
+		opts.SyncPolicy = SyncAlways
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = DefaultCompactThreshold
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop().Sugar()
+	}
+
+	switch opts.SyncPolicy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown sync policy: %s", opts.SyncPolicy)
+	}
+
 	s := &Store{
-		filepath: filepath,
-		urls:     make(map[string]models.URL),
-		users:    make(map[string]models.User),
-		userURLs: make(map[string][]string),
+		filepath:        filepath,
+		opts:            opts,
+		urls:            make(map[string]models.URL),
+		canonical:       make(map[string]string),
+		users:           make(map[string]models.User),
+		userURLs:        make(map[string][]string),
+		urlUsers:        make(map[string][]string),
+		deletedUserURLs: make(map[string]map[string]struct{}),
+		expiring:        make(map[string]time.Time),
+		clicks:          make(map[string][]models.Click),
+		apiKeys:         make(map[string]models.APIKey),
+		apiKeyHashes:    make(map[string]string),
+		revokedTokens:   make(map[string]time.Time),
+		deleteJobs:      make(map[string]models.DeleteJob),
+		syncDir:         syncDir,
 	}
 
 	if err := s.load(); err != nil {
-		return s, err
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(s.filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+
+	if opts.SyncPolicy == SyncInterval {
+		s.stopSync = make(chan struct{})
+		s.syncDone = make(chan struct{})
+		go s.syncPeriodically()
 	}
 
 	return s, nil
@@ -51,17 +237,23 @@ func New(filepath string) (*Store, error) {
 
 // CreateUser registers new user.
 func (s *Store) CreateUser(_ context.Context, user models.User) error {
-	if _, ok := s.users[user.ID]; !ok {
-		s.users[user.ID] = user
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ID]; ok {
+		return nil
 	}
 
-	return s.save()
+	return s.write(journalRecord{Op: opUserCreated, User: &user})
 }
 
 // GetUser fetches user by ID.
 //
 // It returns error if user is not found.
 func (s *Store) GetUser(_ context.Context, userID string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if user, ok := s.users[userID]; ok {
 		return user, nil
 	}
@@ -71,73 +263,577 @@ func (s *Store) GetUser(_ context.Context, userID string) (models.User, error) {
 
 // CreateURL adds a new URL to the storage.
 //
-// It also links the URL to the current user.
+// It also links the URL to the current user. It returns *store.SlugExistsError
+// if a different URL with the same slug already exists. If a URL with the same
+// canonical form already exists, it links that URL to the user instead and
+// returns *store.AlreadyExistsError.
 func (s *Store) CreateURL(_ context.Context, userID string, url models.URL) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.urls[url.Slug] = url
-	s.userURLs[userID] = append(s.userURLs[userID], url.Slug)
+	if err := s.checkCanonical(url); err != nil {
+		var alreadyExists *store.AlreadyExistsError
+		if errors.As(err, &alreadyExists) && !slices.Contains(s.urlUsers[alreadyExists.URL.Slug], userID) {
+			if err := s.write(journalRecord{Op: opLinkCreated, UserID: userID, Slug: alreadyExists.URL.Slug}); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	if err := s.checkSlug(url); err != nil {
+		return err
+	}
 
-	return s.save()
+	return s.write(
+		journalRecord{Op: opURLCreated, URL: &url},
+		journalRecord{Op: opLinkCreated, UserID: userID, Slug: url.Slug},
+	)
 }
 
 // BatchCreateURL adds a batch of URLs to the storage.
 //
-// It also links the created URLs to the current user.
+// It also links the created URLs to the current user. If any of the slugs
+// is already taken, it returns *store.SlugExistsError and adds nothing.
+// If any of the URLs has already been shortened, it returns *store.AlreadyExistsError
+// and adds nothing.
 func (s *Store) BatchCreateURL(_ context.Context, userID string, urls []models.URL) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	records := make([]journalRecord, 0, 2*len(urls))
 	for _, url := range urls {
-		s.urls[url.Slug] = url
-		s.userURLs[userID] = append(s.userURLs[userID], url.Slug)
+		if err := s.checkCanonical(url); err != nil {
+			return err
+		}
+		if err := s.checkSlug(url); err != nil {
+			return err
+		}
+		records = append(records,
+			journalRecord{Op: opURLCreated, URL: &url},
+			journalRecord{Op: opLinkCreated, UserID: userID, Slug: url.Slug},
+		)
+	}
+
+	return s.write(records...)
+}
+
+// BatchUpsertURLs adds URLs of a batch independently of each other and links them to the user.
+//
+// URLs which have already been shortened, including the previous URLs of the batch,
+// are linked to the user instead. URLs whose slug is taken by a different URL are
+// reported with *store.SlugExistsError. The records of the batch are written at once.
+func (s *Store) BatchUpsertURLs(_ context.Context, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, records, err := s.upsertRecords(userID, urls)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		if err := s.write(records...); err != nil {
+			return nil, err
+		}
 	}
 
-	return s.save()
+	return res, nil
+}
+
+// ImportURLs adds URLs read from the stream like BatchUpsertURLs.
+//
+// The stream is added in chunks of importChunkSize URLs, so that it is never held
+// in memory as a whole and the lock is not held while the stream is read.
+// If the stream fails, the URLs created and the links added by the import are removed.
+func (s *Store) ImportURLs(_ context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var (
+		res store.ImportResult
+		// undo are the records which revert the chunks written so far.
+		undo []journalRecord
+	)
+	flush := func(chunk []models.URL) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		results, records, err := s.upsertRecords(userID, chunk)
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			if err := s.write(records...); err != nil {
+				return err
+			}
+		}
+		for _, rec := range records {
+			switch rec.Op {
+			case opURLCreated:
+				undo = append(undo, journalRecord{Op: opURLRemoved, Slug: rec.URL.Slug})
+			case opLinkCreated:
+				undo = append(undo, journalRecord{Op: opLinkRemoved, UserID: userID, Slug: rec.Slug})
+			}
+		}
+		for _, result := range results {
+			res.Add(result)
+		}
+		return nil
+	}
+	rollback := func(err error) (store.ImportResult, error) {
+		if len(undo) == 0 {
+			return store.ImportResult{}, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return store.ImportResult{}, errors.Join(err, s.write(undo...))
+	}
+
+	chunk := make([]models.URL, 0, importChunkSize)
+	for url, err := range urls {
+		if err == nil {
+			chunk = append(chunk, url)
+			if len(chunk) < importChunkSize {
+				continue
+			}
+			err = flush(chunk)
+			chunk = chunk[:0]
+		}
+		if err != nil {
+			return rollback(err)
+		}
+	}
+	if len(chunk) > 0 {
+		if err := flush(chunk); err != nil {
+			return rollback(err)
+		}
+	}
+
+	return res, nil
 }
 
 // GetURL retrieves a URL by its slug from the storage.
 //
-// It returns error if URL is not found.
+// It returns store.ErrNotFound if URL is not found.
 func (s *Store) GetURL(_ context.Context, slug string) (models.URL, error) {
-	var url models.URL
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	url, ok := s.urls[slug]
 	if !ok {
-		return url, errors.New("url was not found")
+		return url, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
 	}
 
 	return url, nil
 }
 
-// ListURLsByUserID returns all URLs created by the specified user.
+// ListURLsByUserID returns all URLs created by the specified user
+// except for the ones deleted by the user.
 func (s *Store) ListURLsByUserID(_ context.Context, userID string) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.userURLList(userID), nil
+}
+
+// ListUserURLs returns a page of URLs created by the specified user which match the query.
+func (s *Store) ListUserURLs(_ context.Context, userID string, q models.URLQuery) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	slugs := s.userURLs[userID]
+	deleted := s.deletedUserURLs[userID]
+
+	return q.Page(len(slugs), func(i int) (models.URL, bool) {
+		_, isDeleted := deleted[slugs[i]]
+		return s.urls[slugs[i]], !isDeleted
+	}), nil
+}
+
+// ListAllUrls returns the full map of stored URLs.
+//
+// This function should not be used in production.
+func (s *Store) ListAllUrls(_ context.Context) (map[string]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]models.URL, len(s.urls))
+	for slug, url := range s.urls {
+		res[slug] = url
+	}
+
+	return res, nil
+}
+
+// CountURLs returns the number of stored URLs which are not deleted.
+func (s *Store) CountURLs(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.urls) - s.deletedURLs, nil
+}
+
+// CountUsers returns the number of registered users.
+func (s *Store) CountUsers(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users), nil
+}
+
+// SoftDeleteURL marks URLs as deleted.
+//
+// It unlinks the URL from the user. The URL itself is marked as deleted
+// only when it has been deleted by all users who created it.
+func (s *Store) SoftDeleteURL(_ context.Context, userID string, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[slug]; !ok {
+		return fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+
+	if !slices.Contains(s.urlUsers[slug], userID) {
+		return nil
+	}
+	if _, ok := s.deletedUserURLs[userID][slug]; ok {
+		return nil
+	}
+
+	return s.write(journalRecord{Op: opLinkDeleted, UserID: userID, Slug: slug})
+}
+
+// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+//
+// Slugs which do not exist or were not created by the user are skipped.
+func (s *Store) BatchSoftDeleteURLs(_ context.Context, userID string, slugs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]journalRecord, 0, len(slugs))
+	for _, slug := range slugs {
+		if !slices.Contains(s.urlUsers[slug], userID) {
+			continue
+		}
+		if _, ok := s.deletedUserURLs[userID][slug]; ok {
+			continue
+		}
+		records = append(records, journalRecord{Op: opLinkDeleted, UserID: userID, Slug: slug})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	return s.write(records...)
+}
+
+// CreateClicks adds a batch of clicks to the storage.
+//
+// Clicks of URLs which do not exist anymore are skipped.
+func (s *Store) CreateClicks(_ context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists := func(click models.Click) bool {
+		_, ok := s.urls[click.Slug]
+		return ok
+	}
+	if !slices.ContainsFunc(clicks, exists) {
+		return nil
+	}
+
+	return s.write(journalRecord{Op: opClicksCreated, Clicks: clicks})
+}
+
+// GetURLStats returns click statistics of the URL created by the specified user.
+//
+// It returns store.ErrNotFound if there is no such URL, it was not created
+// by the user or it was deleted by the user.
+func (s *Store) GetURLStats(_ context.Context, userID, slug string) (models.URLStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, deleted := s.deletedUserURLs[userID][slug]
+	if _, ok := s.urls[slug]; !ok || deleted || !slices.Contains(s.urlUsers[slug], userID) {
+		return models.URLStats{}, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+
+	return models.NewURLStats(slug, s.clicks[slug]), nil
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+//
+// The lock is held for a single call only, so large amounts of expired URLs
+// should be removed with several calls.
+func (s *Store) DeleteExpiredURLs(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slugs := s.expiredSlugs(before, limit)
 	if len(slugs) == 0 {
-		return []models.URL{}, nil
+		return 0, nil
 	}
-	res := make([]models.URL, 0)
+
+	records := make([]journalRecord, 0, len(slugs))
 	for _, slug := range slugs {
-		if url, ok := s.urls[slug]; ok {
-			res = append(res, url)
+		records = append(records, journalRecord{Op: opURLRemoved, Slug: slug})
+	}
+
+	if err := s.write(records...); err != nil {
+		return 0, err
+	}
+
+	return len(slugs), nil
+}
+
+// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+func (s *Store) ScanURLs(_ context.Context, afterSlug string, limit int) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, found := slices.BinarySearch(s.slugs, afterSlug)
+	if found {
+		i++
+	}
+	slugs := s.slugs[i:min(i+limit, len(s.slugs))]
+
+	res := make([]models.URL, 0, len(slugs))
+	for _, slug := range slugs {
+		res = append(res, s.urls[slug])
+	}
+
+	return res, nil
+}
+
+// BlockURLs marks URLs with the given slugs as blocked.
+//
+// Slugs which do not exist or are already blocked are skipped.
+func (s *Store) BlockURLs(_ context.Context, slugs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]journalRecord, 0, len(slugs))
+	for _, slug := range slugs {
+		if url, ok := s.urls[slug]; !ok || url.Blocked {
+			continue
+		}
+		records = append(records, journalRecord{Op: opURLBlocked, Slug: slug})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	return s.write(records...)
+}
+
+// CreateAPIKey adds a new API key of a user.
+func (s *Store) CreateAPIKey(_ context.Context, key models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(journalRecord{Op: opAPIKeyCreated, APIKey: &key})
+}
+
+// ListAPIKeysByUserID returns all API keys of the specified user ordered by creation time.
+func (s *Store) ListAPIKeysByUserID(_ context.Context, userID string) ([]models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]models.APIKey, 0)
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			res = append(res, key)
 		}
 	}
+	slices.SortFunc(res, func(a, b models.APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
 
 	return res, nil
 }
 
-// ListAllUrls returns the full map of stored URLs.
+// GetAPIKeyByHash fetches API key by the hash of the key.
 //
-// This function should not be used in production.
-func (s *Store) ListAllUrls(_ context.Context) (map[string]models.URL, error) {
-	return s.urls, nil
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) GetAPIKeyByHash(_ context.Context, hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.apiKeyHashes[hash]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key: %w", store.ErrNotFound)
+	}
+
+	return s.apiKeys[id], nil
 }
 
-// SoftDeleteURL marks URLs as deleted.
-func (s *Store) SoftDeleteURL(_ context.Context, userID string, slug string) error {
-	// TODO implement
-	return nil
+// RevokeAPIKey removes the API key of the specified user.
+//
+// It returns store.ErrNotFound if the user has no such key.
+func (s *Store) RevokeAPIKey(_ context.Context, userID, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.apiKeys[keyID]; !ok || key.UserID != userID {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	return s.write(journalRecord{Op: opAPIKeyRevoked, KeyID: keyID})
+}
+
+// TouchAPIKey records the time the API key was last used.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) TouchAPIKey(_ context.Context, keyID string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[keyID]; !ok {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	return s.write(journalRecord{Op: opAPIKeyUsed, KeyID: keyID, Time: &usedAt})
+}
+
+// RevokeToken adds the ID of an authentication token to the revocation list.
+//
+// It returns false if the token had already been revoked.
+func (s *Store) RevokeToken(_ context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedTokens[tokenID]; ok {
+		return false, nil
+	}
+	if err := s.write(journalRecord{Op: opTokenRevoked, TokenID: tokenID, Time: &expiresAt}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IsTokenRevoked reports whether the authentication token with the given ID has been revoked.
+func (s *Store) IsTokenRevoked(_ context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revokedTokens[tokenID]
+
+	return ok, nil
+}
+
+// DeleteExpiredRevokedTokens removes revocation list entries of tokens which expired before the given time.
+func (s *Store) DeleteExpiredRevokedTokens(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.expiredTokens(before)
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, s.write(journalRecord{Op: opTokensExpired, Time: &before})
+}
+
+// CreateDeleteJob adds a new job of deleting user URLs.
+func (s *Store) CreateDeleteJob(_ context.Context, job models.DeleteJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(journalRecord{Op: opDeleteJobCreated, DeleteJob: &job})
+}
+
+// GetDeleteJob fetches the job of the specified user by ID.
+//
+// It returns store.ErrNotFound if the user has no such job.
+func (s *Store) GetDeleteJob(_ context.Context, userID, jobID string) (models.DeleteJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.deleteJobs[jobID]
+	if !ok || job.UserID != userID {
+		return models.DeleteJob{}, fmt.Errorf("delete job %s: %w", jobID, store.ErrNotFound)
+	}
+	job.Slugs = slices.Clone(job.Slugs)
+
+	return job, nil
+}
+
+// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time,
+// the oldest first, and postpones their next attempt until leaseUntil.
+func (s *Store) ClaimDeleteJobs(_ context.Context, now, leaseUntil time.Time, limit int) ([]models.DeleteJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]models.DeleteJob, 0)
+	for _, job := range s.deleteJobs {
+		if job.Status == models.DeleteJobPending && !job.NextAttemptAt.After(now) {
+			job.Slugs = slices.Clone(job.Slugs)
+			res = append(res, job)
+		}
+	}
+	slices.SortFunc(res, func(a, b models.DeleteJob) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	if len(res) == 0 {
+		return res, nil
+	}
+
+	records := make([]journalRecord, 0, len(res))
+	for i := range res {
+		res[i].NextAttemptAt = leaseUntil
+		records = append(records, journalRecord{Op: opDeleteJobUpdated, DeleteJob: &res[i]})
+	}
+	if err := s.write(records...); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+//
+// It returns store.ErrNotFound if there is no such job.
+func (s *Store) UpdateDeleteJob(_ context.Context, job models.DeleteJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deleteJobs[job.ID]; !ok {
+		return fmt.Errorf("delete job %s: %w", job.ID, store.ErrNotFound)
+	}
+
+	return s.write(journalRecord{Op: opDeleteJobUpdated, DeleteJob: &job})
+}
+
+// CountPendingDeleteJobs returns the number of pending jobs.
+func (s *Store) CountPendingDeleteJobs(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, job := range s.deleteJobs {
+		if job.Status == models.DeleteJobPending {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) DeleteFinishedDeleteJobs(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, job := range s.deleteJobs {
+		if job.Finished() && job.UpdatedAt.Before(before) {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, s.write(journalRecord{Op: opDeleteJobsRemoved, Time: &before})
 }
 
 // Ping is a storage healthcheck.
@@ -146,22 +842,506 @@ func (s *Store) Ping(_ context.Context) error {
 	return nil
 }
 
-func (s *Store) save() error {
-	state := &ServiceState{
-		URLs:     s.urls,
-		Users:    s.users,
-		UserURLs: s.userURLs,
+// Close compacts the journal and closes the file.
+func (s *Store) Close() error {
+	if s.stopSync != nil {
+		close(s.stopSync)
+		<-s.syncDone
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.records > 0 {
+		err = s.compact()
+	}
+
+	return errors.Join(err, s.journal.Close())
+}
+
+// Compact writes the current state as a snapshot and atomically replaces the journal with it.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// write appends records to the journal and then applies them to the state.
+//
+// Records are written with a single write call, so that a batch is either
+// persisted as a whole or is cut at its tail, which is discarded on load.
+// Once the records are in the journal, the write has succeeded: a failed
+// compaction is only logged and retried after another CompactThreshold records.
+func (s *Store) write(records ...journalRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.journal.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if s.opts.SyncPolicy == SyncAlways {
+		if err := s.journal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	for _, rec := range records {
+		s.apply(rec)
+	}
+
+	s.records += len(records)
+	if s.records >= s.opts.CompactThreshold {
+		if err := s.compact(); err != nil {
+			s.opts.Log.Errorln("error compacting journal", "path", s.filepath, "err", err)
+			s.records = 0
+		}
+	}
+
+	return nil
+}
+
+// apply applies a journal record to the state.
+func (s *Store) apply(rec journalRecord) {
+	switch rec.Op {
+	case opSnapshot:
+		s.restore(rec.State)
+	case opUserCreated:
+		if _, ok := s.users[rec.User.ID]; !ok {
+			s.users[rec.User.ID] = *rec.User
+		}
+	case opURLCreated:
+		s.setURL(*rec.URL)
+	case opLinkCreated:
+		s.linkURLToUser(rec.Slug, rec.UserID)
+	case opLinkDeleted:
+		s.unlinkURLFromUser(rec.Slug, rec.UserID)
+	case opLinkRemoved:
+		s.removeLink(rec.Slug, rec.UserID)
+	case opURLRemoved:
+		s.removeURL(rec.Slug)
+	case opURLBlocked:
+		s.blockURL(rec.Slug)
+	case opClicksCreated:
+		for _, click := range rec.Clicks {
+			s.addClick(click)
+		}
+	case opAPIKeyCreated:
+		s.setAPIKey(*rec.APIKey)
+	case opAPIKeyUsed:
+		if key, ok := s.apiKeys[rec.KeyID]; ok {
+			key.LastUsedAt = rec.Time
+			s.apiKeys[rec.KeyID] = key
+		}
+	case opAPIKeyRevoked:
+		if key, ok := s.apiKeys[rec.KeyID]; ok {
+			delete(s.apiKeys, rec.KeyID)
+			delete(s.apiKeyHashes, key.Hash)
+		}
+	case opTokenRevoked:
+		s.revokedTokens[rec.TokenID] = *rec.Time
+	case opTokensExpired:
+		s.expiredTokens(*rec.Time)
+	case opDeleteJobCreated:
+		s.deleteJobs[rec.DeleteJob.ID] = *rec.DeleteJob
+	case opDeleteJobUpdated:
+		s.updateDeleteJob(*rec.DeleteJob)
+	case opDeleteJobsRemoved:
+		s.removeFinishedDeleteJobs(*rec.Time)
+	}
+}
+
+// upsertRecords returns the results of adding URLs independently of each other and
+// the journal records which add them, s.mu must be held.
+func (s *Store) upsertRecords(userID string, urls []models.URL) ([]store.UpsertResult, []journalRecord, error) {
+	// The state is changed only when the records are written, so the URLs
+	// added by the batch are tracked separately.
+	added := make(map[string]models.URL)
+	addedSlugs := make(map[string]struct{})
+	linked := make(map[string]struct{})
+
+	records := make([]journalRecord, 0, 2*len(urls))
+	link := func(slug string) {
+		if _, ok := linked[slug]; ok || slices.Contains(s.urlUsers[slug], userID) {
+			return
+		}
+		linked[slug] = struct{}{}
+		records = append(records, journalRecord{Op: opLinkCreated, UserID: userID, Slug: slug})
+	}
+
+	res := make([]store.UpsertResult, 0, len(urls))
+	for _, url := range urls {
+		existing, ok := added[url.DedupKey()]
+		if err := s.checkCanonical(url); err != nil {
+			var alreadyExists *store.AlreadyExistsError
+			if !errors.As(err, &alreadyExists) {
+				return nil, nil, err
+			}
+			existing, ok = alreadyExists.URL, true
+		}
+		if ok {
+			link(existing.Slug)
+			res = append(res, store.UpsertResult{URL: existing})
+			continue
+		}
+
+		err := s.checkSlug(url)
+		if _, ok := addedSlugs[url.Slug]; ok {
+			err = &store.SlugExistsError{Slug: url.Slug}
+		}
+		if err != nil {
+			res = append(res, store.UpsertResult{URL: url, Err: err})
+			continue
+		}
+
+		added[url.DedupKey()] = url
+		addedSlugs[url.Slug] = struct{}{}
+		records = append(records, journalRecord{Op: opURLCreated, URL: &url})
+		link(url.Slug)
+		res = append(res, store.UpsertResult{URL: url, Created: true})
+	}
+
+	return res, records, nil
+}
+
+// importChunkSize is the number of URLs added at once by ImportURLs.
+const importChunkSize = 1000
+
+// checkSlug returns *store.SlugExistsError if the slug of url is taken.
+//
+// Slugs of dead URLs stay taken too, so that a new URL does not inherit
+// the owners and the clicks of a deleted one.
+func (s *Store) checkSlug(url models.URL) error {
+	if _, ok := s.urls[url.Slug]; ok {
+		return &store.SlugExistsError{Slug: url.Slug}
+	}
+
+	return nil
+}
+
+// checkCanonical returns *store.AlreadyExistsError if a live URL with the same canonical form exists.
+func (s *Store) checkCanonical(url models.URL) error {
+	slug, ok := s.liveCanonical(url.DedupKey())
+	if !ok {
+		return nil
+	}
+
+	return &store.AlreadyExistsError{
+		Err: fmt.Errorf("url %s has already been shortened", url.Original),
+		URL: s.urls[slug],
+	}
+}
+
+// liveCanonical returns the slug of the URL with the canonical form unless the URL is dead.
+func (s *Store) liveCanonical(key string) (string, bool) {
+	slug, ok := s.canonical[key]
+	if !ok || s.urls[slug].Dead(time.Now()) {
+		return "", false
+	}
+
+	return slug, true
+}
+
+// userURLList returns URLs created by the user which the user has not deleted.
+func (s *Store) userURLList(userID string) []models.URL {
+	deleted := s.deletedUserURLs[userID]
+	res := make([]models.URL, 0, len(s.userURLs[userID]))
+	for _, slug := range s.userURLs[userID] {
+		if _, ok := deleted[slug]; ok {
+			continue
+		}
+		if url, ok := s.urls[slug]; ok {
+			res = append(res, url)
+		}
+	}
+
+	return res
+}
+
+func (s *Store) linkURLToUser(slug, userID string) {
+	if slices.Contains(s.urlUsers[slug], userID) {
+		return
+	}
+
+	// The URL is set before it is linked, so its position is known.
+	slugs := s.userURLs[userID]
+	i, _ := slices.BinarySearchFunc(slugs, s.urls[slug], func(v string, url models.URL) int {
+		return models.CompareURLs(s.urls[v], url)
+	})
+	s.userURLs[userID] = slices.Insert(slugs, i, slug)
+	s.urlUsers[slug] = append(s.urlUsers[slug], userID)
+}
+
+func (s *Store) unlinkURLFromUser(slug, userID string) {
+	if _, ok := s.deletedUserURLs[userID]; !ok {
+		s.deletedUserURLs[userID] = make(map[string]struct{})
+	}
+	s.deletedUserURLs[userID][slug] = struct{}{}
+
+	for _, ownerID := range s.urlUsers[slug] {
+		if _, ok := s.deletedUserURLs[ownerID][slug]; !ok {
+			return
+		}
+	}
+
+	if url, ok := s.urls[slug]; ok && !url.Deleted {
+		url.Deleted = true
+		s.urls[slug] = url
+		s.deletedURLs++
+	}
+}
+
+func (s *Store) setURL(url models.URL) {
+	if existing, ok := s.urls[url.Slug]; ok && existing.Deleted {
+		s.deletedURLs--
+	}
+	if i, found := slices.BinarySearch(s.slugs, url.Slug); !found {
+		s.slugs = slices.Insert(s.slugs, i, url.Slug)
+	}
+	if url.Deleted {
+		s.deletedURLs++
+	}
+	s.urls[url.Slug] = url
+	// A dead URL keeps its canonical form until it is replaced by a new URL,
+	// it must not take the form back from that URL.
+	if slug, ok := s.canonical[url.DedupKey()]; !ok || slug == url.Slug || s.urls[slug].Dead(time.Now()) {
+		s.canonical[url.DedupKey()] = url.Slug
+	}
+	if url.ExpiresAt != nil {
+		s.expiring[url.Slug] = *url.ExpiresAt
+	} else {
+		delete(s.expiring, url.Slug)
+	}
+}
+
+// blockURL marks the URL as blocked if it exists.
+func (s *Store) blockURL(slug string) {
+	if url, ok := s.urls[slug]; ok {
+		url.Blocked = true
+		s.urls[slug] = url
+	}
+}
+
+// expiredTokens removes revoked tokens which expired before the given time
+// and returns the number of removed tokens.
+func (s *Store) expiredTokens(before time.Time) int {
+	var n int
+	for tokenID, expiresAt := range s.revokedTokens {
+		if expiresAt.Before(before) {
+			delete(s.revokedTokens, tokenID)
+			n++
+		}
+	}
+
+	return n
+}
+
+// updateDeleteJob applies the state of the job to the existing one.
+func (s *Store) updateDeleteJob(job models.DeleteJob) {
+	existing, ok := s.deleteJobs[job.ID]
+	if !ok {
+		return
+	}
+
+	existing.Status = job.Status
+	existing.Attempts = job.Attempts
+	existing.LastError = job.LastError
+	existing.UpdatedAt = job.UpdatedAt
+	existing.NextAttemptAt = job.NextAttemptAt
+	s.deleteJobs[job.ID] = existing
+}
+
+// removeFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) removeFinishedDeleteJobs(before time.Time) {
+	for id, job := range s.deleteJobs {
+		if job.Finished() && job.UpdatedAt.Before(before) {
+			delete(s.deleteJobs, id)
+		}
+	}
+}
+
+func (s *Store) setAPIKey(key models.APIKey) {
+	s.apiKeys[key.ID] = key
+	s.apiKeyHashes[key.Hash] = key.ID
+}
+
+// expiredSlugs returns up to limit slugs of URLs which expired before the given time.
+func (s *Store) expiredSlugs(before time.Time, limit int) []string {
+	var slugs []string
+	for slug, expiresAt := range s.expiring {
+		if len(slugs) >= limit {
+			break
+		}
+		if expiresAt.Before(before) {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	return slugs
+}
+
+// addClick adds the click if its URL exists.
+func (s *Store) addClick(click models.Click) {
+	if _, ok := s.urls[click.Slug]; ok {
+		s.clicks[click.Slug] = append(s.clicks[click.Slug], click)
+	}
+}
+
+// removeLink removes the link of the URL to the user, unlike unlinkURLFromUser
+// which marks it as deleted.
+func (s *Store) removeLink(slug, userID string) {
+	s.userURLs[userID] = slices.DeleteFunc(s.userURLs[userID], func(v string) bool { return v == slug })
+	if len(s.userURLs[userID]) == 0 {
+		delete(s.userURLs, userID)
+	}
+	s.urlUsers[slug] = slices.DeleteFunc(s.urlUsers[slug], func(v string) bool { return v == userID })
+	if len(s.urlUsers[slug]) == 0 {
+		delete(s.urlUsers, slug)
+	}
+	delete(s.deletedUserURLs[userID], slug)
+}
+
+// removeURL removes the URL and all its links to users.
+func (s *Store) removeURL(slug string) {
+	for _, userID := range s.urlUsers[slug] {
+		s.userURLs[userID] = slices.DeleteFunc(s.userURLs[userID], func(v string) bool { return v == slug })
+		if len(s.userURLs[userID]) == 0 {
+			delete(s.userURLs, userID)
+		}
+		delete(s.deletedUserURLs[userID], slug)
+	}
+
+	if url, ok := s.urls[slug]; ok {
+		if s.canonical[url.DedupKey()] == slug {
+			delete(s.canonical, url.DedupKey())
+		}
+		if url.Deleted {
+			s.deletedURLs--
+		}
+		if i, found := slices.BinarySearch(s.slugs, slug); found {
+			s.slugs = slices.Delete(s.slugs, i, i+1)
+		}
+	}
+
+	delete(s.urlUsers, slug)
+	delete(s.urls, slug)
+	delete(s.expiring, slug)
+	delete(s.clicks, slug)
+}
+
+// restore replaces the state with the snapshot.
+func (s *Store) restore(state *ServiceState) {
+	s.urls = make(map[string]models.URL, len(state.URLs))
+	// Slugs are sorted at once, so that setURL does not insert them one by one.
+	s.slugs = slices.Sorted(maps.Keys(state.URLs))
+	s.canonical = make(map[string]string, len(state.URLs))
+	s.deletedURLs = 0
+	s.users = make(map[string]models.User, len(state.Users))
+	s.userURLs = make(map[string][]string, len(state.UserURLs))
+	s.urlUsers = make(map[string][]string)
+	s.deletedUserURLs = make(map[string]map[string]struct{}, len(state.DeletedUserURLs))
+	s.expiring = make(map[string]time.Time)
+	s.clicks = make(map[string][]models.Click, len(state.Clicks))
+	s.apiKeys = make(map[string]models.APIKey, len(state.APIKeys))
+	s.apiKeyHashes = make(map[string]string, len(state.APIKeys))
+	s.revokedTokens = make(map[string]time.Time, len(state.RevokedTokens))
+	s.deleteJobs = make(map[string]models.DeleteJob, len(state.DeleteJobs))
+
+	for _, url := range state.URLs {
+		s.setURL(url)
+	}
+	for userID, user := range state.Users {
+		s.users[userID] = user
+	}
+	for userID, slugs := range state.UserURLs {
+		for _, slug := range slugs {
+			s.linkURLToUser(slug, userID)
+		}
+	}
+	for slug, clicks := range state.Clicks {
+		s.clicks[slug] = clicks
+	}
+	for _, key := range state.APIKeys {
+		s.setAPIKey(key)
+	}
+	for tokenID, expiresAt := range state.RevokedTokens {
+		s.revokedTokens[tokenID] = expiresAt
+	}
+	for id, job := range state.DeleteJobs {
+		s.deleteJobs[id] = job
+	}
+	for userID, slugs := range state.DeletedUserURLs {
+		s.deletedUserURLs[userID] = make(map[string]struct{}, len(slugs))
+		for _, slug := range slugs {
+			s.deletedUserURLs[userID][slug] = struct{}{}
+		}
+	}
+}
+
+// snapshot returns the current state.
+func (s *Store) snapshot() *ServiceState {
+	deletedUserURLs := make(map[string][]string, len(s.deletedUserURLs))
+	for userID, slugs := range s.deletedUserURLs {
+		for slug := range slugs {
+			deletedUserURLs[userID] = append(deletedUserURLs[userID], slug)
+		}
+	}
+
+	return &ServiceState{
+		URLs:            s.urls,
+		Users:           s.users,
+		UserURLs:        s.userURLs,
+		DeletedUserURLs: deletedUserURLs,
+		Clicks:          s.clicks,
+		APIKeys:         s.apiKeys,
+		RevokedTokens:   s.revokedTokens,
+		DeleteJobs:      s.deleteJobs,
 	}
+}
 
-	file, err := os.OpenFile(s.filepath, os.O_WRONLY|os.O_CREATE, 0666)
+// compact writes the current state as a snapshot to a temporary file
+// and atomically renames it over the journal.
+func (s *Store) compact() error {
+	tmpPath := s.filepath + ".tmp"
+	// The snapshot file becomes the journal, so that it does not have to be reopened after the rename.
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	return json.NewEncoder(file).Encode(&state)
+	err = json.NewEncoder(tmp).Encode(journalRecord{Op: opSnapshot, State: s.snapshot()})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.filepath)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// The old journal is unlinked now, every following record must go to the snapshot file.
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal = tmp
+	s.records = 0
+
+	return s.syncDir(filepath.Dir(s.filepath))
 }
 
+// load replays the journal.
+//
+// If the last record is incomplete, e.g. because of a crash in the middle of a write,
+// it is discarded and the file is truncated to the last complete record.
 func (s *Store) load() error {
 	file, err := os.OpenFile(s.filepath, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
@@ -170,18 +1350,124 @@ func (s *Store) load() error {
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
-	var state *ServiceState
+	var offset int64
 
-	if err := decoder.Decode(&state); err != nil {
-		if err == io.EOF {
-			return nil
+	for {
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			break
 		}
-		return err
+
+		rec, decodeErr := decodeRecord(raw)
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		s.apply(rec)
+		if rec.Op != opSnapshot {
+			s.records++
+		}
+		offset = decoder.InputOffset()
 	}
 
-	s.urls = state.URLs
-	s.users = state.Users
-	s.userURLs = state.UserURLs
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return os.Truncate(s.filepath, offset)
+	}
 
-	return nil
+	return err
+}
+
+func (s *Store) syncPeriodically() {
+	defer close(s.syncDone)
+
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.journal.Sync() //nolint:errcheck
+			s.mu.Unlock()
+		case <-s.stopSync:
+			return
+		}
+	}
+}
+
+// decodeRecord decodes a journal record.
+//
+// A JSON object without op is treated as a snapshot in the legacy format.
+func decodeRecord(raw json.RawMessage) (journalRecord, error) {
+	var rec journalRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return rec, err
+	}
+
+	switch rec.Op {
+	case "":
+		var state ServiceState
+		if err := json.Unmarshal(raw, &state); err != nil {
+			return rec, err
+		}
+		return journalRecord{Op: opSnapshot, State: &state}, nil
+	case opSnapshot:
+		if rec.State == nil {
+			return rec, errors.New("snapshot record without state")
+		}
+	case opUserCreated:
+		if rec.User == nil {
+			return rec, errors.New("user record without user")
+		}
+	case opURLCreated:
+		if rec.URL == nil {
+			return rec, errors.New("url record without url")
+		}
+	case opClicksCreated:
+		if len(rec.Clicks) == 0 {
+			return rec, errors.New("clicks record without clicks")
+		}
+	case opAPIKeyCreated:
+		if rec.APIKey == nil {
+			return rec, errors.New("api key record without api key")
+		}
+	case opAPIKeyUsed:
+		if rec.Time == nil {
+			return rec, errors.New("api key usage record without time")
+		}
+	case opTokenRevoked:
+		if rec.TokenID == "" || rec.Time == nil {
+			return rec, errors.New("token revocation record without token id or expiration time")
+		}
+	case opTokensExpired:
+		if rec.Time == nil {
+			return rec, errors.New("expired tokens record without time")
+		}
+	case opDeleteJobCreated, opDeleteJobUpdated:
+		if rec.DeleteJob == nil {
+			return rec, errors.New("delete job record without job")
+		}
+	case opDeleteJobsRemoved:
+		if rec.Time == nil {
+			return rec, errors.New("removed delete jobs record without time")
+		}
+	case opLinkCreated, opLinkDeleted, opLinkRemoved, opURLRemoved, opURLBlocked, opAPIKeyRevoked:
+	default:
+		return rec, fmt.Errorf("unknown journal record: %s", rec.Op)
+	}
+
+	return rec, nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
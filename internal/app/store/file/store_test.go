@@ -1,40 +1,58 @@
 package filestore
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"iter"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/storetest"
 	"github.com/madatsci/urlshortener/internal/random"
 )
 
-func TestCreateUser(t *testing.T) {
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := New(t.TempDir()+"/storage.json", Options{})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s
+	})
+}
+
+func BenchmarkCreateUser(b *testing.B) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
-	require.NoError(t, err)
+	s, err := New(filepath, Options{})
+	require.NoError(b, err)
 	defer func() {
-		err = os.Remove(filepath)
-		require.NoError(t, err)
+		err := os.Remove(filepath)
+		require.NoError(b, err)
 	}()
 
 	ctx := context.Background()
 
-	user := random.RandomUser()
-	err = s.CreateUser(ctx, user)
-	require.NoError(t, err)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		user := random.RandomUser()
+		b.StartTimer()
 
-	res, err := s.GetUser(ctx, user.ID)
-	require.NoError(t, err)
-	assert.Equal(t, user.ID, res.ID)
-	assert.Equal(t, user.CreatedAt, res.CreatedAt)
+		s.CreateUser(ctx, user)
+	}
 }
 
-func BenchmarkCreateUser(b *testing.B) {
+func BenchmarkCreateURL(b *testing.B) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
+	s, err := New(filepath, Options{})
 	require.NoError(b, err)
 	defer func() {
 		err := os.Remove(filepath)
@@ -46,15 +64,16 @@ func BenchmarkCreateUser(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		user := random.RandomUser()
+		url := random.RandomURL()
 		b.StartTimer()
 
-		s.CreateUser(ctx, user)
+		s.CreateURL(ctx, user.ID, url)
 	}
 }
 
-func TestCreateURL(t *testing.T) {
+func TestCreateURLCanonical(t *testing.T) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
+	s, err := New(filepath, Options{})
 	require.NoError(t, err)
 	defer func() {
 		err = os.Remove(filepath)
@@ -63,35 +82,139 @@ func TestCreateURL(t *testing.T) {
 
 	ctx := context.Background()
 
-	all, err := s.ListAllUrls(ctx)
+	user1 := random.RandomUser()
+	user2 := random.RandomUser()
+	url := random.RandomURL()
+	url.Original = "HTTP://Example.com"
+	url.Canonical = "http://example.com/"
+	err = s.CreateURL(ctx, user1.ID, url)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// The canonical form is restored from the journal.
+	s, err = New(filepath, Options{})
+	require.NoError(t, err)
+
+	duplicate := random.RandomURL()
+	duplicate.Original = "http://example.com/"
+	duplicate.Canonical = url.Canonical
+	err = s.CreateURL(ctx, user2.ID, duplicate)
+	var alreadyExists *store.AlreadyExistsError
+	require.ErrorAs(t, err, &alreadyExists)
+	assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+
+	urls := random.RandomURLs(2)
+	urls[1].Canonical = url.Canonical
+	err = s.BatchCreateURL(ctx, user1.ID, urls)
+	require.ErrorAs(t, err, &alreadyExists)
+	_, err = s.GetURL(ctx, urls[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	require.NoError(t, s.Close())
+
+	// The link of the existing URL to the user is persisted.
+	s, err = New(filepath, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	user2URLs, err := s.ListURLsByUserID(ctx, user2.ID)
+	require.NoError(t, err)
+	require.Len(t, user2URLs, 1)
+	assert.Equal(t, url.Slug, user2URLs[0].Slug)
+	assert.Equal(t, url.Canonical, user2URLs[0].Canonical)
+}
+
+func TestCreateURLDead(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
 	require.NoError(t, err)
-	require.Equal(t, 0, len(all))
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
 
+	ctx := context.Background()
 	user := random.RandomUser()
-	urls := random.RandomURLs(3)
-	for _, u := range urls {
-		err = s.CreateURL(ctx, user.ID, u)
+
+	past := time.Now().Add(-time.Minute)
+	expired := random.RandomURL()
+	expired.ExpiresAt = &past
+	deleted := random.RandomURL()
+	blocked := random.RandomURL()
+	for _, url := range []models.URL{expired, deleted, blocked} {
+		err = s.CreateURL(ctx, user.ID, url)
 		require.NoError(t, err)
 	}
+	err = s.SoftDeleteURL(ctx, user.ID, deleted.Slug)
+	require.NoError(t, err)
+	err = s.BlockURLs(ctx, []string{blocked.Slug})
+	require.NoError(t, err)
+
+	// Dead URLs are shortened again instead of being returned as duplicates.
+	for _, dead := range []models.URL{expired, deleted, blocked} {
+		// The slug of a dead URL is not taken over, even by the same URL.
+		again := random.RandomURL()
+		again.Slug = dead.Slug
+		again.Original = dead.Original
+		var slugExists *store.SlugExistsError
+		err = s.CreateURL(ctx, user.ID, again)
+		require.ErrorAs(t, err, &slugExists)
+		err = s.BatchCreateURL(ctx, user.ID, []models.URL{again})
+		require.ErrorAs(t, err, &slugExists)
+		upsertRes, err := s.BatchUpsertURLs(ctx, user.ID, []models.URL{again})
+		require.NoError(t, err)
+		require.Len(t, upsertRes, 1)
+		require.ErrorAs(t, upsertRes[0].Err, &slugExists)
 
-	for _, u := range urls {
-		res, getErr := s.GetURL(ctx, u.Slug)
-		require.NoError(t, getErr)
-		assert.Equal(t, u.Original, res.Original)
-		assert.Equal(t, u.Slug, res.Slug)
-		assert.Equal(t, u.ID, res.ID)
-		assert.Equal(t, u.CorrelationID, res.CorrelationID)
-		assert.Equal(t, u.CreatedAt, res.CreatedAt)
+		url := random.RandomURL()
+		url.Original = dead.Original
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err)
+
+		duplicate := random.RandomURL()
+		duplicate.Original = dead.Original
+		err = s.CreateURL(ctx, user.ID, duplicate)
+		var alreadyExists *store.AlreadyExistsError
+		require.ErrorAs(t, err, &alreadyExists)
+		assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+
+		persisted, err := s.GetURL(ctx, dead.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, dead.ID, persisted.ID)
 	}
 
-	all, err = s.ListAllUrls(ctx)
+	// So are they in batches.
+	expiredAgain := random.RandomURL()
+	expiredAgain.ExpiresAt = &past
+	err = s.CreateURL(ctx, user.ID, expiredAgain)
+	require.NoError(t, err)
+
+	upserted := random.RandomURL()
+	upserted.Original = expiredAgain.Original
+	res, err := s.BatchUpsertURLs(ctx, user.ID, []models.URL{upserted})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.True(t, res[0].Created)
+	assert.Equal(t, upserted.Slug, res[0].URL.Slug)
+	require.NoError(t, s.Close())
+
+	// The live URLs keep their canonical forms when the journal is replayed.
+	s, err = New(filepath, Options{})
 	require.NoError(t, err)
-	require.Equal(t, 3, len(all))
+	defer s.Close()
+
+	for _, dead := range []models.URL{expired, deleted, blocked, expiredAgain} {
+		duplicate := random.RandomURL()
+		duplicate.Original = dead.Original
+		err = s.CreateURL(ctx, user.ID, duplicate)
+		var alreadyExists *store.AlreadyExistsError
+		require.ErrorAs(t, err, &alreadyExists)
+		assert.NotEqual(t, dead.Slug, alreadyExists.URL.Slug)
+	}
 }
 
-func BenchmarkCreateURL(b *testing.B) {
+func BenchmarkBatchCreateURL(b *testing.B) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
+	s, err := New(filepath, Options{})
 	require.NoError(b, err)
 	defer func() {
 		err := os.Remove(filepath)
@@ -103,16 +226,32 @@ func BenchmarkCreateURL(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		user := random.RandomUser()
-		url := random.RandomURL()
+		urls := random.RandomURLs(10)
 		b.StartTimer()
 
-		s.CreateURL(ctx, user.ID, url)
+		s.BatchCreateURL(ctx, user.ID, urls)
 	}
 }
 
-func TestBatchCreateURL(t *testing.T) {
+func TestLoadFromFile(t *testing.T) {
+	filepath := "./fixtures/test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	resURLs1, err := s.ListURLsByUserID(ctx, "59100585-a808-4fe2-8dd3-9aaf2b47984f")
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(resURLs1))
+
+	resURLs2, err := s.ListURLsByUserID(ctx, "706be65e-b545-4d5f-bb09-1dd2fd285e44")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(resURLs2))
+}
+
+func TestListUserURLs(t *testing.T) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
+	s, err := New(filepath, Options{})
 	require.NoError(t, err)
 	defer func() {
 		err = os.Remove(filepath)
@@ -121,50 +260,364 @@ func TestBatchCreateURL(t *testing.T) {
 
 	ctx := context.Background()
 
-	urls := random.RandomURLs(3)
 	user := random.RandomUser()
-	err = s.BatchCreateURL(ctx, user.ID, urls)
-	require.NoError(t, err)
+	require.NoError(t, s.CreateUser(ctx, user))
+	other := random.RandomUser()
+	require.NoError(t, s.CreateUser(ctx, other))
 
-	for _, u := range urls {
-		res, getErr := s.GetURL(ctx, u.Slug)
-		require.NoError(t, getErr)
-		assert.Equal(t, u.Original, res.Original)
-		assert.Equal(t, u.Slug, res.Slug)
-		assert.Equal(t, u.CorrelationID, res.CorrelationID)
-		assert.Equal(t, u.ID, res.ID)
-		assert.Equal(t, u.CreatedAt, res.CreatedAt)
+	start := time.Now().UTC().Truncate(time.Second)
+	urls := random.RandomURLs(5)
+	for i := range urls {
+		urls[i].CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		if i%2 == 0 {
+			urls[i].Original = fmt.Sprintf("https://Example.com/%d", i)
+		}
 	}
+	// URLs are added out of the order of their creation time.
+	require.NoError(t, s.BatchCreateURL(ctx, user.ID, []models.URL{urls[3], urls[0], urls[4]}))
+	require.NoError(t, s.CreateURL(ctx, user.ID, urls[2]))
+	require.NoError(t, s.CreateURL(ctx, user.ID, urls[1]))
+	require.NoError(t, s.BatchCreateURL(ctx, other.ID, random.RandomURLs(2)))
+
+	slugs := func(urls []models.URL) []string {
+		res := make([]string, 0, len(urls))
+		for _, url := range urls {
+			res = append(res, url.Slug)
+		}
+		return res
+	}
+
+	page, err := s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[:2]), slugs(page))
+
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, After: models.CursorOf(page[1])})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[2:4]), slugs(page))
 
-	all, err := s.ListAllUrls(ctx)
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, Desc: true, After: models.CursorOf(urls[2])})
 	require.NoError(t, err)
-	require.Equal(t, 3, len(all))
+	assert.Equal(t, []string{urls[1].Slug, urls[0].Slug}, slugs(page))
+
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Search: "example.COM"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{urls[0].Slug, urls[2].Slug, urls[4].Slug}, slugs(page))
+
+	from, to := urls[1].CreatedAt, urls[3].CreatedAt
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{CreatedFrom: &from, CreatedTo: &to})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[1:3]), slugs(page))
+
+	// URLs deleted by the user are skipped.
+	require.NoError(t, s.SoftDeleteURL(ctx, user.ID, urls[4].Slug))
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, Desc: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{urls[3].Slug, urls[2].Slug}, slugs(page))
+
+	// The order is restored from the journal.
+	require.NoError(t, s.Close())
+	s, err = New(filepath, Options{})
+	require.NoError(t, err)
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[:4]), slugs(page))
 }
 
-func BenchmarkBatchCreateURL(b *testing.B) {
+func TestSoftDeleteURL(t *testing.T) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
-	require.NoError(b, err)
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
 	defer func() {
-		err := os.Remove(filepath)
-		require.NoError(b, err)
+		err = os.Remove(filepath)
+		require.NoError(t, err)
 	}()
 
 	ctx := context.Background()
 
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
+	user1 := random.RandomUser()
+	user2 := random.RandomUser()
+	url := random.RandomURL()
+	err = s.CreateURL(ctx, user1.ID, url)
+	require.NoError(t, err)
+	err = s.CreateURL(ctx, user2.ID, url)
+	var alreadyExists *store.AlreadyExistsError
+	require.ErrorAs(t, err, &alreadyExists)
+
+	err = s.SoftDeleteURL(ctx, user1.ID, url.Slug)
+	require.NoError(t, err)
+
+	persistedURL, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, false, persistedURL.Deleted)
+
+	user1URLs, err := s.ListURLsByUserID(ctx, user1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(user1URLs))
+
+	err = s.SoftDeleteURL(ctx, user2.ID, url.Slug)
+	require.NoError(t, err)
+
+	persistedURL, err = s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, true, persistedURL.Deleted)
+
+	// Deleted flags must survive reloading the state from file.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+
+	persistedURL, err = loaded.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, true, persistedURL.Deleted)
+
+	user2URLs, err := loaded.ListURLsByUserID(ctx, user2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(user2URLs))
+}
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("replay", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
 		user := random.RandomUser()
-		urls := random.RandomURLs(10)
-		b.StartTimer()
+		err = s.CreateUser(ctx, user)
+		require.NoError(t, err)
 
-		s.BatchCreateURL(ctx, user.ID, urls)
-	}
+		urls := random.RandomURLs(3)
+		err = s.BatchCreateURL(ctx, user.ID, urls)
+		require.NoError(t, err)
+
+		err = s.SoftDeleteURL(ctx, user.ID, urls[0].Slug)
+		require.NoError(t, err)
+
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+
+		_, err = loaded.GetUser(ctx, user.ID)
+		require.NoError(t, err)
+
+		userURLs, err := loaded.ListURLsByUserID(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(userURLs))
+
+		deletedURL, err := loaded.GetURL(ctx, urls[0].Slug)
+		require.NoError(t, err)
+		assert.Equal(t, true, deletedURL.Deleted)
+	})
+
+	t.Run("delete jobs survive restart", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
+		now := time.Now().UTC()
+		pending := models.DeleteJob{ID: uuid.NewString(), UserID: "user", Slugs: []string{"a"}, Status: models.DeleteJobPending, NextAttemptAt: now}
+		done := models.DeleteJob{ID: uuid.NewString(), UserID: "user", Slugs: []string{"b"}, Status: models.DeleteJobPending, NextAttemptAt: now}
+		require.NoError(t, s.CreateDeleteJob(ctx, pending))
+		require.NoError(t, s.CreateDeleteJob(ctx, done))
+		done.Status = models.DeleteJobDone
+		require.NoError(t, s.UpdateDeleteJob(ctx, done))
+
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+
+		jobs, err := loaded.ClaimDeleteJobs(ctx, now, now.Add(time.Minute), 10)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, pending.ID, jobs[0].ID)
+		assert.Equal(t, []string{"a"}, jobs[0].Slugs)
+
+		// Jobs are kept in the snapshot too.
+		require.NoError(t, loaded.Compact())
+		loaded, err = New(filepath, Options{})
+		require.NoError(t, err)
+
+		job, err := loaded.GetDeleteJob(ctx, "user", done.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DeleteJobDone, job.Status)
+	})
+
+	t.Run("compaction", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{CompactThreshold: 4})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
+		user := random.RandomUser()
+		urls := random.RandomURLs(3)
+		for _, u := range urls {
+			err = s.CreateURL(ctx, user.ID, u)
+			require.NoError(t, err)
+		}
+
+		// 6 records were written: the journal was compacted after the 4th one.
+		data, err := os.ReadFile(filepath)
+		require.NoError(t, err)
+		assert.Equal(t, 3, bytes.Count(data, []byte("\n")))
+		assert.Contains(t, string(data), `"op":"snapshot"`)
+
+		err = s.Close()
+		require.NoError(t, err)
+
+		data, err = os.ReadFile(filepath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, bytes.Count(data, []byte("\n")))
+
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+
+		userURLs, err := loaded.ListURLsByUserID(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 3, len(userURLs))
+	})
+
+	t.Run("failed compaction", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{CompactThreshold: 2})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
+		// The temporary file of the snapshot can not be created.
+		require.NoError(t, os.Mkdir(filepath+".tmp", 0755))
+		defer os.Remove(filepath + ".tmp")
+
+		user := random.RandomUser()
+		url := random.RandomURL()
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err, "the write succeeds although compaction fails")
+
+		persisted, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, url.Original, persisted.Original)
+
+		data, err := os.ReadFile(filepath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), `"op":"snapshot"`)
+
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+		persisted, err = loaded.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, url.Original, persisted.Original)
+		require.NoError(t, loaded.journal.Close())
+		require.NoError(t, s.journal.Close())
+	})
+
+	t.Run("failed sync after compaction", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{CompactThreshold: 2})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+		s.syncDir = func(string) error { return errors.New("sync failed") }
+
+		// The snapshot replaces the journal, but the directory can not be synced.
+		user := random.RandomUser()
+		urls := random.RandomURLs(5)
+		for _, url := range urls {
+			err = s.CreateURL(ctx, user.ID, url)
+			require.NoError(t, err)
+		}
+
+		data, err := os.ReadFile(filepath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"op":"snapshot"`)
+
+		// Records written after the compaction are not lost.
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+		for _, url := range urls {
+			persisted, err := loaded.GetURL(ctx, url.Slug)
+			require.NoError(t, err)
+			assert.Equal(t, url.Original, persisted.Original)
+		}
+		require.NoError(t, loaded.journal.Close())
+		require.NoError(t, s.journal.Close())
+	})
+
+	t.Run("incomplete last record", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
+		user := random.RandomUser()
+		url := random.RandomURL()
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err)
+
+		file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND, 0666)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"op":"url_created","url":{"slug":"tor`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		loaded, err := New(filepath, Options{})
+		require.NoError(t, err)
+
+		all, err := loaded.ListAllUrls(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(all))
+
+		// New records are appended after the last complete one.
+		err = loaded.CreateURL(ctx, user.ID, random.RandomURL())
+		require.NoError(t, err)
+
+		loaded, err = New(filepath, Options{})
+		require.NoError(t, err)
+
+		all, err = loaded.ListAllUrls(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(all))
+	})
+
+	t.Run("unknown sync policy", func(t *testing.T) {
+		_, err := New("./test_storage.json", Options{SyncPolicy: "sometimes"})
+		assert.Error(t, err)
+	})
+
+	t.Run("interval sync policy", func(t *testing.T) {
+		filepath := "./test_storage.json"
+		s, err := New(filepath, Options{SyncPolicy: SyncInterval, SyncInterval: time.Millisecond})
+		require.NoError(t, err)
+		defer func() {
+			err = os.Remove(filepath)
+			require.NoError(t, err)
+		}()
+
+		err = s.CreateUser(ctx, random.RandomUser())
+		require.NoError(t, err)
+
+		err = s.Close()
+		require.NoError(t, err)
+	})
 }
 
-func TestListURLsByUserID(t *testing.T) {
+func TestDeleteExpiredURLs(t *testing.T) {
 	filepath := "./test_storage.json"
-	s, err := New(filepath)
+	s, err := New(filepath, Options{})
 	require.NoError(t, err)
 	defer func() {
 		err = os.Remove(filepath)
@@ -172,43 +625,451 @@ func TestListURLsByUserID(t *testing.T) {
 	}()
 
 	ctx := context.Background()
+	now := time.Now()
+
+	user := random.RandomUser()
+	urls := random.RandomURLs(3)
+	expired := now.Add(-time.Hour)
+	notExpired := now.Add(time.Hour)
+	urls[0].ExpiresAt = &expired
+	urls[1].ExpiresAt = &notExpired
+	err = s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	n, err := s.DeleteExpiredURLs(ctx, now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Removal must survive replaying the journal.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+
+	_, err = loaded.GetURL(ctx, urls[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
 
-	all, err := s.ListAllUrls(ctx)
+	persistedURL, err := loaded.GetURL(ctx, urls[1].Slug)
 	require.NoError(t, err)
-	require.Equal(t, 0, len(all))
+	require.NotNil(t, persistedURL.ExpiresAt)
+	assert.True(t, notExpired.Equal(*persistedURL.ExpiresAt))
+
+	userURLs, err := loaded.ListURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(userURLs))
+
+	// Expiration index must be restored from the snapshot too.
+	require.NoError(t, loaded.Compact())
+	compacted, err := New(filepath, Options{})
+	require.NoError(t, err)
+
+	n, err = compacted.DeleteExpiredURLs(ctx, now.Add(2*time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestBatchUpsertURLs(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
 
 	user1 := random.RandomUser()
-	user1URLs := random.RandomURLs(3)
 	user2 := random.RandomUser()
-	user2URLs := random.RandomURLs(2)
+	for _, user := range []models.User{user1, user2} {
+		err = s.CreateUser(ctx, user)
+		require.NoError(t, err)
+	}
 
-	err = s.BatchCreateURL(ctx, user1.ID, user1URLs)
+	existing := random.RandomURL()
+	err = s.CreateURL(ctx, user1.ID, existing)
 	require.NoError(t, err)
 
-	err = s.BatchCreateURL(ctx, user2.ID, user2URLs)
+	urls := random.RandomURLs(4)
+	// Already shortened by another user.
+	urls[1].Original = existing.Original
+	// Slug is taken by another URL.
+	urls[2].Slug = existing.Slug
+	// Duplicate within the batch.
+	urls[3].Original = urls[0].Original
+
+	res, err := s.BatchUpsertURLs(ctx, user2.ID, urls)
 	require.NoError(t, err)
+	require.Len(t, res, 4)
+
+	assert.True(t, res[0].Created)
+	assert.NoError(t, res[0].Err)
+	assert.Equal(t, urls[0].Slug, res[0].URL.Slug)
 
-	resURLs1, err := s.ListURLsByUserID(ctx, user1.ID)
+	assert.False(t, res[1].Created)
+	assert.NoError(t, res[1].Err)
+	assert.Equal(t, existing.Slug, res[1].URL.Slug)
+
+	var slugExists *store.SlugExistsError
+	assert.False(t, res[2].Created)
+	assert.ErrorAs(t, res[2].Err, &slugExists)
+
+	assert.False(t, res[3].Created)
+	assert.NoError(t, res[3].Err)
+	assert.Equal(t, urls[0].Slug, res[3].URL.Slug)
+
+	_, err = s.GetURL(ctx, urls[3].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	require.NoError(t, s.Close())
+
+	// Results are restored from the journal.
+	s, err = New(filepath, Options{})
 	require.NoError(t, err)
-	assert.Equal(t, 3, len(resURLs1))
+	defer s.Close()
 
-	resURLs2, err := s.ListURLsByUserID(ctx, user2.ID)
+	// Created and existing URLs are linked to the user.
+	user2URLs, err := s.ListURLsByUserID(ctx, user2.ID)
 	require.NoError(t, err)
-	assert.Equal(t, 2, len(resURLs2))
+	slugs := make([]string, 0, len(user2URLs))
+	for _, url := range user2URLs {
+		slugs = append(slugs, url.Slug)
+	}
+	assert.ElementsMatch(t, []string{urls[0].Slug, existing.Slug}, slugs)
 }
 
-func TestLoadFromFile(t *testing.T) {
-	filepath := "./fixtures/test_storage.json"
-	s, err := New(filepath)
+func TestImportURLs(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
 	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
 
 	ctx := context.Background()
 
-	resURLs1, err := s.ListURLsByUserID(ctx, "59100585-a808-4fe2-8dd3-9aaf2b47984f")
+	user1 := random.RandomUser()
+	user2 := random.RandomUser()
+	for _, user := range []models.User{user1, user2} {
+		err = s.CreateUser(ctx, user)
+		require.NoError(t, err)
+	}
+
+	existing := random.RandomURL()
+	err = s.CreateURL(ctx, user1.ID, existing)
 	require.NoError(t, err)
-	assert.Equal(t, 3, len(resURLs1))
 
-	resURLs2, err := s.ListURLsByUserID(ctx, "706be65e-b545-4d5f-bb09-1dd2fd285e44")
+	stream := func(urls []models.URL, streamErr error) iter.Seq2[models.URL, error] {
+		return func(yield func(models.URL, error) bool) {
+			for _, url := range urls {
+				if !yield(url, nil) {
+					return
+				}
+			}
+			if streamErr != nil {
+				yield(models.URL{}, streamErr)
+			}
+		}
+	}
+
+	// Nothing is imported if the stream fails, even after several chunks have been written.
+	failed := random.RandomURLs(2*importChunkSize + 1)
+	failed[importChunkSize-1].Original = existing.Original
+	_, err = s.ImportURLs(ctx, user2.ID, stream(failed, errors.New("broken stream")))
+	require.Error(t, err)
+	_, err = s.GetURL(ctx, failed[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// The import is reverted in the journal as well.
+	loaded, err := New(filepath, Options{})
 	require.NoError(t, err)
-	assert.Equal(t, 2, len(resURLs2))
+	_, err = loaded.GetURL(ctx, failed[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	loadedURLs, err := loaded.ListURLsByUserID(ctx, user2.ID)
+	require.NoError(t, err)
+	assert.Empty(t, loadedURLs)
+	require.NoError(t, loaded.journal.Close())
+
+	urls := random.RandomURLs(4)
+	// Already shortened by another user.
+	urls[1].Original = existing.Original
+	// Slug is taken by another URL.
+	urls[2].Slug = existing.Slug
+	// Duplicate within the stream.
+	urls[3].Original = urls[0].Original
+
+	res, err := s.ImportURLs(ctx, user2.ID, stream(urls, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Created)
+	assert.Equal(t, 2, res.Existing)
+	require.Len(t, res.Conflicts, 1)
+	assert.Equal(t, urls[2].CorrelationID, res.Conflicts[0].CorrelationID)
+	assert.Equal(t, existing.Slug, res.Conflicts[0].Slug)
+
+	url, err := s.GetURL(ctx, urls[0].Slug)
+	require.NoError(t, err)
+	assert.Equal(t, urls[0].Original, url.Original)
+	require.NoError(t, s.Close())
+
+	// Imported URLs are restored from the journal.
+	s, err = New(filepath, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Created and existing URLs are linked to the user.
+	user2URLs, err := s.ListURLsByUserID(ctx, user2.ID)
+	require.NoError(t, err)
+	slugs := make([]string, 0, len(user2URLs))
+	for _, url := range user2URLs {
+		slugs = append(slugs, url.Slug)
+	}
+	assert.ElementsMatch(t, []string{urls[0].Slug, existing.Slug}, slugs)
+}
+
+func TestScanAndBlockURLs(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+
+	user := random.RandomUser()
+	urls := random.RandomURLs(3)
+	err = s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	page, err := s.ScanURLs(ctx, "", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	rest, err := s.ScanURLs(ctx, page[1].Slug, 2)
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Less(t, page[0].Slug, page[1].Slug)
+	assert.Less(t, page[1].Slug, rest[0].Slug)
+	sorted := []string{page[0].Slug, page[1].Slug, rest[0].Slug}
+
+	err = s.BlockURLs(ctx, []string{urls[0].Slug, "unknown"})
+	require.NoError(t, err)
+
+	// Blocking must survive replaying the journal and compaction.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Compact())
+	compacted, err := New(filepath, Options{})
+	require.NoError(t, err)
+
+	for _, s := range []*Store{loaded, compacted} {
+		url, err := s.GetURL(ctx, urls[0].Slug)
+		require.NoError(t, err)
+		assert.True(t, url.Blocked)
+
+		url, err = s.GetURL(ctx, urls[1].Slug)
+		require.NoError(t, err)
+		assert.False(t, url.Blocked)
+
+		// So must the order of slugs.
+		page, err := s.ScanURLs(ctx, "", 10)
+		require.NoError(t, err)
+		require.Len(t, page, len(sorted))
+		for i, url := range page {
+			assert.Equal(t, sorted[i], url.Slug)
+		}
+	}
+}
+
+func TestClicks(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+
+	user := random.RandomUser()
+	url := random.RandomURL()
+	err = s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	now := time.Now()
+	err = s.CreateClicks(ctx, []models.Click{
+		{URLID: url.ID, Slug: url.Slug, CreatedAt: now, IPHash: "a"},
+		{URLID: url.ID, Slug: url.Slug, CreatedAt: now, IPHash: "b"},
+	})
+	require.NoError(t, err)
+
+	// Clicks must survive replaying the journal and compaction.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Compact())
+	loaded, err = New(filepath, Options{})
+	require.NoError(t, err)
+
+	stats, err := loaded.GetURLStats(ctx, user.ID, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+
+	_, err = loaded.GetURLStats(ctx, random.RandomUser().ID, url.Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestAPIKeys(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+
+	user := random.RandomUser()
+	key1 := random.RandomAPIKey(user.ID)
+	key2 := random.RandomAPIKey(user.ID)
+	key2.CreatedAt = key1.CreatedAt.Add(time.Second)
+	other := random.RandomAPIKey(random.RandomUser().ID)
+	for _, key := range []models.APIKey{key2, key1, other} {
+		require.NoError(t, s.CreateAPIKey(ctx, key))
+	}
+
+	usedAt := time.Now().UTC()
+	require.NoError(t, s.TouchAPIKey(ctx, key1.ID, usedAt))
+	err = s.RevokeAPIKey(ctx, user.ID, other.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	require.NoError(t, s.RevokeAPIKey(ctx, user.ID, key2.ID))
+
+	// API keys must survive replaying the journal and compaction.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Compact())
+	loaded, err = New(filepath, Options{})
+	require.NoError(t, err)
+
+	keys, err := loaded.ListAPIKeysByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(keys))
+	assert.Equal(t, key1.ID, keys[0].ID)
+	require.NotNil(t, keys[0].LastUsedAt)
+	assert.True(t, usedAt.Equal(*keys[0].LastUsedAt))
+
+	res, err := loaded.GetAPIKeyByHash(ctx, other.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, res.ID)
+
+	_, err = loaded.GetAPIKeyByHash(ctx, key2.Hash)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestRevokedTokens(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+
+	now := time.Now()
+	expired := uuid.NewString()
+	active := uuid.NewString()
+	for _, tokenID := range []string{expired, active} {
+		expiresAt := now.Add(time.Hour)
+		if tokenID == expired {
+			expiresAt = now.Add(-time.Minute)
+		}
+		revoked, err := s.RevokeToken(ctx, tokenID, expiresAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	// Revoking a token twice is not an error, but it is reported.
+	revoked, err := s.RevokeToken(ctx, active, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	for _, tokenID := range []string{expired, active} {
+		revoked, err := s.IsTokenRevoked(ctx, tokenID)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	revoked, err = s.IsTokenRevoked(ctx, uuid.NewString())
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	n, err := s.DeleteExpiredRevokedTokens(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Revoked tokens must survive replaying the journal and compaction.
+	loaded, err := New(filepath, Options{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Compact())
+	loaded, err = New(filepath, Options{})
+	require.NoError(t, err)
+
+	revoked, err = loaded.IsTokenRevoked(ctx, expired)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = loaded.IsTokenRevoked(ctx, active)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestCount(t *testing.T) {
+	filepath := "./test_storage.json"
+	s, err := New(filepath, Options{})
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(filepath)
+		require.NoError(t, err)
+	}()
+
+	ctx := context.Background()
+
+	users := []models.User{random.RandomUser(), random.RandomUser(), random.RandomUser()}
+	for _, user := range users {
+		require.NoError(t, s.CreateUser(ctx, user))
+	}
+	urls := random.RandomURLs(4)
+	past := time.Now().Add(-time.Minute)
+	urls[3].ExpiresAt = &past
+	require.NoError(t, s.BatchCreateURL(ctx, users[0].ID, urls))
+	require.NoError(t, s.SoftDeleteURL(ctx, users[0].ID, urls[0].Slug))
+	require.NoError(t, s.SoftDeleteURL(ctx, users[0].ID, urls[3].Slug))
+	// Deleting a URL again does not change the number.
+	require.NoError(t, s.SoftDeleteURL(ctx, users[0].ID, urls[0].Slug))
+
+	n, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// Purging a deleted URL does not change the number either.
+	purged, err := s.DeleteExpiredURLs(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	n, err = s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// The counter is restored from the journal.
+	require.NoError(t, s.Close())
+	s, err = New(filepath, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	n, err = s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 }
@@ -0,0 +1,256 @@
+// Package instrumented implements a store.Store decorator which collects
+// latency and error metrics of storage operations.
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
+
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+)
+
+// Store wraps store.Store and observes every call.
+//
+// Use New to create an instance of Store.
+type Store struct {
+	s store.Store
+}
+
+// New wraps s with metrics collection.
+func New(s store.Store) *Store {
+	return &Store{s: s}
+}
+
+// CreateUser registers new user.
+func (s *Store) CreateUser(ctx context.Context, user models.User) (err error) {
+	defer observe("CreateUser", time.Now(), &err)
+	return s.s.CreateUser(ctx, user)
+}
+
+// GetUser fetches user by ID.
+func (s *Store) GetUser(ctx context.Context, userID string) (_ models.User, err error) {
+	defer observe("GetUser", time.Now(), &err)
+	return s.s.GetUser(ctx, userID)
+}
+
+// CreateURL adds a new URL to the storage.
+func (s *Store) CreateURL(ctx context.Context, userID string, url models.URL) (err error) {
+	defer observe("CreateURL", time.Now(), &err)
+	return s.s.CreateURL(ctx, userID, url)
+}
+
+// BatchCreateURL adds a batch of URLs to the storage.
+func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models.URL) (err error) {
+	defer observe("BatchCreateURL", time.Now(), &err)
+	return s.s.BatchCreateURL(ctx, userID, urls)
+}
+
+// BatchUpsertURLs adds URLs of a batch independently of each other.
+func (s *Store) BatchUpsertURLs(ctx context.Context, userID string, urls []models.URL) (_ []store.UpsertResult, err error) {
+	defer observe("BatchUpsertURLs", time.Now(), &err)
+	return s.s.BatchUpsertURLs(ctx, userID, urls)
+}
+
+// ImportURLs adds URLs read from the stream.
+func (s *Store) ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (_ store.ImportResult, err error) {
+	defer observe("ImportURLs", time.Now(), &err)
+	return s.s.ImportURLs(ctx, userID, urls)
+}
+
+// GetURL retrieves a URL by its slug from the storage.
+func (s *Store) GetURL(ctx context.Context, slug string) (_ models.URL, err error) {
+	defer observe("GetURL", time.Now(), &err)
+	return s.s.GetURL(ctx, slug)
+}
+
+// ListURLsByUserID returns all URLs created by the specified user.
+func (s *Store) ListURLsByUserID(ctx context.Context, userID string) (_ []models.URL, err error) {
+	defer observe("ListURLsByUserID", time.Now(), &err)
+	return s.s.ListURLsByUserID(ctx, userID)
+}
+
+// ListUserURLs returns a page of URLs created by the specified user.
+func (s *Store) ListUserURLs(ctx context.Context, userID string, q models.URLQuery) (_ []models.URL, err error) {
+	defer observe("ListUserURLs", time.Now(), &err)
+	return s.s.ListUserURLs(ctx, userID, q)
+}
+
+// ListAllUrls returns the full map of stored URLs.
+func (s *Store) ListAllUrls(ctx context.Context) (_ map[string]models.URL, err error) {
+	defer observe("ListAllUrls", time.Now(), &err)
+	return s.s.ListAllUrls(ctx)
+}
+
+// CountURLs returns the number of stored URLs which are not deleted.
+func (s *Store) CountURLs(ctx context.Context) (_ int, err error) {
+	defer observe("CountURLs", time.Now(), &err)
+	return s.s.CountURLs(ctx)
+}
+
+// CountUsers returns the number of registered users.
+func (s *Store) CountUsers(ctx context.Context) (_ int, err error) {
+	defer observe("CountUsers", time.Now(), &err)
+	return s.s.CountUsers(ctx)
+}
+
+// SoftDeleteURL marks URLs as deleted.
+func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) (err error) {
+	defer observe("SoftDeleteURL", time.Now(), &err)
+	return s.s.SoftDeleteURL(ctx, userID, slug)
+}
+
+// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+func (s *Store) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) (err error) {
+	defer observe("BatchSoftDeleteURLs", time.Now(), &err)
+	return s.s.BatchSoftDeleteURLs(ctx, userID, slugs)
+}
+
+// CreateDeleteJob adds a new job of deleting user URLs.
+func (s *Store) CreateDeleteJob(ctx context.Context, job models.DeleteJob) (err error) {
+	defer observe("CreateDeleteJob", time.Now(), &err)
+	return s.s.CreateDeleteJob(ctx, job)
+}
+
+// GetDeleteJob fetches the job of the specified user by ID.
+func (s *Store) GetDeleteJob(ctx context.Context, userID, jobID string) (_ models.DeleteJob, err error) {
+	defer observe("GetDeleteJob", time.Now(), &err)
+	return s.s.GetDeleteJob(ctx, userID, jobID)
+}
+
+// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time
+// and postpones their next attempt until leaseUntil.
+func (s *Store) ClaimDeleteJobs(ctx context.Context, now, leaseUntil time.Time, limit int) (_ []models.DeleteJob, err error) {
+	defer observe("ClaimDeleteJobs", time.Now(), &err)
+	return s.s.ClaimDeleteJobs(ctx, now, leaseUntil, limit)
+}
+
+// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+func (s *Store) UpdateDeleteJob(ctx context.Context, job models.DeleteJob) (err error) {
+	defer observe("UpdateDeleteJob", time.Now(), &err)
+	return s.s.UpdateDeleteJob(ctx, job)
+}
+
+// CountPendingDeleteJobs returns the number of pending jobs.
+func (s *Store) CountPendingDeleteJobs(ctx context.Context) (_ int, err error) {
+	defer observe("CountPendingDeleteJobs", time.Now(), &err)
+	return s.s.CountPendingDeleteJobs(ctx)
+}
+
+// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) DeleteFinishedDeleteJobs(ctx context.Context, before time.Time) (_ int, err error) {
+	defer observe("DeleteFinishedDeleteJobs", time.Now(), &err)
+	return s.s.DeleteFinishedDeleteJobs(ctx, before)
+}
+
+// CreateClicks adds a batch of clicks to the storage.
+func (s *Store) CreateClicks(ctx context.Context, clicks []models.Click) (err error) {
+	defer observe("CreateClicks", time.Now(), &err)
+	return s.s.CreateClicks(ctx, clicks)
+}
+
+// GetURLStats returns click statistics of the URL created by the specified user.
+func (s *Store) GetURLStats(ctx context.Context, userID, slug string) (_ models.URLStats, err error) {
+	defer observe("GetURLStats", time.Now(), &err)
+	return s.s.GetURLStats(ctx, userID, slug)
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+func (s *Store) DeleteExpiredURLs(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	defer observe("DeleteExpiredURLs", time.Now(), &err)
+	return s.s.DeleteExpiredURLs(ctx, before, limit)
+}
+
+// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+func (s *Store) ScanURLs(ctx context.Context, afterSlug string, limit int) (_ []models.URL, err error) {
+	defer observe("ScanURLs", time.Now(), &err)
+	return s.s.ScanURLs(ctx, afterSlug, limit)
+}
+
+// BlockURLs marks URLs with the given slugs as blocked.
+func (s *Store) BlockURLs(ctx context.Context, slugs []string) (err error) {
+	defer observe("BlockURLs", time.Now(), &err)
+	return s.s.BlockURLs(ctx, slugs)
+}
+
+// CreateAPIKey adds a new API key of a user.
+func (s *Store) CreateAPIKey(ctx context.Context, key models.APIKey) (err error) {
+	defer observe("CreateAPIKey", time.Now(), &err)
+	return s.s.CreateAPIKey(ctx, key)
+}
+
+// ListAPIKeysByUserID returns all API keys of the specified user.
+func (s *Store) ListAPIKeysByUserID(ctx context.Context, userID string) (_ []models.APIKey, err error) {
+	defer observe("ListAPIKeysByUserID", time.Now(), &err)
+	return s.s.ListAPIKeysByUserID(ctx, userID)
+}
+
+// GetAPIKeyByHash fetches API key by the hash of the key.
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (_ models.APIKey, err error) {
+	defer observe("GetAPIKeyByHash", time.Now(), &err)
+	return s.s.GetAPIKeyByHash(ctx, hash)
+}
+
+// RevokeAPIKey removes the API key of the specified user.
+func (s *Store) RevokeAPIKey(ctx context.Context, userID, keyID string) (err error) {
+	defer observe("RevokeAPIKey", time.Now(), &err)
+	return s.s.RevokeAPIKey(ctx, userID, keyID)
+}
+
+// TouchAPIKey records the time the API key was last used.
+func (s *Store) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) (err error) {
+	defer observe("TouchAPIKey", time.Now(), &err)
+	return s.s.TouchAPIKey(ctx, keyID, usedAt)
+}
+
+// RevokeToken adds the ID of an authentication token to the revocation list.
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (_ bool, err error) {
+	defer observe("RevokeToken", time.Now(), &err)
+	return s.s.RevokeToken(ctx, tokenID, expiresAt)
+}
+
+// IsTokenRevoked reports whether the authentication token has been revoked.
+func (s *Store) IsTokenRevoked(ctx context.Context, tokenID string) (_ bool, err error) {
+	defer observe("IsTokenRevoked", time.Now(), &err)
+	return s.s.IsTokenRevoked(ctx, tokenID)
+}
+
+// DeleteExpiredRevokedTokens removes revocation list entries of expired tokens.
+func (s *Store) DeleteExpiredRevokedTokens(ctx context.Context, before time.Time) (_ int, err error) {
+	defer observe("DeleteExpiredRevokedTokens", time.Now(), &err)
+	return s.s.DeleteExpiredRevokedTokens(ctx, before)
+}
+
+// Ping is a storage healthcheck.
+func (s *Store) Ping(ctx context.Context) (err error) {
+	defer observe("Ping", time.Now(), &err)
+	return s.s.Ping(ctx)
+}
+
+// Close releases resources held by the storage.
+func (s *Store) Close() (err error) {
+	defer observe("Close", time.Now(), &err)
+	return s.s.Close()
+}
+
+// observe records the operation latency and counts the error, if any.
+//
+// Expected outcomes, such as a missing entity or a taken slug, are not counted as errors.
+func observe(operation string, start time.Time, err *error) {
+	metrics.StoreOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	if *err == nil || isExpected(*err) {
+		return
+	}
+	metrics.StoreErrors.WithLabelValues(operation).Inc()
+}
+
+func isExpected(err error) bool {
+	var slugExists *store.SlugExistsError
+	var alreadyExists *store.AlreadyExistsError
+
+	return errors.Is(err, store.ErrNotFound) || errors.As(err, &slugExists) || errors.As(err, &alreadyExists)
+}
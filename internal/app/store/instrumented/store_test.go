@@ -0,0 +1,42 @@
+package instrumented
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/metrics"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/memory"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+func TestStore(t *testing.T) {
+	s := New(memory.New())
+	ctx := context.Background()
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, random.RandomUser().ID, url)
+	require.NoError(t, err)
+
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, url.Original, res.Original)
+
+	getURLErrors := testutil.ToFloat64(metrics.StoreErrors.WithLabelValues("GetURL"))
+	_, err = s.GetURL(ctx, "unknown")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	// Missing URL is an expected outcome, not a failure.
+	assert.Equal(t, getURLErrors, testutil.ToFloat64(metrics.StoreErrors.WithLabelValues("GetURL")))
+
+	getUserErrors := testutil.ToFloat64(metrics.StoreErrors.WithLabelValues("GetUser"))
+	_, err = s.GetUser(ctx, "unknown")
+	assert.Error(t, err)
+	assert.Equal(t, getUserErrors+1, testutil.ToFloat64(metrics.StoreErrors.WithLabelValues("GetUser")))
+
+	// Latency is observed per operation: CreateURL, GetURL and GetUser.
+	assert.Equal(t, 3, testutil.CollectAndCount(metrics.StoreOperationDuration))
+}
@@ -5,32 +5,71 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
 )
 
 // Store is an implementation of store.Store interface which stores data in memory.
 //
 // Use New to create an instance of Store.
 type Store struct {
-	urls     map[string]models.URL
-	users    map[string]models.User
+	urls map[string]models.URL
+	// slugs holds slugs of all URLs sorted, so that ScanURLs reads pages without sorting.
+	slugs []string
+	// canonical maps canonical forms of URLs to their slugs to find duplicates.
+	canonical map[string]string
+	// deletedURLs is the number of URLs marked as deleted, so that CountURLs does not scan urls.
+	deletedURLs int
+	users       map[string]models.User
+	// userURLs maps user ID to slugs of URLs created by the user, sorted with models.CompareURLs,
+	// so that pages of user URLs are read without sorting.
 	userURLs map[string][]string
-	mu       sync.Mutex
+	// urlUsers is a reverse index of userURLs: it maps slug to IDs of users who created it.
+	urlUsers map[string][]string
+	// deletedUserURLs holds slugs deleted by each user, like user_urls.is_deleted in the database.
+	deletedUserURLs map[string]map[string]struct{}
+	// clicks maps slug to clicks of the URL.
+	clicks map[string][]models.Click
+	// expiring maps slugs of URLs with limited lifetime to their expiration time.
+	expiring map[string]time.Time
+	// apiKeys maps key ID to API key, apiKeyHashes maps key hash to key ID.
+	apiKeys      map[string]models.APIKey
+	apiKeyHashes map[string]string
+	// revokedTokens maps IDs of revoked authentication tokens to their expiration time.
+	revokedTokens map[string]time.Time
+	// deleteJobs maps job ID to the job of deleting user URLs.
+	deleteJobs map[string]models.DeleteJob
+	mu         sync.Mutex
 }
 
 // New creates a new in-memory storage.
 func New() *Store {
 	return &Store{
-		urls:     make(map[string]models.URL),
-		users:    make(map[string]models.User),
-		userURLs: make(map[string][]string),
+		urls:            make(map[string]models.URL),
+		canonical:       make(map[string]string),
+		users:           make(map[string]models.User),
+		userURLs:        make(map[string][]string),
+		urlUsers:        make(map[string][]string),
+		deletedUserURLs: make(map[string]map[string]struct{}),
+		expiring:        make(map[string]time.Time),
+		clicks:          make(map[string][]models.Click),
+		apiKeys:         make(map[string]models.APIKey),
+		apiKeyHashes:    make(map[string]string),
+		revokedTokens:   make(map[string]time.Time),
+		deleteJobs:      make(map[string]models.DeleteJob),
 	}
 }
 
 // CreateUser registers new user.
 func (s *Store) CreateUser(_ context.Context, user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if _, ok := s.users[user.ID]; !ok {
 		s.users[user.ID] = user
 	}
@@ -42,6 +81,9 @@ func (s *Store) CreateUser(_ context.Context, user models.User) error {
 //
 // It returns error if user is not found.
 func (s *Store) GetUser(_ context.Context, userID string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if user, ok := s.users[userID]; ok {
 		return user, nil
 	}
@@ -51,77 +93,785 @@ func (s *Store) GetUser(_ context.Context, userID string) (models.User, error) {
 
 // CreateURL adds a new URL to the storage.
 //
-// It also links the URL to the current user.
-func (s *Store) CreateURL(_ context.Context, userID string, url models.URL) error { //nolint:unparam
+// It also links the URL to the current user. It returns *store.SlugExistsError
+// if a different URL with the same slug already exists. If a URL with the same
+// canonical form already exists, it links that URL to the user instead and
+// returns *store.AlreadyExistsError.
+func (s *Store) CreateURL(_ context.Context, userID string, url models.URL) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.urls[url.Slug] = url
-	s.userURLs[userID] = append(s.userURLs[userID], url.Slug)
+	if err := s.checkCanonical(url); err != nil {
+		var alreadyExists *store.AlreadyExistsError
+		if errors.As(err, &alreadyExists) {
+			s.linkURLToUser(alreadyExists.URL.Slug, userID)
+		}
+		return err
+	}
+	if err := s.checkSlug(url); err != nil {
+		return err
+	}
+
+	s.setURL(url)
+	s.linkURLToUser(url.Slug, userID)
 
 	return nil
 }
 
 // BatchCreateURL adds a batch of URLs to the storage.
 //
-// It also links the created URLs to the current user.
-func (s *Store) BatchCreateURL(_ context.Context, userID string, urls []models.URL) error { //nolint:unparam
+// It also links the created URLs to the current user. If any of the slugs
+// is already taken, it returns *store.SlugExistsError and adds nothing.
+// If any of the URLs has already been shortened, it returns *store.AlreadyExistsError
+// and adds nothing.
+func (s *Store) BatchCreateURL(_ context.Context, userID string, urls []models.URL) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for _, url := range urls {
-		s.urls[url.Slug] = url
-		s.userURLs[userID] = append(s.userURLs[userID], url.Slug)
+		if err := s.checkCanonical(url); err != nil {
+			return err
+		}
+		if err := s.checkSlug(url); err != nil {
+			return err
+		}
+	}
+
+	for _, url := range urls {
+		s.setURL(url)
+		s.linkURLToUser(url.Slug, userID)
 	}
 
 	return nil
 }
 
+// BatchUpsertURLs adds URLs of a batch independently of each other and links them to the user.
+//
+// URLs which have already been shortened, including the previous URLs of the batch,
+// are linked to the user instead. URLs whose slug is taken by a different URL are
+// reported with *store.SlugExistsError.
+func (s *Store) BatchUpsertURLs(_ context.Context, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.upsertURLs(userID, urls)
+}
+
+// ImportURLs adds URLs read from the stream like BatchUpsertURLs.
+//
+// The stream is added in chunks of importChunkSize URLs, so that it is never held
+// in memory as a whole and the lock is not held while the stream is read.
+// If the stream fails, the URLs created and linked by the import are removed.
+func (s *Store) ImportURLs(_ context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var (
+		res  store.ImportResult
+		undo importUndo
+	)
+	flush := func(chunk []models.URL) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		linked := s.unlinkedDuplicates(userID, chunk)
+		results, err := s.upsertURLs(userID, chunk)
+		if err != nil {
+			return err
+		}
+		undo.linked = append(undo.linked, linked...)
+		for _, result := range results {
+			if result.Created {
+				undo.created = append(undo.created, result.URL.Slug)
+			}
+			res.Add(result)
+		}
+		return nil
+	}
+
+	chunk := make([]models.URL, 0, importChunkSize)
+	for url, err := range urls {
+		if err == nil {
+			chunk = append(chunk, url)
+			if len(chunk) < importChunkSize {
+				continue
+			}
+			err = flush(chunk)
+			chunk = chunk[:0]
+		}
+		if err != nil {
+			s.undoImport(userID, undo)
+			return store.ImportResult{}, err
+		}
+	}
+	if len(chunk) > 0 {
+		if err := flush(chunk); err != nil {
+			s.undoImport(userID, undo)
+			return store.ImportResult{}, err
+		}
+	}
+
+	return res, nil
+}
+
 // GetURL retrieves a URL by its slug from the storage.
 //
-// It returns error if URL is not found.
+// It returns store.ErrNotFound if URL is not found.
 func (s *Store) GetURL(_ context.Context, slug string) (models.URL, error) {
-	var url models.URL
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	url, ok := s.urls[slug]
 	if !ok {
-		return url, errors.New("url was not found")
+		return url, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
 	}
 
 	return url, nil
 }
 
-// ListURLsByUserID returns all URLs created by the specified user.
+// ListURLsByUserID returns all URLs created by the specified user
+// except for the ones deleted by the user.
 func (s *Store) ListURLsByUserID(_ context.Context, userID string) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.userURLList(userID), nil
+}
+
+// ListUserURLs returns a page of URLs created by the specified user which match the query.
+func (s *Store) ListUserURLs(_ context.Context, userID string, q models.URLQuery) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	slugs := s.userURLs[userID]
-	if len(slugs) == 0 {
-		return []models.URL{}, nil
-	}
-	res := make([]models.URL, 0)
-	for _, slug := range slugs {
-		if url, ok := s.urls[slug]; ok {
-			res = append(res, url)
-		}
-	}
+	deleted := s.deletedUserURLs[userID]
 
-	return res, nil
+	return q.Page(len(slugs), func(i int) (models.URL, bool) {
+		_, isDeleted := deleted[slugs[i]]
+		return s.urls[slugs[i]], !isDeleted
+	}), nil
 }
 
 // ListAllUrls returns the full map of stored URLs.
 //
 // This function should not be used in production.
 func (s *Store) ListAllUrls(_ context.Context) (map[string]models.URL, error) {
-	return s.urls, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]models.URL, len(s.urls))
+	for slug, url := range s.urls {
+		res[slug] = url
+	}
+
+	return res, nil
+}
+
+// CountURLs returns the number of stored URLs which are not deleted.
+func (s *Store) CountURLs(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.urls) - s.deletedURLs, nil
+}
+
+// CountUsers returns the number of registered users.
+func (s *Store) CountUsers(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users), nil
 }
 
 // SoftDeleteURL marks URLs as deleted.
+//
+// It unlinks the URL from the user. The URL itself is marked as deleted
+// only when it has been deleted by all users who created it.
 func (s *Store) SoftDeleteURL(_ context.Context, userID string, slug string) error {
-	// TODO implement
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[slug]; !ok {
+		return fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+
+	s.unlinkURLFromUser(slug, userID)
+
+	return nil
+}
+
+// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+//
+// Slugs which do not exist or were not created by the user are skipped.
+func (s *Store) BatchSoftDeleteURLs(_ context.Context, userID string, slugs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, slug := range slugs {
+		s.unlinkURLFromUser(slug, userID)
+	}
+
+	return nil
+}
+
+// CreateClicks adds a batch of clicks to the storage.
+//
+// Clicks of URLs which do not exist anymore are skipped.
+func (s *Store) CreateClicks(_ context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		s.addClick(click)
+	}
+
+	return nil
+}
+
+// GetURLStats returns click statistics of the URL created by the specified user.
+//
+// It returns store.ErrNotFound if there is no such URL, it was not created
+// by the user or it was deleted by the user.
+func (s *Store) GetURLStats(_ context.Context, userID, slug string) (models.URLStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, deleted := s.deletedUserURLs[userID][slug]
+	if _, ok := s.urls[slug]; !ok || deleted || !slices.Contains(s.urlUsers[slug], userID) {
+		return models.URLStats{}, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+
+	return models.NewURLStats(slug, s.clicks[slug]), nil
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+//
+// The lock is held for a single call only, so large amounts of expired URLs
+// should be removed with several calls.
+func (s *Store) DeleteExpiredURLs(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slugs := s.expiredSlugs(before, limit)
+	for _, slug := range slugs {
+		s.removeURL(slug)
+	}
+
+	return len(slugs), nil
+}
+
+// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+func (s *Store) ScanURLs(_ context.Context, afterSlug string, limit int) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, found := slices.BinarySearch(s.slugs, afterSlug)
+	if found {
+		i++
+	}
+	slugs := s.slugs[i:min(i+limit, len(s.slugs))]
+
+	res := make([]models.URL, 0, len(slugs))
+	for _, slug := range slugs {
+		res = append(res, s.urls[slug])
+	}
+
+	return res, nil
+}
+
+// BlockURLs marks URLs with the given slugs as blocked.
+//
+// Slugs which do not exist are skipped.
+func (s *Store) BlockURLs(_ context.Context, slugs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, slug := range slugs {
+		s.blockURL(slug)
+	}
+
+	return nil
+}
+
+// CreateAPIKey adds a new API key of a user.
+func (s *Store) CreateAPIKey(_ context.Context, key models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apiKeys[key.ID] = key
+	s.apiKeyHashes[key.Hash] = key.ID
+
+	return nil
+}
+
+// ListAPIKeysByUserID returns all API keys of the specified user ordered by creation time.
+func (s *Store) ListAPIKeysByUserID(_ context.Context, userID string) ([]models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]models.APIKey, 0)
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			res = append(res, key)
+		}
+	}
+	slices.SortFunc(res, func(a, b models.APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	return res, nil
+}
+
+// GetAPIKeyByHash fetches API key by the hash of the key.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) GetAPIKeyByHash(_ context.Context, hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.apiKeyHashes[hash]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key: %w", store.ErrNotFound)
+	}
+
+	return s.apiKeys[id], nil
+}
+
+// RevokeAPIKey removes the API key of the specified user.
+//
+// It returns store.ErrNotFound if the user has no such key.
+func (s *Store) RevokeAPIKey(_ context.Context, userID, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[keyID]
+	if !ok || key.UserID != userID {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	delete(s.apiKeys, keyID)
+	delete(s.apiKeyHashes, key.Hash)
+
+	return nil
+}
+
+// TouchAPIKey records the time the API key was last used.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) TouchAPIKey(_ context.Context, keyID string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[keyID]
+	if !ok {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	key.LastUsedAt = &usedAt
+	s.apiKeys[keyID] = key
+
 	return nil
 }
 
+// RevokeToken adds the ID of an authentication token to the revocation list.
+//
+// It returns false if the token had already been revoked.
+func (s *Store) RevokeToken(_ context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedTokens[tokenID]; ok {
+		return false, nil
+	}
+	s.revokedTokens[tokenID] = expiresAt
+
+	return true, nil
+}
+
+// IsTokenRevoked reports whether the authentication token with the given ID has been revoked.
+func (s *Store) IsTokenRevoked(_ context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revokedTokens[tokenID]
+
+	return ok, nil
+}
+
+// DeleteExpiredRevokedTokens removes revocation list entries of tokens which expired before the given time.
+func (s *Store) DeleteExpiredRevokedTokens(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for tokenID, expiresAt := range s.revokedTokens {
+		if expiresAt.Before(before) {
+			delete(s.revokedTokens, tokenID)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// CreateDeleteJob adds a new job of deleting user URLs.
+func (s *Store) CreateDeleteJob(_ context.Context, job models.DeleteJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Slugs = slices.Clone(job.Slugs)
+	s.deleteJobs[job.ID] = job
+
+	return nil
+}
+
+// GetDeleteJob fetches the job of the specified user by ID.
+//
+// It returns store.ErrNotFound if the user has no such job.
+func (s *Store) GetDeleteJob(_ context.Context, userID, jobID string) (models.DeleteJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.deleteJobs[jobID]
+	if !ok || job.UserID != userID {
+		return models.DeleteJob{}, fmt.Errorf("delete job %s: %w", jobID, store.ErrNotFound)
+	}
+	job.Slugs = slices.Clone(job.Slugs)
+
+	return job, nil
+}
+
+// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time,
+// the oldest first, and postpones their next attempt until leaseUntil.
+func (s *Store) ClaimDeleteJobs(_ context.Context, now, leaseUntil time.Time, limit int) ([]models.DeleteJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]models.DeleteJob, 0)
+	for _, job := range s.deleteJobs {
+		if job.Status == models.DeleteJobPending && !job.NextAttemptAt.After(now) {
+			res = append(res, job)
+		}
+	}
+	slices.SortFunc(res, func(a, b models.DeleteJob) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	for i := range res {
+		res[i].NextAttemptAt = leaseUntil
+		s.deleteJobs[res[i].ID] = res[i]
+		res[i].Slugs = slices.Clone(res[i].Slugs)
+	}
+
+	return res, nil
+}
+
+// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+//
+// It returns store.ErrNotFound if there is no such job.
+func (s *Store) UpdateDeleteJob(_ context.Context, job models.DeleteJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.deleteJobs[job.ID]
+	if !ok {
+		return fmt.Errorf("delete job %s: %w", job.ID, store.ErrNotFound)
+	}
+
+	existing.Status = job.Status
+	existing.Attempts = job.Attempts
+	existing.LastError = job.LastError
+	existing.UpdatedAt = job.UpdatedAt
+	existing.NextAttemptAt = job.NextAttemptAt
+	s.deleteJobs[job.ID] = existing
+
+	return nil
+}
+
+// CountPendingDeleteJobs returns the number of pending jobs.
+func (s *Store) CountPendingDeleteJobs(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, job := range s.deleteJobs {
+		if job.Status == models.DeleteJobPending {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) DeleteFinishedDeleteJobs(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for id, job := range s.deleteJobs {
+		if job.Finished() && job.UpdatedAt.Before(before) {
+			delete(s.deleteJobs, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
 // Ping is a storage healthcheck.
 func (s *Store) Ping(_ context.Context) error {
 	// Nothing to ping here.
 	return nil
 }
+
+// Close is a no-op for in-memory storage.
+func (s *Store) Close() error {
+	return nil
+}
+
+// upsertURLs adds URLs independently of each other, s.mu must be held.
+func (s *Store) upsertURLs(userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	res := make([]store.UpsertResult, 0, len(urls))
+	for _, url := range urls {
+		if err := s.checkCanonical(url); err != nil {
+			var alreadyExists *store.AlreadyExistsError
+			if !errors.As(err, &alreadyExists) {
+				return nil, err
+			}
+			s.linkURLToUser(alreadyExists.URL.Slug, userID)
+			res = append(res, store.UpsertResult{URL: alreadyExists.URL})
+			continue
+		}
+		if err := s.checkSlug(url); err != nil {
+			res = append(res, store.UpsertResult{URL: url, Err: err})
+			continue
+		}
+
+		s.setURL(url)
+		s.linkURLToUser(url.Slug, userID)
+		res = append(res, store.UpsertResult{URL: url, Created: true})
+	}
+
+	return res, nil
+}
+
+// importChunkSize is the number of URLs added at once by ImportURLs.
+const importChunkSize = 1000
+
+// importUndo holds what an import has changed so far.
+type importUndo struct {
+	// created are slugs of the created URLs.
+	created []string
+	// linked are slugs of the existing URLs which have been linked to the user.
+	linked []string
+}
+
+// unlinkedDuplicates returns slugs of the existing URLs with the same canonical form
+// as urls which are not linked to the user yet, s.mu must be held.
+func (s *Store) unlinkedDuplicates(userID string, urls []models.URL) []string {
+	var slugs []string
+	for _, url := range urls {
+		if slug, ok := s.liveCanonical(url.DedupKey()); ok && !slices.Contains(s.urlUsers[slug], userID) && !slices.Contains(slugs, slug) {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	return slugs
+}
+
+// undoImport removes the URLs created and the links added by an incomplete import.
+func (s *Store) undoImport(userID string, undo importUndo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, slug := range undo.created {
+		s.removeURL(slug)
+	}
+	for _, slug := range undo.linked {
+		s.removeLink(slug, userID)
+	}
+}
+
+// checkSlug returns *store.SlugExistsError if the slug of url is taken.
+//
+// Slugs of dead URLs stay taken too, so that a new URL does not inherit
+// the owners and the clicks of a deleted one.
+func (s *Store) checkSlug(url models.URL) error {
+	if _, ok := s.urls[url.Slug]; ok {
+		return &store.SlugExistsError{Slug: url.Slug}
+	}
+
+	return nil
+}
+
+// checkCanonical returns *store.AlreadyExistsError if a live URL with the same canonical form exists.
+func (s *Store) checkCanonical(url models.URL) error {
+	slug, ok := s.liveCanonical(url.DedupKey())
+	if !ok {
+		return nil
+	}
+
+	return &store.AlreadyExistsError{
+		Err: fmt.Errorf("url %s has already been shortened", url.Original),
+		URL: s.urls[slug],
+	}
+}
+
+// liveCanonical returns the slug of the URL with the canonical form unless the URL is dead.
+func (s *Store) liveCanonical(key string) (string, bool) {
+	slug, ok := s.canonical[key]
+	if !ok || s.urls[slug].Dead(time.Now()) {
+		return "", false
+	}
+
+	return slug, true
+}
+
+// userURLList returns URLs created by the user which the user has not deleted.
+func (s *Store) userURLList(userID string) []models.URL {
+	deleted := s.deletedUserURLs[userID]
+	res := make([]models.URL, 0, len(s.userURLs[userID]))
+	for _, slug := range s.userURLs[userID] {
+		if _, ok := deleted[slug]; ok {
+			continue
+		}
+		if url, ok := s.urls[slug]; ok {
+			res = append(res, url)
+		}
+	}
+
+	return res
+}
+
+func (s *Store) linkURLToUser(slug, userID string) {
+	if slices.Contains(s.urlUsers[slug], userID) {
+		return
+	}
+
+	// The URL is set before it is linked, so its position is known.
+	slugs := s.userURLs[userID]
+	i, _ := slices.BinarySearchFunc(slugs, s.urls[slug], func(v string, url models.URL) int {
+		return models.CompareURLs(s.urls[v], url)
+	})
+	s.userURLs[userID] = slices.Insert(slugs, i, slug)
+	s.urlUsers[slug] = append(s.urlUsers[slug], userID)
+}
+
+// removeLink removes the link of the URL to the user, unlike unlinkURLFromUser
+// which marks it as deleted.
+func (s *Store) removeLink(slug, userID string) {
+	s.userURLs[userID] = slices.DeleteFunc(s.userURLs[userID], func(v string) bool { return v == slug })
+	if len(s.userURLs[userID]) == 0 {
+		delete(s.userURLs, userID)
+	}
+	s.urlUsers[slug] = slices.DeleteFunc(s.urlUsers[slug], func(v string) bool { return v == userID })
+	if len(s.urlUsers[slug]) == 0 {
+		delete(s.urlUsers, slug)
+	}
+	delete(s.deletedUserURLs[userID], slug)
+}
+
+// unlinkURLFromUser marks the URL as deleted by the user if the user has created it.
+//
+// The URL itself is marked as deleted when it has been deleted by all users who created it.
+func (s *Store) unlinkURLFromUser(slug, userID string) {
+	url, ok := s.urls[slug]
+	if !ok || !slices.Contains(s.urlUsers[slug], userID) {
+		return
+	}
+
+	if _, ok := s.deletedUserURLs[userID]; !ok {
+		s.deletedUserURLs[userID] = make(map[string]struct{})
+	}
+	s.deletedUserURLs[userID][slug] = struct{}{}
+
+	for _, ownerID := range s.urlUsers[slug] {
+		if _, ok := s.deletedUserURLs[ownerID][slug]; !ok {
+			return
+		}
+	}
+
+	if !url.Deleted {
+		url.Deleted = true
+		s.urls[slug] = url
+		s.deletedURLs++
+	}
+}
+
+func (s *Store) setURL(url models.URL) {
+	if existing, ok := s.urls[url.Slug]; ok && existing.Deleted {
+		s.deletedURLs--
+	}
+	if i, found := slices.BinarySearch(s.slugs, url.Slug); !found {
+		s.slugs = slices.Insert(s.slugs, i, url.Slug)
+	}
+	if url.Deleted {
+		s.deletedURLs++
+	}
+	s.urls[url.Slug] = url
+	// A dead URL keeps its canonical form until it is replaced by a new URL,
+	// it must not take the form back from that URL.
+	if slug, ok := s.canonical[url.DedupKey()]; !ok || slug == url.Slug || s.urls[slug].Dead(time.Now()) {
+		s.canonical[url.DedupKey()] = url.Slug
+	}
+	if url.ExpiresAt != nil {
+		s.expiring[url.Slug] = *url.ExpiresAt
+	} else {
+		delete(s.expiring, url.Slug)
+	}
+}
+
+// blockURL marks the URL as blocked if it exists.
+func (s *Store) blockURL(slug string) {
+	if url, ok := s.urls[slug]; ok {
+		url.Blocked = true
+		s.urls[slug] = url
+	}
+}
+
+// expiredSlugs returns up to limit slugs of URLs which expired before the given time.
+func (s *Store) expiredSlugs(before time.Time, limit int) []string {
+	var slugs []string
+	for slug, expiresAt := range s.expiring {
+		if len(slugs) >= limit {
+			break
+		}
+		if expiresAt.Before(before) {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	return slugs
+}
+
+// addClick adds the click if its URL exists.
+func (s *Store) addClick(click models.Click) {
+	if _, ok := s.urls[click.Slug]; ok {
+		s.clicks[click.Slug] = append(s.clicks[click.Slug], click)
+	}
+}
+
+// removeURL removes the URL and all its links to users.
+func (s *Store) removeURL(slug string) {
+	for _, userID := range s.urlUsers[slug] {
+		s.userURLs[userID] = slices.DeleteFunc(s.userURLs[userID], func(v string) bool { return v == slug })
+		if len(s.userURLs[userID]) == 0 {
+			delete(s.userURLs, userID)
+		}
+		delete(s.deletedUserURLs[userID], slug)
+	}
+
+	if url, ok := s.urls[slug]; ok {
+		if s.canonical[url.DedupKey()] == slug {
+			delete(s.canonical, url.DedupKey())
+		}
+		if url.Deleted {
+			s.deletedURLs--
+		}
+		if i, found := slices.BinarySearch(s.slugs, slug); found {
+			s.slugs = slices.Delete(s.slugs, i, i+1)
+		}
+	}
+
+	delete(s.urlUsers, slug)
+	delete(s.urls, slug)
+	delete(s.expiring, slug)
+	delete(s.clicks, slug)
+}
@@ -2,98 +2,64 @@ package memory
 
 import (
 	"context"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/storetest"
 	"github.com/madatsci/urlshortener/internal/random"
 )
 
-func TestCreateUser(t *testing.T) {
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return New()
+	})
+}
+
+func TestScanURLs(t *testing.T) {
 	s := New()
 	ctx := context.Background()
 
 	user := random.RandomUser()
-	err := s.CreateUser(ctx, user)
-	require.NoError(t, err)
+	past := time.Now().Add(-time.Minute)
+	urls := random.RandomURLs(5)
+	urls[2].ExpiresAt = &past
+	require.NoError(t, s.BatchCreateURL(ctx, user.ID, urls))
 
-	res, err := s.GetUser(ctx, user.ID)
+	// Removed URLs are not scanned.
+	n, err := s.DeleteExpiredURLs(ctx, time.Now(), 10)
 	require.NoError(t, err)
-	assert.Equal(t, user.ID, res.ID)
-	assert.Equal(t, user.CreatedAt, res.CreatedAt)
-}
+	require.Equal(t, 1, n)
 
-func TestCreateURL(t *testing.T) {
-	s := New()
-	ctx := context.Background()
-
-	urls := random.RandomURLs(3)
-	user := random.RandomUser()
-	for _, u := range urls {
-		err := s.CreateURL(ctx, user.ID, u)
-		require.NoError(t, err)
+	var expected []string
+	for i, url := range urls {
+		if i != 2 {
+			expected = append(expected, url.Slug)
+		}
 	}
+	slices.Sort(expected)
 
-	for _, u := range urls {
-		res, err := s.GetURL(ctx, u.Slug)
+	var scanned []string
+	for _, after := range []string{"", expected[1], expected[3]} {
+		page, err := s.ScanURLs(ctx, after, 2)
 		require.NoError(t, err)
-		assert.Equal(t, u.Original, res.Original)
-		assert.Equal(t, u.Slug, res.Slug)
-		assert.Equal(t, u.ID, res.ID)
-		assert.Equal(t, u.CorrelationID, res.CorrelationID)
-		assert.Equal(t, u.CreatedAt, res.CreatedAt)
+		for _, url := range page {
+			scanned = append(scanned, url.Slug)
+		}
 	}
+	assert.Equal(t, expected, scanned)
 
-	all, err := s.ListAllUrls(ctx)
-	require.NoError(t, err)
-	require.Equal(t, 3, len(all))
-}
-
-func TestBatchCreateURL(t *testing.T) {
-	s := New()
-	ctx := context.Background()
-
-	urls := random.RandomURLs(3)
-	user := random.RandomUser()
-	err := s.BatchCreateURL(ctx, user.ID, urls)
+	// A page may start after a slug which is not stored.
+	page, err := s.ScanURLs(ctx, urls[2].Slug, len(urls))
 	require.NoError(t, err)
-
-	for _, u := range urls {
-		res, getErr := s.GetURL(ctx, u.Slug)
-		require.NoError(t, getErr)
-		assert.Equal(t, u.Original, res.Original)
-		assert.Equal(t, u.Slug, res.Slug)
-		assert.Equal(t, u.CorrelationID, res.CorrelationID)
-		assert.Equal(t, u.ID, res.ID)
-		assert.Equal(t, u.CreatedAt, res.CreatedAt)
+	i, _ := slices.BinarySearch(expected, urls[2].Slug)
+	scanned = scanned[:0]
+	for _, url := range page {
+		scanned = append(scanned, url.Slug)
 	}
-
-	all, err := s.ListAllUrls(ctx)
-	require.NoError(t, err)
-	require.Equal(t, 3, len(all))
-}
-
-func TestListURLsByUserID(t *testing.T) {
-	s := New()
-	ctx := context.Background()
-
-	user1 := random.RandomUser()
-	user1URLs := random.RandomURLs(3)
-	user2 := random.RandomUser()
-	user2URLs := random.RandomURLs(2)
-
-	err := s.BatchCreateURL(ctx, user1.ID, user1URLs)
-	require.NoError(t, err)
-
-	err = s.BatchCreateURL(ctx, user2.ID, user2URLs)
-	require.NoError(t, err)
-
-	resURLs1, err := s.ListURLsByUserID(ctx, user1.ID)
-	require.NoError(t, err)
-	assert.Equal(t, 3, len(resURLs1))
-
-	resURLs2, err := s.ListURLsByUserID(ctx, user2.ID)
-	require.NoError(t, err)
-	assert.Equal(t, 2, len(resURLs2))
+	assert.Equal(t, expected[i:], scanned)
 }
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE urls (
+    id text PRIMARY KEY,
+    correlation_id text NOT NULL DEFAULT '',
+    slug text NOT NULL,
+    original_url text NOT NULL,
+    created_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX urls_slug ON urls (slug);
+CREATE UNIQUE INDEX urls_original_url ON urls (original_url);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE urls;
+-- +goose StatementEnd
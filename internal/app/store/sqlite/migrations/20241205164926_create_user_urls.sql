@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_urls (
+    id text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users(id),
+    url_id text NOT NULL REFERENCES urls(id),
+    is_deleted boolean NOT NULL DEFAULT false,
+    created_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX user_urls_user_id_url_id ON user_urls (user_id, url_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_urls;
+-- +goose StatementEnd
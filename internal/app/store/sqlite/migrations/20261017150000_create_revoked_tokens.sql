@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE revoked_tokens (
+    token_id text PRIMARY KEY,
+    expires_at timestamp NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE revoked_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls ADD COLUMN redirect_type smallint NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN merge_query boolean NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE urls DROP COLUMN merge_query;
+ALTER TABLE urls DROP COLUMN redirect_type;
+-- +goose StatementEnd
@@ -0,0 +1,1271 @@
+// Package sqlite is an implementation of storage which uses an embedded SQLite database.
+//
+// It does not require an external database server, which makes it a good fit
+// for single-instance deployments and tests.
+package sqlite
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pressly/goose/v3"
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/sqlquery"
+)
+
+//go:embed migrations/*.sql
+var embedMigrations embed.FS
+
+// upsertChunkSize is the number of URLs inserted by a single statement of BatchUpsertURLs.
+// It keeps the number of parameters below the limit of 32766.
+const upsertChunkSize = 1000
+
+// Store is an implementation of store.Store interface which interacts with SQLite database.
+//
+// Timestamps are stored as text in UTC, so that they can be compared as strings.
+//
+// Use New to create an instance of Store.
+type Store struct {
+	conn *sql.DB
+}
+
+// New creates a new SQLite-driven storage.
+func New(ctx context.Context, conn *sql.DB) (*Store, error) {
+	store := &Store{conn: conn}
+	if err := store.bootstrap(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// CreateUser registers new user.
+func (s *Store) CreateUser(ctx context.Context, user models.User) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		sqlquery.InsertUser,
+		user.ID,
+		user.CreatedAt.UTC(),
+	)
+
+	return err
+}
+
+// GetUser fetches user by ID.
+//
+// It returns error if user is not found.
+func (s *Store) GetUser(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		sqlquery.GetUser,
+		userID,
+	).Scan(&user.ID, &user.CreatedAt)
+
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// CreateURL adds a new URL to the storage.
+//
+// It also links the URL to the current user. It returns *store.SlugExistsError
+// if a different URL with the same slug already exists. If a URL with the same
+// canonical form already exists, it links that URL to the user instead and
+// returns *store.AlreadyExistsError.
+func (s *Store) CreateURL(ctx context.Context, userID string, url models.URL) error {
+	if err := releaseDeadCanonicals(ctx, s.conn, []any{url.DedupKey()}, time.Now()); err != nil {
+		return err
+	}
+
+	existing, err := getURLByCanonical(ctx, s.conn, url.DedupKey())
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = s.conn.ExecContext(
+			ctx,
+			sqlquery.InsertURL,
+			url.ID,
+			url.CorrelationID,
+			url.Slug,
+			url.Original,
+			url.DedupKey(),
+			url.CreatedAt.UTC(),
+			utc(url.ExpiresAt),
+			url.RedirectType,
+			url.MergeQuery,
+		)
+		if err == nil {
+			return s.linkURLtoUser(ctx, url, userID)
+		}
+		if !isUniqueViolation(err, "urls.canonical_url") {
+			return slugError(err, url.Slug)
+		}
+
+		// The same URL has been created by a concurrent request.
+		existing, err = getURLByCanonical(ctx, s.conn, url.DedupKey())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.linkURLtoUser(ctx, existing, userID); err != nil && !isConstraintViolation(err) {
+		return err
+	}
+
+	return &store.AlreadyExistsError{
+		Err: fmt.Errorf("url %s has already been shortened", url.Original),
+		URL: existing,
+	}
+}
+
+// BatchCreateURL adds a batch of URLs to the storage.
+//
+// It also links the created URLs to the current user. If any of the slugs
+// is already taken, it returns *store.SlugExistsError and adds nothing.
+// If any of the URLs has already been shortened, it returns *store.AlreadyExistsError
+// and adds nothing.
+func (s *Store) BatchCreateURL(ctx context.Context, userID string, urls []models.URL) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	canonicals := make([]any, 0, len(urls))
+	for _, url := range urls {
+		canonicals = append(canonicals, url.DedupKey())
+	}
+	if err = releaseDeadCanonicals(ctx, tx, canonicals, time.Now()); err != nil {
+		return err
+	}
+
+	urlStmt, err := tx.PrepareContext(ctx, sqlquery.InsertURL)
+	if err != nil {
+		return err
+	}
+	defer urlStmt.Close()
+
+	userURLStmt, err := tx.PrepareContext(ctx, sqlquery.InsertUserURL)
+	if err != nil {
+		return err
+	}
+	defer userURLStmt.Close()
+
+	for _, url := range urls {
+		_, err := urlStmt.ExecContext(ctx, url.ID, url.CorrelationID, url.Slug, url.Original, url.DedupKey(), url.CreatedAt.UTC(), utc(url.ExpiresAt), url.RedirectType, url.MergeQuery)
+		if err != nil {
+			if isUniqueViolation(err, "urls.canonical_url") {
+				// The only connection is held by the transaction, so the URL is looked up in it.
+				if existing, getErr := getURLByCanonical(ctx, tx, url.DedupKey()); getErr == nil {
+					return &store.AlreadyExistsError{Err: err, URL: existing}
+				}
+			}
+			return slugError(err, url.Slug)
+		}
+
+		_, err = userURLStmt.ExecContext(ctx, uuid.NewString(), userID, url.ID, false, time.Now().UTC(), url.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BatchUpsertURLs adds URLs of a batch independently of each other and links them to the user.
+//
+// URLs are inserted in chunks with INSERT ... ON CONFLICT DO NOTHING, so that a URL
+// which has already been shortened or has a taken slug does not abort the batch.
+// The existing URLs with the same canonical form are linked to the user instead,
+// URLs whose slug is taken by a different URL are reported with *store.SlugExistsError.
+func (s *Store) BatchUpsertURLs(ctx context.Context, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res := make([]store.UpsertResult, 0, len(urls))
+	for chunk := range slices.Chunk(urls, upsertChunkSize) {
+		chunkRes, err := upsertURLs(ctx, tx, userID, chunk)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, chunkRes...)
+	}
+
+	return res, tx.Commit()
+}
+
+// ImportURLs adds URLs read from the stream like BatchUpsertURLs.
+//
+// SQLite has a single connection, so the stream is spooled to a temporary file first.
+// The connection is only held while the spooled URLs are inserted in chunks within
+// a single transaction, not while the stream is read, e.g. from a slow client.
+// If the stream fails, nothing is imported.
+func (s *Store) ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (store.ImportResult, error) {
+	var res store.ImportResult
+
+	spool, err := spoolURLs(urls)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	flush := func(chunk []models.URL) error {
+		results, err := upsertURLs(ctx, tx, userID, chunk)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			res.Add(result)
+		}
+		return nil
+	}
+
+	chunk := make([]models.URL, 0, upsertChunkSize)
+	dec := json.NewDecoder(bufio.NewReader(spool))
+	for {
+		var url models.URL
+		if err := dec.Decode(&url); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return store.ImportResult{}, err
+		}
+		chunk = append(chunk, url)
+		if len(chunk) == upsertChunkSize {
+			if err := flush(chunk); err != nil {
+				return store.ImportResult{}, err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		if err := flush(chunk); err != nil {
+			return store.ImportResult{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return store.ImportResult{}, err
+	}
+
+	return res, nil
+}
+
+// GetURL retrieves a URL by its slug from the storage.
+//
+// It returns store.ErrNotFound if URL is not found.
+func (s *Store) GetURL(ctx context.Context, slug string) (models.URL, error) {
+	var url models.URL
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		sqlquery.GetURLBySlug,
+		slug,
+	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return url, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+	if err != nil {
+		return url, err
+	}
+
+	return url, nil
+}
+
+// ListURLsByUserID returns all URLs created by the specified user.
+func (s *Store) ListURLsByUserID(ctx context.Context, userID string) ([]models.URL, error) {
+	res := make([]models.URL, 0)
+
+	rows, err := s.conn.QueryContext(
+		ctx,
+		sqlquery.ListURLsByUserID,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, url)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListUserURLs returns a page of URLs created by the specified user which match the query.
+//
+// Pages are selected with a keyset condition on (created_at, id) of the URLs. Both are
+// copied to the links of the user, so that pages are read from the index of the user
+// and deep pages are as cheap as the first one.
+func (s *Store) ListUserURLs(ctx context.Context, userID string, q models.URLQuery) ([]models.URL, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "?"
+	}
+
+	where := []string{"uu.user_id = " + arg(userID), "NOT uu.is_deleted"}
+	if q.Search != "" {
+		where = append(where, "u.original_url LIKE "+arg("%"+escapeLike(q.Search)+"%")+` ESCAPE '\'`)
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "uu.url_created_at >= "+arg(q.CreatedFrom.UTC()))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "uu.url_created_at < "+arg(q.CreatedTo.UTC()))
+	}
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(uu.url_created_at, uu.url_id) %s (%s, %s)", cmp, arg(q.After.CreatedAt.UTC()), arg(q.After.ID)))
+	}
+
+	query := `SELECT u.id, u.correlation_id, u.slug, u.original_url, u.canonical_url, u.created_at, u.is_deleted, u.is_blocked, u.expires_at, u.redirect_type, u.merge_query
+		FROM user_urls uu JOIN urls u ON u.id = uu.url_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY uu.url_created_at ` + order + `, uu.url_id ` + order
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.URL, 0, q.Limit)
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, url)
+	}
+
+	return res, rows.Err()
+}
+
+// ListAllUrls returns the full map of stored URLs.
+//
+// This function should not be used in production.
+func (s *Store) ListAllUrls(ctx context.Context) (map[string]models.URL, error) {
+	res := make(map[string]models.URL, 0)
+
+	rows, err := s.conn.QueryContext(ctx, sqlquery.ListAllURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res[url.Slug] = url
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CountURLs returns the number of stored URLs which are not deleted.
+func (s *Store) CountURLs(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, sqlquery.CountURLs).Scan(&n)
+
+	return n, err
+}
+
+// CountUsers returns the number of registered users.
+func (s *Store) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, sqlquery.CountUsers).Scan(&n)
+
+	return n, err
+}
+
+// SoftDeleteURL marks URLs as deleted.
+func (s *Store) SoftDeleteURL(ctx context.Context, userID string, slug string) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var urlID string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM urls WHERE slug = ?", slug).Scan(&urlID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		sqlquery.DeleteUserURL,
+		userID,
+		urlID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var linksCount int
+	err = tx.QueryRowContext(
+		ctx,
+		sqlquery.CountURLLinks,
+		urlID,
+	).Scan(&linksCount)
+	if err != nil {
+		return err
+	}
+
+	if linksCount == 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			sqlquery.DeleteURL,
+			urlID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+//
+// Slugs which do not exist or were not created by the user are skipped.
+// A URL is marked as deleted when it has been deleted by all users who created it.
+func (s *Store) BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(slugs)), ", ")
+	args := make([]any, 0, len(slugs)+1)
+	args = append(args, userID)
+	for _, slug := range slugs {
+		args = append(args, slug)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE user_urls SET is_deleted = true
+		WHERE user_id = ? AND NOT is_deleted AND url_id IN (SELECT id FROM urls WHERE slug IN (`+in+`))`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE urls SET is_deleted = true
+		WHERE slug IN (`+in+`) AND is_deleted IS NOT TRUE
+		AND NOT EXISTS (SELECT 1 FROM user_urls WHERE url_id = urls.id AND NOT is_deleted)`,
+		args[1:]...,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateClicks adds a batch of clicks to the storage.
+//
+// Clicks of URLs which do not exist anymore are skipped.
+func (s *Store) CreateClicks(ctx context.Context, clicks []models.Click) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.PrepareContext(ctx, sqlquery.InsertClick)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ID, click.CreatedAt.UTC(), click.Referrer, click.UserAgent, click.IPHash, click.URLID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetURLStats returns click statistics of the URL created by the specified user.
+//
+// It returns store.ErrNotFound if there is no such URL, it was not created
+// by the user or it was deleted by the user.
+func (s *Store) GetURLStats(ctx context.Context, userID, slug string) (models.URLStats, error) {
+	stats := models.URLStats{
+		Slug:  slug,
+		Daily: []models.DailyStats{},
+	}
+
+	var urlID string
+	err := s.conn.QueryRowContext(
+		ctx,
+		sqlquery.GetUserURLID,
+		slug,
+		userID,
+	).Scan(&urlID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stats, fmt.Errorf("url %s: %w", slug, store.ErrNotFound)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	err = s.conn.QueryRowContext(
+		ctx,
+		sqlquery.CountClicks,
+		urlID,
+	).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+
+	// Timestamps are stored in UTC, so date() returns the day in UTC.
+	rows, err := s.conn.QueryContext(
+		ctx,
+		"SELECT date(created_at) AS day, COUNT(id), COUNT(DISTINCT ip_hash) FROM clicks WHERE url_id = ? GROUP BY day ORDER BY day",
+		urlID,
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dayStats models.DailyStats
+		if err = rows.Scan(&dayStats.Date, &dayStats.Clicks, &dayStats.UniqueVisitors); err != nil {
+			return stats, err
+		}
+		stats.Daily = append(stats.Daily, dayStats)
+	}
+
+	return stats, rows.Err()
+}
+
+// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+func (s *Store) DeleteExpiredURLs(ctx context.Context, before time.Time, limit int) (int, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id FROM urls WHERE expires_at < ? ORDER BY expires_at LIMIT ?",
+		before.UTC(),
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []any
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	// Rows must be closed before the connection can be used for another query.
+	rows.Close()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_urls WHERE url_id IN ("+placeholders+")", ids...); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM urls WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+func (s *Store) ScanURLs(ctx context.Context, afterSlug string, limit int) ([]models.URL, error) {
+	rows, err := s.conn.QueryContext(
+		ctx,
+		sqlquery.ScanURLs,
+		afterSlug,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []models.URL
+	for rows.Next() {
+		var url models.URL
+		err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, url)
+	}
+
+	return res, rows.Err()
+}
+
+// BlockURLs marks URLs with the given slugs as blocked.
+//
+// Slugs which do not exist are skipped.
+func (s *Store) BlockURLs(ctx context.Context, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(slugs)), ", ")
+	args := make([]any, 0, len(slugs))
+	for _, slug := range slugs {
+		args = append(args, slug)
+	}
+
+	_, err := s.conn.ExecContext(ctx, "UPDATE urls SET is_blocked = true WHERE NOT is_blocked AND slug IN ("+in+")", args...)
+
+	return err
+}
+
+// CreateAPIKey adds a new API key of a user.
+func (s *Store) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		sqlquery.InsertAPIKey,
+		key.ID,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		key.CreatedAt.UTC(),
+	)
+
+	return err
+}
+
+// ListAPIKeysByUserID returns all API keys of the specified user ordered by creation time.
+func (s *Store) ListAPIKeysByUserID(ctx context.Context, userID string) ([]models.APIKey, error) {
+	res := make([]models.APIKey, 0)
+
+	rows, err := s.conn.QueryContext(
+		ctx,
+		sqlquery.ListAPIKeys,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key models.APIKey
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetAPIKeyByHash fetches API key by the hash of the key.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		sqlquery.GetAPIKeyByHash,
+		hash,
+	).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.LastUsedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, fmt.Errorf("api key: %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// RevokeAPIKey removes the API key of the specified user.
+//
+// It returns store.ErrNotFound if the user has no such key.
+func (s *Store) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	res, err := s.conn.ExecContext(ctx, sqlquery.DeleteAPIKey, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	return keyAffected(res, keyID)
+}
+
+// TouchAPIKey records the time the API key was last used.
+//
+// It returns store.ErrNotFound if there is no such key.
+func (s *Store) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	res, err := s.conn.ExecContext(ctx, sqlquery.TouchAPIKey, usedAt.UTC(), keyID)
+	if err != nil {
+		return err
+	}
+
+	return keyAffected(res, keyID)
+}
+
+// RevokeToken adds the ID of an authentication token to the revocation list.
+//
+// It returns false if the token had already been revoked.
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	res, err := s.conn.ExecContext(
+		ctx,
+		sqlquery.RevokeToken,
+		tokenID,
+		expiresAt.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// IsTokenRevoked reports whether the authentication token with the given ID has been revoked.
+func (s *Store) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+
+	err := s.conn.QueryRowContext(
+		ctx,
+		sqlquery.IsTokenRevoked,
+		tokenID,
+	).Scan(&revoked)
+
+	return revoked, err
+}
+
+// DeleteExpiredRevokedTokens removes revocation list entries of tokens which expired before the given time.
+func (s *Store) DeleteExpiredRevokedTokens(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, sqlquery.DeleteExpiredRevokedTokens, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+// CreateDeleteJob adds a new job of deleting user URLs.
+func (s *Store) CreateDeleteJob(ctx context.Context, job models.DeleteJob) error {
+	slugs, err := json.Marshal(job.Slugs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.ExecContext(
+		ctx,
+		sqlquery.InsertDeleteJob,
+		job.ID,
+		job.UserID,
+		string(slugs),
+		string(job.Status),
+		job.Attempts,
+		job.LastError,
+		job.CreatedAt.UTC(),
+		job.UpdatedAt.UTC(),
+		job.NextAttemptAt.UTC(),
+	)
+
+	return err
+}
+
+// GetDeleteJob fetches the job of the specified user by ID.
+//
+// It returns store.ErrNotFound if the user has no such job.
+func (s *Store) GetDeleteJob(ctx context.Context, userID, jobID string) (models.DeleteJob, error) {
+	row := s.conn.QueryRowContext(
+		ctx,
+		"SELECT "+deleteJobColumns+" FROM delete_jobs WHERE id = ? AND user_id = ?",
+		jobID,
+		userID,
+	)
+
+	job, err := scanDeleteJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, fmt.Errorf("delete job %s: %w", jobID, store.ErrNotFound)
+	}
+
+	return job, err
+}
+
+// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time,
+// the oldest first, and postpones their next attempt until leaseUntil.
+//
+// SQLite runs one write at a time, so a single UPDATE claims every job once.
+func (s *Store) ClaimDeleteJobs(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.DeleteJob, error) {
+	rows, err := s.conn.QueryContext(
+		ctx,
+		`UPDATE delete_jobs SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM delete_jobs
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY created_at
+			LIMIT ?
+		)
+		RETURNING `+deleteJobColumns,
+		leaseUntil.UTC(),
+		string(models.DeleteJobPending),
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.DeleteJob, 0)
+	for rows.Next() {
+		job, err := scanDeleteJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	slices.SortFunc(res, func(a, b models.DeleteJob) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	return res, nil
+}
+
+// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+//
+// It returns store.ErrNotFound if there is no such job.
+func (s *Store) UpdateDeleteJob(ctx context.Context, job models.DeleteJob) error {
+	res, err := s.conn.ExecContext(
+		ctx,
+		sqlquery.UpdateDeleteJob,
+		string(job.Status),
+		job.Attempts,
+		job.LastError,
+		job.UpdatedAt.UTC(),
+		job.NextAttemptAt.UTC(),
+		job.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("delete job %s: %w", job.ID, store.ErrNotFound)
+	}
+
+	return nil
+}
+
+// CountPendingDeleteJobs returns the number of pending jobs.
+func (s *Store) CountPendingDeleteJobs(ctx context.Context) (int, error) {
+	var n int
+	err := s.conn.QueryRowContext(ctx, sqlquery.CountDeleteJobs, string(models.DeleteJobPending)).Scan(&n)
+
+	return n, err
+}
+
+// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+func (s *Store) DeleteFinishedDeleteJobs(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.conn.ExecContext(ctx, sqlquery.DeleteFinishedDeleteJobs, string(models.DeleteJobPending), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+// Ping is a storage healthcheck.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.conn.PingContext(ctx)
+}
+
+// Close closes the underlying database connection.
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Store) bootstrap() error {
+	goose.SetBaseFS(embedMigrations)
+
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return err
+	}
+
+	if err := goose.Up(s.conn, "migrations"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// upsertURLs inserts a chunk of URLs of BatchUpsertURLs and links them to the user.
+func upsertURLs(ctx context.Context, tx *sql.Tx, userID string, urls []models.URL) ([]store.UpsertResult, error) {
+	values := make([]string, 0, len(urls))
+	args := make([]any, 0, 9*len(urls))
+	canonicals := make([]any, 0, len(urls))
+	for _, url := range urls {
+		values = append(values, placeholders(9))
+		args = append(args, url.ID, url.CorrelationID, url.Slug, url.Original, url.DedupKey(), url.CreatedAt.UTC(), utc(url.ExpiresAt), url.RedirectType, url.MergeQuery)
+		canonicals = append(canonicals, url.DedupKey())
+	}
+
+	if err := releaseDeadCanonicals(ctx, tx, canonicals, time.Now()); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`INSERT INTO urls (id, correlation_id, slug, original_url, canonical_url, created_at, expires_at, redirect_type, merge_query)
+		VALUES `+strings.Join(values, ", ")+` ON CONFLICT DO NOTHING RETURNING id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := make(map[string]struct{}, len(urls))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		created[id] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// Rows must be closed before the connection can be used for another query.
+	rows.Close()
+
+	// URLs which have not been inserted have either been shortened or have a taken slug.
+	var duplicates []any
+	for _, url := range urls {
+		if _, ok := created[url.ID]; !ok {
+			duplicates = append(duplicates, url.DedupKey())
+		}
+	}
+	existing := make(map[string]models.URL, len(duplicates))
+	if len(duplicates) > 0 {
+		rows, err = tx.QueryContext(
+			ctx,
+			"SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls WHERE canonical_url IN "+placeholders(len(duplicates))+" AND is_canonical",
+			duplicates...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var url models.URL
+			err = rows.Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+			if err != nil {
+				return nil, err
+			}
+			existing[url.Canonical] = url
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+	}
+
+	res := make([]store.UpsertResult, 0, len(urls))
+	links := make([]string, 0, len(urls))
+	linkArgs := make([]any, 0, 6*len(urls))
+	now := time.Now().UTC()
+	for _, url := range urls {
+		result := store.UpsertResult{URL: url, Created: true}
+		if _, ok := created[url.ID]; !ok {
+			if e, ok := existing[url.DedupKey()]; ok {
+				result = store.UpsertResult{URL: e}
+			} else {
+				result = store.UpsertResult{URL: url, Err: &store.SlugExistsError{Slug: url.Slug}}
+			}
+		}
+		res = append(res, result)
+
+		if result.Err == nil {
+			links = append(links, placeholders(6))
+			linkArgs = append(linkArgs, uuid.NewString(), userID, result.URL.ID, false, now, result.URL.CreatedAt.UTC())
+		}
+	}
+
+	if len(links) > 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at, url_created_at)
+			VALUES `+strings.Join(links, ", ")+` ON CONFLICT (user_id, url_id) DO NOTHING`,
+			linkArgs...,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// placeholders returns a row of n placeholders, e.g. (?, ?).
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// releaseDeadCanonicals releases the canonical forms of expired, deleted and blocked URLs,
+// so that new URLs can be created with them instead of being linked to the dead ones.
+func releaseDeadCanonicals(ctx context.Context, e execer, canonicals []any, now time.Time) error {
+	_, err := e.ExecContext(
+		ctx,
+		`UPDATE urls SET is_canonical = false
+		WHERE (is_deleted OR is_blocked OR expires_at <= ?)
+		AND is_canonical AND canonical_url IN `+placeholders(len(canonicals)),
+		append([]any{now.UTC()}, canonicals...)...,
+	)
+
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getURLByCanonical(ctx context.Context, q rowQuerier, canonicalURL string) (models.URL, error) {
+	var url models.URL
+
+	err := q.QueryRowContext(
+		ctx,
+		sqlquery.GetURLByCanonical,
+		canonicalURL,
+	).Scan(&url.ID, &url.CorrelationID, &url.Slug, &url.Original, &url.Canonical, &url.CreatedAt, &url.Deleted, &url.Blocked, &url.ExpiresAt, &url.RedirectType, &url.MergeQuery)
+
+	if err != nil {
+		return url, err
+	}
+
+	return url, nil
+}
+
+func (s *Store) linkURLtoUser(ctx context.Context, url models.URL, userID string) error {
+	userURL := models.UserURL{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		URLID:     url.ID,
+		Deleted:   false,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := s.conn.ExecContext(
+		ctx,
+		sqlquery.InsertUserURL,
+		userURL.ID,
+		userURL.UserID,
+		userURL.URLID,
+		userURL.Deleted,
+		userURL.CreatedAt,
+		url.CreatedAt.UTC(),
+	)
+
+	return err
+}
+
+// slugError converts unique violation of urls.slug into *store.SlugExistsError.
+func slugError(err error, slug string) error {
+	if isUniqueViolation(err, "urls.slug") {
+		return &store.SlugExistsError{Slug: slug}
+	}
+
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique violation of the column, e.g. urls.slug.
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr *sqlitedriver.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), column)
+}
+
+func isConstraintViolation(err error) bool {
+	var sqliteErr *sqlitedriver.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT
+}
+
+// utc converts optional time to UTC, so that it is stored in the same format as other timestamps.
+func utc(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return t.UTC()
+}
+
+// keyAffected returns store.ErrNotFound if the statement did not affect the API key.
+func keyAffected(res sql.Result, keyID string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("api key %s: %w", keyID, store.ErrNotFound)
+	}
+
+	return nil
+}
+
+// escapeLike escapes wildcards of the LIKE pattern with a backslash.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// deleteJobColumns are the columns of delete_jobs in the order of scanDeleteJob.
+const deleteJobColumns = "id, user_id, slugs, status, attempts, last_error, created_at, updated_at, next_attempt_at"
+
+// scanDeleteJob scans a row of deleteJobColumns.
+func scanDeleteJob(row interface{ Scan(dest ...any) error }) (models.DeleteJob, error) {
+	var job models.DeleteJob
+	var slugs []byte
+
+	err := row.Scan(&job.ID, &job.UserID, &slugs, &job.Status, &job.Attempts, &job.LastError, &job.CreatedAt, &job.UpdatedAt, &job.NextAttemptAt)
+	if err != nil {
+		return job, err
+	}
+
+	return job, json.Unmarshal(slugs, &job.Slugs)
+}
+
+// spoolURLs writes URLs read from the stream to a temporary file as JSON lines
+// and returns the file positioned at its start. The caller removes the file.
+func spoolURLs(urls iter.Seq2[models.URL, error]) (*os.File, error) {
+	file, err := os.CreateTemp("", "urlshortener-import-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for url, err := range urls {
+		if err != nil {
+			return fail(err)
+		}
+		if err := enc.Encode(url); err != nil {
+			return fail(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/database"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/app/store/storetest"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+func newTestStore(ctx context.Context) (*Store, error) {
+	conn, err := database.NewSQLiteClient(ctx, database.SQLiteScheme+":memory:")
+	if err != nil {
+		return nil, err
+	}
+
+	return New(ctx, conn)
+}
+
+func cleanup(s *Store) error {
+	for _, table := range []string{"revoked_tokens", "api_keys", "clicks", "user_urls", "users", "urls"} {
+		if _, err := s.conn.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := newTestStore(context.Background())
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		return s
+	})
+}
+
+func BenchmarkCreateUser(b *testing.B) {
+	ctx := context.Background()
+	s, err := newTestStore(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup(s)
+
+	b.Run("create user", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			user := random.RandomUser()
+			b.StartTimer()
+
+			s.CreateUser(ctx, user)
+		}
+	})
+}
+
+func BenchmarkCreateURL(b *testing.B) {
+	ctx := context.Background()
+	s, err := newTestStore(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup(s)
+
+	b.Run("new URL", func(b *testing.B) {
+		user := random.RandomUser()
+		err = s.CreateUser(ctx, user)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			url := random.RandomURL()
+			b.StartTimer()
+
+			s.CreateURL(ctx, user.ID, url)
+		}
+	})
+
+	b.Run("existing URL", func(b *testing.B) {
+		user := random.RandomUser()
+		err := s.CreateUser(ctx, user)
+		require.NoError(b, err)
+
+		url := random.RandomURL()
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			user := random.RandomUser()
+			err := s.CreateUser(ctx, user)
+			require.NoError(b, err)
+			b.StartTimer()
+
+			s.CreateURL(ctx, user.ID, url)
+		}
+	})
+}
+
+func BenchmarkBatchCreateURL(b *testing.B) {
+	ctx := context.Background()
+	s, err := newTestStore(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup(s)
+
+	b.Run("batch create URL", func(b *testing.B) {
+		user := random.RandomUser()
+		err := s.CreateUser(ctx, user)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			urls := random.RandomURLs(10)
+			b.StartTimer()
+
+			s.BatchCreateURL(ctx, user.ID, urls)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+// Package sqlquery holds SQL statements which are shared by the PostgreSQL and SQLite storages.
+//
+// Statements are written with ? placeholders. Use Postgres to rewrite them for PostgreSQL.
+package sqlquery
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Statements of the storages.
+const (
+	// InsertUser adds a user.
+	InsertUser = "INSERT INTO users (id, created_at) VALUES (?, ?)"
+	// GetUser fetches a user by ID.
+	GetUser = "SELECT id, created_at FROM users WHERE id = ?"
+	// InsertURL adds a URL.
+	InsertURL = "INSERT INTO urls (id, correlation_id, slug, original_url, canonical_url, created_at, expires_at, redirect_type, merge_query) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	// InsertUserURL links a URL to a user.
+	InsertUserURL = "INSERT INTO user_urls (id, user_id, url_id, is_deleted, created_at, url_created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	// GetURLBySlug fetches a URL by slug.
+	GetURLBySlug = "SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls WHERE slug = ?"
+	// ListURLsByUserID lists URLs of a user which the user has not deleted.
+	ListURLsByUserID = "SELECT u.id, u.correlation_id, u.slug, u.original_url, u.canonical_url, u.created_at, u.is_deleted, u.is_blocked, u.expires_at, u.redirect_type, u.merge_query FROM user_urls uu JOIN urls u ON u.id = uu.url_id WHERE uu.user_id = ? AND NOT uu.is_deleted ORDER BY uu.url_created_at, uu.url_id"
+	// ListAllURLs lists all URLs.
+	ListAllURLs = "SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls"
+	// CountURLs counts URLs which are not deleted.
+	CountURLs = "SELECT COUNT(*) FROM urls WHERE is_deleted IS NOT TRUE"
+	// CountUsers counts users.
+	CountUsers = "SELECT COUNT(*) FROM users"
+	// DeleteUserURL marks the link of a URL to a user as deleted.
+	DeleteUserURL = "UPDATE user_urls SET is_deleted = true WHERE user_id = ? AND url_id = ?"
+	// CountURLLinks counts users who have not deleted a URL.
+	CountURLLinks = "SELECT COUNT(id) FROM user_urls WHERE url_id = ? AND NOT is_deleted"
+	// DeleteURL marks a URL as deleted.
+	DeleteURL = "UPDATE urls SET is_deleted = true WHERE id = ?"
+	// InsertClick adds a click of a URL unless the URL has been removed. The URL ID is the last parameter.
+	InsertClick = "INSERT INTO clicks (id, url_id, created_at, referrer, user_agent, ip_hash) SELECT ?, id, ?, ?, ?, ? FROM urls WHERE id = ?"
+	// GetUserURLID fetches the ID of a URL by slug if the user has created it.
+	GetUserURLID = "SELECT urls.id FROM urls JOIN user_urls ON user_urls.url_id = urls.id WHERE urls.slug = ? AND user_urls.user_id = ? AND NOT user_urls.is_deleted"
+	// CountClicks counts clicks and unique visitors of a URL.
+	CountClicks = "SELECT COUNT(id), COUNT(DISTINCT ip_hash) FROM clicks WHERE url_id = ?"
+	// ScanURLs lists URLs after a slug ordered by slug.
+	ScanURLs = "SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls WHERE slug > ? ORDER BY slug LIMIT ?"
+	// InsertAPIKey adds an API key.
+	InsertAPIKey = "INSERT INTO api_keys (id, user_id, name, prefix, hash, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	// ListAPIKeys lists API keys of a user.
+	ListAPIKeys = "SELECT id, user_id, name, prefix, hash, created_at, last_used_at FROM api_keys WHERE user_id = ? ORDER BY created_at"
+	// GetAPIKeyByHash fetches an API key by its hash.
+	GetAPIKeyByHash = "SELECT id, user_id, name, prefix, hash, created_at, last_used_at FROM api_keys WHERE hash = ?"
+	// DeleteAPIKey removes an API key of a user.
+	DeleteAPIKey = "DELETE FROM api_keys WHERE id = ? AND user_id = ?"
+	// TouchAPIKey records the time an API key was last used.
+	TouchAPIKey = "UPDATE api_keys SET last_used_at = ? WHERE id = ?"
+	// RevokeToken adds a token to the revocation list unless it is there.
+	RevokeToken = "INSERT INTO revoked_tokens (token_id, expires_at) VALUES (?, ?) ON CONFLICT (token_id) DO NOTHING"
+	// IsTokenRevoked checks whether a token is revoked.
+	IsTokenRevoked = "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = ?)"
+	// DeleteExpiredRevokedTokens removes revocation list entries of expired tokens.
+	DeleteExpiredRevokedTokens = "DELETE FROM revoked_tokens WHERE expires_at < ?"
+	// InsertDeleteJob adds a job of deleting user URLs.
+	InsertDeleteJob = "INSERT INTO delete_jobs (id, user_id, slugs, status, attempts, last_error, created_at, updated_at, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	// UpdateDeleteJob saves the state of a delete job.
+	UpdateDeleteJob = "UPDATE delete_jobs SET status = ?, attempts = ?, last_error = ?, updated_at = ?, next_attempt_at = ? WHERE id = ?"
+	// CountDeleteJobs counts delete jobs with a status.
+	CountDeleteJobs = "SELECT COUNT(*) FROM delete_jobs WHERE status = ?"
+	// DeleteFinishedDeleteJobs removes jobs which finished before a time.
+	DeleteFinishedDeleteJobs = "DELETE FROM delete_jobs WHERE status <> ? AND updated_at < ?"
+	// GetURLByCanonical fetches the URL which holds a canonical form.
+	GetURLByCanonical = "SELECT id, correlation_id, slug, original_url, canonical_url, created_at, is_deleted, is_blocked, expires_at, redirect_type, merge_query FROM urls WHERE canonical_url = ? AND is_canonical"
+)
+
+// Postgres rewrites ? placeholders of the statement as $1, $2 and so on.
+//
+// Statements must not contain question marks other than placeholders.
+func Postgres(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+
+	return b.String()
+}
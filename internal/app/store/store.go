@@ -4,6 +4,10 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
 
 	"github.com/madatsci/urlshortener/internal/app/models"
 )
@@ -17,28 +21,142 @@ type Store interface {
 	GetUser(ctx context.Context, userID string) (models.User, error)
 
 	// CreateURL adds a new URL to the storage.
+	// It returns *SlugExistsError if a different URL with the same slug already exists.
+	// If a URL with the same canonical form already exists, it links that URL
+	// to the user and returns *AlreadyExistsError. Dead URLs (see models.URL.Dead)
+	// are not duplicates, a new URL is created instead of them, but their slugs stay taken.
 	CreateURL(ctx context.Context, userID string, url models.URL) error
 
 	// BatchCreateURL adds a batch of URLs to the storage.
+	// It returns *SlugExistsError and adds nothing if any of the slugs is already taken,
+	// and *AlreadyExistsError if any of the URLs has already been shortened.
 	BatchCreateURL(ctx context.Context, userID string, urls []models.URL) error
 
+	// BatchUpsertURLs adds URLs of a batch independently of each other and links them to the user.
+	// URLs which have already been shortened are not added, the existing URLs are linked instead.
+	// It returns a result per URL in the order of urls.
+	BatchUpsertURLs(ctx context.Context, userID string, urls []models.URL) ([]UpsertResult, error)
+
+	// ImportURLs adds URLs read from the stream and links them to the user like BatchUpsertURLs,
+	// without holding the whole stream in memory.
+	// Either all URLs of the stream are imported or none: if the stream yields an error,
+	// nothing is imported and the error is returned. Storages without transactions may
+	// expose the URLs of an import before it completes and remove them if it fails.
+	ImportURLs(ctx context.Context, userID string, urls iter.Seq2[models.URL, error]) (ImportResult, error)
+
 	// GetURL retrieves a URL by its slug from the storage.
+	// It returns ErrNotFound if there is no URL with such slug.
 	GetURL(ctx context.Context, slug string) (models.URL, error)
 
 	// ListURLsByUserID returns all URLs created by the specified user.
 	ListURLsByUserID(ctx context.Context, userID string) ([]models.URL, error)
 
+	// ListUserURLs returns a page of URLs created by the specified user which match the query.
+	ListUserURLs(ctx context.Context, userID string, q models.URLQuery) ([]models.URL, error)
+
 	// ListAllUrls returns the full map of stored URLs.
 	ListAllUrls(ctx context.Context) (map[string]models.URL, error)
 
+	// CountURLs returns the number of stored URLs which are not deleted.
+	CountURLs(ctx context.Context) (int, error)
+
+	// CountUsers returns the number of registered users.
+	CountUsers(ctx context.Context) (int, error)
+
 	// SoftDeleteURL marks URLs as deleted.
 	SoftDeleteURL(ctx context.Context, userID string, slug string) error
 
+	// BatchSoftDeleteURLs marks URLs with the given slugs created by the user as deleted.
+	// Slugs which do not exist or were not created by the user are skipped.
+	BatchSoftDeleteURLs(ctx context.Context, userID string, slugs []string) error
+
+	// CreateDeleteJob adds a new job of deleting user URLs.
+	CreateDeleteJob(ctx context.Context, job models.DeleteJob) error
+
+	// GetDeleteJob fetches the job of the specified user by ID.
+	// It returns ErrNotFound if the user has no such job.
+	GetDeleteJob(ctx context.Context, userID, jobID string) (models.DeleteJob, error)
+
+	// ClaimDeleteJobs returns up to limit pending jobs which are due to be executed at the given time,
+	// the oldest first, and postpones their next attempt until the lease expires.
+	// A job is returned to one caller only, so that several instances of the service
+	// do not execute the same job. If the caller does not update the job until the lease
+	// expires, the job is claimed again.
+	ClaimDeleteJobs(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.DeleteJob, error)
+
+	// UpdateDeleteJob saves the status, the attempts and the schedule of the job.
+	// It returns ErrNotFound if there is no such job.
+	UpdateDeleteJob(ctx context.Context, job models.DeleteJob) error
+
+	// CountPendingDeleteJobs returns the number of pending jobs.
+	CountPendingDeleteJobs(ctx context.Context) (int, error)
+
+	// DeleteFinishedDeleteJobs removes jobs which finished before the given time.
+	// It returns the number of removed jobs.
+	DeleteFinishedDeleteJobs(ctx context.Context, before time.Time) (int, error)
+
+	// CreateClicks adds a batch of clicks to the storage.
+	// Clicks of URLs which do not exist anymore are skipped.
+	CreateClicks(ctx context.Context, clicks []models.Click) error
+
+	// GetURLStats returns click statistics of the URL created by the specified user.
+	// It returns ErrNotFound if there is no such URL or it was not created by the user.
+	GetURLStats(ctx context.Context, userID, slug string) (models.URLStats, error)
+
+	// DeleteExpiredURLs permanently removes up to limit URLs which expired before the given time.
+	// It returns the number of removed URLs.
+	DeleteExpiredURLs(ctx context.Context, before time.Time, limit int) (int, error)
+
+	// ScanURLs returns up to limit stored URLs with slugs greater than afterSlug, ordered by slug.
+	// It is used to walk through all URLs page by page starting with an empty afterSlug.
+	ScanURLs(ctx context.Context, afterSlug string, limit int) ([]models.URL, error)
+
+	// BlockURLs marks URLs with the given slugs as blocked, so that they are no longer served.
+	// Slugs which do not exist are skipped.
+	BlockURLs(ctx context.Context, slugs []string) error
+
+	// CreateAPIKey adds a new API key of a user.
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+
+	// ListAPIKeysByUserID returns all API keys of the specified user.
+	ListAPIKeysByUserID(ctx context.Context, userID string) ([]models.APIKey, error)
+
+	// GetAPIKeyByHash fetches API key by the hash of the key.
+	// It returns ErrNotFound if there is no such key.
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+
+	// RevokeAPIKey removes the API key of the specified user.
+	// It returns ErrNotFound if the user has no such key.
+	RevokeAPIKey(ctx context.Context, userID, keyID string) error
+
+	// TouchAPIKey records the time the API key was last used.
+	// It returns ErrNotFound if there is no such key.
+	TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error
+
+	// RevokeToken adds the ID of an authentication token to the revocation list.
+	// The entry is kept until the token expires. It returns false if the token
+	// had already been revoked, so that only one of concurrent callers revokes it.
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+
+	// IsTokenRevoked reports whether the authentication token with the given ID has been revoked.
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+
+	// DeleteExpiredRevokedTokens removes revocation list entries of tokens which expired before the given time.
+	// It returns the number of removed entries.
+	DeleteExpiredRevokedTokens(ctx context.Context, before time.Time) (int, error)
+
 	// Ping is a storage healthcheck.
 	Ping(ctx context.Context) error
+
+	// Close releases resources held by the storage and persists pending data.
+	Close() error
 }
 
-// AlreadyExistsError represents RDB integrity constraint violation error on inserts.
+// ErrNotFound is returned when the requested entity does not exist in the storage.
+var ErrNotFound = errors.New("not found")
+
+// AlreadyExistsError is returned when a URL with the same canonical form
+// has already been shortened. URL is the existing URL.
 type AlreadyExistsError struct {
 	Err error
 	URL models.URL
@@ -48,3 +166,45 @@ type AlreadyExistsError struct {
 func (e *AlreadyExistsError) Error() string {
 	return e.Err.Error()
 }
+
+// UpsertResult is the result of adding a URL with BatchUpsertURLs.
+type UpsertResult struct {
+	// URL is the given URL if it has been created, otherwise the existing URL with the same canonical form.
+	URL models.URL
+	// Created reports whether the URL has been added.
+	Created bool
+	// Err is *SlugExistsError if the URL has not been added because a different URL has the same slug.
+	Err error
+}
+
+// ImportResult is the result of ImportURLs.
+type ImportResult struct {
+	// Created is the number of added URLs.
+	Created int
+	// Existing is the number of URLs which have already been shortened and have been linked to the user.
+	Existing int
+	// Conflicts are the URLs which have not been added because a different URL has the same slug.
+	Conflicts []models.URL
+}
+
+// Add counts the result of adding a URL.
+func (r *ImportResult) Add(res UpsertResult) {
+	switch {
+	case res.Created:
+		r.Created++
+	case res.Err != nil:
+		r.Conflicts = append(r.Conflicts, res.URL)
+	default:
+		r.Existing++
+	}
+}
+
+// SlugExistsError is returned when a different URL with the same slug already exists.
+type SlugExistsError struct {
+	Slug string
+}
+
+// Error is an implementation of error built-in interface.
+func (e *SlugExistsError) Error() string {
+	return fmt.Sprintf("slug %s already exists", e.Slug)
+}
@@ -0,0 +1,864 @@
+// Package storetest provides a conformance test suite for store.Store implementations.
+//
+// Every storage runs the same suite, so that they behave the same way
+// regardless of the backend the service is configured with.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/internal/app/store"
+	"github.com/madatsci/urlshortener/internal/random"
+)
+
+// importSize is the number of URLs of the failed import. It is large enough
+// for the in-memory storages to add the stream in several chunks.
+const importSize = 2001
+
+// Run runs the conformance suite against the storage. newStore is called once
+// per subtest and must return an empty storage, it may skip the test.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"CreateUser", testCreateUser},
+		{"CreateURL", testCreateURL},
+		{"CreateURLRedirectOptions", testCreateURLRedirectOptions},
+		{"CreateURLExisting", testCreateURLExisting},
+		{"CreateURLSlugExists", testCreateURLSlugExists},
+		{"CreateURLCanonical", testCreateURLCanonical},
+		{"CreateURLDead", testCreateURLDead},
+		{"BatchCreateURL", testBatchCreateURL},
+		{"ListURLsByUserID", testListURLsByUserID},
+		{"ListUserURLs", testListUserURLs},
+		{"SoftDeleteURL", testSoftDeleteURL},
+		{"SoftDeleteURLLinked", testSoftDeleteURLLinked},
+		{"BatchSoftDeleteURLs", testBatchSoftDeleteURLs},
+		{"DeleteJobs", testDeleteJobs},
+		{"DeleteExpiredURLs", testDeleteExpiredURLs},
+		{"BatchUpsertURLs", testBatchUpsertURLs},
+		{"ImportURLs", testImportURLs},
+		{"ScanAndBlockURLs", testScanAndBlockURLs},
+		{"Clicks", testClicks},
+		{"APIKeys", testAPIKeys},
+		{"RevokedTokens", testRevokedTokens},
+		{"Count", testCount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func createUsers(t *testing.T, s store.Store, n int) []models.User {
+	t.Helper()
+
+	users := make([]models.User, 0, n)
+	for i := 0; i < n; i++ {
+		user := random.RandomUser()
+		require.NoError(t, s.CreateUser(context.Background(), user))
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func slugs(urls []models.URL) []string {
+	res := make([]string, 0, len(urls))
+	for _, url := range urls {
+		res = append(res, url.Slug)
+	}
+
+	return res
+}
+
+// userSlugs returns the slugs of the URLs linked to the user.
+func userSlugs(t *testing.T, s store.Store, userID string) []string {
+	t.Helper()
+
+	urls, err := s.ListURLsByUserID(context.Background(), userID)
+	require.NoError(t, err)
+
+	return slugs(urls)
+}
+
+func stream(urls []models.URL, streamErr error) iter.Seq2[models.URL, error] {
+	return func(yield func(models.URL, error) bool) {
+		for _, url := range urls {
+			if !yield(url, nil) {
+				return
+			}
+		}
+		if streamErr != nil {
+			yield(models.URL{}, streamErr)
+		}
+	}
+}
+
+func testCreateUser(t *testing.T, s store.Store) {
+	ctx := context.Background()
+
+	user := random.RandomUser()
+	err := s.CreateUser(ctx, user)
+	require.NoError(t, err)
+
+	res, err := s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, res.ID)
+	assert.Equal(t, user.CreatedAt.Unix(), res.CreatedAt.Unix())
+}
+
+func testCreateURL(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	all, err := s.ListAllUrls(ctx)
+	require.NoError(t, err)
+	require.Empty(t, all)
+
+	urls := random.RandomURLs(3)
+	for _, url := range urls {
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err)
+	}
+
+	for _, url := range urls {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, url.ID, res.ID)
+		assert.Equal(t, url.CorrelationID, res.CorrelationID)
+		assert.Equal(t, url.Slug, res.Slug)
+		assert.Equal(t, url.Original, res.Original)
+		assert.False(t, res.Deleted)
+		assert.Equal(t, url.CreatedAt.Unix(), res.CreatedAt.Unix())
+	}
+	assert.ElementsMatch(t, slugs(urls), userSlugs(t, s, user.ID))
+
+	all, err = s.ListAllUrls(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	_, err = s.GetURL(ctx, "unknown")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testCreateURLRedirectOptions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	url := random.RandomURL()
+	url.RedirectType = http.StatusPermanentRedirect
+	url.MergeQuery = true
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	upserted := random.RandomURL()
+	upserted.RedirectType = http.StatusFound
+	_, err = s.BatchUpsertURLs(ctx, user.ID, []models.URL{upserted})
+	require.NoError(t, err)
+
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPermanentRedirect, res.RedirectType)
+	assert.True(t, res.MergeQuery)
+
+	res, err = s.GetURL(ctx, upserted.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, res.RedirectType)
+	assert.False(t, res.MergeQuery)
+}
+
+func testCreateURLExisting(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, users[0].ID, url)
+	require.NoError(t, err)
+
+	// The same URL is linked to the second user.
+	err = s.CreateURL(ctx, users[1].ID, url)
+	var alreadyExists *store.AlreadyExistsError
+	require.ErrorAs(t, err, &alreadyExists)
+	assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, url.ID, res.ID)
+	assert.Equal(t, url.Original, res.Original)
+	assert.False(t, res.Deleted)
+
+	for _, user := range users {
+		assert.Equal(t, []string{url.Slug}, userSlugs(t, s, user.ID))
+	}
+
+	all, err := s.ListAllUrls(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func testCreateURLSlugExists(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	other := random.RandomURL()
+	other.Slug = url.Slug
+	err = s.CreateURL(ctx, user.ID, other)
+	var slugExists *store.SlugExistsError
+	require.ErrorAs(t, err, &slugExists)
+	assert.Equal(t, url.Slug, slugExists.Slug)
+
+	urls := random.RandomURLs(2)
+	urls[1].Slug = url.Slug
+	err = s.BatchCreateURL(ctx, user.ID, urls)
+	require.ErrorAs(t, err, &slugExists)
+
+	// Nothing is added when the batch fails.
+	_, err = s.GetURL(ctx, urls[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testCreateURLCanonical(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	url := random.RandomURL()
+	url.Original = "HTTP://Example.com"
+	url.Canonical = "http://example.com/"
+	err := s.CreateURL(ctx, users[0].ID, url)
+	require.NoError(t, err)
+
+	duplicate := random.RandomURL()
+	duplicate.Original = "http://example.com/"
+	duplicate.Canonical = url.Canonical
+	err = s.CreateURL(ctx, users[1].ID, duplicate)
+	var alreadyExists *store.AlreadyExistsError
+	require.ErrorAs(t, err, &alreadyExists)
+	assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+	assert.Equal(t, url.Original, alreadyExists.URL.Original)
+	assert.Equal(t, url.Canonical, alreadyExists.URL.Canonical)
+	assert.Equal(t, []string{url.Slug}, userSlugs(t, s, users[1].ID))
+
+	urls := random.RandomURLs(2)
+	urls[1].Canonical = url.Canonical
+	err = s.BatchCreateURL(ctx, users[0].ID, urls)
+	require.ErrorAs(t, err, &alreadyExists)
+	assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+
+	all, err := s.ListAllUrls(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func testCreateURLDead(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	past := time.Now().Add(-time.Minute)
+	expired := random.RandomURL()
+	expired.ExpiresAt = &past
+	deleted := random.RandomURL()
+	blocked := random.RandomURL()
+	for _, url := range []models.URL{expired, deleted, blocked} {
+		err := s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err)
+	}
+	err := s.SoftDeleteURL(ctx, user.ID, deleted.Slug)
+	require.NoError(t, err)
+	err = s.BlockURLs(ctx, []string{blocked.Slug})
+	require.NoError(t, err)
+
+	// Dead URLs are shortened again instead of being returned as duplicates.
+	for _, dead := range []models.URL{expired, deleted, blocked} {
+		// The slug of a dead URL is not taken over, even by the same URL.
+		again := random.RandomURL()
+		again.Slug = dead.Slug
+		again.Original = dead.Original
+		var slugExists *store.SlugExistsError
+		err = s.CreateURL(ctx, user.ID, again)
+		require.ErrorAs(t, err, &slugExists)
+		err = s.BatchCreateURL(ctx, user.ID, []models.URL{again})
+		require.ErrorAs(t, err, &slugExists)
+		upsertRes, err := s.BatchUpsertURLs(ctx, user.ID, []models.URL{again})
+		require.NoError(t, err)
+		require.Len(t, upsertRes, 1)
+		require.ErrorAs(t, upsertRes[0].Err, &slugExists)
+
+		url := random.RandomURL()
+		url.Original = dead.Original
+		err = s.CreateURL(ctx, user.ID, url)
+		require.NoError(t, err)
+
+		duplicate := random.RandomURL()
+		duplicate.Original = dead.Original
+		err = s.CreateURL(ctx, user.ID, duplicate)
+		var alreadyExists *store.AlreadyExistsError
+		require.ErrorAs(t, err, &alreadyExists)
+		assert.Equal(t, url.Slug, alreadyExists.URL.Slug)
+
+		res, err := s.GetURL(ctx, dead.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, dead.ID, res.ID)
+	}
+
+	// So are they in batches.
+	expiredAgain := random.RandomURL()
+	expiredAgain.ExpiresAt = &past
+	err = s.CreateURL(ctx, user.ID, expiredAgain)
+	require.NoError(t, err)
+
+	upserted := random.RandomURL()
+	upserted.Original = expiredAgain.Original
+	res, err := s.BatchUpsertURLs(ctx, user.ID, []models.URL{upserted})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.True(t, res[0].Created)
+	assert.Equal(t, upserted.Slug, res[0].URL.Slug)
+}
+
+func testBatchCreateURL(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	urls := random.RandomURLs(3)
+	err := s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	for _, url := range urls {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.Equal(t, url.ID, res.ID)
+		assert.Equal(t, url.CorrelationID, res.CorrelationID)
+		assert.Equal(t, url.Original, res.Original)
+		assert.Equal(t, url.CreatedAt.Unix(), res.CreatedAt.Unix())
+	}
+	assert.ElementsMatch(t, slugs(urls), userSlugs(t, s, user.ID))
+
+	all, err := s.ListAllUrls(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func testListURLsByUserID(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	user1URLs := random.RandomURLs(3)
+	err := s.BatchCreateURL(ctx, users[0].ID, user1URLs)
+	require.NoError(t, err)
+
+	user2URLs := random.RandomURLs(2)
+	err = s.BatchCreateURL(ctx, users[1].ID, user2URLs)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, slugs(user1URLs), userSlugs(t, s, users[0].ID))
+	assert.ElementsMatch(t, slugs(user2URLs), userSlugs(t, s, users[1].ID))
+	assert.Empty(t, userSlugs(t, s, uuid.NewString()))
+}
+
+func testListUserURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+	user, other := users[0], users[1]
+
+	start := time.Now().UTC().Truncate(time.Second)
+	urls := random.RandomURLs(5)
+	for i := range urls {
+		urls[i].CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		if i%2 == 0 {
+			urls[i].Original = fmt.Sprintf("https://Example.com/%d", i)
+		}
+	}
+	// URLs are added out of the order of their creation time.
+	require.NoError(t, s.BatchCreateURL(ctx, user.ID, []models.URL{urls[3], urls[0], urls[4]}))
+	require.NoError(t, s.CreateURL(ctx, user.ID, urls[2]))
+	require.NoError(t, s.CreateURL(ctx, user.ID, urls[1]))
+	require.NoError(t, s.BatchCreateURL(ctx, other.ID, random.RandomURLs(2)))
+
+	page, err := s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[:2]), slugs(page))
+
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, After: models.CursorOf(page[1])})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[2:4]), slugs(page))
+
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, Desc: true, After: models.CursorOf(urls[2])})
+	require.NoError(t, err)
+	assert.Equal(t, []string{urls[1].Slug, urls[0].Slug}, slugs(page))
+
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Search: "example.COM"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{urls[0].Slug, urls[2].Slug, urls[4].Slug}, slugs(page))
+
+	from, to := urls[1].CreatedAt, urls[3].CreatedAt
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{CreatedFrom: &from, CreatedTo: &to})
+	require.NoError(t, err)
+	assert.Equal(t, slugs(urls[1:3]), slugs(page))
+
+	// URLs deleted by the user are skipped.
+	require.NoError(t, s.SoftDeleteURL(ctx, user.ID, urls[4].Slug))
+	page, err = s.ListUserURLs(ctx, user.ID, models.URLQuery{Limit: 2, Desc: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{urls[3].Slug, urls[2].Slug}, slugs(page))
+}
+
+func testSoftDeleteURL(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	err = s.SoftDeleteURL(ctx, user.ID, url.Slug)
+	require.NoError(t, err)
+
+	// The URL is deleted together with its only link.
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.True(t, res.Deleted)
+	assert.Empty(t, userSlugs(t, s, user.ID))
+}
+
+func testSoftDeleteURLLinked(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, users[0].ID, url)
+	require.NoError(t, err)
+	err = s.CreateURL(ctx, users[1].ID, url)
+	var alreadyExists *store.AlreadyExistsError
+	require.ErrorAs(t, err, &alreadyExists)
+
+	err = s.SoftDeleteURL(ctx, users[0].ID, url.Slug)
+	require.NoError(t, err)
+
+	// The URL stays while it is linked to another user.
+	assert.Empty(t, userSlugs(t, s, users[0].ID))
+	assert.Equal(t, []string{url.Slug}, userSlugs(t, s, users[1].ID))
+
+	res, err := s.GetURL(ctx, url.Slug)
+	require.NoError(t, err)
+	assert.False(t, res.Deleted)
+}
+
+func testBatchSoftDeleteURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	urls := random.RandomURLs(3)
+	require.NoError(t, s.BatchCreateURL(ctx, users[0].ID, urls))
+	otherURL := random.RandomURL()
+	require.NoError(t, s.CreateURL(ctx, users[1].ID, otherURL))
+
+	err := s.BatchSoftDeleteURLs(ctx, users[0].ID, []string{urls[0].Slug, urls[1].Slug, otherURL.Slug, "unknown"})
+	require.NoError(t, err)
+
+	for _, url := range urls[:2] {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.True(t, res.Deleted)
+	}
+	for _, url := range []models.URL{urls[2], otherURL} {
+		res, err := s.GetURL(ctx, url.Slug)
+		require.NoError(t, err)
+		assert.False(t, res.Deleted)
+	}
+
+	assert.Equal(t, []string{urls[2].Slug}, userSlugs(t, s, users[0].ID))
+}
+
+func testDeleteJobs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	jobs := []models.DeleteJob{
+		{Slugs: []string{"a", "b"}, Status: models.DeleteJobPending, CreatedAt: now.Add(-time.Hour), NextAttemptAt: now},
+		{Slugs: []string{"c"}, Status: models.DeleteJobPending, CreatedAt: now.Add(-time.Minute), NextAttemptAt: now.Add(time.Minute)},
+		{Slugs: []string{"d"}, Status: models.DeleteJobDone, CreatedAt: now.Add(-2 * time.Hour), NextAttemptAt: now},
+	}
+	for i := range jobs {
+		jobs[i].ID = uuid.NewString()
+		jobs[i].UserID = user.ID
+		jobs[i].UpdatedAt = jobs[i].CreatedAt
+		require.NoError(t, s.CreateDeleteJob(ctx, jobs[i]))
+	}
+
+	job, err := s.GetDeleteJob(ctx, user.ID, jobs[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, jobs[0].Slugs, job.Slugs)
+	assert.Equal(t, models.DeleteJobPending, job.Status)
+	assert.True(t, jobs[0].CreatedAt.Equal(job.CreatedAt))
+
+	_, err = s.GetDeleteJob(ctx, random.RandomUser().ID, jobs[0].ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	leaseUntil := now.Add(time.Minute)
+	claimed, err := s.ClaimDeleteJobs(ctx, now, leaseUntil, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, jobs[0].ID, claimed[0].ID)
+	assert.Equal(t, jobs[0].Slugs, claimed[0].Slugs)
+	assert.True(t, leaseUntil.Equal(claimed[0].NextAttemptAt))
+
+	// A claimed job is not claimed again until the lease expires.
+	claimed, err = s.ClaimDeleteJobs(ctx, now, leaseUntil, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	claimed, err = s.ClaimDeleteJobs(ctx, leaseUntil, leaseUntil.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, jobs[0].ID, claimed[0].ID)
+	assert.Equal(t, jobs[1].ID, claimed[1].ID)
+
+	n, err := s.CountPendingDeleteJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	job.Status = models.DeleteJobFailed
+	job.Attempts = 3
+	job.LastError = "storage is down"
+	job.UpdatedAt = now.Add(-30 * time.Minute)
+	require.NoError(t, s.UpdateDeleteJob(ctx, job))
+
+	job, err = s.GetDeleteJob(ctx, user.ID, jobs[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobFailed, job.Status)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Equal(t, "storage is down", job.LastError)
+
+	n, err = s.CountPendingDeleteJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	err = s.UpdateDeleteJob(ctx, models.DeleteJob{ID: uuid.NewString()})
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// Only the jobs finished before the given time are removed.
+	n, err = s.DeleteFinishedDeleteJobs(ctx, now.Add(-20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = s.GetDeleteJob(ctx, user.ID, jobs[0].ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.GetDeleteJob(ctx, user.ID, jobs[1].ID)
+	assert.NoError(t, err)
+}
+
+func testDeleteExpiredURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+	notExpired := now.Add(time.Hour)
+	urls := random.RandomURLs(3)
+	urls[0].ExpiresAt = &expired
+	urls[1].ExpiresAt = &notExpired
+	err := s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	n, err := s.DeleteExpiredURLs(ctx, now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = s.GetURL(ctx, urls[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	res, err := s.GetURL(ctx, urls[1].Slug)
+	require.NoError(t, err)
+	require.NotNil(t, res.ExpiresAt)
+	assert.WithinDuration(t, notExpired, *res.ExpiresAt, time.Millisecond)
+
+	assert.ElementsMatch(t, slugs(urls[1:]), userSlugs(t, s, user.ID))
+}
+
+func testBatchUpsertURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	existing := random.RandomURL()
+	err := s.CreateURL(ctx, users[0].ID, existing)
+	require.NoError(t, err)
+
+	urls := random.RandomURLs(4)
+	// Already shortened by another user.
+	urls[1].Original = existing.Original
+	// Slug is taken by another URL.
+	urls[2].Slug = existing.Slug
+	// Duplicate within the batch.
+	urls[3].Original = urls[0].Original
+
+	res, err := s.BatchUpsertURLs(ctx, users[1].ID, urls)
+	require.NoError(t, err)
+	require.Len(t, res, 4)
+
+	assert.True(t, res[0].Created)
+	assert.NoError(t, res[0].Err)
+	assert.Equal(t, urls[0].Slug, res[0].URL.Slug)
+
+	assert.False(t, res[1].Created)
+	assert.NoError(t, res[1].Err)
+	assert.Equal(t, existing.Slug, res[1].URL.Slug)
+
+	var slugExists *store.SlugExistsError
+	assert.False(t, res[2].Created)
+	assert.ErrorAs(t, res[2].Err, &slugExists)
+
+	assert.False(t, res[3].Created)
+	assert.NoError(t, res[3].Err)
+	assert.Equal(t, urls[0].Slug, res[3].URL.Slug)
+
+	_, err = s.GetURL(ctx, urls[3].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// Created and existing URLs are linked to the user.
+	assert.ElementsMatch(t, []string{urls[0].Slug, existing.Slug}, userSlugs(t, s, users[1].ID))
+}
+
+func testImportURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+
+	existing := random.RandomURL()
+	err := s.CreateURL(ctx, users[0].ID, existing)
+	require.NoError(t, err)
+
+	// Nothing is imported if the stream fails.
+	failed := random.RandomURLs(importSize)
+	failed[importSize/2].Original = existing.Original
+	_, err = s.ImportURLs(ctx, users[1].ID, stream(failed, errors.New("broken stream")))
+	require.Error(t, err)
+	_, err = s.GetURL(ctx, failed[0].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.GetURL(ctx, failed[len(failed)-1].Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.Empty(t, userSlugs(t, s, users[1].ID))
+	assert.Equal(t, []string{existing.Slug}, userSlugs(t, s, users[0].ID))
+
+	urls := random.RandomURLs(4)
+	// Already shortened by another user.
+	urls[1].Original = existing.Original
+	// Slug is taken by another URL.
+	urls[2].Slug = existing.Slug
+	// Duplicate within the stream.
+	urls[3].Original = urls[0].Original
+
+	res, err := s.ImportURLs(ctx, users[1].ID, stream(urls, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Created)
+	assert.Equal(t, 2, res.Existing)
+	require.Len(t, res.Conflicts, 1)
+	assert.Equal(t, urls[2].CorrelationID, res.Conflicts[0].CorrelationID)
+	assert.Equal(t, existing.Slug, res.Conflicts[0].Slug)
+
+	url, err := s.GetURL(ctx, urls[0].Slug)
+	require.NoError(t, err)
+	assert.Equal(t, urls[0].Original, url.Original)
+
+	// Created and existing URLs are linked to the user.
+	assert.ElementsMatch(t, []string{urls[0].Slug, existing.Slug}, userSlugs(t, s, users[1].ID))
+
+	// The storage is not locked while the stream is read.
+	slow := random.RandomURL()
+	slowStream := func(yield func(models.URL, error) bool) {
+		queryCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err := s.GetURL(queryCtx, existing.Slug)
+		assert.NoError(t, err)
+		yield(slow, nil)
+	}
+	res, err = s.ImportURLs(ctx, users[1].ID, slowStream)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Created)
+}
+
+func testScanAndBlockURLs(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	urls := random.RandomURLs(5)
+	err := s.BatchCreateURL(ctx, user.ID, urls)
+	require.NoError(t, err)
+
+	var scanned []string
+	var after string
+	for {
+		page, err := s.ScanURLs(ctx, after, 2)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(page), 2)
+		scanned = append(scanned, slugs(page)...)
+		after = page[len(page)-1].Slug
+	}
+	assert.ElementsMatch(t, slugs(urls), scanned)
+
+	err = s.BlockURLs(ctx, []string{urls[0].Slug, "unknown"})
+	require.NoError(t, err)
+
+	url, err := s.GetURL(ctx, urls[0].Slug)
+	require.NoError(t, err)
+	assert.True(t, url.Blocked)
+
+	url, err = s.GetURL(ctx, urls[1].Slug)
+	require.NoError(t, err)
+	assert.False(t, url.Blocked)
+}
+
+func testClicks(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := createUsers(t, s, 1)[0]
+
+	url := random.RandomURL()
+	err := s.CreateURL(ctx, user.ID, url)
+	require.NoError(t, err)
+
+	now := time.Now()
+	err = s.CreateClicks(ctx, []models.Click{
+		{ID: uuid.NewString(), URLID: url.ID, Slug: url.Slug, CreatedAt: now.Add(-24 * time.Hour), IPHash: "a"},
+		{ID: uuid.NewString(), URLID: url.ID, Slug: url.Slug, CreatedAt: now, IPHash: "a"},
+		{ID: uuid.NewString(), URLID: url.ID, Slug: url.Slug, CreatedAt: now, IPHash: "b"},
+		{ID: uuid.NewString(), URLID: uuid.NewString(), Slug: "unknown", CreatedAt: now, IPHash: "c"},
+	})
+	require.NoError(t, err)
+
+	stats, err := s.GetURLStats(ctx, user.ID, url.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+	require.Len(t, stats.Daily, 2)
+	assert.Equal(t, models.DailyStats{Date: now.Add(-24 * time.Hour).UTC().Format(models.DateFormat), Clicks: 1, UniqueVisitors: 1}, stats.Daily[0])
+	assert.Equal(t, models.DailyStats{Date: now.UTC().Format(models.DateFormat), Clicks: 2, UniqueVisitors: 2}, stats.Daily[1])
+
+	_, err = s.GetURLStats(ctx, uuid.NewString(), url.Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	_, err = s.GetURLStats(ctx, user.ID, "unknown")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	err = s.SoftDeleteURL(ctx, user.ID, url.Slug)
+	require.NoError(t, err)
+	_, err = s.GetURLStats(ctx, user.ID, url.Slug)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testAPIKeys(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 2)
+	user := users[0]
+
+	key1 := random.RandomAPIKey(user.ID)
+	key2 := random.RandomAPIKey(user.ID)
+	key2.CreatedAt = key1.CreatedAt.Add(time.Second)
+	other := random.RandomAPIKey(users[1].ID)
+	for _, key := range []models.APIKey{key2, key1, other} {
+		require.NoError(t, s.CreateAPIKey(ctx, key))
+	}
+
+	keys, err := s.ListAPIKeysByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, key1.ID, keys[0].ID)
+	assert.Equal(t, key2.ID, keys[1].ID)
+	assert.Nil(t, keys[0].LastUsedAt)
+
+	usedAt := time.Now().UTC().Truncate(time.Microsecond)
+	require.NoError(t, s.TouchAPIKey(ctx, key1.ID, usedAt))
+	res, err := s.GetAPIKeyByHash(ctx, key1.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, res.UserID)
+	assert.Equal(t, key1.Name, res.Name)
+	require.NotNil(t, res.LastUsedAt)
+	assert.True(t, usedAt.Equal(*res.LastUsedAt))
+
+	err = s.RevokeAPIKey(ctx, user.ID, other.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.RevokeAPIKey(ctx, user.ID, key1.ID))
+	_, err = s.GetAPIKeyByHash(ctx, key1.Hash)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	err = s.TouchAPIKey(ctx, key1.ID, usedAt)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testRevokedTokens(t *testing.T, s store.Store) {
+	ctx := context.Background()
+
+	now := time.Now()
+	expired := uuid.NewString()
+	active := uuid.NewString()
+	for _, tokenID := range []string{expired, active} {
+		expiresAt := now.Add(time.Hour)
+		if tokenID == expired {
+			expiresAt = now.Add(-time.Minute)
+		}
+		revoked, err := s.RevokeToken(ctx, tokenID, expiresAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	// Revoking a token twice is not an error, but it is reported.
+	revoked, err := s.RevokeToken(ctx, active, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	for _, tokenID := range []string{expired, active} {
+		revoked, err := s.IsTokenRevoked(ctx, tokenID)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	revoked, err = s.IsTokenRevoked(ctx, uuid.NewString())
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	n, err := s.DeleteExpiredRevokedTokens(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	revoked, err = s.IsTokenRevoked(ctx, expired)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = s.IsTokenRevoked(ctx, active)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func testCount(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	users := createUsers(t, s, 3)
+
+	urls := random.RandomURLs(3)
+	require.NoError(t, s.BatchCreateURL(ctx, users[0].ID, urls))
+	require.NoError(t, s.SoftDeleteURL(ctx, users[0].ID, urls[0].Slug))
+
+	n, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
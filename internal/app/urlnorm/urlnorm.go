@@ -0,0 +1,230 @@
+// Package urlnorm validates URLs submitted for shortening and normalizes them.
+//
+// Only absolute http and https URLs with a host are accepted. The canonical
+// form of a URL has a lowercase scheme and host, the host is converted to
+// punycode, default ports are removed, an empty path becomes "/" and
+// percent-encoding is normalized: unreserved characters are decoded, other
+// escapes use uppercase hex digits and characters which must be escaped are
+// escaped. Tracking query parameters, such as utm_source, can be removed too.
+//
+// URLs which differ only in these details have the same canonical form,
+// so that they can be deduplicated.
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultMaxLength is used if Options.MaxLength is not set.
+const DefaultMaxLength = 2048
+
+// trackingParams are query parameters which only identify the source of a visit.
+// Parameters with the utm_ prefix are tracking parameters too.
+var trackingParams = map[string]struct{}{
+	"fbclid":  {},
+	"gclid":   {},
+	"dclid":   {},
+	"msclkid": {},
+	"yclid":   {},
+	"mc_cid":  {},
+	"mc_eid":  {},
+	"_ga":     {},
+	"igshid":  {},
+}
+
+// defaultPorts are the ports which are removed from URLs of the scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Options is used to configure Normalizer.
+type Options struct {
+	// MaxLength is the maximum length of a URL in bytes (default: DefaultMaxLength).
+	MaxLength int
+	// StripTracking removes tracking query parameters, such as utm_source or fbclid.
+	StripTracking bool
+}
+
+// Normalizer validates and normalizes URLs.
+//
+// Use New to create an instance of Normalizer.
+type Normalizer struct {
+	opts Options
+}
+
+// New creates a new Normalizer.
+func New(opts Options) *Normalizer {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultMaxLength
+	}
+
+	return &Normalizer{opts: opts}
+}
+
+// Normalize validates rawURL and returns its canonical form.
+//
+// The error describes why the URL is not accepted and can be shown to the client.
+func (n *Normalizer) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if len(rawURL) > n.opts.MaxLength {
+		return "", fmt.Errorf("must not be longer than %d characters", n.opts.MaxLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.New("malformed URL")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", errors.New("must be an absolute URL with http or https scheme")
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", errors.New("host is required")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	if port := u.Port(); port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", errors.New("invalid port")
+		}
+		if port != defaultPorts[scheme] {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(host)
+
+	path := normalizeEscapes(u.EscapedPath())
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+
+	query := u.RawQuery
+	if n.opts.StripTracking {
+		query = stripTracking(query)
+	}
+	if query = normalizeEscapes(query); query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+
+	if fragment := normalizeEscapes(u.EscapedFragment()); fragment != "" {
+		b.WriteByte('#')
+		b.WriteString(fragment)
+	}
+
+	return b.String(), nil
+}
+
+// normalizeHost lowercases the host and converts internationalized domain names to punycode.
+// IPv6 addresses are returned in brackets.
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return "[" + ip.String() + "]", nil
+		}
+		return ip.String(), nil
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil || ascii == "" {
+		return "", errors.New("invalid host")
+	}
+
+	return ascii, nil
+}
+
+// stripTracking removes tracking parameters from the raw query keeping the order of the others.
+func stripTracking(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		key = strings.ToLower(key)
+
+		if _, ok := trackingParams[key]; ok || strings.HasPrefix(key, "utm_") {
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	return strings.Join(kept, "&")
+}
+
+// normalizeEscapes decodes escaped unreserved characters, makes hex digits of
+// other escapes uppercase and escapes the characters which are not allowed in URLs.
+func normalizeEscapes(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			}
+			i += 2
+		case isUnreserved(c) || strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,96 @@
+package urlnorm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	n := New(Options{})
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "already canonical", url: "https://example.org/path?a=1#top", want: "https://example.org/path?a=1#top"},
+		{name: "uppercase scheme and host", url: "HTTP://Example.COM/Path", want: "http://example.com/Path"},
+		{name: "empty path", url: "http://example.com", want: "http://example.com/"},
+		{name: "surrounding spaces", url: "  http://example.com/ \n", want: "http://example.com/"},
+		{name: "default http port", url: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "default https port", url: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "other port", url: "https://example.com:8443/a", want: "https://example.com:8443/a"},
+		{name: "trailing dot of host", url: "http://example.com./", want: "http://example.com/"},
+		{name: "internationalized domain", url: "https://Пример.рф/", want: "https://xn--e1afmkfd.xn--p1ai/"},
+		{name: "ipv4", url: "http://127.0.0.1:8080/", want: "http://127.0.0.1:8080/"},
+		{name: "ipv6", url: "http://[2001:DB8::1]:80/", want: "http://[2001:db8::1]/"},
+		{name: "ipv6 with port", url: "http://[2001:db8::1]:8080/", want: "http://[2001:db8::1]:8080/"},
+		{name: "escaped unreserved characters", url: "http://example.com/%7Euser/%61bc", want: "http://example.com/~user/abc"},
+		{name: "lowercase escapes", url: "http://example.com/a%2fb?q=%e2%82%ac", want: "http://example.com/a%2Fb?q=%E2%82%AC"},
+		{name: "unescaped characters", url: "http://example.com/a b/é?q=a b", want: "http://example.com/a%20b/%C3%A9?q=a%20b"},
+		{name: "tracking parameters are kept", url: "http://example.com/?utm_source=x&a=1", want: "http://example.com/?utm_source=x&a=1"},
+		{name: "user info", url: "https://User@Example.com/", want: "https://User@example.com/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := n.Normalize(test.url)
+			if test.want == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	t.Run("equivalent URLs have the same canonical form", func(t *testing.T) {
+		a, err := n.Normalize("HTTP://Example.com/")
+		require.NoError(t, err)
+		b, err := n.Normalize("http://example.com")
+		require.NoError(t, err)
+		assert.Equal(t, a, b)
+	})
+}
+
+func TestNormalizeStripTracking(t *testing.T) {
+	n := New(Options{StripTracking: true})
+
+	for url, want := range map[string]string{
+		"http://example.com/?utm_source=news&id=1&UTM_Medium=email": "http://example.com/?id=1",
+		"http://example.com/?fbclid=abc":                            "http://example.com/",
+		"http://example.com/?a=1&gclid=x&b=2#top":                   "http://example.com/?a=1&b=2#top",
+		"http://example.com/?utm=1":                                 "http://example.com/?utm=1",
+	} {
+		got, err := n.Normalize(url)
+		require.NoError(t, err, url)
+		assert.Equal(t, want, got, url)
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	n := New(Options{MaxLength: 64})
+
+	for name, url := range map[string]string{
+		"empty":             "",
+		"javascript":        "javascript:alert(1)",
+		"data":              "data:text/html,<script>alert(1)</script>",
+		"mailto":            "mailto:user@example.com",
+		"relative path":     "/path/to/page",
+		"no scheme":         "example.com/page",
+		"opaque":            "http:example.com",
+		"no host":           "http:///path",
+		"invalid host":      "http://exa_mple.com/",
+		"invalid port":      "http://example.com:99999/",
+		"non-numeric port":  "http://example.com:port/",
+		"malformed":         "http://[::1/",
+		"too long":          "http://example.com/" + strings.Repeat("a", 64),
+		"control character": "http://example.com/\x7f",
+	} {
+		_, err := n.Normalize(url)
+		assert.Error(t, err, name)
+	}
+}
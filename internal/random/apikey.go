@@ -0,0 +1,25 @@
+package random
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/madatsci/urlshortener/internal/app/apikey"
+	"github.com/madatsci/urlshortener/internal/app/models"
+	"github.com/madatsci/urlshortener/pkg/random"
+)
+
+// RandomAPIKey returns a randomly generated models.APIKey struct of the user.
+func RandomAPIKey(userID string) models.APIKey {
+	key := apikey.Prefix + random.ASCIIString(43)
+
+	return models.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      random.ASCIIString(8),
+		Prefix:    apikey.DisplayPrefix(key),
+		Hash:      apikey.Hash(key),
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
@@ -1,4 +1,13 @@
 // Package jwt implements operations with JWT tokens.
+//
+// Two types of tokens are issued: short-lived access tokens which authenticate
+// requests and long-lived refresh tokens which can only be exchanged for a new
+// pair of tokens. Every token has a unique ID (the jti claim), so that it can be revoked.
+//
+// Tokens are signed with a single active key and verified with any key of the keyset,
+// so that signing keys can be rotated without invalidating issued tokens. The key is
+// chosen by the kid header; tokens without it are verified with the HS256 secret
+// if Options.Secret is set and rejected otherwise.
 package jwt
 
 import (
@@ -6,73 +15,204 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
-var errInvalidToken = errors.New("invalid JWT token")
+// Token types.
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+)
+
+// DefaultRefreshDuration is used if Options.RefreshDuration is not set.
+const DefaultRefreshDuration = 30 * 24 * time.Hour
+
+// ErrExpired is returned by Parse if the token of the requested type is authentic but has expired.
+var ErrExpired = errors.New("JWT token has expired")
+
+var (
+	errInvalidToken     = errors.New("invalid JWT token")
+	errInvalidTokenType = errors.New("unexpected JWT token type")
+	errNoSigningKey     = errors.New("no JWT signing key")
+)
 
 // JWT represents data required to create and sign JWT token.
 //
-// Use New to create a new instance of JWT.
+// Use New to create a new instance of JWT. It is safe for concurrent use.
 type JWT struct {
-	Secret []byte
-	claims Claims
+	signingKey *Key
+	// keys holds all verification keys, keysByID maps the kid header to a key.
+	keys            []*Key
+	keysByID        map[string]*Key
+	duration        time.Duration
+	refreshDuration time.Duration
+	issuer          string
 }
 
 // Claims represents JWT token claims.
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID string
+	// Type is either TypeAccess or TypeRefresh. Tokens issued before refresh tokens
+	// were introduced have no type and are treated as access tokens.
+	Type string `json:"typ,omitempty"`
+}
+
+// typ returns the type of the token.
+func (c *Claims) typ() string {
+	if c.Type == "" {
+		return TypeAccess
+	}
+
+	return c.Type
 }
 
 // Options is used to initialize a new JWT.
 type Options struct {
-	Secret   []byte
+	// Secret is the HS256 secret. It verifies tokens without the kid header
+	// and signs new tokens unless SigningKey is set.
+	Secret []byte
+	// SigningKey is the active key which signs new tokens. It must have a non-empty ID.
+	SigningKey *Key
+	// VerificationKeys are older keys which only verify tokens issued before rotation.
+	// They must have non-empty unique IDs.
+	VerificationKeys []*Key
+	// Duration is the lifetime of access tokens.
 	Duration time.Duration
-	Issuer   string
+	// RefreshDuration is the lifetime of refresh tokens (default: DefaultRefreshDuration).
+	RefreshDuration time.Duration
+	Issuer          string
 }
 
 // New creates a new instance of JWT.
 func New(opts Options) *JWT {
-	return &JWT{
-		Secret: opts.Secret,
-		claims: Claims{
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(opts.Duration)),
-				Issuer:    opts.Issuer,
-			},
-		},
+	if opts.RefreshDuration == 0 {
+		opts.RefreshDuration = DefaultRefreshDuration
+	}
+
+	j := &JWT{
+		signingKey:      opts.SigningKey,
+		keysByID:        make(map[string]*Key),
+		duration:        opts.Duration,
+		refreshDuration: opts.RefreshDuration,
+		issuer:          opts.Issuer,
+	}
+
+	var keys []*Key
+	if opts.SigningKey != nil {
+		keys = append(keys, opts.SigningKey)
+	}
+	keys = append(keys, opts.VerificationKeys...)
+	if len(opts.Secret) > 0 {
+		secretKey := NewHMACKey("", opts.Secret)
+		if j.signingKey == nil {
+			j.signingKey = secretKey
+		}
+		keys = append(keys, secretKey)
 	}
+
+	for _, key := range keys {
+		if _, ok := j.keysByID[key.ID]; !ok {
+			j.keysByID[key.ID] = key
+			j.keys = append(j.keys, key)
+		}
+	}
+
+	return j
 }
 
-// GetString returns signed JWT token as string.
+// GetString returns signed access token as string.
 func (j *JWT) GetString(userID string) (string, error) {
-	j.claims.UserID = userID
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, j.claims)
+	token, _, err := j.Issue(userID, TypeAccess)
+	return token, err
+}
 
-	tokenString, err := token.SignedString(j.Secret)
+// Issue returns a new signed token of the given type and its claims.
+func (j *JWT) Issue(userID, tokenType string) (string, *Claims, error) {
+	duration := j.duration
+	if tokenType == TypeRefresh {
+		duration = j.refreshDuration
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    j.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+		UserID: userID,
+		Type:   tokenType,
+	}
+
+	if j.signingKey == nil || !j.signingKey.CanSign() {
+		return "", nil, errNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(j.signingKey.method, claims)
+	if j.signingKey.ID != "" {
+		token.Header["kid"] = j.signingKey.ID
+	}
+
+	tokenString, err := token.SignedString(j.signingKey.signKey)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	return tokenString, claims, nil
 }
 
-// GetUserID parses user ID from token.
+// GetUserID parses user ID from access token.
 func (j *JWT) GetUserID(tokenString string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &j.claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return j.Secret, nil
-	})
+	claims, err := j.Parse(tokenString, TypeAccess)
 	if err != nil {
-		return "", errors.Wrap(err, "token parsing error")
+		return "", err
+	}
+
+	return claims.UserID, nil
+}
+
+// Parse validates the token of the given type and returns its claims.
+func (j *JWT) Parse(tokenString, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.verificationKey)
+	// The signature of an expired token is still verified, so that only
+	// authentic tokens are reported as expired.
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired && claims.typ() == tokenType {
+		return nil, fmt.Errorf("%w: %w", ErrExpired, err)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "token parsing error")
 	}
 
 	if !token.Valid {
-		return "", errInvalidToken
+		return nil, errInvalidToken
+	}
+
+	if claims.typ() != tokenType {
+		return nil, errInvalidTokenType
+	}
+
+	return claims, nil
+}
+
+// verificationKey chooses the key by the kid header of the token.
+//
+// The algorithm of the token must match the key, so that e.g. a public RSA key
+// can not be used as an HMAC secret.
+func (j *JWT) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := j.keysByID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+	if t.Method.Alg() != key.Algorithm() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 	}
 
-	return j.claims.UserID, nil
+	return key.verifyKey, nil
 }
@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -46,6 +47,16 @@ func TestJWT(t *testing.T) {
 		assert.Empty(t, decodedUserID)
 		var targetErr *j.ValidationError
 		assert.ErrorAs(t, err, &targetErr)
+		assert.ErrorIs(t, err, ErrExpired)
+
+		// Tokens with an invalid signature are not reported as expired.
+		_, err = jwt.GetUserID(tokenString + "invalid_data")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrExpired)
+
+		// Expired tokens of another type are not reported as expired.
+		_, err = jwt.Parse(tokenString, TypeRefresh)
+		assert.NotErrorIs(t, err, ErrExpired)
 	})
 
 	t.Run("invalid signature", func(t *testing.T) {
@@ -64,6 +75,72 @@ func TestJWT(t *testing.T) {
 	})
 }
 
+func TestIssue(t *testing.T) {
+	jwt := New(Options{
+		Secret:          []byte("secret_key"),
+		Duration:        time.Minute,
+		RefreshDuration: time.Hour,
+		Issuer:          "test",
+	})
+	userID := uuid.NewString()
+
+	access, accessClaims, err := jwt.Issue(userID, TypeAccess)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	refresh, refreshClaims, err := jwt.Issue(userID, TypeRefresh)
+	require.NoError(t, err)
+
+	// Every token has its own ID and expiration time.
+	assert.NotEqual(t, accessClaims.ID, refreshClaims.ID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), accessClaims.ExpiresAt.Time, 2*time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), refreshClaims.ExpiresAt.Time, 2*time.Second)
+
+	claims, err := jwt.Parse(refresh, TypeRefresh)
+	require.NoError(t, err)
+	assert.Equal(t, refreshClaims.ID, claims.ID)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, "test", claims.Issuer)
+
+	claims, err = jwt.Parse(access, TypeAccess)
+	require.NoError(t, err)
+	assert.Equal(t, accessClaims.ID, claims.ID)
+
+	// Tokens of one type can not be used in place of the other.
+	_, err = jwt.Parse(access, TypeRefresh)
+	assert.ErrorIs(t, err, errInvalidTokenType)
+	_, err = jwt.GetUserID(refresh)
+	assert.ErrorIs(t, err, errInvalidTokenType)
+
+	// Tokens issued before token types were introduced are access tokens.
+	legacy, err := j.NewWithClaims(j.SigningMethodHS256, Claims{UserID: userID}).SignedString([]byte("secret_key"))
+	require.NoError(t, err)
+	decodedUserID, err := jwt.GetUserID(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, userID, decodedUserID)
+}
+
+func TestConcurrentUse(t *testing.T) {
+	jwt := New(Options{
+		Secret:   []byte("secret_key"),
+		Duration: time.Hour,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID := uuid.NewString()
+			token, err := jwt.GetString(userID)
+			assert.NoError(t, err)
+			decodedUserID, err := jwt.GetUserID(token)
+			assert.NoError(t, err)
+			assert.Equal(t, userID, decodedUserID)
+		}()
+	}
+	wg.Wait()
+}
+
 func BenchmarkGetString(b *testing.B) {
 	jwt := New(Options{
 		Secret:   []byte("secret_key"),
@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// Key is a token signing or verification key identified by the kid header.
+//
+// Use NewHMACKey, ParseKeyPEM or LoadKeyFile to create a Key.
+type Key struct {
+	// ID is put to the kid header of tokens signed with the key.
+	ID     string
+	method jwt.SigningMethod
+	// signKey is nil for verification-only keys.
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey creates an HS256 key from the shared secret.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// ParseKeyPEM creates a key from a PEM block.
+//
+// A private key (PKCS #8, or PKCS #1 for RSA) can sign and verify tokens,
+// a public key (PKIX) can only verify them. RSA keys use RS256, Ed25519 keys use EdDSA.
+func ParseKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "key parsing error")
+	}
+
+	key := &Key{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.verifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", parsed)
+	}
+
+	return key, nil
+}
+
+// LoadKeyFile reads a key from the PEM file.
+func LoadKeyFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseKeyPEM(id, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// Algorithm returns the name of the signing algorithm of the key, e.g. "RS256".
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// CanSign reports whether the key contains a private part.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// PublicKey returns the public part of an asymmetric key or nil for HMAC keys.
+func (k *Key) PublicKey() crypto.PublicKey {
+	if _, ok := k.method.(*jwt.SigningMethodHMAC); ok {
+		return nil
+	}
+
+	return k.verifyKey
+}
@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	j "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	rsaFile := writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edFile := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edDER)
+	rsaPubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	rsaPubFile := writePEM(t, dir, "rsa.pub.pem", "PUBLIC KEY", rsaPubDER)
+
+	rsaSigner, err := LoadKeyFile("rsa-1", rsaFile)
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", rsaSigner.Algorithm())
+	assert.True(t, rsaSigner.CanSign())
+
+	edSigner, err := LoadKeyFile("ed-1", edFile)
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", edSigner.Algorithm())
+
+	rsaVerifier, err := LoadKeyFile("rsa-1", rsaPubFile)
+	require.NoError(t, err)
+	assert.False(t, rsaVerifier.CanSign())
+
+	userID := uuid.NewString()
+	secret := []byte("secret_key")
+
+	t.Run("sign with asymmetric keys", func(t *testing.T) {
+		for _, key := range []*Key{rsaSigner, edSigner} {
+			jwt := New(Options{SigningKey: key, Duration: time.Hour})
+			token, err := jwt.GetString(userID)
+			require.NoError(t, err)
+
+			parsed, _, err := j.NewParser().ParseUnverified(token, &Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+			assert.Equal(t, key.Algorithm(), parsed.Header["alg"])
+
+			decodedUserID, err := jwt.GetUserID(token)
+			require.NoError(t, err)
+			assert.Equal(t, userID, decodedUserID)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		old := New(Options{Secret: secret, Duration: time.Hour})
+		oldToken, err := old.GetString(userID)
+		require.NoError(t, err)
+		rsaToken, err := New(Options{SigningKey: rsaSigner, Duration: time.Hour}).GetString(userID)
+		require.NoError(t, err)
+
+		// The Ed25519 key is active now, the RSA key and the secret only verify older tokens.
+		rotated := New(Options{
+			Secret:           secret,
+			SigningKey:       edSigner,
+			VerificationKeys: []*Key{rsaVerifier},
+			Duration:         time.Hour,
+		})
+		for _, token := range []string{oldToken, rsaToken} {
+			decodedUserID, err := rotated.GetUserID(token)
+			require.NoError(t, err)
+			assert.Equal(t, userID, decodedUserID)
+		}
+
+		// Verification-only keys can not sign.
+		_, err = New(Options{SigningKey: rsaVerifier}).GetString(userID)
+		assert.ErrorIs(t, err, errNoSigningKey)
+	})
+
+	t.Run("negative case: unknown key", func(t *testing.T) {
+		token, err := New(Options{SigningKey: edSigner, Duration: time.Hour}).GetString(userID)
+		require.NoError(t, err)
+
+		_, err = New(Options{Secret: secret, SigningKey: rsaSigner}).GetUserID(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("negative case: public key as HMAC secret", func(t *testing.T) {
+		forged := j.NewWithClaims(j.SigningMethodHS256, Claims{UserID: userID})
+		forged.Header["kid"] = "rsa-1"
+		token, err := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+		require.NoError(t, err)
+
+		_, err = New(Options{SigningKey: rsaSigner}).GetUserID(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		jwt := New(Options{
+			Secret:           secret,
+			SigningKey:       edSigner,
+			VerificationKeys: []*Key{rsaVerifier},
+		})
+
+		set := jwt.JWKS()
+		require.Equal(t, 2, len(set.Keys))
+
+		ed := set.Keys[0]
+		assert.Equal(t, "OKP", ed.KeyType)
+		assert.Equal(t, "ed-1", ed.KeyID)
+		assert.Equal(t, "EdDSA", ed.Algorithm)
+		assert.Equal(t, "Ed25519", ed.Curve)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)), ed.X)
+
+		rsa := set.Keys[1]
+		assert.Equal(t, "RSA", rsa.KeyType)
+		assert.Equal(t, "rsa-1", rsa.KeyID)
+		assert.Equal(t, "RS256", rsa.Algorithm)
+		n, err := base64.RawURLEncoding.DecodeString(rsa.N)
+		require.NoError(t, err)
+		assert.Equal(t, rsaKey.N, new(big.Int).SetBytes(n))
+		assert.Equal(t, "AQAB", rsa.E)
+	})
+
+	t.Run("negative case: invalid PEM", func(t *testing.T) {
+		_, err := ParseKeyPEM("id", []byte("not a key"))
+		assert.Error(t, err)
+
+		_, err = LoadKeyFile("id", filepath.Join(dir, "missing.pem"))
+		assert.Error(t, err)
+	})
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	return path
+}